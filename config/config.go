@@ -4,35 +4,87 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
 	// GENERAL
 	HOST           string = "HOST"
+	GRPC_HOST      string = "GRPC_HOST"
 	MONGO_HOST     string = "MONGO_HOST"
 	MONGO_DATABASE string = "MONGO_DATABASE"
 
+	// PRODUCT GROUPS
+	PRODUCT_GROUP_VALIDATION string = "PRODUCT_GROUP_VALIDATION"
+
+	// IMPORT JOBS
+	IMPORT_WORKERS string = "IMPORT_WORKERS"
+
+	// IDEMPOTENCY KEYS
+	IDEMPOTENCY_TTL string = "IDEMPOTENCY_TTL"
+
+	// WEBHOOKS
+	WEBHOOK_WORKERS       string = "WEBHOOK_WORKERS"
+	WEBHOOK_MAX_ATTEMPTS  string = "WEBHOOK_MAX_ATTEMPTS"
+	WEBHOOK_DISABLE_AFTER string = "WEBHOOK_DISABLE_AFTER"
+	WEBHOOK_TIMEOUT       string = "WEBHOOK_TIMEOUT"
+
 	// KAFKA
-	GROUP_ID             string = "GROUP_ID"
-	TOPICS_SUBSCRIBED    string = "TOPICS_SUBSCRIBED"
-	BOOTSTRAP_SERVERS    string = "BOOTSTRAP_SERVERS"
-	REQUEST_TIMEOUT      string = "REQUEST_TIMEOUT"
-	RETRIES              string = "RETRIES"
-	BATCH_SIZE           string = "BATCH_SIZE"
-	LINGER               string = "LINGER"
-	BUFFER_MEMORY        string = "BUFFER_MEMORY"
-	AUTO_COMMIT_INTERVAL string = "AUTO_COMMIT_INTERVAL"
-	AUTO_COMMIT_ENABLE   string = "AUTO_COMMIT_ENABLE"
-	AUTO_OFFSET_RESET    string = "AUTO_OFFSET_RESET"
+	GROUP_ID              string = "GROUP_ID"
+	TOPICS_SUBSCRIBED     string = "TOPICS_SUBSCRIBED"
+	BOOTSTRAP_SERVERS     string = "BOOTSTRAP_SERVERS"
+	REQUEST_TIMEOUT       string = "REQUEST_TIMEOUT"
+	RETRIES               string = "RETRIES"
+	BATCH_SIZE            string = "BATCH_SIZE"
+	LINGER                string = "LINGER"
+	BUFFER_MEMORY         string = "BUFFER_MEMORY"
+	AUTO_COMMIT_INTERVAL  string = "AUTO_COMMIT_INTERVAL"
+	AUTO_COMMIT_ENABLE    string = "AUTO_COMMIT_ENABLE"
+	AUTO_OFFSET_RESET     string = "AUTO_OFFSET_RESET"
+	PRODUCT_EVENTS_TOPIC  string = "PRODUCT_EVENTS_TOPIC"
+	DEAD_LETTER_TOPIC     string = "DEAD_LETTER_TOPIC"
+	MESSAGE_FORMAT        string = "MESSAGE_FORMAT"
+	SCHEMA_REGISTRY_URL   string = "SCHEMA_REGISTRY_URL"
+	CONSUMER_MAX_ATTEMPTS string = "CONSUMER_MAX_ATTEMPTS"
+	CONSUMER_BACKOFF      string = "CONSUMER_BACKOFF"
+	CONSUMER_MAX_BACKOFF  string = "CONSUMER_MAX_BACKOFF"
+)
+
+// Product group validation modes, applied when products reference a product group
+const (
+	GroupValidationStrict = "strict" // products can only reference existing groups
+	GroupValidationWarn   = "warn"   // references to unknown groups are logged
+	GroupValidationOff    = "off"    // references are not checked
+)
+
+// Formats of the produced Kafka messages, Avro and Protobuf messages are in Confluent wire format
+// with the id of their schema in the schema registry
+const (
+	MessageFormatJSON     = "json"
+	MessageFormatAvro     = "avro"
+	MessageFormatProtobuf = "protobuf"
 )
 
 type Config struct {
-	Host              string
-	MongoHost         string
-	MongoDatabaseName string
+	Host                   string
+	GRPCHost               string        // address the gRPC API is served on
+	MongoHost              string
+	MongoDatabaseName      string
+	ProductGroupValidation string        // one of strict|warn|off
+	ImportWorkers          int           // how many import jobs are processed at the same time by this instance
+	IdempotencyTTL         time.Duration // how long responses of requests with an Idempotency-Key are replayed
+	*WebhookConfig
 	*KafkaConsumerConfig
 }
 
+// WebhookConfig is the struct that represents the parameters of webhook deliveries
+type WebhookConfig struct {
+	WebhookWorkers      int           // how many deliveries are sent at the same time by this instance
+	WebhookMaxAttempts  int           // attempts of a delivery before it fails, retried with exponential backoff
+	WebhookDisableAfter int           // consecutive failed deliveries after which a subscription is disabled
+	WebhookTimeout      time.Duration // how long partners have to answer a delivery
+}
+
 // KafkaConsumerConfig is the struct that represents Kafka parameters for the Kafka consumer
 // these parameters are explained in more detail in: https://kafka.apache.org/documentation/#newconsumerconfigs
 type KafkaConsumerConfig struct {
@@ -50,6 +102,15 @@ type KafkaConsumerConfig struct {
 	AutoOffsetReset string // what to do when there is no initial offset in ZooKeeper or if an offset is out of range
 	// IMPORTANT: if set to "earliest", this means that if Kafka loses its commit history, some events may dealed twice.
 	// This is a trade-of for trying not to loose any event produced to Kafka
+
+	ProductEventsTopic string // topic where product events (e.g. status transitions) are produced
+	DeadLetterTopic    string // topic where consumed messages that can't be processed are set aside
+	MessageFormat      string // format of the produced messages, one of json|avro|protobuf
+	SchemaRegistryURL  string // schema registry of Avro and Protobuf messages, or a file:// directory standing in for it
+
+	ConsumerMaxAttempts int           // attempts of a message failing with transient errors before it's dead lettered, 0 retries it until it succeeds
+	ConsumerBackoff     time.Duration // wait before the second attempt of a message, doubled on each attempt up to ConsumerMaxBackoff
+	ConsumerMaxBackoff  time.Duration // longest wait between attempts of a message
 }
 
 func NewConfig() *Config {
@@ -65,6 +126,41 @@ func NewConfig() *Config {
 	autoCommitInt, _ := strconv.Atoi(MustGetEnv(AUTO_COMMIT_INTERVAL))
 	autoCommitEnable, _ := strconv.ParseBool(MustGetEnv(AUTO_COMMIT_ENABLE))
 	autoOffsetReset := MustGetEnv(AUTO_OFFSET_RESET)
+	productEventsTopic := GetEnv(PRODUCT_EVENTS_TOPIC, "product-events")
+	deadLetterTopic := GetEnv(DEAD_LETTER_TOPIC, "products-dlq")
+
+	messageFormat := GetEnv(MESSAGE_FORMAT, MessageFormatJSON)
+	switch messageFormat {
+	case MessageFormatJSON, MessageFormatAvro, MessageFormatProtobuf:
+	default:
+		panic("Environment variable " + MESSAGE_FORMAT + " must be json, avro or protobuf")
+	}
+
+	schemaRegistryURL := GetEnv(SCHEMA_REGISTRY_URL, "")
+	if messageFormat != MessageFormatJSON && schemaRegistryURL == "" {
+		panic("Environment variable " + SCHEMA_REGISTRY_URL + " is required to produce " + messageFormat + " messages")
+	}
+
+	groupValidation := GetEnv(PRODUCT_GROUP_VALIDATION, GroupValidationWarn)
+	switch groupValidation {
+	case GroupValidationStrict, GroupValidationWarn, GroupValidationOff:
+	default:
+		panic("Environment variable " + PRODUCT_GROUP_VALIDATION + " must be strict, warn or off")
+	}
+
+	importWorkers, err := strconv.Atoi(GetEnv(IMPORT_WORKERS, "2"))
+	if err != nil || importWorkers < 0 {
+		panic("Environment variable " + IMPORT_WORKERS + " must be a number of workers")
+	}
+
+	idempotencyTTL := time.Duration(mustGetCount(IDEMPOTENCY_TTL, "24", 1)) * time.Hour
+
+	webhookConfig := &WebhookConfig{
+		WebhookWorkers:      mustGetCount(WEBHOOK_WORKERS, "2", 0),
+		WebhookMaxAttempts:  mustGetCount(WEBHOOK_MAX_ATTEMPTS, "8", 1),
+		WebhookDisableAfter: mustGetCount(WEBHOOK_DISABLE_AFTER, "3", 1),
+		WebhookTimeout:      time.Duration(mustGetCount(WEBHOOK_TIMEOUT, "10", 1)) * time.Second,
+	}
 
 	kafkaConfig := &KafkaConsumerConfig{
 		GroupID:            MustGetEnv(GROUP_ID),
@@ -78,12 +174,25 @@ func NewConfig() *Config {
 		AutoCommitInterval: autoCommitInt,
 		AutoCommitEnable:   autoCommitEnable,
 		AutoOffsetReset:    autoOffsetReset,
+		ProductEventsTopic: productEventsTopic,
+		DeadLetterTopic:    deadLetterTopic,
+		MessageFormat:      messageFormat,
+		SchemaRegistryURL:  schemaRegistryURL,
+
+		ConsumerMaxAttempts: mustGetCount(CONSUMER_MAX_ATTEMPTS, "0", 0),
+		ConsumerBackoff:     time.Duration(mustGetCount(CONSUMER_BACKOFF, "1", 1)) * time.Second,
+		ConsumerMaxBackoff:  time.Duration(mustGetCount(CONSUMER_MAX_BACKOFF, "60", 1)) * time.Second,
 	}
 
 	return &Config{
 		Host:              MustGetEnv(HOST),
+		GRPCHost:          GetEnv(GRPC_HOST, "localhost:8070"),
 		MongoHost:         MustGetEnv(MONGO_HOST),
 		MongoDatabaseName: MustGetEnv(MONGO_DATABASE),
+		ProductGroupValidation: groupValidation,
+		ImportWorkers:          importWorkers,
+		IdempotencyTTL:         idempotencyTTL,
+		WebhookConfig:          webhookConfig,
 		KafkaConsumerConfig:    kafkaConfig,
 	}
 }
@@ -97,3 +206,24 @@ func MustGetEnv(envVarName string) string {
 
 	return res
 }
+
+// GetEnv returns the value of an optional environment variable or the provided default if not set
+func GetEnv(envVarName string, defaultValue string) string {
+	res, found := os.LookupEnv(envVarName)
+
+	if !found {
+		return defaultValue
+	}
+
+	return res
+}
+
+// mustGetCount returns the value of an optional environment variable that must be a number not below min
+func mustGetCount(envVarName string, defaultValue string, min int) int {
+	count, err := strconv.Atoi(GetEnv(envVarName, defaultValue))
+	if err != nil || count < min {
+		panic("Environment variable " + envVarName + " must be a number not below " + strconv.Itoa(min))
+	}
+
+	return count
+}
@@ -27,26 +27,68 @@ func BuildContainer() *dig.Container {
 	if err != nil {panic(err)}
 	err = container.Provide(repositories.NewProductRepository)
 	if err != nil {panic(err)}
+	err = container.Provide(repositories.NewProductGroupRepository)
+	if err != nil {panic(err)}
+	err = container.Provide(repositories.NewImportJobRepository)
+	if err != nil {panic(err)}
+	err = container.Provide(repositories.NewWebhookRepository)
+	if err != nil {panic(err)}
+	err = container.Provide(repositories.NewIdempotencyRepository)
+	if err != nil {panic(err)}
 
 
 	// services
+	err = container.Provide(services.NewSchemaRegistry)
+	if err != nil {panic(err)}
+	err = container.Provide(services.NewMessageSerde)
+	if err != nil {panic(err)}
+	err = container.Provide(services.NewKafkaProducer)
+	if err != nil {panic(err)}
+	err = container.Provide(services.NewProductGroupService)
+	if err != nil {panic(err)}
 	err = container.Provide(services.NewProductService)
 	if err != nil {panic(err)}
+	err = container.Provide(services.NewKafkaDeadLetter)
+	if err != nil {panic(err)}
 	err = container.Provide(services.NewKafkaConsumer)
 	if err != nil {panic(err)}
+	err = container.Provide(services.NewImportJobService)
+	if err != nil {panic(err)}
+	err = container.Provide(services.NewImportWorker)
+	if err != nil {panic(err)}
+	err = container.Provide(services.NewWebhookService)
+	if err != nil {panic(err)}
+	err = container.Provide(services.NewWebhookDispatcher)
+	if err != nil {panic(err)}
+	err = container.Provide(services.NewIdempotencyService)
+	if err != nil {panic(err)}
 
 	// controllers
 	err = container.Provide(controllers.NewProductController)
 	if err != nil {panic(err)}
+	err = container.Provide(controllers.NewProductGroupController)
+	if err != nil {panic(err)}
+	err = container.Provide(controllers.NewImportJobController)
+	if err != nil {panic(err)}
+	err = container.Provide(controllers.NewProductGRPCController)
+	if err != nil {panic(err)}
+	err = container.Provide(controllers.NewGraphQLController)
+	if err != nil {panic(err)}
+	err = container.Provide(controllers.NewWebhookController)
+	if err != nil {panic(err)}
 
 	// generic http layer
 	err = container.Provide(handlers.NewHttpHandlers)
 	if err != nil {panic(err)}
+	err = container.Provide(handlers.NewIdempotencyHandler)
+	if err != nil {panic(err)}
 
 	
 	// server
 	err = container.Provide(server.NewServer)
 	if err != nil {panic(err)}
+	err = container.Provide(server.NewGRPCServer)
+	if err != nil {panic(err)}
 
 	return container
 }
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"products/models/request"
+	"products/models/response"
+	"products/util/errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etag returns the entity tag of a product version
+func etag(version int64) string {
+	return "\"" + strconv.FormatInt(version, 10) + "\""
+}
+
+// representationTag returns the entity tag of a representation of a product version, e.g. its XML form, a projection
+// or an as_of read, so caches revalidating with If-None-Match don't serve one representation for another.
+// The current JSON form, with no representation, keeps the version tag.
+func representationTag(version int64, representation string) string {
+	if representation == "" {
+		return etag(version)
+	}
+
+	sum := sha1.Sum([]byte(representation))
+	return "\"" + strconv.FormatInt(version, 10) + "-" + hex.EncodeToString(sum[:4]) + "\""
+}
+
+// ifMatchVersions reads the product versions the client expects to change from the If-Match header, nil for "*" matching any version.
+// Tags that aren't product ETags are left out, they never match.
+func ifMatchVersions(c *gin.Context) ([]int64, *mresponse.ErrorResponse) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return nil, errors.HandleErrorResponse(errors.PRECONDITION_REQUIRED, nil, "")
+	}
+
+	if strings.TrimSpace(header) == "*" {
+		return nil, nil
+	}
+
+	versions := []int64{}
+	for _, t := range strings.Split(header, ",") {
+		// tags of other representations than the current JSON form are suffixed, they hold the same version
+		tag := strings.Trim(strings.TrimPrefix(strings.TrimSpace(t), "W/"), "\"")
+		if i := strings.IndexByte(tag, '-'); i > 0 {
+			tag = tag[:i]
+		}
+
+		version, err := strconv.ParseInt(tag, 10, 64)
+		if err == nil {
+			versions = append(versions, version)
+		}
+	}
+
+	return versions, nil
+}
+
+// ifMatchVersion returns the product version a write is conditional on, the one of the If-Match header.
+// With "*" or a list of tags it is the current version of the product, if it's one of them, so the write still fails
+// if the product is changed meanwhile. Products matching no tag fail the precondition.
+func (pc ProductController) ifMatchVersion(c *gin.Context) (int64, *mresponse.ErrorResponse) {
+	versions, e := ifMatchVersions(c)
+	if e != nil {
+		return 0, e
+	}
+
+	if len(versions) == 1 {
+		return versions[0], nil
+	}
+
+	if versions != nil && len(versions) == 0 {
+		return 0, errors.HandleErrorResponse(errors.PRECONDITION_FAILED, nil, "")
+	}
+
+	current, e := pc.ProductService.ReadOne(c.Param("id"), nil, nil)
+	if e != nil {
+		if e.HttpCode == 404 {
+			return 0, errors.HandleErrorResponse(errors.PRECONDITION_FAILED, nil, "")
+		}
+		return 0, e
+	}
+
+	if versions == nil {
+		return current.Version, nil
+	}
+
+	for _, version := range versions {
+		if version == current.Version {
+			return version, nil
+		}
+	}
+
+	return 0, errors.HandleErrorResponse(errors.PRECONDITION_FAILED, nil, "")
+}
+
+// noneMatch tells if the If-None-Match header matches the provided entity tag
+func noneMatch(c *gin.Context, tag string) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// representation describes the representation of a product read, empty for its current JSON form
+func representation(c *gin.Context, asOf *time.Time, projection *mrequest.Projection) string {
+	parts := []string{}
+
+	if acceptsXML(c) {
+		parts = append(parts, "xml")
+	} else if projection != nil {
+		parts = append(parts, "fields="+strings.Join(projection.Fields, ","), "exclude="+strings.Join(projection.Exclude, ","))
+	}
+
+	if asOf != nil {
+		parts = append(parts, "as_of="+asOf.UTC().Format(time.RFC3339Nano))
+	}
+
+	return strings.Join(parts, ";")
+}
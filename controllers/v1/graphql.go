@@ -0,0 +1,285 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/url"
+	"products/models/request"
+	"products/models/response"
+	"products/services"
+	"products/util/errors"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+type (
+	// GraphQLController represents the controller for the GraphQL queries and mutations on products
+	GraphQLController struct {
+		ProductService services.ProductServiceContract
+		schema         graphql.Schema
+	}
+
+	// graphQLRequest is the body of a GraphQL request
+	graphQLRequest struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+
+	// graphQLError keeps the error response of the services in the extensions of GraphQL errors
+	graphQLError struct {
+		*mresponse.ErrorResponse
+	}
+)
+
+// NewGraphQLController is the constructor of GraphQLController
+func NewGraphQLController(ps services.ProductServiceContract) *GraphQLController {
+	qc := GraphQLController{
+		ProductService: ps,
+	}
+	qc.schema = newGraphQLSchema(&qc)
+
+	return &qc
+}
+
+// QueryAction executes a GraphQL query or mutation, sent as JSON with the query, its variables and operationName.
+// Only the selected product fields are read, e.g. { products(filters: [{field: "ProductCode", operator: "prefix", value: "PRF"}]) { items { ProductCode } } }
+func (qc GraphQLController) QueryAction(c *gin.Context) {
+	req := graphQLRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&req)
+
+	if req.Query == "" {
+		details := []mresponse.ErrorDetail{
+			mresponse.ErrorDetail{
+				Property: "query",
+				Message:  "Field token cannot be empty or is missing",
+			},
+		}
+		e := errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	res := graphql.Do(graphql.Params{
+		Schema:         qc.schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        c.Request.Context(),
+	})
+
+	c.JSON(200, res)
+}
+
+func (qc *GraphQLController) resolveProduct(p graphql.ResolveParams) (interface{}, error) {
+	asOf, e := mrequest.ParseAsOf(stringParams(p.Args, "as_of"))
+	if e != nil {
+		return nil, graphQLError{e}
+	}
+
+	res, err := qc.ProductService.ReadOne(p.Args["id"].(string), asOf, selectedProjection(p.Info))
+
+	if err != nil {
+		return nil, graphQLError{err}
+	}
+
+	return res, nil
+}
+
+func (qc *GraphQLController) resolveProducts(p graphql.ResolveParams) (interface{}, error) {
+	params := stringParams(p.Args, "sort", "order", "group_subtree", "as_of")
+
+	for _, arg := range []string{"page", "per_page"} {
+		if value, ok := p.Args[arg].(int); ok {
+			params.Set(arg, strconv.Itoa(value))
+		}
+	}
+
+	for _, arg := range []string{"include_archived", "only_archived", "with_total"} {
+		if value, ok := p.Args[arg].(bool); ok {
+			params.Set(arg, strconv.FormatBool(value))
+		}
+	}
+
+	if cursor, ok := p.Args["cursor"].(string); ok {
+		params.Set("cursor", cursor)
+	}
+
+	if status, ok := p.Args["status"].([]interface{}); ok {
+		values := []string{}
+		for _, s := range status {
+			values = append(values, s.(string))
+		}
+		params.Set("status", strings.Join(values, ","))
+	}
+
+	// unknown fields are reported, instead of being ignored like unknown query params
+	details := []mresponse.ErrorDetail{}
+	allowedFilters := validFilters()
+	filters, _ := p.Args["filters"].([]interface{})
+	for _, f := range filters {
+		filter := f.(map[string]interface{})
+		field := filter["field"].(string)
+		if _, ok := allowedFilters[field]; !ok {
+			details = append(details, mresponse.ErrorDetail{
+				Property: "filters",
+				Message:  "Unknown field " + field,
+			})
+			continue
+		}
+
+		if operator, ok := filter["operator"].(string); ok && operator != "" {
+			field += "[" + operator + "]"
+		}
+		params.Add(field, filter["value"].(string))
+	}
+
+	if len(details) != 0 {
+		return nil, graphQLError{errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")}
+	}
+
+	req, e := mrequest.NewListRequest(params, validSorts(), validFilters())
+	if e != nil {
+		return nil, graphQLError{e}
+	}
+	req.Projection = selectedProjection(p.Info, "items")
+
+	res, err := qc.ProductService.List(req)
+
+	if err != nil {
+		return nil, graphQLError{err}
+	}
+
+	return res, nil
+}
+
+func (qc *GraphQLController) resolveCreateProduct(p graphql.ResolveParams) (interface{}, error) {
+	pReq := mrequest.ProductCreate{}
+	decodeInput(p.Args["input"], &pReq)
+
+	e := errors.ValidateRequest(&pReq)
+	if e != nil {
+		return nil, graphQLError{e}
+	}
+
+	created, err := qc.ProductService.CreateOne(&pReq)
+
+	if err != nil {
+		return nil, graphQLError{err}
+	}
+
+	res, err := qc.ProductService.ReadOne(created.ID, nil, selectedProjection(p.Info))
+
+	if err != nil {
+		return nil, graphQLError{err}
+	}
+
+	return res, nil
+}
+
+func (qc *GraphQLController) resolveUpdateProduct(p graphql.ResolveParams) (interface{}, error) {
+	pReq := mrequest.ProductUpdate{}
+	decodeInput(p.Args["input"], &pReq)
+
+	e := errors.ValidateRequest(&pReq)
+	if e != nil {
+		return nil, graphQLError{e}
+	}
+
+	res, err := qc.ProductService.UpdateOne(p.Args["id"].(string), int64(p.Args["version"].(int)), &pReq)
+
+	if err != nil {
+		return nil, graphQLError{err}
+	}
+
+	return res, nil
+}
+
+// Extensions adds the code and the error details of the error response to the GraphQL error
+func (e graphQLError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{
+		"code": e.Code,
+	}
+
+	if len(e.Errors) != 0 {
+		ext["errors"] = e.Errors
+	}
+
+	return ext
+}
+
+func (e graphQLError) Error() string {
+	return e.Response
+}
+
+// selectedProjection returns the projection of the product fields selected under the field being resolved, following path
+func selectedProjection(info graphql.ResolveInfo, path ...string) *mrequest.Projection {
+	projection := mrequest.Projection{Fields: []string{}}
+	seen := map[string]bool{}
+
+	for _, field := range info.FieldASTs {
+		collectFields(info, field.SelectionSet, path, "", &projection, seen)
+	}
+
+	return &projection
+}
+
+// collectFields adds the selected fields that can be projected, nested ones prefixed by their parent (e.g. CustomsDetails.CNCode)
+func collectFields(info graphql.ResolveInfo, set *ast.SelectionSet, path []string, prefix string, projection *mrequest.Projection, seen map[string]bool) {
+	if set == nil {
+		return
+	}
+
+	for _, selection := range set.Selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			if len(path) > 0 {
+				if s.Name.Value == path[0] {
+					collectFields(info, s.SelectionSet, path[1:], prefix, projection, seen)
+				}
+				continue
+			}
+
+			name := prefix + s.Name.Value
+			if _, ok := mrequest.ProjectionFields[name]; !ok || seen[name] {
+				continue
+			}
+
+			before := len(projection.Fields)
+			collectFields(info, s.SelectionSet, nil, name+".", projection, seen)
+			if len(projection.Fields) == before {
+				seen[name] = true
+				projection.Fields = append(projection.Fields, name)
+			}
+
+		case *ast.InlineFragment:
+			collectFields(info, s.SelectionSet, path, prefix, projection, seen)
+
+		case *ast.FragmentSpread:
+			if fragment, ok := info.Fragments[s.Name.Value].(*ast.FragmentDefinition); ok {
+				collectFields(info, fragment.SelectionSet, path, prefix, projection, seen)
+			}
+		}
+	}
+}
+
+// stringParams returns the string arguments as the query params of the REST API
+func stringParams(args map[string]interface{}, names ...string) url.Values {
+	params := url.Values{}
+	for _, name := range names {
+		if value, ok := args[name].(string); ok && value != "" {
+			params.Set(name, value)
+		}
+	}
+
+	return params
+}
+
+// decodeInput decodes an input object into a request, input fields have the JSON names of the request
+func decodeInput(input interface{}, req interface{}) {
+	b, _ := json.Marshal(input)
+	json.Unmarshal(b, req)
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"github.com/graphql-go/graphql"
+)
+
+// GraphQL field names are the JSON names of the REST API, so selections map directly to mrequest.Projection fields
+
+var customsDetailsType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "CustomsDetails",
+	Fields: graphql.Fields{
+		"CNCode":   &graphql.Field{Type: graphql.NewList(graphql.String)},
+		"UNNumber": &graphql.Field{Type: graphql.NewList(graphql.String)},
+	},
+})
+
+var productType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Product",
+	Fields: graphql.Fields{
+		"id":                 &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+		"version":            &graphql.Field{Type: graphql.Int},
+		"status":             &graphql.Field{Type: graphql.String},
+		"ProductType":        &graphql.Field{Type: graphql.String},
+		"ProductCode":        &graphql.Field{Type: graphql.String},
+		"ProductGroup":       &graphql.Field{Type: graphql.String},
+		"ProductDescription": &graphql.Field{Type: graphql.String},
+		"ProductNumberCode":  &graphql.Field{Type: graphql.String},
+		"CustomsDetails":     &graphql.Field{Type: customsDetailsType},
+		"archived_at":        &graphql.Field{Type: graphql.DateTime},
+		"archive_reason":     &graphql.Field{Type: graphql.String},
+	},
+})
+
+var productListType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ProductList",
+	Fields: graphql.Fields{
+		"total":       &graphql.Field{Type: graphql.Int},
+		"per_page":    &graphql.Field{Type: graphql.Int},
+		"page":        &graphql.Field{Type: graphql.Int},
+		"next_cursor": &graphql.Field{Type: graphql.String},
+		"items":       &graphql.Field{Type: graphql.NewList(productType)},
+	},
+})
+
+var productFilterInput = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name:        "ProductFilter",
+	Description: "A condition on a product field, like the Field[operator]=value query params of the REST API",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"field":    &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"operator": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"value":    &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+	},
+})
+
+var customsDetailsInput = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "CustomsDetailsInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"CNCode":   &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+		"UNNumber": &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+	},
+})
+
+// productInputFields are the fields of a product sent on mutations, with the names of mrequest.ProductCreate JSON
+func productInputFields(withStatus bool) graphql.InputObjectConfigFieldMap {
+	fields := graphql.InputObjectConfigFieldMap{
+		"ProductType":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"ProductCode":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"ProductGroup":       &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"ProductDescription": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"ProductNumberCode":  &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"CustomsDetails":     &graphql.InputObjectFieldConfig{Type: customsDetailsInput},
+	}
+
+	if withStatus {
+		fields["status"] = &graphql.InputObjectFieldConfig{Type: graphql.String}
+	}
+
+	return fields
+}
+
+var productCreateInput = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name:   "ProductCreateInput",
+	Fields: productInputFields(true),
+})
+
+var productUpdateInput = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name:   "ProductUpdateInput",
+	Fields: productInputFields(false),
+})
+
+// newGraphQLSchema builds the schema with the resolvers of the controller
+func newGraphQLSchema(qc *GraphQLController) graphql.Schema {
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"product": &graphql.Field{
+				Type:        productType,
+				Description: "The product with the provided id, as it existed at as_of if provided",
+				Args: graphql.FieldConfigArgument{
+					"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"as_of": &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: qc.resolveProduct,
+			},
+			"products": &graphql.Field{
+				Type:        productListType,
+				Description: "A page of products, with the arguments of the REST API list query params",
+				Args: graphql.FieldConfigArgument{
+					"page":             &graphql.ArgumentConfig{Type: graphql.Int},
+					"per_page":         &graphql.ArgumentConfig{Type: graphql.Int},
+					"sort":             &graphql.ArgumentConfig{Type: graphql.String},
+					"order":            &graphql.ArgumentConfig{Type: graphql.String},
+					"filters":          &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(productFilterInput))},
+					"status":           &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+					"include_archived": &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"only_archived":    &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"group_subtree":    &graphql.ArgumentConfig{Type: graphql.String},
+					"as_of":            &graphql.ArgumentConfig{Type: graphql.String},
+					"cursor":           &graphql.ArgumentConfig{Type: graphql.String},
+					"with_total":       &graphql.ArgumentConfig{Type: graphql.Boolean},
+				},
+				Resolve: qc.resolveProducts,
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createProduct": &graphql.Field{
+				Type: productType,
+				Args: graphql.FieldConfigArgument{
+					"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(productCreateInput)},
+				},
+				Resolve: qc.resolveCreateProduct,
+			},
+			"updateProduct": &graphql.Field{
+				Type:        productType,
+				Description: "Replaces the product, version must be the current one like the If-Match header of the REST API",
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"version": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+					"input":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(productUpdateInput)},
+				},
+				Resolve: qc.resolveUpdateProduct,
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:    query,
+		Mutation: mutation,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return schema
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"products/models/request"
+	"products/models/response"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stub ProductService keeping the requests of GraphQL resolvers
+type MockGraphQLProductService struct {
+	MockProductService
+	projection *mrequest.Projection
+	list       *mrequest.ListRequest
+}
+
+func (ps *MockGraphQLProductService) ReadOne(id string, asOf *time.Time, projection *mrequest.Projection) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	ps.projection = projection
+
+	if id == "some-unique-id" { // created products
+		id = "507f191e810c19729de860ea"
+	}
+
+	return ps.MockProductService.ReadOne(id, asOf, projection)
+}
+
+func (ps *MockGraphQLProductService) List(req *mrequest.ListRequest) (*mresponse.ProductList, *mresponse.ErrorResponse) {
+	ps.list = req
+
+	return ps.MockProductService.List(req)
+}
+
+func graphQLQuery(r *gin.Engine, query string, variables map[string]interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	req, _ := http.NewRequest(http.MethodPost, "/graphql", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	res := map[string]interface{}{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	return w, res
+}
+
+func TestGraphQLQueries(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	ps := &MockGraphQLProductService{}
+	qc := NewGraphQLController(ps)
+
+	r := gin.Default()
+
+	r.POST("/graphql", qc.QueryAction)
+
+	// TEST MISSING QUERY
+
+	w, _ := graphQLQuery(r, "", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	// TEST PRODUCT WITH SELECTED FIELDS
+
+	w, res := graphQLQuery(r, `query ($id: ID!) { product(id: $id) { id version ...desc CustomsDetails { CNCode } } }
+		fragment desc on Product { ProductDescription }`, map[string]interface{}{"id": "507f191e810c19729de860ea"})
+
+	product := res["data"].(map[string]interface{})["product"].(map[string]interface{})
+	if product["version"] != float64(3) || product["ProductDescription"] != "current-description" || len(product) != 4 {
+		t.Fatalf("Unexpected product:\n%s", w.Body.String())
+	}
+
+	if strings.Join(ps.projection.Fields, ",") != "version,ProductDescription,CustomsDetails.CNCode" {
+		t.Fatalf("Unexpected projection %v", ps.projection.Fields)
+	}
+
+	// TEST PRODUCTS WITH FILTERS
+
+	w, res = graphQLQuery(r, `{ products(page: 1, per_page: 10, sort: "id", status: ["active", "draft"], filters: [{field: "ProductCode", operator: "prefix", value: "PRF"}]) { page items { id ProductCode } } }`, nil)
+
+	items := res["data"].(map[string]interface{})["products"].(map[string]interface{})["items"].([]interface{})
+	if len(items) != 2 || items[0].(map[string]interface{})["id"] != "some-id-1" {
+		t.Fatalf("Unexpected products:\n%s", w.Body.String())
+	}
+
+	if len(ps.list.Filters) != 1 || ps.list.Filters[0].Operator != mrequest.FilterPrefix || len(ps.list.Status) != 2 || strings.Join(ps.list.Projection.Fields, ",") != "ProductCode" {
+		t.Fatalf("Unexpected list request %v", ps.list)
+	}
+
+	// unknown filters are reported
+	w, res = graphQLQuery(r, `{ products(filters: [{field: "Price", value: "1"}]) { page } }`, nil)
+
+	errs := res["errors"].([]interface{})
+	extensions := errs[0].(map[string]interface{})["extensions"].(map[string]interface{})
+	if extensions["code"] != "INVALID_REQUEST" || !strings.Contains(w.Body.String(), "Unknown field Price") {
+		t.Fatalf("Unexpected error:\n%s", w.Body.String())
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	qc := NewGraphQLController(&MockGraphQLProductService{})
+
+	r := gin.Default()
+
+	r.POST("/graphql", qc.QueryAction)
+
+	// TEST CREATE
+
+	w, res := graphQLQuery(r, `mutation ($input: ProductCreateInput!) { createProduct(input: $input) { id ProductDescription } }`, map[string]interface{}{
+		"input": map[string]interface{}{"ProductType": "P", "ProductCode": "PRF-001", "ProductDescription": "Parafuso", "ProductNumberCode": "R1", "CustomsDetails": map[string]interface{}{"CNCode": []string{"7318"}}},
+	})
+
+	if res["data"].(map[string]interface{})["createProduct"] == nil {
+		t.Fatalf("Expected the product to be created:\n%s", w.Body.String())
+	}
+
+	// invalid products are reported with the error details
+	w, res = graphQLQuery(r, `mutation { createProduct(input: {ProductType: "X"}) { id } }`, nil)
+
+	if res["errors"] == nil || !strings.Contains(w.Body.String(), `"property":"ProductCode"`) {
+		t.Fatalf("Expected a validation error:\n%s", w.Body.String())
+	}
+
+	// TEST UPDATE
+
+	update := `mutation ($version: Int!) { updateProduct(id: "507f191e810c19729de860ea", version: $version, input: {ProductType: "P", ProductCode: "PRF-001", ProductDescription: "Parafuso", ProductNumberCode: "R1"}) { version } }`
+
+	w, res = graphQLQuery(r, update, map[string]interface{}{"version": 2})
+	if !strings.Contains(w.Body.String(), `"code":"PRECONDITION_FAILED"`) {
+		t.Fatalf("Expected a version mismatch error:\n%s", w.Body.String())
+	}
+
+	w, res = graphQLQuery(r, update, map[string]interface{}{"version": 3})
+	if res["data"].(map[string]interface{})["updateProduct"].(map[string]interface{})["version"] != float64(4) {
+		t.Fatalf("Unexpected updated product:\n%s", w.Body.String())
+	}
+}
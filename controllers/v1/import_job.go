@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"products/models/request"
+	"products/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// ImportJobController represents the controller for operating on the import jobs resource
+	ImportJobController struct {
+		ImportJobService services.ImportJobServiceContract
+	}
+)
+
+// NewImportJobController is the constructor of ImportJobController
+func NewImportJobController(js services.ImportJobServiceContract) *ImportJobController {
+	return &ImportJobController{
+		ImportJobService: js,
+	}
+}
+
+// CreateAction queues the import of a CSV or TSV file, sent like on ProductController.ImportCSVAction and with the same options.
+// The job is returned as soon as the file is stored, its progress can be followed with ReadAction.
+func (jc ImportJobController) CreateAction(c *gin.Context) {
+	body, contentType, fileName, e := importBody(c)
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	req, e := mrequest.NewImportJobCreate(c.Request.URL.Query(), contentType, fileName)
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	res, err := jc.ImportJobService.CreateOne(req, body)
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(202, res)
+}
+
+// ReadAction returns the import job with the id provided in the URL, with its status, progress and row errors
+func (jc ImportJobController) ReadAction(c *gin.Context) {
+	res, err := jc.ImportJobService.ReadOne(c.Param("id"))
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// CancelAction cancels the import job with the id provided in the URL, if it has not finished yet
+func (jc ImportJobController) CancelAction(c *gin.Context) {
+	res, err := jc.ImportJobService.CancelOne(c.Param("id"))
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"products/models/request"
+	"products/models/response"
+	"products/util/errors"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stub ImportJobService behaviour
+type MockImportJobService struct {
+	job  *mrequest.ImportJobCreate
+	file string
+}
+
+func (js *MockImportJobService) CreateOne(request *mrequest.ImportJobCreate, file io.Reader) (*mresponse.ImportJob, *mresponse.ErrorResponse) {
+	data, _ := ioutil.ReadAll(file)
+	js.job, js.file = request, string(data)
+
+	return &mresponse.ImportJob{ID: "some-unique-id", Status: request.Status, FileName: request.FileName, Size: int64(len(data)), DryRun: request.DryRun}, nil
+}
+
+func (js *MockImportJobService) ReadOne(id string) (*mresponse.ImportJob, *mresponse.ErrorResponse) {
+	if id != "some-unique-id" {
+		return nil, errors.HandleErrorResponse(errors.NOT_FOUND, nil, "Import job not found")
+	}
+
+	return &mresponse.ImportJob{ID: id, Status: mresponse.JobRunning, Rows: 500, Valid: 499, Imported: 499, Failed: 1, ErrorCount: 1}, nil
+}
+
+func (js *MockImportJobService) CancelOne(id string) (*mresponse.ImportJob, *mresponse.ErrorResponse) {
+	if id == "finished-id" {
+		return nil, errors.HandleErrorResponse(errors.CONFLICT, nil, "Import job already finished")
+	}
+
+	return &mresponse.ImportJob{ID: id, Status: mresponse.JobCancelled}, nil
+}
+
+func TestCreateImportJobAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	js := &MockImportJobService{}
+	jc := NewImportJobController(js)
+
+	r := gin.Default()
+
+	r.POST("/api/v1/product/imports", jc.CreateAction)
+
+	// TEST INVALID OPTIONS
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/product/imports?encoding=utf-16", strings.NewReader("ProductCode\nA1\n"))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"encoding"`) {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	// TEST MULTIPART FILE
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	file, _ := form.CreateFormFile("file", "catalog.csv")
+	file.Write([]byte("ProductCode;ProductType\nA1;P\n"))
+	form.Close()
+
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/product/imports?delimiter=semicolon&dry_run=true", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+
+	res := mresponse.ImportJob{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.Status != mresponse.JobQueued || res.FileName != "catalog.csv" || !res.DryRun || js.file != "ProductCode;ProductType\nA1;P\n" {
+		t.Fatalf("Unexpected import job:\n%s", w.Body.String())
+	}
+
+	// options are kept to process the job with them
+	options, _ := mrequest.ParseImportOptions(js.job.Options, js.job.ContentType)
+	if options.Delimiter != ';' || !options.DryRun {
+		t.Fatalf("Unexpected import options %q", js.job.Options)
+	}
+}
+
+func TestReadAndCancelImportJobActions(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	jc := NewImportJobController(&MockImportJobService{})
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/imports/:id", jc.ReadAction)
+	r.POST("/api/v1/product/imports/:id/cancel", jc.CancelAction)
+
+	expected := map[string]int{
+		"GET /api/v1/product/imports/some-unique-id":         http.StatusOK,
+		"GET /api/v1/product/imports/unknown-id":             http.StatusNotFound,
+		"POST /api/v1/product/imports/some-unique-id/cancel": http.StatusOK,
+		"POST /api/v1/product/imports/finished-id/cancel":    http.StatusConflict,
+	}
+
+	for route, code := range expected {
+		parts := strings.SplitN(route, " ", 2)
+		req, _ := http.NewRequest(parts[0], parts[1], nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != code {
+			t.Fatalf("Expected to get status %d on %s but instead got %d\nResponse body:\n%s", code, route, w.Code, w.Body.String())
+		}
+	}
+}
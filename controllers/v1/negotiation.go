@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"products/models/request"
+	"products/models/response"
+	"products/models/saft-pt-4"
+	"products/util/errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// productXML is a product with the SAF-T (PT) element names, the product metadata of the API is kept in attributes
+type productXML struct {
+	ID            string     `xml:"id,attr,omitempty"`
+	Version       int64      `xml:"version,attr,omitempty"`
+	Status        string     `xml:"status,attr,omitempty"`
+	ArchivedAt    *time.Time `xml:"archived_at,attr,omitempty"`
+	ArchiveReason string     `xml:"archive_reason,attr,omitempty"`
+	msaft.Product
+}
+
+// productListXML is a page of products, with the pagination of the API in attributes
+type productListXML struct {
+	XMLName    xml.Name      `xml:"Products"`
+	Total      *int64        `xml:"total,attr,omitempty"`
+	PerPage    int64         `xml:"per_page,attr"`
+	Page       int64         `xml:"page,attr,omitempty"`
+	NextCursor string        `xml:"next_cursor,attr,omitempty"`
+	Items      []*productXML `xml:"Product"`
+}
+
+// acceptsXML tells if the client prefers XML over JSON in the Accept header, JSON is the default
+func acceptsXML(c *gin.Context) bool {
+	return c.NegotiateFormat(gin.MIMEJSON, gin.MIMEXML, gin.MIMEXML2) != gin.MIMEJSON
+}
+
+// isXML tells if the request body is XML
+func isXML(c *gin.Context) bool {
+	return c.ContentType() == gin.MIMEXML || c.ContentType() == gin.MIMEXML2
+}
+
+// respond writes the response in the format negotiated with the client, products and product lists are written in XML with the SAF-T (PT) element names
+func respond(c *gin.Context, code int, v interface{}) {
+	if !acceptsXML(c) {
+		c.JSON(code, v)
+		return
+	}
+
+	switch res := v.(type) {
+	case *mresponse.ProductRead:
+		c.XML(code, newProductXML(res))
+	case *mresponse.ProductList:
+		c.XML(code, newProductListXML(res))
+	default:
+		c.XML(code, v)
+	}
+}
+
+// decodeProductCreate decodes the product to create from a JSON body, or from a SAF-T Product element if the body is XML
+func decodeProductCreate(c *gin.Context) (*mrequest.ProductCreate, *mresponse.ErrorResponse) {
+	pReq := mrequest.ProductCreate{}
+	if !isXML(c) {
+		json.NewDecoder(c.Request.Body).Decode(&pReq)
+		return &pReq, nil
+	}
+
+	p, e := decodeProductXML(c)
+	if e != nil {
+		return nil, e
+	}
+
+	pReq.Status = p.Status
+	pReq.ProductType = p.ProductType
+	pReq.ProductCode = p.ProductCode
+	pReq.ProductGroup = p.ProductGroup
+	pReq.ProductDescription = p.ProductDescription
+	pReq.ProductNumberCode = p.ProductNumberCode
+	pReq.CustomsDetails = customsDetailsFromXML(p.CustomsDetails)
+
+	return &pReq, nil
+}
+
+// decodeProductUpdate decodes the product replacing the current one from a JSON body, or from a SAF-T Product element if the body is XML
+func decodeProductUpdate(c *gin.Context) (*mrequest.ProductUpdate, *mresponse.ErrorResponse) {
+	pReq := mrequest.ProductUpdate{}
+	if !isXML(c) {
+		json.NewDecoder(c.Request.Body).Decode(&pReq)
+		return &pReq, nil
+	}
+
+	p, e := decodeProductXML(c)
+	if e != nil {
+		return nil, e
+	}
+
+	pReq.ProductType = p.ProductType
+	pReq.ProductCode = p.ProductCode
+	pReq.ProductGroup = p.ProductGroup
+	pReq.ProductDescription = p.ProductDescription
+	pReq.ProductNumberCode = p.ProductNumberCode
+	pReq.CustomsDetails = customsDetailsFromXML(p.CustomsDetails)
+
+	return &pReq, nil
+}
+
+func decodeProductXML(c *gin.Context) (*productXML, *mresponse.ErrorResponse) {
+	p := productXML{}
+	err := xml.NewDecoder(c.Request.Body).Decode(&p)
+	if err != nil {
+		details := []mresponse.ErrorDetail{
+			mresponse.ErrorDetail{
+				Property: "Product",
+				Message:  "Must be a SAF-T (PT) Product element: " + err.Error(),
+			},
+		}
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	return &p, nil
+}
+
+func newProductXML(p *mresponse.ProductRead) *productXML {
+	res := productXML{
+		ID:            p.ID,
+		Version:       p.Version,
+		Status:        p.Status,
+		ArchivedAt:    p.ArchivedAt,
+		ArchiveReason: p.ArchiveReason,
+		Product: msaft.Product{
+			ProductType:        p.ProductType,
+			ProductCode:        p.ProductCode,
+			ProductGroup:       p.ProductGroup,
+			ProductDescription: p.ProductDescription,
+			ProductNumberCode:  p.ProductNumberCode,
+		},
+	}
+
+	if p.CustomsDetails != nil {
+		res.CustomsDetails = &msaft.CustomsDetails{
+			CNCode:   p.CustomsDetails.CNCode,
+			UNNumber: p.CustomsDetails.UNNumber,
+		}
+	}
+
+	return &res
+}
+
+func newProductListXML(l *mresponse.ProductList) *productListXML {
+	res := productListXML{
+		Total:      l.Total,
+		PerPage:    l.PerPage,
+		Page:       l.Page,
+		NextCursor: l.NextCursor,
+		Items:      []*productXML{},
+	}
+
+	if l.Items != nil {
+		for _, p := range *l.Items {
+			res.Items = append(res.Items, newProductXML(p))
+		}
+	}
+
+	return &res
+}
+
+func customsDetailsFromXML(d *msaft.CustomsDetails) *mrequest.CustomsDetails {
+	if d == nil {
+		return nil
+	}
+
+	return &mrequest.CustomsDetails{
+		CNCode:   d.CNCode,
+		UNNumber: d.UNNumber,
+	}
+}
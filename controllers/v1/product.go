@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"products/models/request"
+	"products/models/response"
 	"products/services"
 	"products/util/errors"
 	"encoding/json"
@@ -23,45 +24,331 @@ func NewProductController(ps services.ProductServiceContract) *ProductController
 	}
 }
 
-// CreateAction creates a new product
+// CreateAction creates a new product, sent as JSON or as a SAF-T (PT) Product element with "Content-Type: application/xml"
 func (pc ProductController) CreateAction(c *gin.Context) {
-	pReq := mrequest.ProductCreate{}
+	pReq, e := decodeProductCreate(c)
+	if e != nil {
+		respond(c, e.HttpCode, e)
+		return
+	}
+
+	e = errors.ValidateRequest(pReq)
+	if e != nil {
+		respond(c, e.HttpCode, e)
+		return
+	}
+
+	pRes, err := pc.ProductService.CreateOne(pReq)
+
+	if err != nil {
+		respond(c, err.HttpCode, err)
+		return
+	}
+
+	respond(c, 200, pRes)
+}
+
+// ReadAction returns the product with the id provided in the URL.
+// An "as_of" query param returns the product as it existed at that moment.
+// "fields" or "exclude" query params return only some of the product fields (e.g. fields=ProductCode,ProductDescription).
+// With "Accept: application/xml" the product is returned as a SAF-T (PT) Product element, always with all its fields as SAF-T requires them.
+func (pc ProductController) ReadAction(c *gin.Context) {
+	asOf, e := mrequest.ParseAsOf(c.Request.URL.Query())
+	if e != nil {
+		respond(c, e.HttpCode, e)
+		return
+	}
+
+	projection, e := mrequest.NewProjection(c.Request.URL.Query())
+	if e != nil {
+		respond(c, e.HttpCode, e)
+		return
+	}
+
+	// SAF-T requires all the product fields
+	if acceptsXML(c) {
+		projection = nil
+	}
+
+	res, err := pc.ProductService.ReadOne(c.Param("id"), asOf, projection)
+
+	if err != nil {
+		respond(c, err.HttpCode, err)
+		return
+	}
+
+	tag := representationTag(res.Version, representation(c, asOf, projection))
+	c.Header("ETag", tag)
+	c.Header("Vary", "Accept")
+
+	if noneMatch(c, tag) {
+		c.Status(304)
+		return
+	}
+
+	if projection != nil {
+		c.JSON(200, projectProduct(res, projection))
+		return
+	}
+
+	respond(c, 200, res)
+}
+
+// UpdateAction replaces the product with the id provided in the URL.
+// The If-Match header must hold the ETag of the product version being replaced.
+// Like on CreateAction the product can be sent as a SAF-T (PT) Product element.
+func (pc ProductController) UpdateAction(c *gin.Context) {
+	version, e := pc.ifMatchVersion(c)
+	if e != nil {
+		respond(c, e.HttpCode, e)
+		return
+	}
+
+	pReq, e := decodeProductUpdate(c)
+	if e != nil {
+		respond(c, e.HttpCode, e)
+		return
+	}
+
+	e = errors.ValidateRequest(pReq)
+	if e != nil {
+		respond(c, e.HttpCode, e)
+		return
+	}
+
+	res, err := pc.ProductService.UpdateOne(c.Param("id"), version, pReq)
+
+	if err != nil {
+		respond(c, err.HttpCode, err)
+		return
+	}
+
+	c.Header("ETag", etag(res.Version))
+	respond(c, 200, res)
+}
+
+// PatchAction changes only the provided fields of the product with the id provided in the URL.
+// The If-Match header must hold the ETag of the product version being changed.
+func (pc ProductController) PatchAction(c *gin.Context) {
+	version, e := pc.ifMatchVersion(c)
+	if e != nil {
+		respond(c, e.HttpCode, e)
+		return
+	}
+
+	pReq := mrequest.ProductPatch{}
 	json.NewDecoder(c.Request.Body).Decode(&pReq)
 
-	e := errors.ValidateRequest(&pReq)
+	res, err := pc.ProductService.PatchOne(c.Param("id"), version, &pReq)
+
+	if err != nil {
+		respond(c, err.HttpCode, err)
+		return
+	}
+
+	c.Header("ETag", etag(res.Version))
+	respond(c, 200, res)
+}
+
+// DeleteAction archives the product with the id provided in the URL, with an optional "reason" query param.
+// The If-Match header must hold the ETag of the product version being archived.
+func (pc ProductController) DeleteAction(c *gin.Context) {
+	version, e := pc.ifMatchVersion(c)
+	if e != nil {
+		respond(c, e.HttpCode, e)
+		return
+	}
+
+	res, err := pc.ProductService.ArchiveOne(c.Param("id"), version, c.Query("reason"))
+
+	if err != nil {
+		respond(c, err.HttpCode, err)
+		return
+	}
+
+	c.Header("ETag", etag(res.Version))
+	respond(c, 200, res)
+}
+
+// RestoreAction brings back the archived product with the id provided in the URL.
+// The If-Match header must hold the ETag of the archived product version.
+func (pc ProductController) RestoreAction(c *gin.Context) {
+	version, e := pc.ifMatchVersion(c)
+	if e != nil {
+		respond(c, e.HttpCode, e)
+		return
+	}
+
+	res, err := pc.ProductService.RestoreOne(c.Param("id"), version)
+
+	if err != nil {
+		respond(c, err.HttpCode, err)
+		return
+	}
+
+	c.Header("ETag", etag(res.Version))
+	respond(c, 200, res)
+}
+
+// ActivateAction moves the product with the id provided in the URL to the active status
+func (pc ProductController) ActivateAction(c *gin.Context) {
+	pc.changeStatus(c, mresponse.StatusActive)
+}
+
+// DiscontinueAction moves the product with the id provided in the URL to the discontinued status
+func (pc ProductController) DiscontinueAction(c *gin.Context) {
+	pc.changeStatus(c, mresponse.StatusDiscontinued)
+}
+
+// BlockAction moves the product with the id provided in the URL to the blocked status
+func (pc ProductController) BlockAction(c *gin.Context) {
+	pc.changeStatus(c, mresponse.StatusBlocked)
+}
+
+// changeStatus performs a product status transition.
+// The If-Match header must hold the ETag of the product version being changed.
+func (pc ProductController) changeStatus(c *gin.Context, status string) {
+	version, e := pc.ifMatchVersion(c)
+	if e != nil {
+		respond(c, e.HttpCode, e)
+		return
+	}
+
+	res, err := pc.ProductService.ChangeStatus(c.Param("id"), version, status)
+
+	if err != nil {
+		respond(c, err.HttpCode, err)
+		return
+	}
+
+	c.Header("ETag", etag(res.Version))
+	respond(c, 200, res)
+}
+
+// ListAction list products, archived products are only listed with "include_archived=true" or "only_archived=true".
+// Filters have the format Field[operator]=value (e.g. ProductCode[prefix]=ABC, ProductType[in]=P,S)
+// and sort is a list of fields, descending if prefixed by "-" (e.g. sort=ProductGroup,-ProductCode).
+// Products of a product group and all its subgroups are listed with "group_subtree=<code>"
+func (pc ProductController) ListAction(c *gin.Context) {
+	qValues := c.Request.URL.Query()
+	req, e := mrequest.NewListRequest(qValues, validSorts(), validFilters())
+	if e != nil {
+		respond(c, e.HttpCode, e)
+		return
+	}
+
+	// SAF-T requires all the product fields
+	if acceptsXML(c) {
+		req.Projection = nil
+	}
+
+	res, err := pc.ProductService.List(req)
+
+	if err != nil {
+		respond(c, err.HttpCode, err)
+		return
+	}
+
+	if req.Projection != nil {
+		c.JSON(200, projectList(res, req.Projection))
+		return
+	}
+
+	respond(c, 200, res)
+}
+
+// FacetsAction returns the counts of the listed products by ProductType and ProductGroup and how many have CN codes and UN numbers.
+// It accepts the same filters as ListAction, e.g. /facets?ProductGroup[prefix]=Ferragens&status=active
+func (pc ProductController) FacetsAction(c *gin.Context) {
+	req, e := mrequest.NewListRequest(c.Request.URL.Query(), validSorts(), validFilters())
 	if e != nil {
 		c.JSON(e.HttpCode, e)
 		return
 	}
 
-	pRes, err := pc.ProductService.CreateOne(&pReq)
+	res, err := pc.ProductService.Facets(req)
 
 	if err != nil {
 		c.JSON(err.HttpCode, err)
 		return
 	}
 
-	c.JSON(200, pRes)
+	c.JSON(200, res)
 }
 
-// ListAction list products
-func (pc ProductController) ListAction(c *gin.Context) {
+// GroupsAction returns the product groups in use with the number of products in each one,
+// optionally only the ones starting with a prefix (e.g. /groups?prefix=ferr)
+func (pc ProductController) GroupsAction(c *gin.Context) {
+	req, e := mrequest.NewDistinctRequest("ProductGroup", c.Request.URL.Query(), true)
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	pc.distinct(c, req)
+}
+
+// DistinctAction returns the distinct values of a product field, with their number of products if with_counts=true
+// (e.g. /distinct?field=ProductType&with_counts=true)
+func (pc ProductController) DistinctAction(c *gin.Context) {
+	req, e := mrequest.NewDistinctRequest(c.Query("field"), c.Request.URL.Query(), false)
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	pc.distinct(c, req)
+}
+
+// distinct responds with the distinct values of the requested product field
+func (pc ProductController) distinct(c *gin.Context, req *mrequest.DistinctRequest) {
+	res, err := pc.ProductService.Distinct(req)
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// validSorts are the fields products can be listed by
+func validSorts() map[string]string {
 	validSorts := map[string]string{}
 	validSorts["ProductNumberCode"]="ProductNumberCode"
 	validSorts["ProductCode"]="ProductCode"
 	validSorts["ProductDescription"]="ProductDescription"
+	validSorts["ProductGroup"]="ProductGroup"
+	validSorts["ProductType"]="ProductType"
 	validSorts["_id"]="_id"
 
+	return validSorts
+}
+
+// validFilters are the fields products can be filtered by
+func validFilters() map[string]string {
 	validFilters := map[string]string{}
 	validFilters["ProductNumberCode"]="ProductNumberCode"
 	validFilters["ProductCode"]="ProductCode"
 	validFilters["ProductDescription"]="ProductDescription"
+	validFilters["ProductType"]="ProductType"
+	validFilters["ProductGroup"]="ProductGroup"
+	validFilters["CustomsDetails.CNCode"]="CustomsDetails.CNCode"
 	validFilters["_id"]="_id"
 
-	qValues := c.Request.URL.Query()
-	req := mrequest.NewListRequest(qValues, validSorts, validFilters)
+	return validFilters
+}
 
-	res, err := pc.ProductService.List(req)
+// SearchAction runs a full text search on products, results are sorted by relevance and have the matching terms highlighted.
+// Searching is stemmed and accent insensitive for Portuguese (e.g. q=parafusos inox matches "Parafuso em aço inóx")
+func (pc ProductController) SearchAction(c *gin.Context) {
+	req, e := mrequest.NewSearchRequest(c.Request.URL.Query())
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	res, err := pc.ProductService.Search(req)
 
 	if err != nil {
 		c.JSON(err.HttpCode, err)
@@ -70,3 +357,39 @@ func (pc ProductController) ListAction(c *gin.Context) {
 
 	c.JSON(200, res)
 }
+
+// SuggestAction returns type-ahead suggestions, the products with a code or description word starting with the prefix words
+func (pc ProductController) SuggestAction(c *gin.Context) {
+	req, e := mrequest.NewSuggestRequest(c.Request.URL.Query())
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	res, err := pc.ProductService.Suggest(req)
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// SaftExportAction returns the SAF-T (PT) MasterFiles products section for a fiscal period
+func (pc ProductController) SaftExportAction(c *gin.Context) {
+	req, e := mrequest.NewSaftExport(c.Request.URL.Query())
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	res, err := pc.ProductService.ExportSaft(req)
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.XML(200, res)
+}
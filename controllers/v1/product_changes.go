@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"products/models/request"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// changesHeartbeat is how often an idle change feed is kept alive, so proxies don't close the connection
+const changesHeartbeat = 15 * time.Second
+
+var changesUpgrader = websocket.Upgrader{
+	// terminals connect from any origin, the API has no cookies to be protected from other sites
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// ChangesAction streams the product inserts, updates and deletes as Server-Sent Events, e.g. GET /api/v1/product/changes
+// Events have the change token as id and the operation as type. Reconnecting clients send the last id in the
+// Last-Event-ID header (or the token query param) and get the changes that happened meanwhile.
+func (pc ProductController) ChangesAction(c *gin.Context) {
+	token := c.GetHeader("Last-Event-ID")
+	if token == "" {
+		token = c.Query("token")
+	}
+
+	req, e := mrequest.NewChangesRequest(token)
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	changes, err := pc.ProductService.Watch(c.Request.Context(), req)
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(200)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(changesHeartbeat)
+	defer heartbeat.Stop()
+
+	// the feed ends when the client is gone or the change stream fails, then clients reconnect
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(change)
+			fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", change.Token, change.Operation, data)
+
+		case <-heartbeat.C:
+			io.WriteString(c.Writer, ": keep-alive\n\n")
+		}
+
+		c.Writer.Flush()
+	}
+}
+
+// ChangesWebSocketAction streams the product changes over a WebSocket, each change as a JSON text message,
+// e.g. GET /api/v1/product/changes/ws?token=...
+// Reconnecting clients send the token of the last change they got and get the changes that happened meanwhile.
+func (pc ProductController) ChangesWebSocketAction(c *gin.Context) {
+	req, e := mrequest.NewChangesRequest(c.Query("token"))
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	// hijacked connections are not cancelled by the server, the feed is stopped when the socket is closed
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	changes, err := pc.ProductService.Watch(ctx, req)
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	conn, uerr := changesUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if uerr != nil {
+		return
+	}
+	defer conn.Close()
+
+	// clients only send control messages, reading them answers pings and detects when the socket is closed
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(changesHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "change feed ended"), time.Now().Add(time.Second))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(changesHeartbeat))
+			if err := conn.WriteJSON(change); err != nil {
+				return
+			}
+
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(changesHeartbeat)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"products/models/request"
+	"products/models/response"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportFlushRows is how many products are written before flushing them to the client
+const exportFlushRows = 100
+
+// ExportAction streams all the listed products as a CSV or NDJSON file, with the columns in the order they're selected, e.g.
+// GET /api/v1/product/export?format=ndjson&columns=id,ProductCode,CNCode&status=active
+// Products are filtered and sorted like in ListAction, but there's no pagination.
+func (pc ProductController) ExportAction(c *gin.Context) {
+	req, e := mrequest.NewExportRequest(c.Request.URL.Query(), validSorts(), validFilters())
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	w := exportWriter{c: c, req: req}
+
+	err := pc.ProductService.Export(req.List, w.write)
+
+	if err != nil && !w.started {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	// the response status was already sent, the client gets a truncated file
+	if err != nil {
+		log.Printf("Export interrupted after %d products: %s\n", w.rows, err.Response)
+		return
+	}
+
+	w.close()
+}
+
+// exportWriter writes the exported products to the response, it starts when the first product is written
+type exportWriter struct {
+	c       *gin.Context
+	req     *mrequest.ExportRequest
+	csv     *csv.Writer
+	started bool
+	rows    int
+}
+
+func (this *exportWriter) start() {
+	extension, contentType := "csv", "text/csv; charset=utf-8"
+	if this.req.Format == mrequest.ExportNDJSON {
+		extension, contentType = "ndjson", "application/x-ndjson"
+	}
+
+	this.c.Header("Content-Type", contentType)
+	this.c.Header("Content-Disposition", `attachment; filename="products.`+extension+`"`)
+	this.c.Status(200)
+
+	if this.req.Format == mrequest.ExportCSV {
+		this.csv = csv.NewWriter(this.c.Writer)
+		this.csv.Write(this.req.Columns)
+	}
+
+	this.started = true
+}
+
+func (this *exportWriter) write(p *mresponse.ProductRead) error {
+	if !this.started {
+		this.start()
+	}
+
+	var err error
+	if this.csv != nil {
+		err = this.writeCSV(p)
+	} else {
+		err = this.writeNDJSON(p)
+	}
+	if err != nil {
+		return err
+	}
+
+	this.rows++
+	if this.rows%exportFlushRows == 0 {
+		this.flush()
+	}
+
+	return nil
+}
+
+func (this *exportWriter) writeCSV(p *mresponse.ProductRead) error {
+	record := make([]string, len(this.req.Columns))
+	for i, column := range this.req.Columns {
+		switch value := exportValue(p, column).(type) {
+		case []string:
+			record[i] = strings.Join(value, this.req.ValuesDelimiter)
+		case int64:
+			record[i] = strconv.FormatInt(value, 10)
+		case *time.Time:
+			if value != nil {
+				record[i] = value.Format(time.RFC3339)
+			}
+		case string:
+			record[i] = value
+		}
+	}
+
+	this.csv.Write(record)
+
+	return this.csv.Error()
+}
+
+// writeNDJSON writes the product as a JSON object in one line, with the columns as keys in their order
+func (this *exportWriter) writeNDJSON(p *mresponse.ProductRead) error {
+	line := bytes.NewBufferString("{")
+	for i, column := range this.req.Columns {
+		if i > 0 {
+			line.WriteString(",")
+		}
+
+		key, _ := json.Marshal(column)
+		value, err := json.Marshal(exportValue(p, column))
+		if err != nil {
+			return err
+		}
+
+		line.Write(key)
+		line.WriteString(":")
+		line.Write(value)
+	}
+	line.WriteString("}\n")
+
+	_, err := this.c.Writer.Write(line.Bytes())
+
+	return err
+}
+
+func (this *exportWriter) flush() {
+	if this.csv != nil {
+		this.csv.Flush()
+	}
+	this.c.Writer.Flush()
+}
+
+// close writes the headers of an empty export and flushes the last products
+func (this *exportWriter) close() {
+	if !this.started {
+		this.start()
+	}
+
+	this.flush()
+}
+
+// exportValue returns the value of a product on an export column, CN codes and UN numbers are never nil
+func exportValue(p *mresponse.ProductRead, column string) interface{} {
+	switch column {
+	case "id":
+		return p.ID
+	case "version":
+		return p.Version
+	case "status":
+		return p.Status
+	case "ProductType":
+		return p.ProductType
+	case "ProductCode":
+		return p.ProductCode
+	case "ProductGroup":
+		return p.ProductGroup
+	case "ProductDescription":
+		return p.ProductDescription
+	case "ProductNumberCode":
+		return p.ProductNumberCode
+	case "CustomsDetails.CNCode", "CustomsDetails.UNNumber":
+		values := []string{}
+		if p.CustomsDetails != nil && column == "CustomsDetails.CNCode" {
+			values = append(values, p.CustomsDetails.CNCode...)
+		}
+		if p.CustomsDetails != nil && column == "CustomsDetails.UNNumber" {
+			values = append(values, p.CustomsDetails.UNNumber...)
+		}
+		return values
+	case "archived_at":
+		return p.ArchivedAt
+	case "archive_reason":
+		return p.ArchiveReason
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"encoding/json"
+	"products/models/request"
+	"products/services"
+	"products/util/errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// ProductGroupController represents the controller for operating on the product groups resource
+	ProductGroupController struct {
+		ProductGroupService services.ProductGroupServiceContract
+	}
+)
+
+// NewProductGroupController is the constructor of ProductGroupController
+func NewProductGroupController(gs services.ProductGroupServiceContract) *ProductGroupController {
+	return &ProductGroupController{
+		ProductGroupService: gs,
+	}
+}
+
+// CreateAction creates a new product group, placed under the group with the "parent" code if provided
+func (gc ProductGroupController) CreateAction(c *gin.Context) {
+	gReq := mrequest.ProductGroupCreate{}
+	json.NewDecoder(c.Request.Body).Decode(&gReq)
+
+	e := errors.ValidateRequest(&gReq)
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	gRes, err := gc.ProductGroupService.CreateOne(&gReq)
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, gRes)
+}
+
+// ReadAction returns the product group with the code provided in the URL
+func (gc ProductGroupController) ReadAction(c *gin.Context) {
+	res, err := gc.ProductGroupService.ReadOne(c.Param("code"))
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// ListAction lists all product groups, or only the children of the "parent" query param (root groups if it's empty)
+func (gc ProductGroupController) ListAction(c *gin.Context) {
+	var parent *string
+	if p, ok := c.GetQuery("parent"); ok {
+		parent = &p
+	}
+
+	res, err := gc.ProductGroupService.List(parent)
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// UpdateAction renames a product group or moves it, with its subgroups, under another parent
+func (gc ProductGroupController) UpdateAction(c *gin.Context) {
+	gReq := mrequest.ProductGroupUpdate{}
+	json.NewDecoder(c.Request.Body).Decode(&gReq)
+
+	res, err := gc.ProductGroupService.UpdateOne(c.Param("code"), &gReq)
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// DeleteAction removes a product group, only if it has no subgroups nor products
+func (gc ProductGroupController) DeleteAction(c *gin.Context) {
+	err := gc.ProductGroupService.DeleteOne(c.Param("code"))
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.Status(204)
+}
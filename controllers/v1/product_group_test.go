@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"products/models/request"
+	"products/models/response"
+	"products/util/errors"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stub ProductGroupService behaviour
+type MockProductGroupService struct {
+	parent *string
+}
+
+func (gs *MockProductGroupService) CreateOne(request *mrequest.ProductGroupCreate) (*mresponse.ProductGroup, *mresponse.ErrorResponse) {
+	if request.Parent == "unknown" {
+		details := []mresponse.ErrorDetail{{Property: "parent", Message: "Must be the code of an existing product group"}}
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	return &mresponse.ProductGroup{ID: "some-unique-id", Code: request.Code, Name: request.Name, Parent: request.Parent}, nil
+}
+
+func (gs *MockProductGroupService) ReadOne(code string) (*mresponse.ProductGroup, *mresponse.ErrorResponse) {
+	if code != "tools" {
+		return nil, errors.HandleErrorResponse(errors.NOT_FOUND, nil, "Product group not found")
+	}
+
+	return &mresponse.ProductGroup{ID: "some-unique-id", Code: code, Name: "Tools", Parent: "hardware", Ancestors: []string{"hardware"}}, nil
+}
+
+func (gs *MockProductGroupService) List(parent *string) (*mresponse.ProductGroupList, *mresponse.ErrorResponse) {
+	gs.parent = parent
+
+	items := []*mresponse.ProductGroup{}
+	return &mresponse.ProductGroupList{Items: &items}, nil
+}
+
+func (gs *MockProductGroupService) UpdateOne(code string, request *mrequest.ProductGroupUpdate) (*mresponse.ProductGroup, *mresponse.ErrorResponse) {
+	return &mresponse.ProductGroup{ID: "some-unique-id", Code: code, Name: request.Name, Parent: request.Parent}, nil
+}
+
+func (gs *MockProductGroupService) DeleteOne(code string) *mresponse.ErrorResponse {
+	if code == "hardware" {
+		return errors.HandleErrorResponse(errors.CONFLICT, nil, "Product group has subgroups, move or delete them first")
+	}
+
+	return nil
+}
+
+func (gs *MockProductGroupService) Subtree(code string) ([]string, *mresponse.ErrorResponse) {
+	return []string{code}, nil
+}
+
+func (gs *MockProductGroupService) ValidateReference(code string) *mresponse.ErrorResponse {
+	return nil
+}
+
+func TestCreateProductGroupAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	gc := NewProductGroupController(&MockProductGroupService{})
+
+	r := gin.Default()
+
+	r.POST("/api/v1/product-group", gc.CreateAction)
+
+	// TEST MISSING NAME
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/product-group", bytes.NewBufferString(`{"code":"drills"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	// TEST UNKNOWN PARENT
+
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/product-group", bytes.NewBufferString(`{"code":"drills","name":"Drills","parent":"unknown"}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"parent"`) {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	// TEST SUCCESS
+
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/product-group", bytes.NewBufferString(`{"code":"drills","name":"Drills","parent":"tools"}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+}
+
+func TestListProductGroupAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	gs := &MockProductGroupService{}
+	gc := NewProductGroupController(gs)
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product-group", gc.ListAction)
+
+	expected := map[string]*string{
+		"":              nil,
+		"?parent=":      new(string),
+		"?parent=tools": func() *string { s := "tools"; return &s }(),
+	}
+
+	for query, parent := range expected {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/product-group"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		if (parent == nil) != (gs.parent == nil) || (parent != nil && *parent != *gs.parent) {
+			t.Fatalf("Unexpected parent for %q", query)
+		}
+	}
+}
+
+func TestReadAndDeleteProductGroupActions(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	gc := NewProductGroupController(&MockProductGroupService{})
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product-group/:code", gc.ReadAction)
+	r.DELETE("/api/v1/product-group/:code", gc.DeleteAction)
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/product-group/unknown", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusNotFound, w.Code, w.Body.String())
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, "/api/v1/product-group/hardware", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusConflict, w.Code, w.Body.String())
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, "/api/v1/product-group/tools", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+}
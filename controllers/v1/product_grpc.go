@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"context"
+	"net/url"
+	"products/models/request"
+	"products/models/response"
+	"products/proto/productpb"
+	"products/services"
+	"products/util/errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// grpcCodes maps the App error codes to gRPC status codes, unknown codes are Internal
+var grpcCodes = map[string]codes.Code{
+	errors.SERVICE_UNAVAILABLE:   codes.Unavailable,
+	errors.INVALID_REQUEST:       codes.InvalidArgument,
+	errors.EMPTY:                 codes.InvalidArgument,
+	errors.DUPLICATED_ENTITY:     codes.AlreadyExists,
+	errors.UNAUTHORIZED:          codes.Unauthenticated,
+	errors.NOT_FOUND:             codes.NotFound,
+	errors.PRECONDITION_FAILED:   codes.FailedPrecondition,
+	errors.PRECONDITION_REQUIRED: codes.FailedPrecondition,
+	errors.CONFLICT:              codes.FailedPrecondition,
+	errors.IDEMPOTENCY_MISMATCH:  codes.FailedPrecondition,
+}
+
+type (
+	// ProductGRPCController implements the gRPC ProductService, with the same rules of ProductController
+	ProductGRPCController struct {
+		productpb.UnimplementedProductServiceServer
+		ProductService services.ProductServiceContract
+	}
+)
+
+// NewProductGRPCController is the constructor of ProductGRPCController
+func NewProductGRPCController(ps services.ProductServiceContract) *ProductGRPCController {
+	return &ProductGRPCController{
+		ProductService: ps,
+	}
+}
+
+// CreateProduct creates a new product
+func (rc *ProductGRPCController) CreateProduct(ctx context.Context, in *productpb.ProductCreate) (*productpb.CreateProductResponse, error) {
+	pReq := productCreateFromPB(in)
+
+	e := errors.ValidateRequest(pReq)
+	if e != nil {
+		return nil, grpcError(e)
+	}
+
+	res, err := rc.ProductService.CreateOne(pReq)
+
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &productpb.CreateProductResponse{Id: res.ID}, nil
+}
+
+// CreateProducts creates many products in one bulk operation, none is created if any is invalid.
+// Properties of error details are prefixed by the index of the product, e.g. [3].ProductCode
+func (rc *ProductGRPCController) CreateProducts(ctx context.Context, in *productpb.CreateProductsRequest) (*productpb.CreateProductsResponse, error) {
+	pReqs := []*mrequest.ProductCreate{}
+	details := []mresponse.ErrorDetail{}
+
+	for i, p := range in.Products {
+		pReq := productCreateFromPB(p)
+
+		e := errors.ValidateRequest(pReq)
+		if e != nil {
+			for _, d := range e.Errors {
+				d.Property = "[" + strconv.Itoa(i) + "]." + d.Property
+				details = append(details, d)
+			}
+		}
+
+		pReqs = append(pReqs, pReq)
+	}
+
+	if len(details) != 0 {
+		return nil, grpcError(errors.HandleErrorResponse(errors.INVALID_REQUEST, details, ""))
+	}
+
+	res, err := rc.ProductService.CreateMany(&pReqs)
+
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	ids := []string{}
+	for _, p := range *res {
+		ids = append(ids, p.ID)
+	}
+
+	return &productpb.CreateProductsResponse{Ids: ids}, nil
+}
+
+// GetProduct returns the product with the provided id, as it existed at as_of if provided
+func (rc *ProductGRPCController) GetProduct(ctx context.Context, in *productpb.GetProductRequest) (*productpb.Product, error) {
+	var asOf *time.Time
+	if in.AsOf != nil {
+		t := in.AsOf.AsTime()
+		asOf = &t
+	}
+
+	res, err := rc.ProductService.ReadOne(in.Id, asOf, nil)
+
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return productToPB(res), nil
+}
+
+// ListProducts returns a page of products, the request is validated like the query params of ListAction
+func (rc *ProductGRPCController) ListProducts(ctx context.Context, in *productpb.ListRequest) (*productpb.ProductList, error) {
+	req, e := mrequest.NewListRequest(listParams(in), validSorts(), validFilters())
+	if e != nil {
+		return nil, grpcError(e)
+	}
+
+	res, err := rc.ProductService.List(req)
+
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	list := productpb.ProductList{
+		PerPage:    res.PerPage,
+		Page:       res.Page,
+		NextCursor: res.NextCursor,
+		Items:      []*productpb.Product{},
+	}
+
+	if res.Total != nil {
+		list.Total = wrapperspb.Int64(*res.Total)
+	}
+
+	if res.Items != nil {
+		for _, p := range *res.Items {
+			list.Items = append(list.Items, productToPB(p))
+		}
+	}
+
+	return &list, nil
+}
+
+// ExportProducts streams all the listed products, pagination fields of the request are ignored
+func (rc *ProductGRPCController) ExportProducts(in *productpb.ListRequest, stream productpb.ProductService_ExportProductsServer) error {
+	params := listParams(in)
+	params.Del("cursor")
+
+	req, e := mrequest.NewListRequest(params, validSorts(), validFilters())
+	if e != nil {
+		return grpcError(e)
+	}
+
+	err := rc.ProductService.Export(req, func(p *mresponse.ProductRead) error {
+		return stream.Send(productToPB(p))
+	})
+
+	if err != nil {
+		return grpcError(err)
+	}
+
+	return nil
+}
+
+// grpcError returns the gRPC status of an error response, with the error response in its details
+func grpcError(e *mresponse.ErrorResponse) error {
+	code, ok := grpcCodes[e.Code]
+	if !ok {
+		code = codes.Internal
+	}
+
+	res := productpb.ErrorResponse{Code: e.Code, Response: e.Response}
+	for _, d := range e.Errors {
+		res.Errors = append(res.Errors, &productpb.ErrorDetail{Property: d.Property, Message: d.Message})
+	}
+
+	st, err := status.New(code, e.Response).WithDetails(&res)
+	if err != nil {
+		return status.Error(code, e.Response)
+	}
+
+	return st.Err()
+}
+
+// listParams returns the query params of ListAction for a gRPC list request
+func listParams(in *productpb.ListRequest) url.Values {
+	params := url.Values{}
+
+	if in.Page != 0 {
+		params.Set("page", strconv.Itoa(int(in.Page)))
+	}
+	if in.PerPage != 0 {
+		params.Set("per_page", strconv.Itoa(int(in.PerPage)))
+	}
+	if in.Sort != "" {
+		params.Set("sort", in.Sort)
+	}
+	if in.Order != "" {
+		params.Set("order", in.Order)
+	}
+	for filter, value := range in.Filters {
+		params.Set(filter, value)
+	}
+	if len(in.Status) != 0 {
+		params.Set("status", strings.Join(in.Status, ","))
+	}
+	if in.IncludeArchived {
+		params.Set("include_archived", "true")
+	}
+	if in.OnlyArchived {
+		params.Set("only_archived", "true")
+	}
+	if in.GroupSubtree != "" {
+		params.Set("group_subtree", in.GroupSubtree)
+	}
+	if in.AsOf != nil {
+		params.Set("as_of", in.AsOf.AsTime().Format(time.RFC3339Nano))
+	}
+	if in.CursorMode {
+		params.Set("cursor", in.Cursor)
+	}
+	if in.SkipTotal {
+		params.Set("with_total", "false")
+	}
+
+	return params
+}
+
+func productCreateFromPB(p *productpb.ProductCreate) *mrequest.ProductCreate {
+	pReq := mrequest.ProductCreate{
+		Status:             p.Status,
+		ProductType:        p.ProductType,
+		ProductCode:        p.ProductCode,
+		ProductGroup:       p.ProductGroup,
+		ProductDescription: p.ProductDescription,
+		ProductNumberCode:  p.ProductNumberCode,
+	}
+
+	if p.CustomsDetails != nil {
+		pReq.CustomsDetails = &mrequest.CustomsDetails{
+			CNCode:   p.CustomsDetails.CnCode,
+			UNNumber: p.CustomsDetails.UnNumber,
+		}
+	}
+
+	return &pReq
+}
+
+func productToPB(p *mresponse.ProductRead) *productpb.Product {
+	res := productpb.Product{
+		Id:                 p.ID,
+		Version:            p.Version,
+		Status:             p.Status,
+		ProductType:        p.ProductType,
+		ProductCode:        p.ProductCode,
+		ProductGroup:       p.ProductGroup,
+		ProductDescription: p.ProductDescription,
+		ProductNumberCode:  p.ProductNumberCode,
+		ArchiveReason:      p.ArchiveReason,
+	}
+
+	if p.CustomsDetails != nil {
+		res.CustomsDetails = &productpb.CustomsDetails{
+			CnCode:   p.CustomsDetails.CNCode,
+			UnNumber: p.CustomsDetails.UNNumber,
+		}
+	}
+
+	if p.ArchivedAt != nil {
+		res.ArchivedAt = timestamppb.New(*p.ArchivedAt)
+	}
+
+	return &res
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+	"products/models/response"
+	"products/proto/productpb"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stub ExportProducts server stream, collecting the sent products
+type mockExportStream struct {
+	grpc.ServerStream
+	products []*productpb.Product
+}
+
+func (s *mockExportStream) Send(p *productpb.Product) error {
+	s.products = append(s.products, p)
+	return nil
+}
+
+func TestProductGRPCController(t *testing.T) {
+
+	rc := NewProductGRPCController(&MockProductService{})
+
+	// TEST CREATE WITH ERROR DETAILS
+
+	_, err := rc.CreateProduct(context.Background(), &productpb.ProductCreate{ProductType: "X", ProductCode: "PRF-001"})
+
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument || len(st.Details()) != 1 {
+		t.Fatalf("Expected an invalid argument status with details but got %v", err)
+	}
+
+	details := st.Details()[0].(*productpb.ErrorResponse)
+	if details.Code != "INVALID_REQUEST" || len(details.Errors) == 0 {
+		t.Fatalf("Unexpected error response %v", details)
+	}
+
+	created, err := rc.CreateProduct(context.Background(), &productpb.ProductCreate{
+		ProductType:        "P",
+		ProductCode:        "PRF-001",
+		ProductDescription: "Parafuso inox",
+		ProductNumberCode:  "R1",
+		CustomsDetails:     &productpb.CustomsDetails{CnCode: []string{"7318"}},
+	})
+	if err != nil || created.Id != "some-unique-id" {
+		t.Fatalf("Expected the product to be created but got %v", err)
+	}
+
+	// TEST BULK CREATE WITH INDEXED ERRORS
+
+	_, err = rc.CreateProducts(context.Background(), &productpb.CreateProductsRequest{
+		Products: []*productpb.ProductCreate{
+			&productpb.ProductCreate{ProductType: "P", ProductCode: "PRF-001", ProductDescription: "Parafuso inox", ProductNumberCode: "R1"},
+			&productpb.ProductCreate{ProductType: "P", ProductDescription: "Porca", ProductNumberCode: "R2"},
+		},
+	})
+
+	details = status.Convert(err).Details()[0].(*productpb.ErrorResponse)
+	if details.Errors[0].Property != "[1].ProductCode" {
+		t.Fatalf("Unexpected error details %v", details.Errors)
+	}
+
+	// TEST GET
+
+	_, err = rc.GetProduct(context.Background(), &productpb.GetProductRequest{Id: "unknown-id"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected a not found status but got %v", err)
+	}
+
+	p, err := rc.GetProduct(context.Background(), &productpb.GetProductRequest{Id: "507f191e810c19729de860ea"})
+	if err != nil || p.Version != 3 || p.ProductDescription != "current-description" {
+		t.Fatalf("Unexpected product %v %v", p, err)
+	}
+
+	// TEST LIST
+
+	_, err = rc.ListProducts(context.Background(), &productpb.ListRequest{Page: 1, PerPage: 10, Sort: "Unknown"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected an invalid argument status but got %v", err)
+	}
+
+	list, err := rc.ListProducts(context.Background(), &productpb.ListRequest{Page: 1, PerPage: 10, Sort: "id", Order: "normal"})
+	if err != nil || len(list.Items) != 2 || list.Items[0].Id != "some-id-1" || list.Total != nil {
+		t.Fatalf("Unexpected list %v %v", list, err)
+	}
+
+	// TEST EXPORT STREAM
+
+	stream := &mockExportStream{}
+	err = rc.ExportProducts(&productpb.ListRequest{Page: 3, Filters: map[string]string{"ProductCode[prefix]": "PR"}}, stream)
+	if err != nil || len(stream.products) != 2 || stream.products[0].CustomsDetails.CnCode[1] != "7319" || stream.products[1].ArchivedAt == nil {
+		t.Fatalf("Unexpected exported products %v %v", stream.products, err)
+	}
+
+	err = rc.ExportProducts(&productpb.ListRequest{Status: []string{mresponse.StatusBlocked}}, stream)
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("Expected an unavailable status but got %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"io"
+	"mime"
+	"products/models/request"
+	"products/models/response"
+	"products/util/errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImportCSVAction creates products from a CSV or TSV file, sent as request body or as the "file" field of a multipart form.
+// Columns are mapped to product fields with the options of mrequest.NewCSVImport, e.g.
+// POST /api/v1/product/import/csv?delimiter=semicolon&encoding=windows-1252&map[Código]=ProductCode&map[NC]=CNCode&dry_run=true
+// The response summarizes the import with the errors of each invalid row, with "report=csv" only the errors are sent as a CSV file.
+func (pc ProductController) ImportCSVAction(c *gin.Context) {
+	body, contentType, _, e := importBody(c)
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	req, e := mrequest.NewCSVImport(c.Request.URL.Query(), contentType)
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	res, err := pc.ProductService.ImportCSV(body, req)
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	if req.Report == mrequest.ReportCSV {
+		writeImportReport(c, res, req.Delimiter)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// importBody returns the file to import, its content type and its name if sent in a multipart form,
+// reading multipart forms as a stream instead of parsing them into memory
+func importBody(c *gin.Context) (io.Reader, string, string, *mresponse.ErrorResponse) {
+	if c.ContentType() != "multipart/form-data" {
+		return c.Request.Body, c.ContentType(), "", nil
+	}
+
+	form, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, "", "", errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, err.Error())
+	}
+
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", "", errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, err.Error())
+		}
+
+		if part.FormName() == "file" {
+			contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			return part, contentType, part.FileName(), nil
+		}
+	}
+
+	details := []mresponse.ErrorDetail{
+		mresponse.ErrorDetail{
+			Property: "file",
+			Message:  "Field token cannot be empty or is missing",
+		},
+	}
+	return nil, "", "", errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+}
+
+// writeImportReport sends the row errors of an import as a CSV file, with the same delimiter of the imported file.
+// The counts of the summary are sent as headers.
+func writeImportReport(c *gin.Context, res *mresponse.ImportResult, delimiter rune) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="import-errors.csv"`)
+	c.Header("X-Import-Rows", strconv.FormatInt(res.Rows, 10))
+	c.Header("X-Import-Valid", strconv.FormatInt(res.Valid, 10))
+	c.Header("X-Import-Imported", strconv.FormatInt(res.Imported, 10))
+	c.Header("X-Import-Failed", strconv.FormatInt(res.Failed, 10))
+	c.Status(200)
+
+	w := csv.NewWriter(c.Writer)
+	w.Comma = delimiter
+
+	w.Write([]string{"row", "property", "message"})
+	for _, rowErr := range res.Errors {
+		w.Write([]string{strconv.FormatInt(rowErr.Row, 10), rowErr.Property, rowErr.Message})
+	}
+
+	w.Flush()
+}
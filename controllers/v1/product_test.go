@@ -1,19 +1,27 @@
 package controllers
 
 import (
+	"context"
+	"encoding/csv"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"products/models/request"
 	"products/models/response"
+	"products/models/saft-pt-4"
 	"products/util/errors"
+	"strings"
 	"testing"
+	"time"
 
 	"bytes"
 	"encoding/json"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 // stub ProductService behaviour
@@ -38,6 +46,212 @@ func (ps *MockProductService) CreateMany(request *[]*mrequest.ProductCreate) (*[
 	return nil, nil
 }
 
+func (ps *MockProductService) ReadOne(id string, asOf *time.Time, projection *mrequest.Projection) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	if id != "507f191e810c19729de860ea" {
+		return nil, errors.HandleErrorResponse(errors.NOT_FOUND, nil, "Product not found")
+	}
+
+	pRes := mresponse.ProductRead{}
+	pRes.ID = id
+	pRes.Version = 3
+	pRes.ProductDescription = "current-description"
+	if asOf != nil {
+		pRes.ProductDescription = "description-at-" + asOf.Format(time.RFC3339)
+	}
+
+	// projections excluding the ProductDescription don't bring it
+	if projection != nil && len(projection.Exclude) > 0 && projection.Exclude[0] == "ProductDescription" {
+		pRes.ProductDescription = ""
+	}
+
+	return &pRes, nil
+}
+
+func (ps *MockProductService) UpdateOne(id string, version int64, request *mrequest.ProductUpdate) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	if version != 3 {
+		return nil, errors.HandleErrorResponse(errors.PRECONDITION_FAILED, nil, "")
+	}
+
+	pRes := mresponse.ProductRead{}
+	pRes.ID = id
+	pRes.Version = version + 1
+
+	return &pRes, nil
+}
+
+func (ps *MockProductService) PatchOne(id string, version int64, request *mrequest.ProductPatch) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	return ps.UpdateOne(id, version, nil)
+}
+
+func (ps *MockProductService) ArchiveOne(id string, version int64, reason string) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	pRes, err := ps.UpdateOne(id, version, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	archivedAt := time.Now()
+	pRes.ArchivedAt = &archivedAt
+	pRes.ArchiveReason = reason
+
+	return pRes, nil
+}
+
+func (ps *MockProductService) RestoreOne(id string, version int64) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	if version == 3 { // product at version 3 is not archived
+		return nil, errors.HandleErrorResponse(errors.CONFLICT, nil, "Product is not archived")
+	}
+
+	return ps.UpdateOne(id, 3, nil)
+}
+
+func (ps *MockProductService) ChangeStatus(id string, version int64, status string) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	if status == mresponse.StatusActive { // mocked product is already active
+		return nil, errors.HandleErrorResponse(errors.CONFLICT, nil, "Product can not move from status active to active")
+	}
+
+	pRes, err := ps.UpdateOne(id, version, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pRes.Status = status
+
+	return pRes, nil
+}
+
+func (ps *MockProductService) ExportSaft(req *mrequest.SaftExport) (*msaft.AuditFile, *mresponse.ErrorResponse) {
+	p := msaft.Product{
+		ProductType:        "P",
+		ProductCode:        "some-product-code",
+		ProductDescription: "description-at-" + req.EndDate.Format("2006-01-02"),
+	}
+
+	return &msaft.AuditFile{Products: []*msaft.Product{&p}}, nil
+}
+
+func (ps *MockProductService) Search(req *mrequest.SearchRequest) (*mresponse.ProductSearchList, *mresponse.ErrorResponse) {
+	p := mresponse.ProductRead{
+		ID:                 "507f191e810c19729de860ea",
+		ProductCode:        "some-product-code",
+		ProductDescription: "Parafuso inox",
+	}
+
+	items := []*mresponse.ProductSearchHit{
+		&mresponse.ProductSearchHit{
+			ProductRead: &p,
+			Score:       1.5,
+			Highlights:  map[string]string{"ProductDescription": "<em>Parafuso</em> inox"},
+		},
+	}
+
+	return &mresponse.ProductSearchList{Total: 1, PerPage: int64(req.PerPage), Page: int64(req.Page), Items: &items}, nil
+}
+
+func (ps *MockProductService) Suggest(req *mrequest.SuggestRequest) (*mresponse.ProductSuggestList, *mresponse.ErrorResponse) {
+	items := []*mresponse.ProductSuggestion{
+		&mresponse.ProductSuggestion{
+			ID:                 "507f191e810c19729de860ea",
+			ProductCode:        "PRF-001",
+			ProductDescription: "Parafuso inox",
+		},
+	}
+
+	return &mresponse.ProductSuggestList{Items: &items}, nil
+}
+
+func (ps *MockProductService) Facets(req *mrequest.ListRequest) (*mresponse.ProductFacets, *mresponse.ErrorResponse) {
+	res := mresponse.ProductFacets{
+		Total:        3,
+		ProductType:  []*mresponse.FacetCount{&mresponse.FacetCount{Value: "P", Count: 3}},
+		ProductGroup: []*mresponse.FacetCount{&mresponse.FacetCount{Value: "", Count: 3}},
+		CNCode:       mresponse.PresenceCount{With: 1, Without: 2},
+		UNNumber:     mresponse.PresenceCount{With: 0, Without: 3},
+	}
+
+	if len(req.Filters) > 0 {
+		res.Total = 1
+	}
+
+	return &res, nil
+}
+
+func (ps *MockProductService) Distinct(req *mrequest.DistinctRequest) (*mresponse.DistinctList, *mresponse.ErrorResponse) {
+	item := mresponse.DistinctValue{Value: "Ferragens"}
+	if req.WithCounts {
+		item.Count = 7
+	}
+
+	return &mresponse.DistinctList{Field: req.Field, Items: []*mresponse.DistinctValue{&item}}, nil
+}
+
+func (ps *MockProductService) ImportCSV(r io.Reader, req *mrequest.CSVImport) (*mresponse.ImportResult, *mresponse.ErrorResponse) {
+	reader := csv.NewReader(r)
+	reader.Comma = req.Delimiter
+
+	records, err := reader.ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, "File is empty, the first row must have the column headers")
+	}
+
+	res := mresponse.ImportResult{DryRun: req.DryRun, Rows: int64(len(records) - 1), Errors: []*mresponse.ImportRowError{}}
+	for i, record := range records[1:] {
+		if record[0] == "" {
+			res.Failed++
+			res.Errors = append(res.Errors, &mresponse.ImportRowError{Row: int64(i + 2), Property: "ProductCode", Message: "Field token cannot be empty or is missing"})
+			continue
+		}
+		res.Valid++
+	}
+
+	if !req.DryRun {
+		res.Imported = res.Valid
+	}
+
+	return &res, nil
+}
+
+func (ps *MockProductService) UpsertByCode(request *mrequest.ProductCreate) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	return &mresponse.ProductRead{ID: "some-unique-id", Version: 1, ProductCode: request.ProductCode}, nil
+}
+
+func (ps *MockProductService) ArchiveByCode(code string, reason string) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	return &mresponse.ProductRead{ID: "some-unique-id", Version: 2, ProductCode: code, ArchiveReason: reason}, nil
+}
+
+func (ps *MockProductService) Export(req *mrequest.ListRequest, each func(*mresponse.ProductRead) error) *mresponse.ErrorResponse {
+	if len(req.Status) > 0 && req.Status[0] == mresponse.StatusBlocked {
+		return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, "error ocurred on service")
+	}
+
+	if len(req.Status) > 0 && req.Status[0] == mresponse.StatusDraft {
+		return nil
+	}
+
+	archivedAt := time.Date(2018, 1, 1, 10, 0, 0, 0, time.UTC)
+	products := []*mresponse.ProductRead{
+		&mresponse.ProductRead{ID: "some-id-1", Version: 2, Status: mresponse.StatusActive, ProductCode: "PRF-001", ProductDescription: "Parafuso, inox", CustomsDetails: &mresponse.CustomsDetails{CNCode: []string{"7318", "7319"}}},
+		&mresponse.ProductRead{ID: "some-id-2", Version: 1, Status: mresponse.StatusActive, ProductCode: "PRC-001", ArchivedAt: &archivedAt},
+	}
+
+	for _, p := range products {
+		err := each(p)
+		if err != nil {
+			return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (ps *MockProductService) Watch(ctx context.Context, req *mrequest.ChangesRequest) (<-chan *mresponse.ProductChange, *mresponse.ErrorResponse) {
+	changes := make(chan *mresponse.ProductChange, 2)
+	changes <- &mresponse.ProductChange{Token: "token-1", Operation: mresponse.ChangeUpdate, ProductID: "some-id-1", Product: &mresponse.ProductRead{ID: "some-id-1", Version: 2, ProductCode: "PRF-001"}}
+	changes <- &mresponse.ProductChange{Token: "token-2", Operation: mresponse.ChangeArchive, ProductID: "some-id-2"}
+	close(changes)
+
+	return changes, nil
+}
+
 func (ps *MockProductService) List(req *mrequest.ListRequest) (*mresponse.ProductList, *mresponse.ErrorResponse) {
 
 	// success case
@@ -200,3 +414,966 @@ func TestListActionSuccess(t *testing.T) {
 		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, bodyString)
 	}
 }
+
+func TestListActionInvalidAsOf(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product", pc.ListAction)
+
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/product?per_page=10&page=1&as_of=yesterday", nil)
+	if err != nil {
+		t.Fatalf("Couldn't create request: %v\n", err)
+	}
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
+
+func TestReadActionAsOf(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/:id", pc.ReadAction)
+
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/product/507f191e810c19729de860ea?as_of=2018-01-01", nil)
+	if err != nil {
+		t.Fatalf("Couldn't create request: %v\n", err)
+	}
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	res := mresponse.ProductRead{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.ProductDescription != "description-at-2018-01-01T00:00:00Z" {
+		t.Fatalf("Expected product as of 2018-01-01 but got %s", res.ProductDescription)
+	}
+}
+
+func TestReadActionNotFound(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/:id", pc.ReadAction)
+
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/product/507f191e810c19729de860eb", nil)
+	if err != nil {
+		t.Fatalf("Couldn't create request: %v\n", err)
+	}
+
+	w := httptest.NewRecorder()
+
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusNotFound, w.Code, w.Body.String())
+	}
+}
+
+func TestSaftExportAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/saft", pc.SaftExportAction)
+
+	// TEST INVALID PERIOD
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/product/saft?start_date=2018-12-31&end_date=2018-01-01", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	// TEST SUCCESS
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/product/saft?start_date=2018-01-01&end_date=2018-12-31", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), "<MasterFiles><Product><ProductType>P</ProductType>") {
+		t.Fatalf("Expected SAF-T MasterFiles but got:\n%s", w.Body.String())
+	}
+}
+
+func TestSearchAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/search", pc.SearchAction)
+
+	// TEST MISSING QUERY
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/product/search?q=", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	// TEST SUCCESS
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/product/search?q=parafuso", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), `"score":1.5`) || !strings.Contains(w.Body.String(), `"ProductCode":"some-product-code"`) {
+		t.Fatalf("Expected search hits but got:\n%s", w.Body.String())
+	}
+}
+
+func TestSuggestAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/suggest", pc.SuggestAction)
+
+	// TEST INVALID LIMIT
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/product/suggest?prefix=paraf&limit=500", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	// TEST SUCCESS
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/product/suggest?prefix=paraf", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), `"ProductCode":"PRF-001"`) {
+		t.Fatalf("Expected suggestions but got:\n%s", w.Body.String())
+	}
+}
+
+func TestReadActionProjection(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/:id", pc.ReadAction)
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/product/507f191e810c19729de860ea?fields=ProductDescription", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	expected := `{"ProductDescription":"current-description","id":"507f191e810c19729de860ea"}`
+	if w.Body.String() != expected {
+		t.Fatalf("Expected body %s but got:\n%s", expected, w.Body.String())
+	}
+
+	// projections have their own ETag of the product version
+	if !strings.HasPrefix(w.Header().Get("ETag"), `"3-`) {
+		t.Fatalf("Expected an ETag of version 3 but got %s", w.Header().Get("ETag"))
+	}
+}
+
+func TestFacetsAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/facets", pc.FacetsAction)
+
+	// TEST INVALID FILTER
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/product/facets?ProductCode[unknown]=abc", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	// TEST SUCCESS WITH FILTER
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/product/facets?ProductGroup[prefix]=Ferr", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), `"total":1`) || !strings.Contains(w.Body.String(), `"CNCode":{"with":1,"without":2}`) {
+		t.Fatalf("Expected facets but got:\n%s", w.Body.String())
+	}
+}
+
+func TestDistinctActions(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/groups", pc.GroupsAction)
+	r.GET("/api/v1/product/distinct", pc.DistinctAction)
+
+	tests := []struct {
+		url  string
+		code int
+		body string
+	}{
+		{"/api/v1/product/groups?prefix=ferr", http.StatusOK, `{"field":"ProductGroup","items":[{"value":"Ferragens","count":7}]}`},
+		{"/api/v1/product/distinct?field=ProductGroup", http.StatusOK, `{"field":"ProductGroup","items":[{"value":"Ferragens"}]}`},
+		{"/api/v1/product/distinct?field=ProductDescription", http.StatusBadRequest, ""},
+		{"/api/v1/product/distinct?field=ProductType&with_counts=maybe", http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodGet, test.url, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != test.code {
+			t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", test.code, w.Code, w.Body.String())
+		}
+
+		if test.body != "" && w.Body.String() != test.body {
+			t.Fatalf("Expected body %s but got:\n%s", test.body, w.Body.String())
+		}
+	}
+}
+
+func TestReadActionNotModified(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/:id", pc.ReadAction)
+
+	// TEST ETAG
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/product/507f191e810c19729de860ea", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Header().Get("ETag") != "\"3\"" {
+		t.Fatalf("Expected ETag \"3\" but got %s", w.Header().Get("ETag"))
+	}
+
+	// TEST NOT MODIFIED
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/product/507f191e810c19729de860ea", nil)
+	req.Header.Set("If-None-Match", "\"3\"")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusNotModified, w.Code, w.Body.String())
+	}
+
+	// TEST OTHER REPRESENTATIONS HAVE THEIR OWN ETAG
+
+	tags := map[string]bool{"\"3\"": true}
+	for _, read := range []struct{ query, accept string }{
+		{"?fields=ProductCode", ""},
+		{"?exclude=ProductCode", ""},
+		{"", "application/xml"},
+		{"?as_of=2018-01-01T00:00:00Z", ""},
+	} {
+		req, _ = http.NewRequest(http.MethodGet, "/api/v1/product/507f191e810c19729de860ea"+read.query, nil)
+		req.Header.Set("If-None-Match", "\"3\"")
+		if read.accept != "" {
+			req.Header.Set("Accept", read.accept)
+		}
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		tag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || tags[tag] || !strings.HasPrefix(tag, "\"3-") || w.Header().Get("Vary") != "Accept" {
+			t.Fatalf("Expected %s%s to get status %d with its own ETag but instead got %d with ETag %s", read.query, read.accept, http.StatusOK, w.Code, tag)
+		}
+		tags[tag] = true
+
+		// the tag of a representation still holds the product version
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest(http.MethodPut, "/", nil)
+		c.Request.Header.Set("If-Match", tag)
+		if versions, e := ifMatchVersions(c); e != nil || len(versions) != 1 || versions[0] != 3 {
+			t.Fatalf("Expected If-Match %s to hold version 3 but got %v %v", tag, versions, e)
+		}
+	}
+}
+
+func TestUpdateActionPreconditions(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.PUT("/api/v1/product/:id", pc.UpdateAction)
+
+	body := mrequest.ProductUpdate{
+		ProductType:        "P",
+		ProductCode:        "some-product-code",
+		ProductGroup:       "some-product-group",
+		ProductDescription: "some-product-description",
+		ProductNumberCode:  "some-product-number-code",
+	}
+	jsonValue, _ := json.Marshal(body)
+
+	// TEST MISSING IF-MATCH
+
+	req, _ := http.NewRequest(http.MethodPut, "/api/v1/product/507f191e810c19729de860ea", bytes.NewBuffer(jsonValue))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPreconditionRequired {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusPreconditionRequired, w.Code, w.Body.String())
+	}
+
+	// TEST VERSION MISMATCH
+
+	req, _ = http.NewRequest(http.MethodPut, "/api/v1/product/507f191e810c19729de860ea", bytes.NewBuffer(jsonValue))
+	req.Header.Set("If-Match", "\"2\"")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusPreconditionFailed, w.Code, w.Body.String())
+	}
+
+	// TEST SUCCESS
+
+	req, _ = http.NewRequest(http.MethodPut, "/api/v1/product/507f191e810c19729de860ea", bytes.NewBuffer(jsonValue))
+	req.Header.Set("If-Match", "\"3\"")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != "\"4\"" {
+		t.Fatalf("Expected to get status %d with ETag \"4\" but instead got %d with ETag %s", http.StatusOK, w.Code, w.Header().Get("ETag"))
+	}
+
+	// TEST ANY VERSION AND LISTS OF VERSIONS
+
+	for _, precondition := range []struct {
+		id, ifMatch string
+		code        int
+	}{
+		{"507f191e810c19729de860ea", "*", http.StatusOK},
+		{"507f191e810c19729de860ea", "\"2\", W/\"3\"", http.StatusOK},
+		{"507f191e810c19729de860ea", "\"1\", \"2\"", http.StatusPreconditionFailed},
+		{"507f191e810c19729de860ea", "\"other-server-tag\"", http.StatusPreconditionFailed},
+		{"507f191e810c19729de860eb", "*", http.StatusPreconditionFailed},
+	} {
+		req, _ = http.NewRequest(http.MethodPut, "/api/v1/product/"+precondition.id, bytes.NewBuffer(jsonValue))
+		req.Header.Set("If-Match", precondition.ifMatch)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != precondition.code {
+			t.Fatalf("Expected If-Match %s to get status %d but instead got %d\nResponse body:\n%s", precondition.ifMatch, precondition.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestDeleteActionSuccess(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.DELETE("/api/v1/product/:id", pc.DeleteAction)
+
+	req, _ := http.NewRequest(http.MethodDelete, "/api/v1/product/507f191e810c19729de860ea?reason=discontinued", nil)
+	req.Header.Set("If-Match", "W/\"3\"")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	res := mresponse.ProductRead{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if res.ArchivedAt == nil || res.ArchiveReason != "discontinued" || w.Header().Get("ETag") != "\"4\"" {
+		t.Fatalf("Expected product to be archived but got:\n%s", w.Body.String())
+	}
+}
+
+func TestRestoreActionNotArchived(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.POST("/api/v1/product/:id/restore", pc.RestoreAction)
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/product/507f191e810c19729de860ea/restore", nil)
+	req.Header.Set("If-Match", "\"3\"")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusConflict, w.Code, w.Body.String())
+	}
+}
+
+func TestListActionInvalidArchivedFilter(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product", pc.ListAction)
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/product?per_page=10&page=1&only_archived=yes", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
+
+func TestStatusTransitionActions(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.POST("/api/v1/product/:id/activate", pc.ActivateAction)
+	r.POST("/api/v1/product/:id/discontinue", pc.DiscontinueAction)
+
+	// TEST ILLEGAL TRANSITION
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/product/507f191e810c19729de860ea/activate", nil)
+	req.Header.Set("If-Match", "\"3\"")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusConflict, w.Code, w.Body.String())
+	}
+
+	// TEST SUCCESS
+
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/product/507f191e810c19729de860ea/discontinue", nil)
+	req.Header.Set("If-Match", "\"3\"")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	res := mresponse.ProductRead{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if w.Code != http.StatusOK || res.Status != mresponse.StatusDiscontinued {
+		t.Fatalf("Expected product to be discontinued but got status %d\nResponse body:\n%s", w.Code, w.Body.String())
+	}
+}
+
+func TestListActionInvalidStatus(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product", pc.ListAction)
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/product?per_page=10&page=1&status=active,sold-out", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
+
+func TestImportCSVAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.POST("/api/v1/product/import/csv", pc.ImportCSVAction)
+	r.POST("/api/v1/product/:id/restore", pc.RestoreAction)
+
+	// TEST INVALID OPTIONS
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/product/import/csv?encoding=utf-16&map[Code]=Unknown", strings.NewReader("ProductCode\nA1\n"))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"encoding"`) || !strings.Contains(w.Body.String(), `"map[Code]"`) {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	// TEST TSV BODY WITH SUMMARY
+
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/product/import/csv?dry_run=true", strings.NewReader("ProductCode\tProductType\nA1\tP\n\tP\n"))
+	req.Header.Set("Content-Type", "text/tab-separated-values")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	res := mresponse.ImportResult{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+
+	if !res.DryRun || res.Rows != 2 || res.Valid != 1 || res.Imported != 0 || len(res.Errors) != 1 || res.Errors[0].Row != 3 {
+		t.Fatalf("Unexpected import result:\n%s", w.Body.String())
+	}
+
+	// TEST MULTIPART FILE WITH CSV REPORT
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	form.WriteField("comment", "supplier catalog")
+	file, _ := form.CreateFormFile("file", "catalog.csv")
+	file.Write([]byte("ProductCode;ProductType\n;P\nA2;S\n"))
+	form.Close()
+
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/product/import/csv?delimiter=semicolon&report=csv", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	expected := "row;property;message\n2;ProductCode;Field token cannot be empty or is missing\n"
+	if w.Body.String() != expected || w.Header().Get("X-Import-Imported") != "1" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Unexpected import report:\n%s", w.Body.String())
+	}
+
+	// TEST MULTIPART WITHOUT FILE
+
+	body = &bytes.Buffer{}
+	form = multipart.NewWriter(body)
+	form.WriteField("comment", "supplier catalog")
+	form.Close()
+
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/product/import/csv", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"file"`) {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+}
+
+func TestExportAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/export", pc.ExportAction)
+
+	// TEST INVALID OPTIONS
+
+	for _, query := range []string{"format=xlsx", "columns=ProductCode,Unknown", "columns=CNCode,CustomsDetails.CNCode"} {
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/product/export?"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected to get status %d for %s but instead got %d\nResponse body:\n%s", http.StatusBadRequest, query, w.Code, w.Body.String())
+		}
+	}
+
+	// TEST CSV WITH SELECTED COLUMNS
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/product/export?columns=ProductCode,id,CNCode,ProductDescription,archived_at", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	expected := "ProductCode,id,CustomsDetails.CNCode,ProductDescription,archived_at\n" +
+		"PRF-001,some-id-1,7318|7319,\"Parafuso, inox\",\n" +
+		"PRC-001,some-id-2,,,2018-01-01T10:00:00Z\n"
+
+	if w.Code != http.StatusOK || w.Body.String() != expected || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Unexpected export with status %d:\n%s", w.Code, w.Body.String())
+	}
+
+	// TEST NDJSON KEEPS COLUMNS ORDER
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/product/export?format=ndjson&columns=version,id,CNCode", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	expected = `{"version":2,"id":"some-id-1","CustomsDetails.CNCode":["7318","7319"]}` + "\n" +
+		`{"version":1,"id":"some-id-2","CustomsDetails.CNCode":[]}` + "\n"
+
+	if w.Code != http.StatusOK || w.Body.String() != expected || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Unexpected export with status %d:\n%s", w.Code, w.Body.String())
+	}
+
+	// TEST EMPTY EXPORT HAS HEADER ROW
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/product/export?status=draft&columns=id,ProductCode", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "id,ProductCode\n" {
+		t.Fatalf("Unexpected export with status %d:\n%s", w.Code, w.Body.String())
+	}
+
+	// TEST SERVICE ERROR BEFORE STREAMING
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/product/export?status=blocked", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusInternalServerError, w.Code, w.Body.String())
+	}
+}
+
+func TestXMLContentNegotiation(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.POST("/api/v1/product", pc.CreateAction)
+	r.GET("/api/v1/product", pc.ListAction)
+	r.GET("/api/v1/product/:id", pc.ReadAction)
+	r.PUT("/api/v1/product/:id", pc.UpdateAction)
+
+	// TEST XML PRODUCT
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/product/507f191e810c19729de860ea", nil)
+	req.Header.Set("Accept", "application/xml")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/xml") {
+		t.Fatalf("Expected to get status %d with XML but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if !strings.Contains(w.Body.String(), `<Product id="507f191e810c19729de860ea" version="3"><ProductType></ProductType><ProductCode></ProductCode><ProductGroup></ProductGroup><ProductDescription>current-description</ProductDescription>`) {
+		t.Fatalf("Unexpected XML product:\n%s", w.Body.String())
+	}
+
+	// projections are ignored, SAF-T products have all their fields
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/product/507f191e810c19729de860ea?exclude=ProductDescription", nil)
+	req.Header.Set("Accept", "application/xml")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<ProductDescription>current-description</ProductDescription>`) {
+		t.Fatalf("Expected an XML product with all its fields but got %d:\n%s", w.Code, w.Body.String())
+	}
+
+	// JSON is preferred unless XML is asked for
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/product/507f191e810c19729de860ea", nil)
+	req.Header.Set("Accept", "*/*")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("Expected a JSON response but got %s", w.Header().Get("Content-Type"))
+	}
+
+	// TEST XML LIST
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/product?per_page=10&page=1&sort=id&order=normal", nil)
+	req.Header.Set("Accept", "text/xml")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<Products per_page="0"><Product id="some-id-1">`) {
+		t.Fatalf("Unexpected XML list %d:\n%s", w.Code, w.Body.String())
+	}
+
+	// TEST XML BODY WITH XML ERROR
+
+	body := `<Product status="draft"><ProductType>P</ProductType><ProductDescription>Parafuso</ProductDescription><ProductNumberCode>R1</ProductNumberCode></Product>`
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/product", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("Accept", "application/xml")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `<error><property>ProductCode</property>`) {
+		t.Fatalf("Expected to get status %d with an XML error but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	body = `<Product><ProductType>P</ProductType><ProductCode>PRF-001</ProductCode><ProductDescription>Parafuso</ProductDescription><ProductNumberCode>R1</ProductNumberCode>` +
+		`<CustomsDetails><CNCode>7318</CNCode><CNCode>7319</CNCode></CustomsDetails></Product>`
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/product", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("Accept", "application/xml")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<Product id="some-unique-id"></Product>`) {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// other root elements are rejected
+	req, _ = http.NewRequest(http.MethodPut, "/api/v1/product/507f191e810c19729de860ea", strings.NewReader(`<Customer></Customer>`))
+	req.Header.Set("Content-Type", "text/xml")
+	req.Header.Set("If-Match", "\"3\"")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"property":"Product"`) {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	req, _ = http.NewRequest(http.MethodPut, "/api/v1/product/507f191e810c19729de860ea", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/xml")
+	req.Header.Set("If-Match", "\"3\"")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != "\"4\"" {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+}
+
+func TestChangesAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/changes", pc.ChangesAction)
+
+	// TEST INVALID RESUME TOKEN
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/product/changes", nil)
+	req.Header.Set("Last-Event-ID", "not a token")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	// TEST EVENTS
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/product/changes", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	expected := "id: token-1\nevent: update\ndata: {\"token\":\"token-1\",\"operation\":\"update\",\"product_id\":\"some-id-1\",\"product\":{\"id\":\"some-id-1\",\"version\":2,\"ProductCode\":\"PRF-001\"}}\n\n" +
+		"id: token-2\nevent: archive\ndata: {\"token\":\"token-2\",\"operation\":\"archive\",\"product_id\":\"some-id-2\"}\n\n"
+
+	if w.Code != http.StatusOK || w.Body.String() != expected || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected events with status %d:\n%s", w.Code, w.Body.String())
+	}
+}
+
+func TestChangesWebSocketAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	pps := &MockProductService{}
+
+	pc := ProductController{
+		ProductService: pps,
+	}
+
+	r := gin.Default()
+
+	r.GET("/api/v1/product/changes/ws", pc.ChangesWebSocketAction)
+
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/api/v1/product/changes/ws"
+
+	_, res, err := websocket.DefaultDialer.Dial(url+"?token=not+a+token", nil)
+	if err == nil || res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected the invalid token to be rejected but got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Expected to connect but got %v", err)
+	}
+	defer conn.Close()
+
+	changes := []*mresponse.ProductChange{}
+	for {
+		change := mresponse.ProductChange{}
+		err := conn.ReadJSON(&change)
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+				t.Fatalf("Expected the feed to end but got %v", err)
+			}
+			break
+		}
+		changes = append(changes, &change)
+	}
+
+	if len(changes) != 2 || changes[0].Product.Version != 2 || changes[1].Token != "token-2" {
+		t.Fatalf("Unexpected changes %v", changes)
+	}
+}
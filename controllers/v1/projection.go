@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"encoding/json"
+	"products/models/request"
+	"products/models/response"
+)
+
+// projectProduct returns the product JSON trimmed to the fields of the projection
+func projectProduct(p *mresponse.ProductRead, projection *mrequest.Projection) map[string]interface{} {
+	return projection.Trim(toJSONMap(p))
+}
+
+// projectList returns the product list JSON with its items trimmed to the fields of the projection
+func projectList(l *mresponse.ProductList, projection *mrequest.Projection) map[string]interface{} {
+	items := []map[string]interface{}{}
+	for _, p := range *l.Items {
+		items = append(items, projectProduct(p, projection))
+	}
+
+	doc := toJSONMap(l)
+	doc["items"] = items
+
+	return doc
+}
+
+func toJSONMap(v interface{}) map[string]interface{} {
+	doc := map[string]interface{}{}
+	b, _ := json.Marshal(v)
+	json.Unmarshal(b, &doc)
+
+	return doc
+}
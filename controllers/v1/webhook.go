@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"encoding/json"
+	"products/models/request"
+	"products/services"
+	"products/util/errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// WebhookController represents the controller for operating on the webhook subscriptions resource
+	WebhookController struct {
+		WebhookService services.WebhookServiceContract
+	}
+)
+
+// NewWebhookController is the constructor of WebhookController
+func NewWebhookController(ws services.WebhookServiceContract) *WebhookController {
+	return &WebhookController{
+		WebhookService: ws,
+	}
+}
+
+// CreateAction subscribes an URL to product events, e.g. {"url": "https://partner/hooks", "events": ["product.created"]}
+// The response has the secret payloads are signed with, it's not returned again.
+func (wc WebhookController) CreateAction(c *gin.Context) {
+	wReq := mrequest.WebhookCreate{}
+	json.NewDecoder(c.Request.Body).Decode(&wReq)
+
+	e := errors.ValidateRequest(&wReq)
+	if e != nil {
+		c.JSON(e.HttpCode, e)
+		return
+	}
+
+	res, err := wc.WebhookService.CreateOne(&wReq)
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// ReadAction returns the webhook subscription with the id provided in the URL
+func (wc WebhookController) ReadAction(c *gin.Context) {
+	res, err := wc.WebhookService.ReadOne(c.Param("id"))
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// ListAction lists all webhook subscriptions
+func (wc WebhookController) ListAction(c *gin.Context) {
+	res, err := wc.WebhookService.List()
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// UpdateAction replaces the URL, events and description of a webhook subscription, and enables or disables it
+func (wc WebhookController) UpdateAction(c *gin.Context) {
+	wReq := mrequest.WebhookUpdate{}
+	json.NewDecoder(c.Request.Body).Decode(&wReq)
+
+	res, err := wc.WebhookService.UpdateOne(c.Param("id"), &wReq)
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
+
+// DeleteAction removes a webhook subscription and its delivery log
+func (wc WebhookController) DeleteAction(c *gin.Context) {
+	err := wc.WebhookService.DeleteOne(c.Param("id"))
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.Status(204)
+}
+
+// DeliveriesAction returns the delivery log of a webhook subscription, latest first, with the attempts of each delivery.
+// The page, per_page and status query params select the deliveries, e.g. ?status=failed
+func (wc WebhookController) DeliveriesAction(c *gin.Context) {
+	res, err := wc.WebhookService.ListDeliveries(c.Param("id"), mrequest.NewDeliveryListRequest(c.Request.URL.Query()))
+
+	if err != nil {
+		c.JSON(err.HttpCode, err)
+		return
+	}
+
+	c.JSON(200, res)
+}
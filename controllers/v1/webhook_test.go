@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"products/models/request"
+	"products/models/response"
+	"products/util/errors"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stub WebhookService behaviour
+type MockWebhookService struct {
+	deliveries *mrequest.DeliveryListRequest
+}
+
+func (ws *MockWebhookService) CreateOne(request *mrequest.WebhookCreate) (*mresponse.WebhookSubscription, *mresponse.ErrorResponse) {
+	if request.URL == "ftp://partner.example/hooks" {
+		details := []mresponse.ErrorDetail{{Property: "url", Message: "Must be an http or https URL"}}
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	return &mresponse.WebhookSubscription{ID: "some-unique-id", URL: request.URL, Events: request.Events, Secret: "generated-secret", Enabled: true}, nil
+}
+
+func (ws *MockWebhookService) ReadOne(id string) (*mresponse.WebhookSubscription, *mresponse.ErrorResponse) {
+	if id != "some-unique-id" {
+		return nil, errors.HandleErrorResponse(errors.NOT_FOUND, nil, "Webhook subscription not found")
+	}
+
+	return &mresponse.WebhookSubscription{ID: id, URL: "https://partner.example/hooks", Events: []string{mresponse.WebhookProductCreated}, Enabled: true}, nil
+}
+
+func (ws *MockWebhookService) List() (*mresponse.WebhookSubscriptionList, *mresponse.ErrorResponse) {
+	items := []*mresponse.WebhookSubscription{}
+	return &mresponse.WebhookSubscriptionList{Items: &items}, nil
+}
+
+func (ws *MockWebhookService) UpdateOne(id string, request *mrequest.WebhookUpdate) (*mresponse.WebhookSubscription, *mresponse.ErrorResponse) {
+	return &mresponse.WebhookSubscription{ID: id, URL: request.URL, Events: request.Events, Enabled: *request.Enabled}, nil
+}
+
+func (ws *MockWebhookService) DeleteOne(id string) *mresponse.ErrorResponse {
+	if id != "some-unique-id" {
+		return errors.HandleErrorResponse(errors.NOT_FOUND, nil, "Webhook subscription not found")
+	}
+
+	return nil
+}
+
+func (ws *MockWebhookService) ListDeliveries(id string, request *mrequest.DeliveryListRequest) (*mresponse.WebhookDeliveryList, *mresponse.ErrorResponse) {
+	ws.deliveries = request
+
+	items := []*mresponse.WebhookDelivery{}
+	return &mresponse.WebhookDeliveryList{PerPage: int64(request.PerPage), Page: int64(request.Page), Items: &items}, nil
+}
+
+func TestCreateWebhookAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	wc := NewWebhookController(&MockWebhookService{})
+
+	r := gin.Default()
+
+	r.POST("/api/v1/webhooks", wc.CreateAction)
+
+	// TEST MISSING EVENTS
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/webhooks", bytes.NewBufferString(`{"url":"https://partner.example/hooks"}`))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	// TEST INVALID URL
+
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/webhooks", bytes.NewBufferString(`{"url":"ftp://partner.example/hooks","events":["product.created"]}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"url"`) {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusBadRequest, w.Code, w.Body.String())
+	}
+
+	// TEST SUCCESS
+
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/webhooks", bytes.NewBufferString(`{"url":"https://partner.example/hooks","events":["product.created"]}`))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"secret":"generated-secret"`) {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+}
+
+func TestReadAndDeleteWebhookActions(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	wc := NewWebhookController(&MockWebhookService{})
+
+	r := gin.Default()
+
+	r.GET("/api/v1/webhooks/:id", wc.ReadAction)
+	r.DELETE("/api/v1/webhooks/:id", wc.DeleteAction)
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/webhooks/unknown", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusNotFound, w.Code, w.Body.String())
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/webhooks/some-unique-id", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"secret"`) {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, "/api/v1/webhooks/some-unique-id", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+}
+
+func TestWebhookDeliveriesAction(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	ws := &MockWebhookService{}
+	wc := NewWebhookController(ws)
+
+	r := gin.Default()
+
+	r.GET("/api/v1/webhooks/:id/deliveries", wc.DeliveriesAction)
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/webhooks/some-unique-id/deliveries?status=failed&page=2", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	if ws.deliveries.Status != mresponse.DeliveryFailed || ws.deliveries.Page != 2 || ws.deliveries.PerPage != 20 {
+		t.Fatalf("Unexpected deliveries request %v", ws.deliveries)
+	}
+}
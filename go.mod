@@ -0,0 +1,20 @@
+module products
+
+go 1.16
+
+require (
+	github.com/asaskevich/govalidator v0.0.0-20180315120708-ccb8e960c48f
+	github.com/buger/jsonparser v1.6.1 // indirect
+	github.com/confluentinc/confluent-kafka-go v0.11.4
+	github.com/gin-gonic/gin v1.7.0
+	github.com/go-stack/stack v1.7.0 // indirect
+	github.com/gorilla/websocket v1.4.2
+	github.com/graphql-go/graphql v0.8.1
+	github.com/linkedin/goavro/v2 v2.10.0
+	github.com/mongodb/mongo-go-driver v0.0.10
+	github.com/tidwall/pretty v1.2.2 // indirect
+	go.uber.org/dig v1.3.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	google.golang.org/grpc v1.43.0
+	google.golang.org/protobuf v1.27.1
+)
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"products/services"
+	"products/util/errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// idempotencyMemoryBody is the size up to which request bodies are kept in memory while processed, bigger ones
+	// (e.g. imported files) are kept in a temporary file
+	idempotencyMemoryBody = 1 << 20
+
+	// idempotencyMaxResponse is the size of the biggest response saved to be replayed, requests with bigger responses
+	// are processed again when retried
+	idempotencyMaxResponse = 4 << 20
+)
+
+// IdempotencyHandler makes POST requests sent with an Idempotency-Key header safe to retry
+type IdempotencyHandler struct {
+	IdempotencyService services.IdempotencyServiceContract
+}
+
+// NewIdempotencyHandler is the IdempotencyHandler constructor
+func NewIdempotencyHandler(is services.IdempotencyServiceContract) *IdempotencyHandler {
+	return &IdempotencyHandler{
+		IdempotencyService: is,
+	}
+}
+
+// Handle processes a request with an Idempotency-Key header only once: retries with the same key get the response
+// of the first request, with an "Idempotent-Replayed: true" header. Keys are bound to the method, URL, content type
+// and body of the first request, reusing them with a different request gets a 422.
+// Requests without the header are processed as usual.
+func (h *IdempotencyHandler) Handle(c *gin.Context) {
+	key := c.GetHeader("Idempotency-Key")
+	if key == "" {
+		return
+	}
+
+	body, requestHash, err := spoolBody(c.Request)
+	if err != nil {
+		e := errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, err.Error())
+		c.AbortWithStatusJSON(e.HttpCode, e)
+		return
+	}
+	defer body.Close()
+	c.Request.Body = body
+
+	stored, e := h.IdempotencyService.Begin(key, requestHash)
+	if e != nil {
+		c.AbortWithStatusJSON(e.HttpCode, e)
+		return
+	}
+
+	if stored != nil {
+		for name, value := range stored.Header {
+			c.Header(name, value)
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Status(stored.Status)
+		c.Writer.Write(stored.Body)
+		c.Abort()
+		return
+	}
+
+	w := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+
+	c.Next()
+
+	if w.overflow {
+		e = h.IdempotencyService.Release(key)
+	} else {
+		header := map[string]string{}
+		for name, values := range w.Header() {
+			header[name] = values[0]
+		}
+		e = h.IdempotencyService.Complete(key, w.Status(), header, w.body.Bytes())
+	}
+
+	if e != nil {
+		log.Printf("Error saving response of Idempotency-Key %s: %s\n", key, e.Response)
+	}
+}
+
+// spoolBody reads the request body, to hash it along with the method, URL and content type, and returns a copy to process.
+// Multipart bodies are hashed by their parts, as clients retrying them may pick another boundary.
+func spoolBody(r *http.Request) (io.ReadCloser, string, error) {
+	contentType := r.Header.Get("Content-Type")
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	boundary := ""
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		contentType, boundary = mediaType, params["boundary"]
+	}
+
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n"+contentType+"\n")
+
+	if r.Body == nil {
+		return ioutil.NopCloser(&bytes.Buffer{}), hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	spooled, err := spool(r.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if boundary == "" {
+		_, err = io.Copy(hash, spooled)
+	} else {
+		err = hashParts(hash, spooled, boundary)
+	}
+	if err == nil {
+		_, err = spooled.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spooled.Close()
+		return nil, "", err
+	}
+
+	return spooled, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// spooledBody is a copy of a request body, which can be read again
+type spooledBody interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// spool copies the body in memory, or in a temporary file if bigger than idempotencyMemoryBody
+func spool(body io.Reader) (spooledBody, error) {
+	buf := &bytes.Buffer{}
+	_, err := io.CopyN(buf, body, idempotencyMemoryBody)
+	if err == io.EOF {
+		return &memoryBody{bytes.NewReader(buf.Bytes())}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := ioutil.TempFile("", "idempotent-body-")
+	if err != nil {
+		return nil, err
+	}
+	spooled := &tempFileBody{file}
+
+	_, err = io.Copy(file, io.MultiReader(buf, body))
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spooled.Close()
+		return nil, err
+	}
+
+	return spooled, nil
+}
+
+// hashParts hashes the form name, file name, content type and content of each part of a multipart body
+func hashParts(hash io.Writer, body io.Reader, boundary string) error {
+	reader := multipart.NewReader(body, boundary)
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(hash, "%q %q %q\n", part.FormName(), part.FileName(), part.Header.Get("Content-Type"))
+
+		content := sha256.New()
+		_, err = io.Copy(content, part)
+		if err != nil {
+			return err
+		}
+		hash.Write(content.Sum(nil))
+	}
+}
+
+// memoryBody is a request body kept in memory
+type memoryBody struct {
+	*bytes.Reader
+}
+
+func (b *memoryBody) Close() error {
+	return nil
+}
+
+// tempFileBody is a request body kept in a temporary file, removed when closed
+type tempFileBody struct {
+	*os.File
+}
+
+func (b *tempFileBody) Close() error {
+	b.File.Close()
+	return os.Remove(b.File.Name())
+}
+
+// recordingWriter keeps a copy of the response written, unless it's bigger than idempotencyMaxResponse
+type recordingWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *recordingWriter) record(data []byte) {
+	if w.overflow || w.body.Len()+len(data) > idempotencyMaxResponse {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+
+	w.body.Write(data)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"products/models/response"
+	"products/util/errors"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stub IdempotencyService behaviour, keeping the responses in memory
+type MockIdempotencyService struct {
+	requests map[string]*mresponse.IdempotentRequest
+}
+
+func (is *MockIdempotencyService) Begin(key string, requestHash string) (*mresponse.IdempotentRequest, *mresponse.ErrorResponse) {
+	stored, ok := is.requests[key]
+	if !ok {
+		is.requests[key] = &mresponse.IdempotentRequest{Key: key, RequestHash: requestHash}
+		return nil, nil
+	}
+
+	if stored.RequestHash != requestHash {
+		return nil, errors.HandleErrorResponse(errors.IDEMPOTENCY_MISMATCH, nil, "")
+	}
+
+	return stored, nil
+}
+
+func (is *MockIdempotencyService) Complete(key string, status int, header map[string]string, body []byte) *mresponse.ErrorResponse {
+	stored := is.requests[key]
+	stored.Status, stored.Header, stored.Body = status, header, body
+
+	return nil
+}
+
+func (is *MockIdempotencyService) Release(key string) *mresponse.ErrorResponse {
+	delete(is.requests, key)
+
+	return nil
+}
+
+func TestIdempotencyHandler(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+
+	ih := NewIdempotencyHandler(&MockIdempotencyService{requests: map[string]*mresponse.IdempotentRequest{}})
+
+	r := gin.Default()
+
+	created := 0
+	r.POST("/api/v1/product", ih.Handle, func(c *gin.Context) {
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		created++
+		c.Header("ETag", `"1"`)
+		c.String(200, "created %d from %s", created, body)
+	})
+
+	post := func(key string, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/product", bytes.NewBufferString(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// TEST FIRST REQUEST
+
+	w := post("key-1", `{"ProductCode":"A"}`)
+	if w.Code != http.StatusOK || w.Body.String() != `created 1 from {"ProductCode":"A"}` || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("Unexpected response %d %s", w.Code, w.Body.String())
+	}
+
+	// TEST RETRY IS REPLAYED
+
+	w = post("key-1", `{"ProductCode":"A"}`)
+	if w.Code != http.StatusOK || w.Body.String() != `created 1 from {"ProductCode":"A"}` || w.Header().Get("ETag") != `"1"` || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("Expected the response to be replayed but got %d %s", w.Code, w.Body.String())
+	}
+
+	// TEST KEY REUSED WITH A DIFFERENT BODY
+
+	w = post("key-1", `{"ProductCode":"B"}`)
+	if w.Code != http.StatusUnprocessableEntity || created != 1 {
+		t.Fatalf("Expected to get status %d but instead got %d\nResponse body:\n%s", http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	}
+
+	// TEST REQUESTS WITHOUT KEY
+
+	w = post("", `{"ProductCode":"A"}`)
+	if w.Code != http.StatusOK || created != 2 {
+		t.Fatalf("Expected the request to be processed but got %d %s", w.Code, w.Body.String())
+	}
+
+	// TEST BIG BODIES
+
+	big := strings.Repeat("x", idempotencyMemoryBody+10)
+	w = post("key-2", big)
+	if w.Code != http.StatusOK || w.Body.String() != "created 3 from "+big {
+		t.Fatalf("Expected the whole body to be processed but got %d", w.Code)
+	}
+
+	w = post("key-2", big+"y")
+	if w.Code != http.StatusUnprocessableEntity || created != 3 {
+		t.Fatalf("Expected to get status %d but instead got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	// TEST MULTIPART RETRIES WITH ANOTHER BOUNDARY
+
+	upload := func(key string, boundary string, file string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		form := multipart.NewWriter(body)
+		form.SetBoundary(boundary)
+		part, _ := form.CreateFormFile("file", "products.csv")
+		part.Write([]byte(file))
+		form.Close()
+
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/product", body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w = upload("key-3", "boundary-1", "ProductCode\r\nA1\r\n")
+	if w.Code != http.StatusOK || created != 4 {
+		t.Fatalf("Expected the upload to be processed but got %d %s", w.Code, w.Body.String())
+	}
+
+	w = upload("key-3", "boundary-2", "ProductCode\r\nA1\r\n")
+	if w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "true" || !strings.Contains(w.Body.String(), "boundary-1") {
+		t.Fatalf("Expected the upload to be replayed but got %d %s", w.Code, w.Body.String())
+	}
+
+	w = upload("key-3", "boundary-2", "ProductCode\r\nA2\r\n")
+	if w.Code != http.StatusUnprocessableEntity || created != 4 {
+		t.Fatalf("Expected to get status %d but instead got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+}
@@ -0,0 +1,75 @@
+package helper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Supported text encodings
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingWindows1252 = "windows-1252"
+	EncodingISO88591    = "iso-8859-1"
+)
+
+// windows1252 maps the bytes 0x80-0x9F of Windows-1252, the only ones that differ from ISO-8859-1.
+// Bytes undefined in Windows-1252 keep their Latin-1 value, as browsers do.
+var windows1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\u008D', 'Ž', '\u008F',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\u009D', 'ž', 'Ÿ',
+}
+
+// NewDecoder returns a reader of the UTF-8 text of r, which is encoded with encoding.
+// A leading UTF-8 byte order mark is dropped, spreadsheet applications usually add it.
+func NewDecoder(r io.Reader, encoding string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	switch strings.ToLower(encoding) {
+	case EncodingUTF8, "utf8", "":
+		if bom, err := br.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+			br.Discard(3)
+		}
+		return br, nil
+	case EncodingWindows1252, "cp1252":
+		return &singleByteReader{r: br, windows: true}, nil
+	case EncodingISO88591, "latin1":
+		return &singleByteReader{r: br}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported encoding %s", encoding)
+}
+
+// singleByteReader decodes text with one byte per character to UTF-8
+type singleByteReader struct {
+	r       *bufio.Reader
+	windows bool
+	pending []byte
+}
+
+func (this *singleByteReader) Read(p []byte) (int, error) {
+	if len(this.pending) == 0 {
+		buf := make([]byte, len(p)/utf8.UTFMax+1)
+		n, err := this.r.Read(buf)
+		if n == 0 {
+			return 0, err
+		}
+
+		for _, b := range buf[:n] {
+			r := rune(b)
+			if this.windows && b >= 0x80 && b <= 0x9F {
+				r = windows1252[b-0x80]
+			}
+			this.pending = append(this.pending, string(r)...)
+		}
+	}
+
+	n := copy(p, this.pending)
+	this.pending = this.pending[n:]
+
+	return n, nil
+}
@@ -10,8 +10,19 @@ func main() {
 
 	container := containers.BuildContainer()
 
+	// Fire gRPC server
+	err := container.Invoke(func(grpcServer *server.GRPCServer) {
+		go func() {
+			grpcServer.Run()
+		}()
+	})
+
+	if err != nil {
+		panic(err)
+	}
+
 	// Fire server
-	err := container.Invoke(func(server *server.Server) {
+	err = container.Invoke(func(server *server.Server) {
 
 		// Fire Kafka consumer
 		e := container.Invoke(func(kafkaConsumer *services.KafkaConsumer) {
@@ -24,6 +35,28 @@ func main() {
 			panic(e)
 		}
 
+		// Fire import workers
+		e = container.Invoke(func(importWorker *services.ImportWorker) {
+			go func() {
+				importWorker.Run()
+			}()
+		})
+
+		if e != nil {
+			panic(e)
+		}
+
+		// Fire webhook deliveries
+		e = container.Invoke(func(webhookDispatcher *services.WebhookDispatcher) {
+			go func() {
+				webhookDispatcher.Run()
+			}()
+		})
+
+		if e != nil {
+			panic(e)
+		}
+
 		server.Run()
 	})
 
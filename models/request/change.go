@@ -0,0 +1,62 @@
+package mrequest
+
+import (
+	"encoding/base64"
+	"products/models/response"
+	"products/util/errors"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+)
+
+// ChangesRequest is a request of the product change feed.
+// If ResumeAfter is set the feed starts right after that change, otherwise it starts with the next change.
+type ChangesRequest struct {
+	ResumeAfter *bson.Document
+}
+
+// NewChangesRequest returns the request of the feed resuming after the change of the provided token, if any
+func NewChangesRequest(token string) (*ChangesRequest, *mresponse.ErrorResponse) {
+	req := ChangesRequest{}
+
+	if token == "" {
+		return &req, nil
+	}
+
+	doc, err := DecodeResumeToken(token)
+	if err != nil {
+		details := []mresponse.ErrorDetail{
+			mresponse.ErrorDetail{
+				Property: "token",
+				Message:  "Invalid resume token",
+			},
+		}
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+	req.ResumeAfter = doc
+
+	return &req, nil
+}
+
+// EncodeResumeToken returns the opaque token of a change stream resume token (the _id of a change event)
+func EncodeResumeToken(id *bson.Document) string {
+	if id == nil {
+		return ""
+	}
+
+	b, err := id.MarshalBSON()
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeResumeToken parses a token returned by EncodeResumeToken
+func DecodeResumeToken(token string) (*bson.Document, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.ReadDocument(b)
+}
@@ -0,0 +1,209 @@
+package mrequest
+
+import (
+	"fmt"
+	"net/url"
+	"products/helper"
+	"products/models/response"
+	"products/util/errors"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ImportFields are the product fields CSV columns can be mapped to
+var ImportFields = map[string]bool{
+	"Status":                  true,
+	"ProductType":             true,
+	"ProductCode":             true,
+	"ProductGroup":            true,
+	"ProductDescription":      true,
+	"ProductNumberCode":       true,
+	"CustomsDetails.CNCode":   true,
+	"CustomsDetails.UNNumber": true,
+}
+
+// importAliases are short names accepted for the nested customs fields
+var importAliases = map[string]string{
+	"CNCode":   "CustomsDetails.CNCode",
+	"UNNumber": "CustomsDetails.UNNumber",
+}
+
+// namedDelimiters are accepted besides the delimiter itself, a ";" must be encoded as %3B in the query string
+var namedDelimiters = map[string]string{
+	"comma":     ",",
+	"semicolon": ";",
+	"tab":       "\t",
+	`\t`:        "\t",
+	"pipe":      "|",
+}
+
+// Formats of the import report
+const (
+	ReportJSON = "json"
+	ReportCSV  = "csv"
+)
+
+type CSVImport struct {
+	Delimiter rune   `json:"delimiter" valid:"-"`
+	Encoding  string `json:"encoding" valid:""`
+
+	// Mapping has the product field of each column header, columns not mapped are read if their header is a product field
+	Mapping map[string]string `json:"mapping" valid:"-"`
+
+	// ValuesDelimiter splits the columns of multi-valued fields, CN codes and UN numbers
+	ValuesDelimiter string `json:"values_delimiter" valid:""`
+
+	DryRun bool   `json:"dry_run" valid:""`
+	Report string `json:"report" valid:"in(json|csv)"`
+}
+
+// NewCSVImport creates a CSVImport from params sent in URL query string, TSV files are read if contentType is text/tab-separated-values.
+// url example: http://products/import/csv?delimiter=semicolon&encoding=windows-1252&map[Código]=ProductCode&map[NC]=CNCode&values_delimiter=,&dry_run=true&report=csv
+func NewCSVImport(params url.Values, contentType string) (*CSVImport, *mresponse.ErrorResponse) {
+	req := CSVImport{
+		Delimiter:       ',',
+		Encoding:        helper.EncodingUTF8,
+		Mapping:         map[string]string{},
+		ValuesDelimiter: "|",
+		Report:          ReportJSON,
+	}
+
+	details := []mresponse.ErrorDetail{}
+
+	// set delimiter
+	if contentType == "text/tab-separated-values" {
+		req.Delimiter = '\t'
+	}
+
+	if delimiter := params.Get("delimiter"); delimiter != "" {
+		if named, ok := namedDelimiters[delimiter]; ok {
+			delimiter = named
+		}
+
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			details = append(details, mresponse.ErrorDetail{
+				Property: "delimiter",
+				Message:  "Must be a single character or one of comma|semicolon|tab|pipe",
+			})
+		}
+		req.Delimiter = r
+	}
+
+	// set encoding
+	if encoding := params.Get("encoding"); encoding != "" {
+		req.Encoding = strings.ToLower(encoding)
+	}
+
+	if _, err := helper.NewDecoder(strings.NewReader(""), req.Encoding); err != nil {
+		details = append(details, mresponse.ErrorDetail{
+			Property: "encoding",
+			Message:  "Must be utf-8|windows-1252|iso-8859-1",
+		})
+	}
+
+	// set columns mapping, with params map[Header]=Field
+	for param, values := range params {
+		if !strings.HasPrefix(param, "map[") || !strings.HasSuffix(param, "]") {
+			continue
+		}
+
+		header := strings.TrimSpace(param[4 : len(param)-1])
+		field := importField(strings.TrimSpace(values[0]))
+
+		if header == "" || field == "" {
+			allowed := []string{}
+			for f := range ImportFields {
+				allowed = append(allowed, f)
+			}
+			sort.Strings(allowed)
+
+			details = append(details, mresponse.ErrorDetail{
+				Property: param,
+				Message:  "Must map a column header to one of " + strings.Join(allowed, "|"),
+			})
+			continue
+		}
+
+		req.Mapping[header] = field
+	}
+
+	// set values delimiter
+	if delimiter := params.Get("values_delimiter"); delimiter != "" {
+		req.ValuesDelimiter = delimiter
+	}
+
+	if strings.ContainsRune(req.ValuesDelimiter, req.Delimiter) {
+		details = append(details, mresponse.ErrorDetail{
+			Property: "values_delimiter",
+			Message:  "Must be different from the columns delimiter",
+		})
+	}
+
+	// set dry run and report format
+	req.DryRun = parseBool(params, "dry_run", &details)
+
+	if report := params.Get("report"); report != "" {
+		req.Report = report
+	}
+
+	if len(details) != 0 {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	e := errors.ValidateRequest(&req)
+	if e != nil {
+		return nil, e
+	}
+
+	return &req, nil
+}
+
+// Columns returns the product field of each column of the header row, empty for columns that are not imported
+func (this *CSVImport) Columns(header []string) ([]string, error) {
+	columns := make([]string, len(header))
+	found := map[string]bool{}
+
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+
+		field, ok := this.Mapping[h]
+		if ok {
+			found[h] = true
+		} else {
+			field = importField(h)
+		}
+
+		columns[i] = field
+	}
+
+	for h := range this.Mapping {
+		if !found[h] {
+			return nil, fmt.Errorf("Column %s is mapped but is not in the header row", h)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, field := range columns {
+		if field != "" && seen[field] {
+			return nil, fmt.Errorf("Field %s is read from more than one column", field)
+		}
+		seen[field] = true
+	}
+
+	return columns, nil
+}
+
+// importField returns the product field named name, or its alias, or empty if there's none
+func importField(name string) string {
+	if field, ok := importAliases[name]; ok {
+		return field
+	}
+
+	if ImportFields[name] {
+		return name
+	}
+
+	return ""
+}
@@ -0,0 +1,45 @@
+package mrequest
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNewCSVImport(t *testing.T) {
+	params, _ := url.ParseQuery("delimiter=tab&encoding=ISO-8859-1&map[Ref]=ProductNumberCode&map[NC]=CNCode&dry_run=true&report=csv")
+
+	req, e := NewCSVImport(params, "text/csv")
+	if e != nil {
+		t.Fatalf("Expected no error but got %v", e.Errors)
+	}
+
+	if req.Delimiter != '\t' || req.Encoding != "iso-8859-1" || !req.DryRun || req.Report != ReportCSV || req.ValuesDelimiter != "|" {
+		t.Fatalf("Unexpected import request %v", req)
+	}
+
+	columns, err := req.Columns([]string{"ProductCode", " Ref ", "Notes", "NC"})
+	if err != nil {
+		t.Fatalf("Expected no error but got %v", err)
+	}
+
+	expected := []string{"ProductCode", "ProductNumberCode", "", "CustomsDetails.CNCode"}
+	for i, field := range expected {
+		if columns[i] != field {
+			t.Fatalf("Expected column %d to be %q but got %q", i, field, columns[i])
+		}
+	}
+
+	for _, header := range [][]string{{"ProductCode", "NC"}, {"Ref", "NC", "CNCode"}} {
+		if _, err := req.Columns(header); err == nil {
+			t.Fatalf("Expected a columns error for %v", header)
+		}
+	}
+
+	for _, query := range []string{"delimiter=ab", "encoding=utf-16", "map[Ref]=Unknown", "values_delimiter=,", "report=xml", "dry_run=maybe"} {
+		params, _ = url.ParseQuery(query)
+		_, e = NewCSVImport(params, "")
+		if e == nil || e.HttpCode != 400 {
+			t.Fatalf("Expected an import error for %s", query)
+		}
+	}
+}
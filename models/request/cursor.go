@@ -0,0 +1,64 @@
+package mrequest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// ListCursor is the position of the last product of a page in cursor (keyset) pagination mode:
+// its values on the sort fields plus its id as tie-breaker. Clients get it as an opaque token.
+// Values are nil where the product has no value, null and missing fields sort apart from "".
+type ListCursor struct {
+	Sort   string
+	Order  string
+	Values []*string
+	ID     objectid.ObjectID
+}
+
+// listCursorToken is the serialized form of a ListCursor
+type listCursorToken struct {
+	Sort   string    `json:"s"`
+	Order  string    `json:"o"`
+	Values []*string `json:"v,omitempty"`
+	ID     string    `json:"id"`
+}
+
+// Encode returns the opaque token of the cursor
+func (c *ListCursor) Encode() string {
+	b, _ := json.Marshal(listCursorToken{
+		Sort:   c.Sort,
+		Order:  c.Order,
+		Values: c.Values,
+		ID:     c.ID.Hex(),
+	})
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeListCursor parses a token returned by ListCursor.Encode
+func DecodeListCursor(token string) (*ListCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	t := listCursorToken{}
+	err = json.Unmarshal(b, &t)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := objectid.FromHex(t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ListCursor{
+		Sort:   t.Sort,
+		Order:  t.Order,
+		Values: t.Values,
+		ID:     id,
+	}, nil
+}
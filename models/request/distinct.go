@@ -0,0 +1,61 @@
+package mrequest
+
+import (
+	"net/url"
+	"products/models/response"
+	"products/util/errors"
+	"sort"
+	"strings"
+)
+
+// DistinctFields are the product fields distinct values can be listed of
+var DistinctFields = map[string]bool{
+	"ProductType":             true,
+	"ProductCode":             true,
+	"ProductGroup":            true,
+	"ProductNumberCode":       true,
+	"CustomsDetails.CNCode":   true,
+	"CustomsDetails.UNNumber": true,
+}
+
+type DistinctRequest struct {
+	Field      string `json:"field" valid:"required"`
+	Prefix     string `json:"prefix" valid:""`
+	WithCounts bool   `json:"with_counts" valid:""`
+}
+
+// NewDistinctRequest creates a DistinctRequest for field with the options sent in URL query string.
+// withCounts is the default of the "with_counts" param.
+// url example: http://products/distinct?field=ProductGroup&prefix=ferr&with_counts=true
+func NewDistinctRequest(field string, params url.Values, withCounts bool) (*DistinctRequest, *mresponse.ErrorResponse) {
+	req := DistinctRequest{
+		Field:      field,
+		Prefix:     strings.TrimSpace(params.Get("prefix")),
+		WithCounts: withCounts,
+	}
+
+	details := []mresponse.ErrorDetail{}
+
+	if !DistinctFields[field] {
+		allowed := []string{}
+		for f := range DistinctFields {
+			allowed = append(allowed, f)
+		}
+		sort.Strings(allowed)
+
+		details = append(details, mresponse.ErrorDetail{
+			Property: "field",
+			Message:  "Must be one of " + strings.Join(allowed, "|"),
+		})
+	}
+
+	if params.Get("with_counts") != "" {
+		req.WithCounts = parseBool(params, "with_counts", &details)
+	}
+
+	if len(details) != 0 {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	return &req, nil
+}
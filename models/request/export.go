@@ -0,0 +1,121 @@
+package mrequest
+
+import (
+	"net/url"
+	"products/models/response"
+	"products/util/errors"
+	"strings"
+)
+
+// ExportColumns are the columns products can be exported with, in the order they're written when columns are not selected
+var ExportColumns = []string{
+	"id",
+	"version",
+	"status",
+	"ProductType",
+	"ProductCode",
+	"ProductGroup",
+	"ProductDescription",
+	"ProductNumberCode",
+	"CustomsDetails.CNCode",
+	"CustomsDetails.UNNumber",
+	"archived_at",
+	"archive_reason",
+}
+
+// Formats of the export
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+)
+
+type ExportRequest struct {
+	List    *ListRequest `json:"list" valid:"-"`
+	Format  string       `json:"format" valid:"required,in(csv|ndjson)~Must be csv|ndjson"`
+	Columns []string     `json:"columns" valid:"-"`
+
+	// ValuesDelimiter joins the values of multi-valued columns, CN codes and UN numbers, in CSV files
+	ValuesDelimiter string `json:"values_delimiter" valid:""`
+}
+
+// NewExportRequest creates an ExportRequest from params sent in URL query string.
+// Products are selected and sorted with the same params of NewListRequest, but all of them are exported.
+// url example: http://products/export?format=csv&columns=ProductCode,ProductDescription,CNCode&ProductGroup[prefix]=Ferr&sort=ProductCode
+func NewExportRequest(params url.Values, allowedSorts map[string]string, allowedFilters map[string]string) (*ExportRequest, *mresponse.ErrorResponse) {
+	req := ExportRequest{
+		Format:          params.Get("format"),
+		Columns:         ExportColumns,
+		ValuesDelimiter: "|",
+	}
+
+	if req.Format == "" {
+		req.Format = ExportCSV
+	}
+
+	if delimiter := params.Get("values_delimiter"); delimiter != "" {
+		req.ValuesDelimiter = delimiter
+	}
+
+	// pagination and projection params don't apply to exports
+	listParams := url.Values{}
+	for param, values := range params {
+		switch param {
+		case "cursor", "fields", "exclude":
+		default:
+			listParams[param] = values
+		}
+	}
+
+	list, e := NewListRequest(listParams, allowedSorts, allowedFilters)
+	if e != nil {
+		return nil, e
+	}
+	req.List = list
+
+	// set columns, reading only their fields
+	if columns := params.Get("columns"); columns != "" {
+		req.Columns = []string{}
+		req.List.Projection = &Projection{Fields: []string{}}
+
+		seen := map[string]bool{}
+		for _, column := range strings.Split(columns, ",") {
+			column = strings.TrimSpace(column)
+			if alias, ok := importAliases[column]; ok {
+				column = alias
+			}
+
+			if !isExportColumn(column) || seen[column] {
+				details := []mresponse.ErrorDetail{
+					mresponse.ErrorDetail{
+						Property: "columns",
+						Message:  "Must be a comma separated list of unique columns, allowed columns are " + strings.Join(ExportColumns, "|"),
+					},
+				}
+				return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+			}
+			seen[column] = true
+
+			req.Columns = append(req.Columns, column)
+			if column != "id" {
+				req.List.Projection.Fields = append(req.List.Projection.Fields, column)
+			}
+		}
+	}
+
+	e = errors.ValidateRequest(&req)
+	if e != nil {
+		return nil, e
+	}
+
+	return &req, nil
+}
+
+func isExportColumn(column string) bool {
+	for _, c := range ExportColumns {
+		if c == column {
+			return true
+		}
+	}
+
+	return false
+}
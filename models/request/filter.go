@@ -0,0 +1,136 @@
+package mrequest
+
+import (
+	"net/url"
+	"products/models/response"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// Filter operators
+const (
+	FilterEq       = "eq"
+	FilterNe       = "ne"
+	FilterIn       = "in"
+	FilterNin      = "nin"
+	FilterPrefix   = "prefix"
+	FilterContains = "contains"
+	FilterExists   = "exists"
+	FilterGt       = "gt"
+	FilterGte      = "gte"
+	FilterLt       = "lt"
+	FilterLte      = "lte"
+)
+
+var filterOperators = map[string]bool{
+	FilterEq:       true,
+	FilterNe:       true,
+	FilterIn:       true,
+	FilterNin:      true,
+	FilterPrefix:   true,
+	FilterContains: true,
+	FilterExists:   true,
+	FilterGt:       true,
+	FilterGte:      true,
+	FilterLt:       true,
+	FilterLte:      true,
+}
+
+// Filter is a condition on a product field.
+// Values hold strings, except for "_id" filters which hold objectid.ObjectID and "exists" filters which hold one bool.
+type Filter struct {
+	Field    string        `json:"field"`
+	Operator string        `json:"operator"`
+	Values   []interface{} `json:"values" valid:"-"`
+}
+
+// parseFilters reads filters from params with format Field[operator]=value, e.g. ProductCode[prefix]=ABC or ProductType[in]=P,S.
+// A param without operator (Field=value) keeps the original behaviour: "eq" for _id and case insensitive "contains" for the other fields.
+// Params that are not allowed filters are ignored.
+func parseFilters(params url.Values, allowedFilters map[string]string) ([]*Filter, []mresponse.ErrorDetail) {
+	filters := []*Filter{}
+	details := []mresponse.ErrorDetail{}
+
+	for param, values := range params {
+		name, operator := param, ""
+		if i := strings.Index(param, "["); i > 0 && strings.HasSuffix(param, "]") {
+			name, operator = param[:i], param[i+1:len(param)-1]
+		}
+
+		field, ok := allowedFilters[name]
+		if !ok {
+			continue
+		}
+
+		if operator == "" {
+			operator = FilterContains
+			if field == "_id" {
+				operator = FilterEq
+			}
+		}
+
+		if !filterOperators[operator] {
+			details = append(details, mresponse.ErrorDetail{
+				Property: param,
+				Message:  "Unknown filter operator, must be one of eq|ne|in|nin|prefix|contains|exists|gt|gte|lt|lte",
+			})
+			continue
+		}
+
+		filter, message := newFilter(field, operator, values[0])
+		if message != "" {
+			details = append(details, mresponse.ErrorDetail{
+				Property: param,
+				Message:  message,
+			})
+			continue
+		}
+
+		filters = append(filters, filter)
+	}
+
+	return filters, details
+}
+
+// newFilter builds a filter parsing the raw value according to the operator and field, returning an error message if it's invalid
+func newFilter(field string, operator string, raw string) (*Filter, string) {
+	f := Filter{
+		Field:    field,
+		Operator: operator,
+	}
+
+	if operator == FilterExists {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, "Must be true or false"
+		}
+		f.Values = []interface{}{b}
+		return &f, ""
+	}
+
+	raws := []string{raw}
+	if operator == FilterIn || operator == FilterNin {
+		raws = strings.Split(raw, ",")
+	}
+
+	for _, r := range raws {
+		if field != "_id" {
+			f.Values = append(f.Values, r)
+			continue
+		}
+
+		if operator == FilterPrefix || operator == FilterContains {
+			return nil, "Operator not supported on ids"
+		}
+
+		oid, err := objectid.FromHex(r)
+		if err != nil {
+			return nil, "Must be a valid product id"
+		}
+		f.Values = append(f.Values, oid)
+	}
+
+	return &f, ""
+}
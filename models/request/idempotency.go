@@ -0,0 +1,12 @@
+package mrequest
+
+import "time"
+
+// IdempotencyKeyCreate reserves an Idempotency-Key for a request while it's processed.
+// RequestHash identifies the request, the key can't be used again with a different one.
+type IdempotencyKeyCreate struct {
+	Key         string    `bson:"_id"`
+	RequestHash string    `bson:"RequestHash"`
+	CreatedAt   time.Time `bson:"CreatedAt"`
+	ExpiresAt   time.Time `bson:"ExpiresAt"`
+}
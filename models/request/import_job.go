@@ -0,0 +1,48 @@
+package mrequest
+
+import (
+	"net/url"
+	"products/models/response"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// ImportJobCreate is a queued import of a CSV file, processed in background with the options it was created with
+type ImportJobCreate struct {
+	ID          objectid.ObjectID           `bson:"_id" json:"-"`
+	Status      string                      `bson:"Status" json:"status"`
+	FileName    string                      `bson:"FileName" json:"file_name"`
+	ContentType string                      `bson:"ContentType" json:"content_type"`
+	Options     string                      `bson:"Options" json:"options"`
+	DryRun      bool                        `bson:"DryRun" json:"dry_run"`
+	Size        int64                       `bson:"Size" json:"size"`
+	Errors      []*mresponse.ImportRowError `bson:"Errors" json:"-"`
+	CreatedAt   time.Time                   `bson:"CreatedAt" json:"created_at"`
+}
+
+// NewImportJobCreate creates an ImportJobCreate for a file with the options of NewCSVImport sent in URL query string.
+// Options are validated and stored encoded as a query string, to be parsed again when the job is processed.
+func NewImportJobCreate(params url.Values, contentType string, fileName string) (*ImportJobCreate, *mresponse.ErrorResponse) {
+	options, e := NewCSVImport(params, contentType)
+	if e != nil {
+		return nil, e
+	}
+
+	return &ImportJobCreate{
+		Status:      mresponse.JobQueued,
+		FileName:    fileName,
+		ContentType: contentType,
+		Options:     params.Encode(),
+		DryRun:      options.DryRun,
+		Errors:      []*mresponse.ImportRowError{},
+		CreatedAt:   time.Now().UTC(),
+	}, nil
+}
+
+// ParseImportOptions parses the import options stored by a job, encoded as a query string
+func ParseImportOptions(options string, contentType string) (*CSVImport, *mresponse.ErrorResponse) {
+	params, _ := url.ParseQuery(options)
+
+	return NewCSVImport(params, contentType)
+}
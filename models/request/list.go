@@ -2,39 +2,88 @@ package mrequest
 
 import (
 	"net/url"
+	"products/models/response"
+	"products/util/errors"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type ListRequest struct {
-	PerPage int                    `json:"per_page" valid:"required"`
-	Page    int                    `json:"page" valid:"required"`
-	Sort    string                 `json:"sort" valid:"required,in(id|_id)"`
-	Order   string                 `json:"order" valid:"required,in(normal|reverse)"`
-	Filters map[string]interface{} `json:"filters" valid:""`
+	PerPage int        `json:"per_page" valid:"required"`
+	Page    int        `json:"page" valid:"required"`
+	Sort    string     `json:"sort" valid:"required"`
+	Order   string     `json:"order" valid:"required,in(normal|reverse)"`
+	Filters []*Filter  `json:"filters" valid:""`
+	AsOf    *time.Time `json:"as_of" valid:""`
+
+	// SortKeys are the fields parsed from Sort, with the direction already reversed if Order is "reverse".
+	// They always include _id, added as last key if not requested, so products are always in the same order.
+	SortKeys []*SortKey `json:"sort_keys" valid:"-"`
+
+	IncludeArchived bool     `json:"include_archived" valid:""`
+	OnlyArchived    bool     `json:"only_archived" valid:""`
+	Status          []string `json:"status" valid:""`
+
+	// CursorMode enables keyset pagination, pages start after the After cursor instead of skipping previous pages
+	CursorMode bool        `json:"cursor_mode" valid:""`
+	After      *ListCursor `json:"-" valid:"-"`
+	SkipTotal  bool        `json:"skip_total" valid:""`
+
+	Projection *Projection `json:"projection" valid:"-"`
+
+	// GroupSubtree lists only the products of a product group or any of its subgroups
+	GroupSubtree string `json:"group_subtree" valid:""`
+}
+
+// SortKey is a field products are sorted by
+type SortKey struct {
+	Field      string `json:"field"`
+	Descending bool   `json:"descending"`
 }
 
 // NewListRequest creates a ListRequest from params sent in URL query string
-// url example: http://products?per_page=10&page=1&sort=id&order=normal
-func NewListRequest(params url.Values, allowedSorts map[string]string, allowedFilters map[string]string) *ListRequest {
+// url example: http://products?per_page=10&page=1&sort=ProductGroup,-ProductCode&order=normal&as_of=2018-01-01T00:00:00Z&include_archived=true&status=active,blocked
+// Filters use the format Field[operator]=value, e.g. http://products?ProductCode[prefix]=ABC&ProductType[in]=P,S
+// Products of a product group and all its subgroups are listed with group_subtree=Hardware
+// Responses can be limited to some fields with fields=ProductCode,ProductDescription or leave some out with exclude=CustomsDetails
+// Cursor pagination is used when the "cursor" param is sent, empty for the first page and then the next_cursor of the previous page,
+// e.g. http://products?sort=ProductCode&cursor=&with_total=false
+func NewListRequest(params url.Values, allowedSorts map[string]string, allowedFilters map[string]string) (*ListRequest, *mresponse.ErrorResponse) {
 	allowedOrders := make(map[string]string)
 	allowedOrders["normal"] = "normal"
 	allowedOrders["reverse"] = "reverse"
 
 	var req ListRequest
+	details := []mresponse.ErrorDetail{}
+
+	// set order
+	if order := params.Get("order"); order != "" {
+		req.Order = order
+	} else {
+		req.Order = "normal"
+	}
+
+	if _, ok := allowedOrders[req.Order]; !ok {
+		details = append(details, mresponse.ErrorDetail{
+			Property: "order",
+			Message:  "Must be normal|reverse",
+		})
+	}
 
 	// set sort
 	if sort := params.Get("sort"); sort != "" {
 		req.Sort = sort
 	} else {
-		req.Sort = "id"
+		req.Sort = "_id"
 	}
 
-	// set order
-	if order := params.Get("order"); order != "" {
-		req.Order = order
-	} else {
-		req.Order = "normal"
+	sortKeys, detail := parseSort(req.Sort, req.Order == "reverse", allowedSorts)
+	if detail != nil {
+		details = append(details, *detail)
 	}
+	req.SortKeys = sortKeys
 
 	// set per_page
 	if ok := params.Get("per_page"); ok != "" {
@@ -53,12 +102,184 @@ func NewListRequest(params url.Values, allowedSorts map[string]string, allowedFi
 	}
 
 	// set filter
-	req.Filters = make(map[string]interface{})
-	for _, filter := range allowedFilters {
-		if val, ok := params[filter]; ok {
-			req.Filters[filter] = val[0]
+	filters, filterDetails := parseFilters(params, allowedFilters)
+	details = append(details, filterDetails...)
+	req.Filters = filters
+
+	// set as_of
+	asOf, e := ParseAsOf(params)
+	if e != nil {
+		return nil, e
+	}
+	req.AsOf = asOf
+
+	// set archived products visibility
+	req.IncludeArchived = parseBool(params, "include_archived", &details)
+	req.OnlyArchived = parseBool(params, "only_archived", &details)
+
+	// set status
+	if status := params.Get("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			switch s {
+			case mresponse.StatusDraft, mresponse.StatusActive, mresponse.StatusDiscontinued, mresponse.StatusBlocked:
+				req.Status = append(req.Status, s)
+			default:
+				details = append(details, mresponse.ErrorDetail{
+					Property: "status",
+					Message:  "Must be a comma separated list of draft|active|discontinued|blocked",
+				})
+			}
+		}
+	}
+
+	// set product group subtree
+	req.GroupSubtree = params.Get("group_subtree")
+
+	// set fields projection
+	projection, e := NewProjection(params)
+	if e != nil {
+		details = append(details, e.Errors...)
+	}
+	req.Projection = projection
+
+	// set cursor pagination
+	if token, ok := params["cursor"]; ok {
+		req.CursorMode = true
+		req.Page = 1
+
+		if token[0] != "" {
+			after, err := DecodeListCursor(token[0])
+			if err != nil || after.Sort != req.Sort || after.Order != req.Order || len(after.Values) != cursorValues(req.SortKeys) {
+				details = append(details, mresponse.ErrorDetail{
+					Property: "cursor",
+					Message:  "Must be a next_cursor returned for the same sort and order",
+				})
+			}
+			req.After = after
+		}
+	}
+
+	// set total counting, it can be skipped as counting is expensive on large catalogs
+	if params.Get("with_total") != "" {
+		req.SkipTotal = !parseBool(params, "with_total", &details)
+	}
+
+	if len(details) != 0 {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	return &req, nil
+}
+
+// parseSort reads a comma separated list of fields, each one descending if prefixed by "-", e.g. ProductGroup,-ProductCode.
+// Fields must be allowed sorts, "_id" is always allowed and "id" is accepted as its alias. If reverse is set all directions are reversed.
+func parseSort(value string, reverse bool, allowedSorts map[string]string) ([]*SortKey, *mresponse.ErrorDetail) {
+	keys := []*SortKey{}
+	seen := map[string]bool{}
+
+	for _, name := range strings.Split(value, ",") {
+		key := SortKey{}
+
+		name = strings.TrimSpace(name)
+		if strings.HasPrefix(name, "-") {
+			key.Descending = true
+			name = name[1:]
+		} else if strings.HasPrefix(name, "+") { // a "+" not encoded in the query string is received as a space
+			name = name[1:]
+		}
+
+		if name == "id" {
+			name = "_id"
+		}
+
+		field, ok := allowedSorts[name]
+		if name == "_id" {
+			field, ok = "_id", true
+		}
+
+		if !ok || seen[field] {
+			allowed := []string{}
+			for s := range allowedSorts {
+				allowed = append(allowed, s)
+			}
+			sort.Strings(allowed)
+
+			return nil, &mresponse.ErrorDetail{
+				Property: "sort",
+				Message:  "Must be a comma separated list of unique fields, optionally prefixed by - for descending order, allowed fields are " + strings.Join(allowed, "|"),
+			}
+		}
+		seen[field] = true
+
+		key.Field = field
+		key.Descending = key.Descending != reverse
+		keys = append(keys, &key)
+	}
+
+	// _id is the tie-breaker of products with the same values
+	if !seen["_id"] {
+		keys = append(keys, &SortKey{Field: "_id", Descending: reverse})
+	}
+
+	return keys, nil
+}
+
+// cursorValues returns how many sort values a cursor has for the sort keys, the values of the keys before _id
+func cursorValues(keys []*SortKey) int {
+	for i, key := range keys {
+		if key.Field == "_id" {
+			return i
+		}
+	}
+
+	return len(keys)
+}
+
+// ParseAsOf reads the optional "as_of" param used to query products as they existed at that moment.
+// Accepts RFC3339 timestamps (2018-01-01T10:00:00Z) or plain dates (2018-01-01), the latter meaning the start of that day in UTC.
+func ParseAsOf(params url.Values) (*time.Time, *mresponse.ErrorResponse) {
+	value := params.Get("as_of")
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := parseTimestamp(value)
+	if err != nil {
+		details := []mresponse.ErrorDetail{
+			mresponse.ErrorDetail{
+				Property: "as_of",
+				Message:  "Must be a RFC3339 timestamp or a date with format YYYY-MM-DD",
+			},
 		}
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	return &t, nil
+}
+
+// parseBool reads an optional boolean param, adding an error detail if it is not a valid boolean
+func parseBool(params url.Values, name string, details *[]mresponse.ErrorDetail) bool {
+	value := params.Get(name)
+	if value == "" {
+		return false
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		*details = append(*details, mresponse.ErrorDetail{
+			Property: name,
+			Message:  "Must be true or false",
+		})
+	}
+
+	return b
+}
+
+func parseTimestamp(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", value)
 	}
 
-	return &req
+	return t.UTC(), err
 }
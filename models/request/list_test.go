@@ -0,0 +1,210 @@
+package mrequest
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+var allowedFilters = map[string]string{
+	"ProductCode":           "ProductCode",
+	"ProductType":           "ProductType",
+	"CustomsDetails.CNCode": "CustomsDetails.CNCode",
+	"_id":                   "_id",
+}
+
+var allowedSorts = map[string]string{
+	"ProductCode":  "ProductCode",
+	"ProductGroup": "ProductGroup",
+}
+
+func TestNewListRequestFilters(t *testing.T) {
+	params, _ := url.ParseQuery("ProductCode[prefix]=AB.C&ProductType[in]=P,S&CustomsDetails.CNCode[exists]=true&_id=507f191e810c19729de860ea&page=2")
+
+	req, e := NewListRequest(params, map[string]string{}, allowedFilters)
+	if e != nil {
+		t.Fatalf("Expected no error but got %v", e.Errors)
+	}
+
+	if len(req.Filters) != 4 {
+		t.Fatalf("Expected 4 filters but got %d", len(req.Filters))
+	}
+
+	filters := map[string]*Filter{}
+	for _, f := range req.Filters {
+		filters[f.Field] = f
+	}
+
+	if f := filters["ProductCode"]; f.Operator != FilterPrefix || f.Values[0] != "AB.C" {
+		t.Fatalf("Unexpected ProductCode filter %v", f)
+	}
+
+	if f := filters["ProductType"]; f.Operator != FilterIn || len(f.Values) != 2 || f.Values[1] != "S" {
+		t.Fatalf("Unexpected ProductType filter %v", f)
+	}
+
+	if f := filters["CustomsDetails.CNCode"]; f.Operator != FilterExists || f.Values[0] != true {
+		t.Fatalf("Unexpected CustomsDetails.CNCode filter %v", f)
+	}
+
+	oid, _ := objectid.FromHex("507f191e810c19729de860ea")
+	if f := filters["_id"]; f.Operator != FilterEq || f.Values[0] != oid {
+		t.Fatalf("Unexpected _id filter %v", f)
+	}
+}
+
+func TestNewListRequestDefaultFilterOperator(t *testing.T) {
+	params, _ := url.ParseQuery("ProductCode=abc")
+
+	req, e := NewListRequest(params, map[string]string{}, allowedFilters)
+	if e != nil {
+		t.Fatalf("Expected no error but got %v", e.Errors)
+	}
+
+	if len(req.Filters) != 1 || req.Filters[0].Operator != FilterContains {
+		t.Fatalf("Expected a contains filter but got %v", req.Filters)
+	}
+}
+
+func TestNewListRequestInvalidFilters(t *testing.T) {
+	queries := []string{
+		"ProductCode[like]=abc",
+		"_id=not-an-id",
+		"_id[contains]=507f",
+		"ProductCode[exists]=maybe",
+	}
+
+	for _, query := range queries {
+		params, _ := url.ParseQuery(query)
+
+		_, e := NewListRequest(params, map[string]string{}, allowedFilters)
+		if e == nil || e.HttpCode != 400 {
+			t.Fatalf("Expected invalid request for %s", query)
+		}
+	}
+}
+
+func TestNewListRequestCursor(t *testing.T) {
+	params, _ := url.ParseQuery("sort=ProductCode&cursor=&with_total=false")
+
+	req, e := NewListRequest(params, allowedSorts, allowedFilters)
+	if e != nil {
+		t.Fatalf("Expected no error but got %v", e.Errors)
+	}
+
+	if !req.CursorMode || req.After != nil || !req.SkipTotal {
+		t.Fatalf("Unexpected first page request %v", req)
+	}
+
+	oid, _ := objectid.FromHex("507f191e810c19729de860ea")
+	value := "AB.C"
+	next := ListCursor{Sort: "ProductCode", Order: "normal", Values: []*string{&value}, ID: oid}
+
+	params = url.Values{"sort": {"ProductCode"}, "cursor": {next.Encode()}}
+	req, e = NewListRequest(params, allowedSorts, allowedFilters)
+	if e != nil {
+		t.Fatalf("Expected no error but got %v", e.Errors)
+	}
+
+	if req.After == nil || *req.After.Values[0] != "AB.C" || req.After.ID != oid || req.SkipTotal {
+		t.Fatalf("Unexpected next page request %v", req)
+	}
+
+	// products without a value on the sort field are encoded as null
+	next.Values = []*string{nil}
+	params = url.Values{"sort": {"ProductCode"}, "cursor": {next.Encode()}}
+	req, e = NewListRequest(params, allowedSorts, allowedFilters)
+	if e != nil || len(req.After.Values) != 1 || req.After.Values[0] != nil {
+		t.Fatalf("Expected a null cursor value but got %v %v", req, e)
+	}
+
+	// cursors are only valid for the sort they were created for
+	params = url.Values{"sort": {"-ProductCode"}, "cursor": {next.Encode()}}
+	_, e = NewListRequest(params, allowedSorts, allowedFilters)
+	if e == nil || e.HttpCode != 400 {
+		t.Fatal("Expected a cursor error")
+	}
+
+	params = url.Values{"cursor": {"not-a-cursor"}}
+	_, e = NewListRequest(params, allowedSorts, allowedFilters)
+	if e == nil || e.HttpCode != 400 {
+		t.Fatal("Expected a cursor error")
+	}
+}
+
+func TestNewListRequestSort(t *testing.T) {
+	params, _ := url.ParseQuery("sort=ProductGroup,-ProductCode&order=reverse")
+
+	req, e := NewListRequest(params, allowedSorts, allowedFilters)
+	if e != nil {
+		t.Fatalf("Expected no error but got %v", e.Errors)
+	}
+
+	expected := []SortKey{{"ProductGroup", true}, {"ProductCode", false}, {"_id", true}}
+	if len(req.SortKeys) != len(expected) {
+		t.Fatalf("Expected %d sort keys but got %d", len(expected), len(req.SortKeys))
+	}
+	for i, key := range req.SortKeys {
+		if *key != expected[i] {
+			t.Fatalf("Expected sort key %v but got %v", expected[i], *key)
+		}
+	}
+
+	// id is an alias of _id, which is not added again as tie-breaker
+	params, _ = url.ParseQuery("sort=-id")
+	req, e = NewListRequest(params, allowedSorts, allowedFilters)
+	if e != nil || len(req.SortKeys) != 1 || req.SortKeys[0].Field != "_id" || !req.SortKeys[0].Descending {
+		t.Fatalf("Unexpected sort keys %v", req.SortKeys)
+	}
+
+	for _, sort := range []string{"ProductDescription", "ProductCode,-ProductCode", "ProductCode,"} {
+		params = url.Values{"sort": {sort}}
+		_, e = NewListRequest(params, allowedSorts, allowedFilters)
+		if e == nil || e.HttpCode != 400 || e.Errors[0].Property != "sort" {
+			t.Fatalf("Expected a sort error for %q", sort)
+		}
+	}
+}
+
+func TestProjection(t *testing.T) {
+	params, _ := url.ParseQuery("fields=ProductCode,CustomsDetails.CNCode")
+
+	p, e := NewProjection(params)
+	if e != nil {
+		t.Fatalf("Expected no error but got %v", e.Errors)
+	}
+
+	doc := map[string]interface{}{
+		"id":                 "507f191e810c19729de860ea",
+		"version":            3,
+		"ProductCode":        "ABC",
+		"ProductDescription": "some description",
+		"CustomsDetails": map[string]interface{}{
+			"CNCode":   []string{"1234"},
+			"UNNumber": []string{"5678"},
+		},
+	}
+
+	res := p.Trim(doc)
+	customs, _ := res["CustomsDetails"].(map[string]interface{})
+	if len(res) != 3 || res["ProductCode"] != "ABC" || res["id"] == nil || len(customs) != 1 || customs["CNCode"] == nil {
+		t.Fatalf("Unexpected projection %v", res)
+	}
+
+	params, _ = url.ParseQuery("exclude=CustomsDetails,version")
+	p, _ = NewProjection(params)
+
+	res = p.Trim(doc)
+	if len(res) != 3 || res["CustomsDetails"] != nil || res["version"] != nil {
+		t.Fatalf("Unexpected projection %v", res)
+	}
+
+	for _, query := range []string{"fields=ProductCode&exclude=CustomsDetails", "fields=Unknown"} {
+		params, _ = url.ParseQuery(query)
+		_, e = NewProjection(params)
+		if e == nil || e.HttpCode != 400 {
+			t.Fatalf("Expected a projection error for %s", query)
+		}
+	}
+}
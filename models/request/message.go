@@ -0,0 +1,31 @@
+package mrequest
+
+import "encoding/json"
+
+// Types of the product messages consumed from Kafka, each one an operation over the products of its payload
+const (
+	MessageProductsCreate = "products.create" // payload is an array of ProductCreate, none is created if any is invalid
+	MessageProductsUpsert = "products.upsert" // payload is an array of ProductCreate, matched to existing products by code
+	MessageProductsDelete = "products.delete" // payload is an array of MessageProductDelete
+)
+
+// MessageVersion is the version of the envelope and payloads this service understands.
+// Changes breaking the contract bump it, messages of other versions are set aside in the dead letter topic.
+const MessageVersion = 1
+
+// MessageEnvelope is the contract of messages consumed from Kafka: the payload with what's needed to process and trace it.
+// Source is the producing system, CorrelationID traces the message across systems and Tenant is the owner of the data.
+type MessageEnvelope struct {
+	Type          string          `json:"type"`
+	Version       int             `json:"version"`
+	Source        string          `json:"source,omitempty"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Tenant        string          `json:"tenant,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// MessageProductDelete identifies a product to delete, which is archived with the reason provided
+type MessageProductDelete struct {
+	ProductCode string `json:"ProductCode" valid:"required~Field token cannot be empty or is missing"`
+	Reason      string `json:"reason,omitempty" valid:"runelength(1|200)~Must be between 1 and 200 characters"`
+}
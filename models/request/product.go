@@ -1,16 +1,22 @@
 package mrequest
 
 import (
+	"products/models/response"
+
 	"github.com/mongodb/mongo-go-driver/bson/objectid"
 )
 
 type ProductCreate struct {
-	ProductType        string          `bson:"ProductType" json:"ProductType,omitempty" valid:"required~Field token cannot be empty or is missing,in(P|S|O)~Must be P|S|O"`
-	ProductCode        string          `bson:"ProductCode" json:"ProductCode,omitempty" valid:"required~Field token cannot be empty or is missing"`
-	ProductGroup       string          `bson:"ProductGroup" json:"ProductGroup,omitempty" valid:"runelength(1|50)~Must be between 1 and 50 characters"`
-	ProductDescription string          `bson:"ProductDescription" json:"ProductDescription,omitempty" valid:"required~Field token cannot be empty or is missing,runelength(2|200)~Must be between 2 and 200 characters"`
-	ProductNumberCode  string          `bson:"ProductNumberCode" json:"ProductNumberCode,omitempty" valid:"required~Field token cannot be empty or is missing,runelength(1|60)~Must be between 1 and 60 characters"`
-	CustomsDetails     *CustomsDetails `bson:"CustomsDetails" json:"CustomsDetails,omitempty"`
+	ID                 objectid.ObjectID `bson:"_id" json:"-"`
+	Version            int64             `bson:"Version" json:"-"`
+	Status             string            `bson:"Status" json:"status,omitempty" valid:"in(draft|active)~Must be draft|active"`
+	ProductType        string            `bson:"ProductType" json:"ProductType,omitempty" valid:"required~Field token cannot be empty or is missing,in(P|S|O)~Must be P|S|O"`
+	ProductCode        string            `bson:"ProductCode" json:"ProductCode,omitempty" valid:"required~Field token cannot be empty or is missing"`
+	ProductGroup       string            `bson:"ProductGroup" json:"ProductGroup,omitempty" valid:"runelength(1|50)~Must be between 1 and 50 characters"`
+	ProductDescription string            `bson:"ProductDescription" json:"ProductDescription,omitempty" valid:"required~Field token cannot be empty or is missing,runelength(2|200)~Must be between 2 and 200 characters"`
+	ProductNumberCode  string            `bson:"ProductNumberCode" json:"ProductNumberCode,omitempty" valid:"required~Field token cannot be empty or is missing,runelength(1|60)~Must be between 1 and 60 characters"`
+	CustomsDetails     *CustomsDetails   `bson:"CustomsDetails" json:"CustomsDetails,omitempty"`
+	SuggestKeys        []string          `bson:"SuggestKeys" json:"-" valid:"-"`
 }
 
 type ProductRead struct {
@@ -32,6 +38,55 @@ type ProductUpdate struct {
 	CustomsDetails     *CustomsDetails `bson:"CustomsDetails" json:"CustomsDetails,omitempty"`
 }
 
+// ProductPatch holds a partial update of a product, only the provided fields are changed
+type ProductPatch struct {
+	ProductType        *string         `json:"ProductType,omitempty"`
+	ProductCode        *string         `json:"ProductCode,omitempty"`
+	ProductGroup       *string         `json:"ProductGroup,omitempty"`
+	ProductDescription *string         `json:"ProductDescription,omitempty"`
+	ProductNumberCode  *string         `json:"ProductNumberCode,omitempty"`
+	CustomsDetails     *CustomsDetails `json:"CustomsDetails,omitempty"`
+}
+
+// Merge applies the patch over the current product state, returning the resulting full update
+func (p *ProductPatch) Merge(current *mresponse.ProductRead) *ProductUpdate {
+	u := ProductUpdate{
+		ProductType:        current.ProductType,
+		ProductCode:        current.ProductCode,
+		ProductGroup:       current.ProductGroup,
+		ProductDescription: current.ProductDescription,
+		ProductNumberCode:  current.ProductNumberCode,
+	}
+
+	if current.CustomsDetails != nil {
+		u.CustomsDetails = &CustomsDetails{
+			CNCode:   current.CustomsDetails.CNCode,
+			UNNumber: current.CustomsDetails.UNNumber,
+		}
+	}
+
+	if p.ProductType != nil {
+		u.ProductType = *p.ProductType
+	}
+	if p.ProductCode != nil {
+		u.ProductCode = *p.ProductCode
+	}
+	if p.ProductGroup != nil {
+		u.ProductGroup = *p.ProductGroup
+	}
+	if p.ProductDescription != nil {
+		u.ProductDescription = *p.ProductDescription
+	}
+	if p.ProductNumberCode != nil {
+		u.ProductNumberCode = *p.ProductNumberCode
+	}
+	if p.CustomsDetails != nil {
+		u.CustomsDetails = p.CustomsDetails
+	}
+
+	return &u
+}
+
 type ProductDelete struct {
 	ID                 objectid.ObjectID `bson:"_id" json:"id,omitempty" valid:"required~Cannot be empty" bson:"_id"`
 	ProductType        string            `bson:"ProductType" json:"ProductType,omitempty" bson:"ProductType"`
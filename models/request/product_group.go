@@ -0,0 +1,20 @@
+package mrequest
+
+import (
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+type ProductGroupCreate struct {
+	ID        objectid.ObjectID `bson:"_id" json:"-"`
+	Code      string            `bson:"Code" json:"code,omitempty" valid:"required~Field token cannot be empty or is missing,runelength(1|50)~Must be between 1 and 50 characters"`
+	Name      string            `bson:"Name" json:"name,omitempty" valid:"required~Field token cannot be empty or is missing,runelength(1|100)~Must be between 1 and 100 characters"`
+	Parent    string            `bson:"Parent" json:"parent,omitempty" valid:"runelength(1|50)~Must be between 1 and 50 characters"`
+	Ancestors []string          `bson:"Ancestors" json:"-" valid:"-"`
+}
+
+// ProductGroupUpdate changes the name of a product group or moves it, along with its subgroups, under another parent.
+// An empty parent makes it a root group.
+type ProductGroupUpdate struct {
+	Name   string `bson:"Name" json:"name,omitempty" valid:"required~Field token cannot be empty or is missing,runelength(1|100)~Must be between 1 and 100 characters"`
+	Parent string `bson:"Parent" json:"parent,omitempty" valid:"runelength(1|50)~Must be between 1 and 50 characters"`
+}
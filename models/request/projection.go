@@ -0,0 +1,147 @@
+package mrequest
+
+import (
+	"net/url"
+	"products/models/response"
+	"products/util/errors"
+	"sort"
+	"strings"
+)
+
+// ProjectionFields maps the product fields that can be selected or excluded, by their JSON name, to their stored name
+var ProjectionFields = map[string]string{
+	"ProductType":             "ProductType",
+	"ProductCode":             "ProductCode",
+	"ProductGroup":            "ProductGroup",
+	"ProductDescription":      "ProductDescription",
+	"ProductNumberCode":       "ProductNumberCode",
+	"CustomsDetails":          "CustomsDetails",
+	"CustomsDetails.CNCode":   "CustomsDetails.CNCode",
+	"CustomsDetails.UNNumber": "CustomsDetails.UNNumber",
+	"version":                 "Version",
+	"status":                  "Status",
+	"archived_at":             "ArchivedAt",
+	"archive_reason":          "ArchiveReason",
+}
+
+// Projection holds the product fields, by their JSON name, to be returned (Fields) or left out (Exclude) of a response.
+// Only one of them is set, the product id is always returned.
+type Projection struct {
+	Fields  []string `json:"fields"`
+	Exclude []string `json:"exclude"`
+}
+
+// NewProjection creates a Projection from the "fields" or "exclude" params sent in URL query string, nil if none was sent
+// url example: http://products?fields=ProductCode,ProductDescription or http://products?exclude=CustomsDetails
+func NewProjection(params url.Values) (*Projection, *mresponse.ErrorResponse) {
+	fields, exclude := params.Get("fields"), params.Get("exclude")
+	if fields == "" && exclude == "" {
+		return nil, nil
+	}
+
+	details := []mresponse.ErrorDetail{}
+
+	if fields != "" && exclude != "" {
+		details = append(details, mresponse.ErrorDetail{
+			Property: "exclude",
+			Message:  "Cannot be used along with fields",
+		})
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	p := Projection{}
+	p.Fields, details = parseProjectionFields("fields", fields, details)
+	p.Exclude, details = parseProjectionFields("exclude", exclude, details)
+
+	if len(details) != 0 {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	return &p, nil
+}
+
+// parseProjectionFields splits a comma separated list of fields, adding an error detail for each unknown field
+func parseProjectionFields(param string, value string, details []mresponse.ErrorDetail) ([]string, []mresponse.ErrorDetail) {
+	if value == "" {
+		return nil, details
+	}
+
+	fields := []string{}
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if _, ok := ProjectionFields[field]; !ok {
+			allowed := []string{}
+			for f := range ProjectionFields {
+				allowed = append(allowed, f)
+			}
+			sort.Strings(allowed)
+
+			details = append(details, mresponse.ErrorDetail{
+				Property: param,
+				Message:  "Unknown field " + field + ", allowed fields are " + strings.Join(allowed, "|"),
+			})
+			continue
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, details
+}
+
+// Trim removes from a product JSON document the fields not selected or excluded, nested fields included
+func (p *Projection) Trim(doc map[string]interface{}) map[string]interface{} {
+	if len(p.Exclude) > 0 {
+		for _, field := range p.Exclude {
+			removeField(doc, strings.Split(field, "."))
+		}
+		return doc
+	}
+
+	res := map[string]interface{}{}
+	if id, ok := doc["id"]; ok {
+		res["id"] = id
+	}
+
+	for _, field := range p.Fields {
+		copyField(res, doc, strings.Split(field, "."))
+	}
+
+	return res
+}
+
+func removeField(doc map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(doc, path[0])
+		return
+	}
+
+	if sub, ok := doc[path[0]].(map[string]interface{}); ok {
+		removeField(sub, path[1:])
+	}
+}
+
+func copyField(dst map[string]interface{}, src map[string]interface{}, path []string) {
+	value, ok := src[path[0]]
+	if !ok {
+		return
+	}
+
+	if len(path) == 1 {
+		dst[path[0]] = value
+		return
+	}
+
+	sub, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	dstSub, ok := dst[path[0]].(map[string]interface{})
+	if !ok {
+		dstSub = map[string]interface{}{}
+		dst[path[0]] = dstSub
+	}
+
+	copyField(dstSub, sub, path[1:])
+}
@@ -0,0 +1,40 @@
+package mrequest
+
+import (
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// ProductRevision is a snapshot of a product valid between ValidFrom (inclusive) and ValidTo (exclusive).
+// The revision currently in use has a nil ValidTo.
+type ProductRevision struct {
+	ProductID          objectid.ObjectID `bson:"ProductID" json:"-"`
+	ValidFrom          time.Time         `bson:"ValidFrom" json:"valid_from"`
+	ValidTo            *time.Time        `bson:"ValidTo" json:"valid_to"`
+	Version            int64             `bson:"Version" json:"version"`
+	Status             string            `bson:"Status" json:"status"`
+	ProductType        string            `bson:"ProductType" json:"ProductType,omitempty"`
+	ProductCode        string            `bson:"ProductCode" json:"ProductCode,omitempty"`
+	ProductGroup       string            `bson:"ProductGroup" json:"ProductGroup,omitempty"`
+	ProductDescription string            `bson:"ProductDescription" json:"ProductDescription,omitempty"`
+	ProductNumberCode  string            `bson:"ProductNumberCode" json:"ProductNumberCode,omitempty"`
+	CustomsDetails     *CustomsDetails   `bson:"CustomsDetails" json:"CustomsDetails,omitempty"`
+	ArchivedAt         *time.Time        `bson:"ArchivedAt,omitempty" json:"-"` // set only while reading the product, archived products have no open revision
+}
+
+// NewProductRevision creates the revision of a newly created product starting at validFrom
+func NewProductRevision(p *ProductCreate, validFrom time.Time) *ProductRevision {
+	return &ProductRevision{
+		ProductID:          p.ID,
+		ValidFrom:          validFrom,
+		Version:            p.Version,
+		Status:             p.Status,
+		ProductType:        p.ProductType,
+		ProductCode:        p.ProductCode,
+		ProductGroup:       p.ProductGroup,
+		ProductDescription: p.ProductDescription,
+		ProductNumberCode:  p.ProductNumberCode,
+		CustomsDetails:     p.CustomsDetails,
+	}
+}
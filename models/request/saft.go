@@ -0,0 +1,57 @@
+package mrequest
+
+import (
+	"net/url"
+	"products/models/response"
+	"products/util/errors"
+	"time"
+)
+
+// SaftExport represents the fiscal period for which the SAF-T MasterFiles section is generated
+type SaftExport struct {
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+}
+
+// NewSaftExport creates a SaftExport from params sent in URL query string
+// url example: http://products/saft?start_date=2018-01-01&end_date=2018-12-31
+func NewSaftExport(params url.Values) (*SaftExport, *mresponse.ErrorResponse) {
+	details := []mresponse.ErrorDetail{}
+
+	startDate, err := time.Parse("2006-01-02", params.Get("start_date"))
+	if err != nil {
+		details = append(details, mresponse.ErrorDetail{
+			Property: "start_date",
+			Message:  "Must be a date with format YYYY-MM-DD",
+		})
+	}
+
+	endDate, err := time.Parse("2006-01-02", params.Get("end_date"))
+	if err != nil {
+		details = append(details, mresponse.ErrorDetail{
+			Property: "end_date",
+			Message:  "Must be a date with format YYYY-MM-DD",
+		})
+	}
+
+	if len(details) == 0 && endDate.Before(startDate) {
+		details = append(details, mresponse.ErrorDetail{
+			Property: "end_date",
+			Message:  "Must not be before start_date",
+		})
+	}
+
+	if len(details) != 0 {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	return &SaftExport{
+		StartDate: startDate,
+		EndDate:   endDate,
+	}, nil
+}
+
+// PeriodEnd returns the first instant after the period, as EndDate is inclusive
+func (s *SaftExport) PeriodEnd() time.Time {
+	return s.EndDate.AddDate(0, 0, 1)
+}
@@ -0,0 +1,47 @@
+package mrequest
+
+import (
+	"net/url"
+	"products/models/response"
+	"products/util/errors"
+	"strconv"
+	"strings"
+)
+
+type SearchRequest struct {
+	Query   string `json:"q" valid:"required"`
+	PerPage int    `json:"per_page" valid:"required"`
+	Page    int    `json:"page" valid:"required"`
+}
+
+// NewSearchRequest creates a SearchRequest from params sent in URL query string
+// url example: http://products/search?q=parafuso+inox&per_page=10&page=1
+func NewSearchRequest(params url.Values) (*SearchRequest, *mresponse.ErrorResponse) {
+	req := SearchRequest{
+		Query: strings.TrimSpace(params.Get("q")),
+	}
+
+	if req.Query == "" {
+		details := []mresponse.ErrorDetail{
+			mresponse.ErrorDetail{
+				Property: "q",
+				Message:  "Field cannot be empty or is missing",
+			},
+		}
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	// set per_page
+	req.PerPage, _ = strconv.Atoi(params.Get("per_page"))
+	if req.PerPage <= 0 {
+		req.PerPage = 20
+	}
+
+	// set page
+	req.Page, _ = strconv.Atoi(params.Get("page"))
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+
+	return &req, nil
+}
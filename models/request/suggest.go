@@ -0,0 +1,53 @@
+package mrequest
+
+import (
+	"net/url"
+	"products/models/response"
+	"products/util/errors"
+	"strconv"
+	"strings"
+)
+
+// maxSuggestLimit is the maximum number of suggestions returned at once
+const maxSuggestLimit = 50
+
+type SuggestRequest struct {
+	Prefix string `json:"prefix" valid:"required"`
+	Limit  int    `json:"limit" valid:"required"`
+}
+
+// NewSuggestRequest creates a SuggestRequest from params sent in URL query string
+// url example: http://products/suggest?prefix=paraf&limit=10
+func NewSuggestRequest(params url.Values) (*SuggestRequest, *mresponse.ErrorResponse) {
+	req := SuggestRequest{
+		Prefix: strings.TrimSpace(params.Get("prefix")),
+	}
+
+	details := []mresponse.ErrorDetail{}
+
+	if req.Prefix == "" {
+		details = append(details, mresponse.ErrorDetail{
+			Property: "prefix",
+			Message:  "Field cannot be empty or is missing",
+		})
+	}
+
+	// set limit
+	req.Limit = 10
+	if limit := params.Get("limit"); limit != "" {
+		var err error
+		req.Limit, err = strconv.Atoi(limit)
+		if err != nil || req.Limit <= 0 || req.Limit > maxSuggestLimit {
+			details = append(details, mresponse.ErrorDetail{
+				Property: "limit",
+				Message:  "Must be a number between 1 and " + strconv.Itoa(maxSuggestLimit),
+			})
+		}
+	}
+
+	if len(details) != 0 {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	return &req, nil
+}
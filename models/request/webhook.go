@@ -0,0 +1,77 @@
+package mrequest
+
+import (
+	"net/url"
+	"products/models/response"
+	"strconv"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// WebhookCreate is a subscription of a partner URL to the events of some types.
+// If no secret is provided one is generated, it's only returned when the subscription is created.
+type WebhookCreate struct {
+	ID          objectid.ObjectID `bson:"_id" json:"-"`
+	URL         string            `bson:"URL" json:"url,omitempty" valid:"required~Field token cannot be empty or is missing,requrl~Must be a valid URL"`
+	Events      []string          `bson:"Events" json:"events,omitempty" valid:"required~Field token cannot be empty or is missing"`
+	Description string            `bson:"Description" json:"description,omitempty" valid:"runelength(1|200)~Must be between 1 and 200 characters"`
+	Secret      string            `bson:"Secret" json:"secret,omitempty" valid:"runelength(16|128)~Must be between 16 and 128 characters"`
+	Enabled     *bool             `bson:"Enabled" json:"enabled,omitempty" valid:"-"`
+	CreatedAt   time.Time         `bson:"CreatedAt" json:"-" valid:"-"`
+}
+
+// WebhookUpdate replaces the URL, events and description of a subscription.
+// Enabling a subscription that was disabled after failing deliveries clears its failures, Enabled is kept if not sent.
+type WebhookUpdate struct {
+	URL         string   `bson:"URL" json:"url,omitempty" valid:"required~Field token cannot be empty or is missing,requrl~Must be a valid URL"`
+	Events      []string `bson:"Events" json:"events,omitempty" valid:"required~Field token cannot be empty or is missing"`
+	Description string   `bson:"Description" json:"description,omitempty" valid:"runelength(1|200)~Must be between 1 and 200 characters"`
+	Enabled     *bool    `bson:"Enabled" json:"enabled,omitempty" valid:"-"`
+}
+
+// WebhookDeliveryCreate is a delivery of an event to a subscription, queued to be sent by a WebhookDispatcher.
+// Payload is the body sent on every attempt, so its signature can always be checked by partners.
+type WebhookDeliveryCreate struct {
+	SubscriptionID objectid.ObjectID `bson:"SubscriptionID"`
+	EventID        string            `bson:"EventID"`
+	EventType      string            `bson:"EventType"`
+	Payload        string            `bson:"Payload"`
+	Status         string            `bson:"Status"`
+	NextAttemptAt  time.Time         `bson:"NextAttemptAt"`
+	CreatedAt      time.Time         `bson:"CreatedAt"`
+}
+
+// DeliveryListRequest is a page of the delivery log of a subscription, optionally only the deliveries with a status
+type DeliveryListRequest struct {
+	PerPage int
+	Page    int
+	Status  string
+}
+
+// NewDeliveryListRequest returns the request for the page, per_page and status query params
+func NewDeliveryListRequest(params url.Values) *DeliveryListRequest {
+	req := DeliveryListRequest{
+		Status: params.Get("status"),
+	}
+
+	// set per_page
+	req.PerPage, _ = strconv.Atoi(params.Get("per_page"))
+	if req.PerPage <= 0 || req.PerPage > 100 {
+		req.PerPage = 20
+	}
+
+	// set page
+	req.Page, _ = strconv.Atoi(params.Get("page"))
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+
+	switch req.Status {
+	case mresponse.DeliveryPending, mresponse.DeliveryDelivered, mresponse.DeliveryFailed, mresponse.DeliverySkipped:
+	default:
+		req.Status = ""
+	}
+
+	return &req
+}
@@ -0,0 +1,25 @@
+package mresponse
+
+import "time"
+
+// Operations of the product change feed. Products are never deleted, archiving and restoring them are updates
+// told apart by their change of ArchivedAt.
+const (
+	ChangeInsert  = "insert"
+	ChangeUpdate  = "update"
+	ChangeReplace = "replace"
+	ChangeArchive = "archive"
+	ChangeRestore = "restore"
+)
+
+// ProductChange is a change of a product on the change feed.
+// Token resumes the feed right after this change, Product is the product looked up after the change,
+// not set if it no longer exists. OccurredAt is the cluster time of the change, with a precision of seconds,
+// not set by MongoDB versions before 4.0.
+type ProductChange struct {
+	Token      string       `json:"token"`
+	Operation  string       `json:"operation"`
+	ProductID  string       `json:"product_id"`
+	Product    *ProductRead `json:"product,omitempty"`
+	OccurredAt *time.Time   `json:"occurred_at,omitempty"`
+}
@@ -0,0 +1,12 @@
+package mresponse
+
+// DistinctValue is a value of a product field, with the number of products having it if counts were requested
+type DistinctValue struct {
+	Value string `json:"value" bson:"_id"`
+	Count int64  `json:"count,omitempty" bson:"count"`
+}
+
+type DistinctList struct {
+	Field string           `json:"field"`
+	Items []*DistinctValue `json:"items"`
+}
@@ -1,17 +1,20 @@
 package mresponse
 
+import "encoding/xml"
+
 type (
 	// ErrorResponse represents the error model for App error response messages
 	ErrorResponse struct {
-		HttpCode int           `json:"-"`
-		Code     string        `json:"code"`
-		Response string        `json:"response"`
-		Errors   []ErrorDetail `json:"errors,omitempty"`
+		XMLName  xml.Name      `json:"-" xml:"Error"`
+		HttpCode int           `json:"-" xml:"-"`
+		Code     string        `json:"code" xml:"code"`
+		Response string        `json:"response" xml:"response"`
+		Errors   []ErrorDetail `json:"errors,omitempty" xml:"errors>error,omitempty"`
 	}
 
 	// ErrorDetail represents a detailed error message applyed to the specific field or property that caused an error to the response
 	ErrorDetail struct {
-		Property string `json:"property"`
-		Message  string `json:"message"`
+		Property string `json:"property" xml:"property"`
+		Message  string `json:"message" xml:"message"`
 	}
 )
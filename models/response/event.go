@@ -0,0 +1,23 @@
+package mresponse
+
+import (
+	"time"
+)
+
+// Product lifecycle statuses
+const (
+	StatusDraft        = "draft"
+	StatusActive       = "active"
+	StatusDiscontinued = "discontinued"
+	StatusBlocked      = "blocked"
+)
+
+// ProductEvent is produced to the product events topic whenever a product changes
+type ProductEvent struct {
+	Type       string    `json:"type"`
+	ProductID  string    `json:"product_id"`
+	Version    int64     `json:"version"`
+	From       string    `json:"from,omitempty"`
+	To         string    `json:"to,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
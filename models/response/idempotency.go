@@ -0,0 +1,15 @@
+package mresponse
+
+import "time"
+
+// IdempotentRequest is a request sent with an Idempotency-Key header and, once processed, its response.
+// Status is 0 while the request is processed.
+type IdempotentRequest struct {
+	Key         string            `bson:"_id"`
+	RequestHash string            `bson:"RequestHash"`
+	Status      int               `bson:"Status"`
+	Header      map[string]string `bson:"Header"`
+	Body        []byte            `bson:"Body"`
+	CreatedAt   time.Time         `bson:"CreatedAt"`
+	ExpiresAt   time.Time         `bson:"ExpiresAt"`
+}
@@ -0,0 +1,62 @@
+package mresponse
+
+import (
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// Statuses of import jobs
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// ImportRowError is an error on a row of an imported file, rows are numbered as in a spreadsheet with the header as row 1
+type ImportRowError struct {
+	Row      int64  `json:"row" bson:"Row"`
+	Property string `json:"property" bson:"Property"`
+	Message  string `json:"message" bson:"Message"`
+}
+
+// ImportResult summarizes an import, on dry runs valid rows are counted but not imported
+type ImportResult struct {
+	DryRun   bool              `json:"dry_run"`
+	Rows     int64             `json:"rows"`
+	Valid    int64             `json:"valid"`
+	Imported int64             `json:"imported"`
+	Failed   int64             `json:"failed"`
+	Errors   []*ImportRowError `json:"errors"`
+}
+
+// ImportJob is an import processed in background, with its progress so far.
+// Only the first errors are kept, ErrorCount has the total of row errors.
+type ImportJob struct {
+	ID             string            `json:"id"`
+	IDdb           objectid.ObjectID `json:"-" bson:"_id"`
+	Status         string            `json:"status" bson:"Status"`
+	Message        string            `json:"message,omitempty" bson:"Message"`
+	FileName       string            `json:"file_name,omitempty" bson:"FileName"`
+	Size           int64             `json:"size" bson:"Size"`
+	ProcessedBytes int64             `json:"processed_bytes" bson:"ProcessedBytes"`
+	DryRun         bool              `json:"dry_run" bson:"DryRun"`
+	Rows           int64             `json:"rows" bson:"Rows"`
+	Valid          int64             `json:"valid" bson:"Valid"`
+	Imported       int64             `json:"imported" bson:"Imported"`
+	Failed         int64             `json:"failed" bson:"Failed"`
+	ErrorCount     int64             `json:"error_count" bson:"ErrorCount"`
+	Errors         []*ImportRowError `json:"errors" bson:"Errors"`
+	CreatedAt      time.Time         `json:"created_at" bson:"CreatedAt"`
+	StartedAt      *time.Time        `json:"started_at,omitempty" bson:"StartedAt,omitempty"`
+	FinishedAt     *time.Time        `json:"finished_at,omitempty" bson:"FinishedAt,omitempty"`
+
+	// the import options and how far the job went, to resume it if the worker processing it stops
+	ContentType   string     `json:"-" bson:"ContentType"`
+	Options       string     `json:"-" bson:"Options"`
+	ProcessedRows int64      `json:"-" bson:"ProcessedRows"`
+	HeartbeatAt   *time.Time `json:"-" bson:"HeartbeatAt,omitempty"`
+	ClaimToken    string     `json:"-" bson:"ClaimToken,omitempty"` // set on each claim, only the worker holding it can write the job
+}
@@ -1,6 +1,9 @@
 package mresponse
 
 import (
+	"encoding/xml"
+	"time"
+
 	"github.com/mongodb/mongo-go-driver/bson/objectid"
 )
 
@@ -20,23 +23,52 @@ type CustomsDetails struct {
 }
 
 type ProductCreate struct {
-	ID string `json:"id,omitempty"`
+	XMLName xml.Name `json:"-" xml:"Product"`
+	ID      string   `json:"id,omitempty" xml:"id,attr,omitempty"`
 }
 
 type ProductRead struct {
 	ID                 string            `json:"id,omitempty"`
 	IDdb               objectid.ObjectID `json:"-" bson:"_id"`
+	Version            int64             `json:"version" bson:"Version"`
+	Status             string            `json:"status,omitempty" bson:"Status"`
 	ProductType        string            `json:"ProductType,omitempty" bson:"ProductType"`
 	ProductCode        string            `json:"ProductCode,omitempty" bson:"ProductCode"`
 	ProductGroup       string            `json:"ProductGroup,omitempty" bson:"ProductGroup,omitempty"`
 	ProductDescription string            `json:"ProductDescription,omitempty" bson:"ProductDescription"`
 	ProductNumberCode  string            `json:"ProductNumberCode,omitempty" bson:"ProductNumberCode"`
 	CustomsDetails     *CustomsDetails   `json:"CustomsDetails,omitempty" bson:"CustomsDetails,omitempty"`
+	ArchivedAt         *time.Time        `json:"archived_at,omitempty" bson:"ArchivedAt,omitempty"`
+	ArchiveReason      string            `json:"archive_reason,omitempty" bson:"ArchiveReason,omitempty"`
 }
 
+// ProductList is a page of products. Total is only set if it was requested, in page mode it's always set by default.
+// In cursor mode Page is not set and NextCursor, if there are more products, is the cursor of the next page.
 type ProductList struct {
-	Total   int64           `json:"total"`
-	PerPage int64           `json:"per_page"`
-	Page    int64           `json:"page"`
-	Items   *[]*ProductRead `json:"items"`
+	Total      *int64          `json:"total,omitempty"`
+	PerPage    int64           `json:"per_page"`
+	Page       int64           `json:"page,omitempty"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+	Items      *[]*ProductRead `json:"items"`
+}
+
+// FacetCount is the number of products having a value on a field
+type FacetCount struct {
+	Value string `json:"value" bson:"_id"`
+	Count int64  `json:"count" bson:"count"`
+}
+
+// PresenceCount is the number of products with and without a value on a field
+type PresenceCount struct {
+	With    int64 `json:"with"`
+	Without int64 `json:"without"`
+}
+
+// ProductFacets is the composition of a products listing
+type ProductFacets struct {
+	Total        int64         `json:"total"`
+	ProductType  []*FacetCount `json:"ProductType"`
+	ProductGroup []*FacetCount `json:"ProductGroup"`
+	CNCode       PresenceCount `json:"CNCode"`
+	UNNumber     PresenceCount `json:"UNNumber"`
 }
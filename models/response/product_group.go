@@ -0,0 +1,18 @@
+package mresponse
+
+import "github.com/mongodb/mongo-go-driver/bson/objectid"
+
+// ProductGroup is a node of the product groups hierarchy, products reference it by Code on their ProductGroup.
+// Ancestors are the codes of the groups above it, from the root group down to its parent.
+type ProductGroup struct {
+	ID        string            `json:"id,omitempty"`
+	IDdb      objectid.ObjectID `json:"-" bson:"_id"`
+	Code      string            `json:"code" bson:"Code"`
+	Name      string            `json:"name" bson:"Name"`
+	Parent    string            `json:"parent,omitempty" bson:"Parent"`
+	Ancestors []string          `json:"ancestors" bson:"Ancestors"`
+}
+
+type ProductGroupList struct {
+	Items *[]*ProductGroup `json:"items"`
+}
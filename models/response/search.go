@@ -0,0 +1,16 @@
+package mresponse
+
+// ProductSearchHit is a product matching a full text search, along with its relevance score
+// and the matching fields with the searched terms highlighted
+type ProductSearchHit struct {
+	*ProductRead
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+type ProductSearchList struct {
+	Total   int64                `json:"total"`
+	PerPage int64                `json:"per_page"`
+	Page    int64                `json:"page"`
+	Items   *[]*ProductSearchHit `json:"items"`
+}
@@ -0,0 +1,15 @@
+package mresponse
+
+import "github.com/mongodb/mongo-go-driver/bson/objectid"
+
+// ProductSuggestion is a product matching a type-ahead prefix
+type ProductSuggestion struct {
+	ID                 string            `json:"id,omitempty"`
+	IDdb               objectid.ObjectID `json:"-" bson:"_id"`
+	ProductCode        string            `json:"ProductCode" bson:"ProductCode"`
+	ProductDescription string            `json:"ProductDescription" bson:"ProductDescription"`
+}
+
+type ProductSuggestList struct {
+	Items *[]*ProductSuggestion `json:"items"`
+}
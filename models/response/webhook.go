@@ -0,0 +1,83 @@
+package mresponse
+
+import (
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// Webhook event types, subscriptions are notified of the product changes of the types they subscribed
+const (
+	WebhookProductCreated  = "product.created"
+	WebhookProductUpdated  = "product.updated"
+	WebhookProductDeleted  = "product.deleted" // products are archived rather than deleted
+	WebhookProductRestored = "product.restored"
+)
+
+// Statuses of webhook deliveries
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+	DeliverySkipped   = "skipped" // the subscription was disabled before the delivery was sent
+)
+
+// WebhookSubscription is a partner URL notified of product changes.
+// Subscriptions are disabled after consecutive failed deliveries, with the reason why.
+type WebhookSubscription struct {
+	ID                  string            `json:"id"`
+	IDdb                objectid.ObjectID `json:"-" bson:"_id"`
+	URL                 string            `json:"url" bson:"URL"`
+	Events              []string          `json:"events" bson:"Events"`
+	Description         string            `json:"description,omitempty" bson:"Description"`
+	Secret              string            `json:"secret,omitempty" bson:"Secret"`
+	Enabled             bool              `json:"enabled" bson:"Enabled"`
+	ConsecutiveFailures int64             `json:"consecutive_failures" bson:"ConsecutiveFailures"`
+	DisabledReason      string            `json:"disabled_reason,omitempty" bson:"DisabledReason"`
+	CreatedAt           time.Time         `json:"created_at" bson:"CreatedAt"`
+}
+
+type WebhookSubscriptionList struct {
+	Items *[]*WebhookSubscription `json:"items"`
+}
+
+// WebhookPayload is the JSON body posted to subscriptions. ID is the same for all the subscriptions notified of a change,
+// partners use it to discard events they already got.
+type WebhookPayload struct {
+	ID         string       `json:"id"`
+	Type       string       `json:"type"`
+	ProductID  string       `json:"product_id"`
+	Product    *ProductRead `json:"product,omitempty"`
+	OccurredAt time.Time    `json:"occurred_at"`
+}
+
+// WebhookAttempt is an attempt to send a delivery, with the beginning of the response of the partner if there was one
+type WebhookAttempt struct {
+	At         time.Time `json:"at" bson:"At"`
+	StatusCode int       `json:"status_code,omitempty" bson:"StatusCode"`
+	Response   string    `json:"response,omitempty" bson:"Response"`
+	Error      string    `json:"error,omitempty" bson:"Error"`
+	DurationMs int64     `json:"duration_ms" bson:"DurationMs"`
+}
+
+// WebhookDelivery is the delivery of an event to a subscription, with the log of its attempts
+type WebhookDelivery struct {
+	ID             string            `json:"id"`
+	IDdb           objectid.ObjectID `json:"-" bson:"_id"`
+	SubscriptionID objectid.ObjectID `json:"-" bson:"SubscriptionID"`
+	EventID        string            `json:"event_id" bson:"EventID"`
+	EventType      string            `json:"event_type" bson:"EventType"`
+	Payload        string            `json:"payload" bson:"Payload"`
+	Status         string            `json:"status" bson:"Status"`
+	Attempts       []*WebhookAttempt `json:"attempts" bson:"Attempts"`
+	NextAttemptAt  *time.Time        `json:"next_attempt_at,omitempty" bson:"NextAttemptAt,omitempty"`
+	CreatedAt      time.Time         `json:"created_at" bson:"CreatedAt"`
+	FinishedAt     *time.Time        `json:"finished_at,omitempty" bson:"FinishedAt,omitempty"`
+}
+
+type WebhookDeliveryList struct {
+	Total   int64               `json:"total"`
+	PerPage int64               `json:"per_page"`
+	Page    int64               `json:"page"`
+	Items   *[]*WebhookDelivery `json:"items"`
+}
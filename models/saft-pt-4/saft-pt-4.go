@@ -16,8 +16,8 @@ type Product struct {
 
 type CustomsDetails struct {
 	XMLName  xml.Name `xml:"CustomsDetails"`
-	CNCode   string   `bson:"CNCode" json:"CNCode" xml:"CNCode"`
-	UNNumber string   `bson:"UNNumber" json:"UNNumber" xml:"UNNumber"`
+	CNCode   []string `bson:"CNCode" json:"CNCode" xml:"CNCode"`
+	UNNumber []string `bson:"UNNumber" json:"UNNumber" xml:"UNNumber"`
 }
 
 type AuditFile struct {
@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"context"
+	"products/models/request"
+	"products/models/response"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/mongoopt"
+)
+
+// IdempotencyRepository persists the requests sent with an Idempotency-Key and their responses, until they expire
+type IdempotencyRepository struct {
+	keys MongoCollection
+}
+
+type IdempotencyRepositoryContract interface {
+	Reserve(request *mrequest.IdempotencyKeyCreate) (*mresponse.IdempotentRequest, error)
+	Complete(response *mresponse.IdempotentRequest) error
+	Release(key string) error
+}
+
+// NewIdempotencyRepository is the constructor for IdempotencyRepository
+func NewIdempotencyRepository(db *DBCollections) IdempotencyRepositoryContract {
+	return &IdempotencyRepository{keys: db.IdempotencyKey}
+}
+
+// Reserve saves the key of a request about to be processed, unless it's already saved.
+// Returns nil if reserved, otherwise the request saved with the key. Expired keys are reserved again.
+func (this *IdempotencyRepository) Reserve(request *mrequest.IdempotencyKeyCreate) (*mresponse.IdempotentRequest, error) {
+
+	// expired keys are removed by a TTL index, but only once a minute
+	_, err := this.keys.DeleteOne(
+		context.Background(),
+		bson.NewDocument(
+			bson.EC.String("_id", request.Key),
+			bson.EC.SubDocumentFromElements("ExpiresAt", bson.EC.Time("$lte", time.Now().UTC())),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	result := this.keys.FindOneAndUpdate(
+		context.Background(),
+		bson.NewDocument(bson.EC.String("_id", request.Key)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$setOnInsert",
+			bson.EC.String("RequestHash", request.RequestHash),
+			bson.EC.Int32("Status", 0),
+			bson.EC.Time("CreatedAt", request.CreatedAt),
+			bson.EC.Time("ExpiresAt", request.ExpiresAt),
+		)),
+		findopt.Upsert(true),
+		findopt.ReturnDocument(mongoopt.Before),
+	)
+
+	existing := mresponse.IdempotentRequest{}
+	err = result.Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &existing, nil
+}
+
+// Complete saves the response of a reserved key, replayed until it expires
+func (this *IdempotencyRepository) Complete(response *mresponse.IdempotentRequest) error {
+	header := bson.NewDocument()
+	for name, value := range response.Header {
+		header.Append(bson.EC.String(name, value))
+	}
+
+	_, err := this.keys.UpdateOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.String("_id", response.Key)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$set",
+			bson.EC.Int32("Status", int32(response.Status)),
+			bson.EC.SubDocument("Header", header),
+			bson.EC.Binary("Body", response.Body),
+			bson.EC.Time("ExpiresAt", response.ExpiresAt),
+		)),
+	)
+
+	return err
+}
+
+// Release removes a reserved key, so the request can be sent again with it
+func (this *IdempotencyRepository) Release(key string) error {
+	_, err := this.keys.DeleteOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.String("_id", key)),
+	)
+
+	return err
+}
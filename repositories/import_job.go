@@ -0,0 +1,310 @@
+package repositories
+
+import (
+	"context"
+	"io"
+	"products/models/request"
+	"products/models/response"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/mongoopt"
+)
+
+// importChunkSize is the size of the chunks imported files are stored in, like GridFS does
+const importChunkSize = 255 * 1024
+
+// MaxImportJobErrors is how many row errors are kept in a job, so jobs of files with many errors don't exceed the document size limit
+const MaxImportJobErrors = 1000
+
+// ImportJobRepository persists import jobs and their files, stored in chunks so any instance can process them
+type ImportJobRepository struct {
+	jobs  MongoCollection
+	files MongoCollection
+}
+
+type ImportJobRepositoryContract interface {
+	CreateOne(job *mrequest.ImportJobCreate, file io.Reader) (*mongo.InsertOneResult, error)
+	ReadByID(id objectid.ObjectID) (*mresponse.ImportJob, error)
+	Claim(staleBefore time.Time) (*mresponse.ImportJob, error)
+	UpdateProgress(job *mresponse.ImportJob, rowErrors []*mresponse.ImportRowError) (bool, error)
+	Finish(job *mresponse.ImportJob, status string, message string) (bool, error)
+	Cancel(id objectid.ObjectID) (bool, error)
+	OpenFile(id objectid.ObjectID) (io.ReadCloser, error)
+	DeleteFile(id objectid.ObjectID) error
+}
+
+// importChunk is a piece of an imported file
+type importChunk struct {
+	ID    objectid.ObjectID `bson:"_id"`
+	JobID objectid.ObjectID `bson:"JobID"`
+	N     int64             `bson:"N"`
+	Data  []byte            `bson:"Data"`
+}
+
+// NewImportJobRepository is the constructor for ImportJobRepository
+func NewImportJobRepository(db *DBCollections) ImportJobRepositoryContract {
+	return &ImportJobRepository{jobs: db.ImportJob, files: db.ImportFile}
+}
+
+// CreateOne stores the file and then saves the job, queued to be processed
+func (this *ImportJobRepository) CreateOne(job *mrequest.ImportJobCreate, file io.Reader) (*mongo.InsertOneResult, error) {
+	job.ID = objectid.New()
+	job.Size = 0
+
+	buf := make([]byte, importChunkSize)
+	for n := int64(0); ; n++ {
+		read, err := io.ReadFull(file, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			this.DeleteFile(job.ID)
+			return nil, err
+		}
+
+		chunk := importChunk{ID: objectid.New(), JobID: job.ID, N: n, Data: buf[:read]}
+		_, e := this.files.InsertOne(context.Background(), &chunk)
+		if e != nil {
+			this.DeleteFile(job.ID)
+			return nil, e
+		}
+		job.Size += int64(read)
+
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+
+	res, err := this.jobs.InsertOne(context.Background(), job)
+	if err != nil {
+		this.DeleteFile(job.ID)
+	}
+
+	return res, err
+}
+
+// ReadByID returns the job with the provided id
+func (this *ImportJobRepository) ReadByID(id objectid.ObjectID) (*mresponse.ImportJob, error) {
+	result := this.jobs.FindOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", id)),
+	)
+
+	job := mresponse.ImportJob{}
+	err := result.Decode(&job)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// Claim marks the oldest queued job as running and returns it, or nil if there's none.
+// Running jobs without progress since staleBefore are claimed again, their worker is assumed to have stopped.
+// Each claim sets a new ClaimToken, so a worker that was only slow can no longer write the job once it's claimed again.
+func (this *ImportJobRepository) Claim(staleBefore time.Time) (*mresponse.ImportJob, error) {
+	now := time.Now().UTC()
+
+	filter := bson.NewDocument(
+		bson.EC.ArrayFromElements("$or",
+			bson.VC.DocumentFromElements(bson.EC.String("Status", mresponse.JobQueued)),
+			bson.VC.DocumentFromElements(
+				bson.EC.String("Status", mresponse.JobRunning),
+				bson.EC.SubDocumentFromElements("HeartbeatAt", bson.EC.Time("$lt", staleBefore)),
+			),
+		),
+	)
+
+	update := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("$set",
+			bson.EC.String("Status", mresponse.JobRunning),
+			bson.EC.Time("HeartbeatAt", now),
+			bson.EC.String("ClaimToken", objectid.New().Hex()),
+		),
+		// only set the first time the job is claimed
+		bson.EC.SubDocumentFromElements("$min", bson.EC.Time("StartedAt", now)),
+	)
+
+	result := this.jobs.FindOneAndUpdate(
+		context.Background(),
+		filter,
+		update,
+		findopt.Sort(bson.NewDocument(bson.EC.Int32("CreatedAt", 1))),
+		findopt.ReturnDocument(mongoopt.After),
+	)
+
+	job := mresponse.ImportJob{}
+	err := result.Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// UpdateProgress saves the counters of a running job and adds its new row errors, while the job is not cancelled.
+// Returns false if the job is no longer running or was claimed again by another worker.
+func (this *ImportJobRepository) UpdateProgress(job *mresponse.ImportJob, rowErrors []*mresponse.ImportRowError) (bool, error) {
+	update := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("$set",
+			bson.EC.Int64("ProcessedBytes", job.ProcessedBytes),
+			bson.EC.Int64("ProcessedRows", job.ProcessedRows),
+			bson.EC.Int64("Rows", job.Rows),
+			bson.EC.Int64("Valid", job.Valid),
+			bson.EC.Int64("Imported", job.Imported),
+			bson.EC.Int64("Failed", job.Failed),
+			bson.EC.Int64("ErrorCount", job.ErrorCount),
+			bson.EC.Time("HeartbeatAt", time.Now().UTC()),
+		),
+	)
+
+	if len(rowErrors) > 0 {
+		values := []*bson.Value{}
+		for _, e := range rowErrors {
+			values = append(values, bson.VC.DocumentFromElements(
+				bson.EC.Int64("Row", e.Row),
+				bson.EC.String("Property", e.Property),
+				bson.EC.String("Message", e.Message),
+			))
+		}
+
+		update.Append(bson.EC.SubDocumentFromElements("$push",
+			bson.EC.SubDocumentFromElements("Errors",
+				bson.EC.ArrayFromElements("$each", values...),
+				bson.EC.Int32("$slice", MaxImportJobErrors),
+			),
+		))
+	}
+
+	res, err := this.jobs.UpdateOne(context.Background(), runningJob(job), update)
+	if err != nil {
+		return false, err
+	}
+
+	return res.MatchedCount == 1, nil
+}
+
+// Finish sets the final status of a running job and deletes its file.
+// Returns false if the job is no longer running or was claimed again by another worker, its file is then left as it is.
+func (this *ImportJobRepository) Finish(job *mresponse.ImportJob, status string, message string) (bool, error) {
+	update := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("$set",
+			bson.EC.String("Status", status),
+			bson.EC.String("Message", message),
+			bson.EC.Time("FinishedAt", time.Now().UTC()),
+		),
+	)
+
+	res, err := this.jobs.UpdateOne(context.Background(), runningJob(job), update)
+	if err != nil {
+		return false, err
+	}
+
+	if res.MatchedCount == 0 {
+		return false, nil
+	}
+
+	return true, this.DeleteFile(job.IDdb)
+}
+
+// Cancel cancels a queued or running job and deletes its file. Returns false if the job had already finished.
+func (this *ImportJobRepository) Cancel(id objectid.ObjectID) (bool, error) {
+	filter := bson.NewDocument(
+		bson.EC.ObjectID("_id", id),
+		bson.EC.SubDocumentFromElements("Status", bson.EC.ArrayFromElements("$in",
+			bson.VC.String(mresponse.JobQueued),
+			bson.VC.String(mresponse.JobRunning),
+		)),
+	)
+
+	update := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("$set",
+			bson.EC.String("Status", mresponse.JobCancelled),
+			bson.EC.Time("FinishedAt", time.Now().UTC()),
+		),
+	)
+
+	res, err := this.jobs.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	if res.MatchedCount == 0 {
+		return false, nil
+	}
+
+	return true, this.DeleteFile(id)
+}
+
+// OpenFile returns a reader of the file of a job, reading its chunks in order
+func (this *ImportJobRepository) OpenFile(id objectid.ObjectID) (io.ReadCloser, error) {
+	cursor, err := this.files.Find(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("JobID", id)),
+		findopt.Sort(bson.NewDocument(bson.EC.Int32("N", 1))),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chunkReader{cursor: cursor}, nil
+}
+
+// DeleteFile deletes the file of a job
+func (this *ImportJobRepository) DeleteFile(id objectid.ObjectID) error {
+	_, err := this.files.DeleteMany(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("JobID", id)),
+	)
+
+	return err
+}
+
+// runningJob matches the job while it's running under the claim of the worker processing it
+func runningJob(job *mresponse.ImportJob) *bson.Document {
+	return bson.NewDocument(
+		bson.EC.ObjectID("_id", job.IDdb),
+		bson.EC.String("Status", mresponse.JobRunning),
+		bson.EC.String("ClaimToken", job.ClaimToken),
+	)
+}
+
+// chunkReader reads the data of the chunks of a file as they are decoded from the cursor
+type chunkReader struct {
+	cursor  mongo.Cursor
+	pending []byte
+}
+
+func (this *chunkReader) Read(p []byte) (int, error) {
+	for len(this.pending) == 0 {
+		if !this.cursor.Next(context.Background()) {
+			if err := this.cursor.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+
+		chunk := importChunk{}
+		err := this.cursor.Decode(&chunk)
+		if err != nil {
+			return 0, err
+		}
+		this.pending = chunk.Data
+	}
+
+	n := copy(p, this.pending)
+	this.pending = this.pending[n:]
+
+	return n, nil
+}
+
+func (this *chunkReader) Close() error {
+	return this.cursor.Close(context.Background())
+}
@@ -2,12 +2,16 @@ package repositories
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"log"
+	"time"
 
 	"products/config"
+	"products/models/request"
 
 	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
 	"github.com/mongodb/mongo-go-driver/mongo"
 	"github.com/mongodb/mongo-go-driver/mongo/aggregateopt"
 	"github.com/mongodb/mongo-go-driver/mongo/changestreamopt"
@@ -47,7 +51,16 @@ type MongoCollection interface {
 }
 
 type DBCollections struct {
-	Product MongoCollection
+	Product         MongoCollection
+	ProductRevision MongoCollection
+	ProductGroup    MongoCollection
+	ImportJob       MongoCollection
+	ImportFile      MongoCollection
+
+	WebhookSubscription MongoCollection
+	WebhookDelivery     MongoCollection
+
+	IdempotencyKey MongoCollection
 }
 
 // Returns a mongo database with collections indexes set
@@ -77,8 +90,225 @@ func NewDBCollections(config *config.Config) *DBCollections {
 		Options: options,
 	}
 
+	// set products full text search index, Portuguese stemming and diacritic insensitive (text index version 3)
+	keys, err = bson.ParseExtJSONObject(`{ "ProductDescription": "text", "ProductCode": "text", "ProductNumberCode": "text", "ProductGroup": "text" }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	options, err = bson.ParseExtJSONObject(`{
+		"name": "products_text",
+		"default_language": "portuguese",
+		"weights": { "ProductCode": 10, "ProductNumberCode": 10, "ProductDescription": 5, "ProductGroup": 2 }
+	}`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	productsTextIndex := mongo.IndexModel{
+		Keys:    keys,
+		Options: options,
+	}
+
+	// set products type-ahead suggestions index, a multikey index over the folded codes and description words
+	keys, err = bson.ParseExtJSONObject(`{ "SuggestKeys": 1 }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	productsSuggestIndex := mongo.IndexModel{
+		Keys: keys,
+	}
+
+	// set products listing indexes, one per sortable field with _id as tie-breaker.
+	// They have the collation of listings, indexes with other collations can't be used to sort strings.
+	productsIndexes := []mongo.IndexModel{productsIndex, productsTextIndex, productsSuggestIndex}
+	for _, field := range []string{"ProductNumberCode", "ProductCode", "ProductDescription", "ProductGroup", "ProductType"} {
+		keys, err = bson.ParseExtJSONObject(`{ "` + field + `": 1, "_id": 1 }`)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options, err = bson.ParseExtJSONObject(`{ "collation": { "locale": "pt" } }`)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		productsIndexes = append(productsIndexes, mongo.IndexModel{
+			Keys:    keys,
+			Options: options,
+		})
+	}
+
 	productCollection := db.Collection("products")
-	_, err = productCollection.Indexes().CreateOne(context.Background(), productsIndex)
+	_, err = productCollection.Indexes().CreateMany(context.Background(), productsIndexes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// set the suggestion keys of products stored before type-ahead suggestions, only those without keys are updated
+	backfilled, err := backfillSuggestKeys(productCollection)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if backfilled > 0 {
+		log.Printf("Suggestion keys set on %d products\n", backfilled)
+	}
+
+	// set product revisions indexes
+	keys, err = bson.ParseExtJSONObject(`{ "ProductID": 1, "ValidFrom": -1 }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	revisionsByProductIndex := mongo.IndexModel{
+		Keys: keys,
+	}
+
+	keys, err = bson.ParseExtJSONObject(`{ "ValidFrom": 1, "ValidTo": 1 }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	revisionsByValidityIndex := mongo.IndexModel{
+		Keys: keys,
+	}
+
+	productRevisionCollection := db.Collection("product_revisions")
+	_, err = productRevisionCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{revisionsByProductIndex, revisionsByValidityIndex})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// record the initial revision of products stored before revisions were kept, so they can be read as of any time
+	backfilled, err = backfillRevisions(productCollection, productRevisionCollection)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if backfilled > 0 {
+		log.Printf("Initial revisions recorded for %d products\n", backfilled)
+	}
+
+	// set product groups indexes, groups are unique by code and subtrees are found by ancestor
+	keys, err = bson.ParseExtJSONObject(`{ "Code": 1 }`)
+	options, err = bson.ParseExtJSONObject(`{ "unique": true }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	groupsByCodeIndex := mongo.IndexModel{
+		Keys:    keys,
+		Options: options,
+	}
+
+	keys, err = bson.ParseExtJSONObject(`{ "Ancestors": 1 }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	groupsByAncestorIndex := mongo.IndexModel{
+		Keys: keys,
+	}
+
+	productGroupCollection := db.Collection("product_groups")
+	_, err = productGroupCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{groupsByCodeIndex, groupsByAncestorIndex})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// set import jobs indexes, workers claim the oldest jobs of a status and files are read by job in chunks order
+	keys, err = bson.ParseExtJSONObject(`{ "Status": 1, "CreatedAt": 1 }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	jobsByStatusIndex := mongo.IndexModel{
+		Keys: keys,
+	}
+
+	importJobCollection := db.Collection("import_jobs")
+	_, err = importJobCollection.Indexes().CreateOne(context.Background(), jobsByStatusIndex)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keys, err = bson.ParseExtJSONObject(`{ "JobID": 1, "N": 1 }`)
+	options, err = bson.ParseExtJSONObject(`{ "unique": true }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chunksByJobIndex := mongo.IndexModel{
+		Keys:    keys,
+		Options: options,
+	}
+
+	importFileCollection := db.Collection("import_files")
+	_, err = importFileCollection.Indexes().CreateOne(context.Background(), chunksByJobIndex)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// set webhook deliveries indexes: events are queued once per subscription, workers claim the deliveries due
+	// and subscriptions list their deliveries latest first. Deliveries are kept for 30 days.
+	keys, err = bson.ParseExtJSONObject(`{ "SubscriptionID": 1, "EventID": 1 }`)
+	options, err = bson.ParseExtJSONObject(`{ "unique": true }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deliveriesByEventIndex := mongo.IndexModel{
+		Keys:    keys,
+		Options: options,
+	}
+
+	keys, err = bson.ParseExtJSONObject(`{ "Status": 1, "NextAttemptAt": 1 }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deliveriesDueIndex := mongo.IndexModel{
+		Keys: keys,
+	}
+
+	keys, err = bson.ParseExtJSONObject(`{ "SubscriptionID": 1, "CreatedAt": -1 }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deliveriesBySubscriptionIndex := mongo.IndexModel{
+		Keys: keys,
+	}
+
+	keys, err = bson.ParseExtJSONObject(`{ "CreatedAt": 1 }`)
+	options, err = bson.ParseExtJSONObject(`{ "expireAfterSeconds": 2592000 }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deliveriesExpiryIndex := mongo.IndexModel{
+		Keys:    keys,
+		Options: options,
+	}
+
+	webhookDeliveryCollection := db.Collection("webhook_deliveries")
+	_, err = webhookDeliveryCollection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{deliveriesByEventIndex, deliveriesDueIndex, deliveriesBySubscriptionIndex, deliveriesExpiryIndex})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// set idempotency keys expiry index, keys are removed once their response is no longer replayed
+	keys, err = bson.ParseExtJSONObject(`{ "ExpiresAt": 1 }`)
+	options, err = bson.ParseExtJSONObject(`{ "expireAfterSeconds": 0 }`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	idempotencyExpiryIndex := mongo.IndexModel{
+		Keys:    keys,
+		Options: options,
+	}
+
+	idempotencyKeyCollection := db.Collection("idempotency_keys")
+	_, err = idempotencyKeyCollection.Indexes().CreateOne(context.Background(), idempotencyExpiryIndex)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -86,6 +316,114 @@ func NewDBCollections(config *config.Config) *DBCollections {
 	fmt.Println("Connected to mongo database successfully with all indexes set")
 
 	return &DBCollections{
-		Product: productCollection,
+		Product:         productCollection,
+		ProductRevision: productRevisionCollection,
+		ProductGroup:    productGroupCollection,
+		ImportJob:       importJobCollection,
+		ImportFile:      importFileCollection,
+
+		WebhookSubscription: db.Collection("webhook_subscriptions"),
+		WebhookDelivery:     webhookDeliveryCollection,
+
+		IdempotencyKey: idempotencyKeyCollection,
 	}
 }
+
+// backfillSuggestKeys sets the SuggestKeys of the products without them, returning how many products were updated
+func backfillSuggestKeys(products MongoCollection) (int, error) {
+	filter := bson.NewDocument(bson.EC.SubDocumentFromElements("SuggestKeys", bson.EC.Boolean("$exists", false)))
+	fields := bson.NewDocument(
+		bson.EC.Int32("ProductCode", 1),
+		bson.EC.Int32("ProductNumberCode", 1),
+		bson.EC.Int32("ProductDescription", 1),
+	)
+
+	cursor, err := products.Find(context.Background(), filter, findopt.Projection(fields))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	count := 0
+	for cursor.Next(context.Background()) {
+		p := struct {
+			ID                 objectid.ObjectID `bson:"_id"`
+			ProductCode        string            `bson:"ProductCode"`
+			ProductNumberCode  string            `bson:"ProductNumberCode"`
+			ProductDescription string            `bson:"ProductDescription"`
+		}{}
+
+		err = cursor.Decode(&p)
+		if err != nil {
+			return count, err
+		}
+
+		keys := bson.NewArray()
+		for _, key := range suggestKeys(p.ProductCode, p.ProductNumberCode, p.ProductDescription) {
+			keys.Append(bson.VC.String(key))
+		}
+
+		_, err = products.UpdateOne(context.Background(),
+			bson.NewDocument(bson.EC.ObjectID("_id", p.ID)),
+			bson.NewDocument(bson.EC.SubDocumentFromElements("$set", bson.EC.Array("SuggestKeys", keys))),
+		)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, cursor.Err()
+}
+
+// backfillRevisions inserts a revision of the current state of each product without revisions, valid since the product
+// was created as told by its ObjectID. Archived products get a revision closed when they were archived.
+func backfillRevisions(products MongoCollection, revisions MongoCollection) (int, error) {
+	pipeline := bson.NewArray(
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$lookup",
+			bson.EC.String("from", "product_revisions"),
+			bson.EC.String("localField", "_id"),
+			bson.EC.String("foreignField", "ProductID"),
+			bson.EC.String("as", "Revisions"),
+		)),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$match",
+			bson.EC.SubDocumentFromElements("Revisions.0", bson.EC.Boolean("$exists", false)),
+		)),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$project", bson.EC.Int32("Revisions", 0))),
+	)
+
+	cursor, err := products.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	count := 0
+	for cursor.Next(context.Background()) {
+		p := struct {
+			ID objectid.ObjectID `bson:"_id"`
+		}{}
+		revision := mrequest.ProductRevision{}
+
+		err = cursor.Decode(&p)
+		if err == nil {
+			err = cursor.Decode(&revision)
+		}
+		if err != nil {
+			return count, err
+		}
+
+		revision.ProductID = p.ID
+		revision.ValidFrom = time.Unix(int64(binary.BigEndian.Uint32(p.ID[0:4])), 0).UTC()
+		revision.ValidTo = revision.ArchivedAt
+		revision.ArchivedAt = nil
+
+		_, err = revisions.InsertOne(context.Background(), &revision)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, cursor.Err()
+}
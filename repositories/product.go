@@ -2,35 +2,89 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"log"
+	"products/helper"
 	"products/models/request"
 	"products/models/response"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
 	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/aggregateopt"
+	"github.com/mongodb/mongo-go-driver/mongo/changestreamopt"
+	"github.com/mongodb/mongo-go-driver/mongo/countopt"
 	"github.com/mongodb/mongo-go-driver/mongo/findopt"
 	"github.com/mongodb/mongo-go-driver/mongo/insertopt"
+	"github.com/mongodb/mongo-go-driver/mongo/mongoopt"
 )
 
+var (
+	// ErrVersionMismatch is returned by conditional writes when the stored product has a different version than the expected one
+	ErrVersionMismatch = errors.New("product was modified meanwhile, version does not match")
+
+	// ErrArchived is returned by writes that require the product not to be archived
+	ErrArchived = errors.New("product is archived")
+
+	// ErrNotArchived is returned by writes that require the product to be archived
+	ErrNotArchived = errors.New("product is not archived")
+)
+
+// listCollation orders and compares strings on listings following Portuguese rules, e.g. "Água" sorts before "Bola".
+// The listing indexes created by NewDBCollections have the same collation, otherwise they couldn't be used to sort.
+var listCollation = &mongoopt.Collation{Locale: "pt"}
+
 // ProductRepository performs CRUD operations on users resource
 type ProductRepository struct {
-	products MongoCollection
+	products  MongoCollection
+	revisions MongoCollection
 }
 
 type ProductRepositoryContract interface {
 	CreateOne(request *mrequest.ProductCreate) (*mongo.InsertOneResult, error)
 	ReadOne(p *mrequest.ProductRead) (*mresponse.Product, error)
+	ReadByID(id objectid.ObjectID, asOf *time.Time, projection *mrequest.Projection) (*mresponse.ProductRead, error)
 	InsertMany(request *[]*mrequest.ProductCreate) (*mongo.InsertManyResult, error)
+	UpdateOne(id objectid.ObjectID, version int64, request *mrequest.ProductUpdate) (*mongo.UpdateResult, error)
+	ArchiveOne(id objectid.ObjectID, version int64, reason string) (*mongo.UpdateResult, error)
+	RestoreOne(id objectid.ObjectID, version int64) (*mongo.UpdateResult, error)
+	UpdateStatus(id objectid.ObjectID, version int64, status string) (*mongo.UpdateResult, error)
 	List(req *mrequest.ListRequest) (int64, int64, int64, mongo.Cursor, error)
+	Export(req *mrequest.ListRequest) (mongo.Cursor, error)
+	ListForPeriod(start time.Time, end time.Time) (mongo.Cursor, error)
+	Facets(req *mrequest.ListRequest) (mongo.Cursor, error)
+	Distinct(req *mrequest.DistinctRequest) ([]interface{}, error)
+	CountDistinct(req *mrequest.DistinctRequest) (mongo.Cursor, error)
+	Search(req *mrequest.SearchRequest) (int64, int64, int64, mongo.Cursor, error)
+	Suggest(req *mrequest.SuggestRequest) (mongo.Cursor, error)
+	Watch(ctx context.Context, req *mrequest.ChangesRequest) (mongo.Cursor, error)
 }
 
 // NewProductRepository is the constructor for ProductRepository
 func NewProductRepository(db *DBCollections) ProductRepositoryContract {
-	return &ProductRepository{products: db.Product}
+	return &ProductRepository{products: db.Product, revisions: db.ProductRevision}
 }
 
 // CreateOne saves provided model instance to database
 func (this *ProductRepository) CreateOne(request *mrequest.ProductCreate) (*mongo.InsertOneResult, error) {
-	return this.products.InsertOne(context.Background(), request)
+	request.ID = objectid.New()
+	request.Version = 1
+	request.SuggestKeys = suggestKeys(request.ProductCode, request.ProductNumberCode, request.ProductDescription)
+
+	res, err := this.products.InsertOne(context.Background(), request)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = this.revisions.InsertOne(context.Background(), mrequest.NewProductRevision(request, time.Now().UTC()))
+	if err != nil {
+		log.Printf("Error saving revision of product %s: %s\n", request.ID.Hex(), err.Error())
+	}
+
+	return res, nil
 }
 
 // ReadOne returns a product based on ProductCode sent in request
@@ -51,58 +105,869 @@ func (this *ProductRepository) ReadOne(p *mrequest.ProductRead) (*mresponse.Prod
 	return &res, nil
 }
 
+// ReadByID returns the product with the provided id.
+// If asOf is provided the product is returned as it existed at that moment, from its revisions history.
+// If projection is provided only the selected fields are read.
+func (this *ProductRepository) ReadByID(id objectid.ObjectID, asOf *time.Time, projection *mrequest.Projection) (*mresponse.ProductRead, error) {
+	var result *mongo.DocumentResult
+
+	opts := []findopt.One{}
+	if fields := projectionDocument(projection, nil); fields != nil {
+		opts = append(opts, findopt.Projection(fields))
+	}
+
+	if asOf == nil {
+		result = this.products.FindOne(
+			context.Background(),
+			bson.NewDocument(bson.EC.ObjectID("_id", id)),
+			opts...,
+		)
+	} else {
+		args := append(validAt(*asOf), bson.EC.ObjectID("ProductID", id))
+		result = this.revisions.FindOne(
+			context.Background(),
+			bson.NewDocument(args...),
+			opts...,
+		)
+	}
+
+	res := mresponse.ProductRead{}
+	err := result.Decode(&res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// revisions have their own _id
+	res.IDdb = id
+
+	return &res, nil
+}
+
 func (this *ProductRepository) InsertMany(request *[]*mrequest.ProductCreate) (*mongo.InsertManyResult, error) {
 	// transform to []interface{} (https://golang.org/doc/faq#convert_slice_of_interface)
 	s := make([]interface{}, len(*request))
 	for i, v := range *request {
+		v.ID = objectid.New()
+		v.Version = 1
+		v.SuggestKeys = suggestKeys(v.ProductCode, v.ProductNumberCode, v.ProductDescription)
 		s[i] = v
 	}
 
 	// { ordered: false } ordered is false in order to don't stop execution because an error ocurred on one of the inserts
 	opt := insertopt.Ordered(false)
-	return this.products.InsertMany(context.Background(), s, opt)
+	res, err := this.products.InsertMany(context.Background(), s, opt)
+
+	// only products actually inserted get a revision
+	failed := map[int]bool{}
+	if err != nil {
+		bulkErr, ok := err.(mongo.BulkWriteError)
+		if !ok {
+			return res, err
+		}
+		for _, writeErr := range bulkErr.WriteErrors {
+			failed[writeErr.Index] = true
+		}
+	}
+
+	now := time.Now().UTC()
+	revisions := []interface{}{}
+	for i, v := range *request {
+		if !failed[i] {
+			revisions = append(revisions, mrequest.NewProductRevision(v, now))
+		}
+	}
+
+	if len(revisions) > 0 {
+		_, e := this.revisions.InsertMany(context.Background(), revisions, opt)
+		if e != nil {
+			log.Printf("Error saving products revisions: %s\n", e.Error())
+		}
+	}
+
+	return res, err
+}
+
+// UpdateOne replaces the product fields only if the stored product still has the provided version and is not archived, incrementing its version.
+// Returns mongo.ErrNoDocuments if the product does not exist, ErrVersionMismatch if it was modified meanwhile and ErrArchived if it is archived.
+func (this *ProductRepository) UpdateOne(id objectid.ObjectID, version int64, request *mrequest.ProductUpdate) (*mongo.UpdateResult, error) {
+	set := bson.NewDocument(productFields(request)...)
+
+	return this.conditionalUpdate(id, version, false, set)
+}
+
+// ArchiveOne marks the product as archived with the provided reason, hiding it from default listings.
+// Archived products are kept as their revisions are referenced by issued documents.
+func (this *ProductRepository) ArchiveOne(id objectid.ObjectID, version int64, reason string) (*mongo.UpdateResult, error) {
+	set := bson.NewDocument(
+		bson.EC.Time("ArchivedAt", time.Now().UTC()),
+		bson.EC.String("ArchiveReason", reason),
+	)
+
+	return this.conditionalUpdate(id, version, false, set)
+}
+
+// RestoreOne brings an archived product back to the catalog
+func (this *ProductRepository) RestoreOne(id objectid.ObjectID, version int64) (*mongo.UpdateResult, error) {
+	set := bson.NewDocument(
+		bson.EC.Null("ArchivedAt"),
+		bson.EC.String("ArchiveReason", ""),
+	)
+
+	return this.conditionalUpdate(id, version, true, set)
+}
+
+// UpdateStatus moves the product to the provided lifecycle status. Transitions are validated by the service layer.
+func (this *ProductRepository) UpdateStatus(id objectid.ObjectID, version int64, status string) (*mongo.UpdateResult, error) {
+	set := bson.NewDocument(bson.EC.String("Status", status))
+
+	return this.conditionalUpdate(id, version, false, set)
+}
+
+// conditionalUpdate sets the provided fields and increments the product version, only if the product is at the provided version
+// and is archived or not as required. Each successful update records a new product revision.
+func (this *ProductRepository) conditionalUpdate(id objectid.ObjectID, version int64, archived bool, set *bson.Document) (*mongo.UpdateResult, error) {
+	filter := bson.NewDocument(
+		bson.EC.ObjectID("_id", id),
+		versionFilter(version),
+		archivedFilter(archived),
+	)
+
+	update := bson.NewDocument(
+		bson.EC.SubDocument("$set", set),
+		bson.EC.SubDocumentFromElements("$inc", bson.EC.Int64("Version", 1)),
+	)
+
+	res, err := this.products.UpdateOne(context.Background(), filter, update)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.MatchedCount == 0 {
+		return nil, this.conditionalWriteError(id, version)
+	}
+
+	err = this.recordRevision(id, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error saving revision of product %s: %s\n", id.Hex(), err.Error())
+	}
+
+	return res, nil
+}
+
+// conditionalWriteError tells why a conditional write on a product matched no documents
+func (this *ProductRepository) conditionalWriteError(id objectid.ObjectID, version int64) error {
+	p := mresponse.ProductRead{}
+	err := this.products.FindOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", id)),
+	).Decode(&p)
+
+	if err != nil {
+		return err
+	}
+
+	if p.Version != version {
+		return ErrVersionMismatch
+	}
+
+	if p.ArchivedAt != nil {
+		return ErrArchived
+	}
+
+	return ErrNotArchived
+}
+
+// recordRevision closes the open revision of the product and, unless the product is archived, saves its current state as a new revision
+func (this *ProductRepository) recordRevision(id objectid.ObjectID, now time.Time) error {
+	revision := mrequest.ProductRevision{}
+	findErr := this.products.FindOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", id)),
+	).Decode(&revision)
+
+	if findErr != nil && findErr != mongo.ErrNoDocuments {
+		return findErr
+	}
+
+	_, err := this.revisions.UpdateMany(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("ProductID", id), bson.EC.Null("ValidTo")),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$set", bson.EC.Time("ValidTo", now))),
+	)
+	if err != nil {
+		return err
+	}
+
+	if findErr == mongo.ErrNoDocuments || revision.ArchivedAt != nil { // product is no longer active
+		return nil
+	}
+
+	revision.ProductID = id
+	revision.ValidFrom = now
+	revision.ValidTo = nil
+
+	_, err = this.revisions.InsertOne(context.Background(), &revision)
+
+	return err
 }
 
 // List will return a mongo.Cursor along with pagination utility values
-// total, perPage, page, cursor, error - these are the return values 
+// total, perPage, page, cursor, error - these are the return values
+// If req.AsOf is set, products are listed as they existed at that moment.
+// In cursor mode the page starts after req.After and has one more product than perPage, telling whether there is a next page.
+// total is not counted if req.SkipTotal is set.
 func (this *ProductRepository) List(req *mrequest.ListRequest) (int64, int64, int64, mongo.Cursor, error) {
 
-	args := []*bson.Element{}
+	conditions := []*bson.Value{}
+	if req.After != nil {
+		conditions = append(conditions, afterCursor(req.After, req.SortKeys, req.AsOf != nil))
+	}
+
+	args := listQuery(req, conditions...)
+	// the total is of the whole listing, not only of the products after the cursor
+	countArgs := listQuery(req)
+
+	sorting := listSorting(req)
+
+	perPage := int64(req.PerPage)
+	page := int64(req.Page)
 
-	for key, value := range req.Filters {
-		if key != "_id" { // filter by text fields
-			pattern := value.(string) 
-			elem := bson.EC.Regex(key, pattern, "i")
-			args = append(args, elem)
-		} else { // filter by _id
-			elem := bson.EC.String(key, value.(string))
-			args = append(args, elem)
+	skip := perPage * (page - 1)
+	limit := perPage
+	if req.CursorMode {
+		skip = 0
+		limit = perPage + 1
+	}
+
+	if req.AsOf != nil {
+		return this.listAsOf(args, countArgs, sorting, skip, limit, req)
+	}
+
+	var total int64
+	if !req.SkipTotal {
+		var e error
+		total, e = this.products.Count(
+			context.Background(),
+			bson.NewDocument(countArgs...),
+			countopt.Collation(listCollation),
+		)
+		if e != nil {
+			return 0, perPage, page, nil, e
 		}
 	}
 
-	total, e := this.products.Count(
+	opts := []findopt.Find{
+		findopt.Sort(sorting),
+		findopt.Skip(skip),
+		findopt.Limit(limit),
+		findopt.Collation(listCollation),
+	}
+	if fields := projectionDocument(req.Projection, req.SortKeys); fields != nil {
+		opts = append(opts, findopt.Projection(fields))
+	}
+
+	cursor, e := this.products.Find(
 		context.Background(),
 		bson.NewDocument(args...),
+		opts...,
 	)
 
-	sorting := map[string]int{}
-	var sortingValue int
-	if req.Order == "reverse" {
-		sortingValue = -1
-	} else {
-		sortingValue = 1
+	return total, perPage, page, cursor, e
+}
+
+// Export returns a cursor over all the listed products, sorted as in the listing but without pagination
+func (this *ProductRepository) Export(req *mrequest.ListRequest) (mongo.Cursor, error) {
+	args := listQuery(req)
+	sorting := listSorting(req)
+	fields := projectionDocument(req.Projection, req.SortKeys)
+
+	if req.AsOf != nil {
+		pipeline := bson.NewArray(
+			bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$match", args...)),
+			bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$addFields", bson.EC.String("_id", "$ProductID"))),
+			bson.VC.DocumentFromElements(bson.EC.SubDocument("$sort", sorting)),
+		)
+		if fields != nil {
+			pipeline.Append(bson.VC.DocumentFromElements(bson.EC.SubDocument("$project", fields)))
+		}
+
+		return this.revisions.Aggregate(context.Background(), pipeline, aggregateopt.Collation(listCollation))
+	}
+
+	opts := []findopt.Find{
+		findopt.Sort(sorting),
+		findopt.Collation(listCollation),
+	}
+	if fields != nil {
+		opts = append(opts, findopt.Projection(fields))
+	}
+
+	return this.products.Find(
+		context.Background(),
+		bson.NewDocument(args...),
+		opts...,
+	)
+}
+
+// listAsOf lists the revisions valid at asOf, exposing them with the _id of the product they belong to.
+// The total is counted on countArgs, the listing query without the cursor conditions.
+func (this *ProductRepository) listAsOf(args []*bson.Element, countArgs []*bson.Element, sorting *bson.Document, skip int64, limit int64, req *mrequest.ListRequest) (int64, int64, int64, mongo.Cursor, error) {
+	perPage := int64(req.PerPage)
+	page := int64(req.Page)
+
+	var total int64
+	if !req.SkipTotal {
+		var e error
+		total, e = this.revisions.Count(
+			context.Background(),
+			bson.NewDocument(countArgs...),
+			countopt.Collation(listCollation),
+		)
+		if e != nil {
+			return 0, perPage, page, nil, e
+		}
+	}
+
+	pipeline := bson.NewArray(
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$match", args...)),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$addFields", bson.EC.String("_id", "$ProductID"))),
+		bson.VC.DocumentFromElements(bson.EC.SubDocument("$sort", sorting)),
+		bson.VC.DocumentFromElements(bson.EC.Int64("$skip", skip)),
+		bson.VC.DocumentFromElements(bson.EC.Int64("$limit", limit)),
+	)
+	if fields := projectionDocument(req.Projection, req.SortKeys); fields != nil {
+		pipeline.Append(bson.VC.DocumentFromElements(bson.EC.SubDocument("$project", fields)))
+	}
+
+	cursor, e := this.revisions.Aggregate(context.Background(), pipeline, aggregateopt.Collation(listCollation))
+
+	return total, perPage, page, cursor, e
+}
+
+// listQuery returns the query matching the products of a listing along with the extra conditions, if any.
+// If req.AsOf is set the query applies to the revisions valid at that moment.
+func listQuery(req *mrequest.ListRequest, conditions ...*bson.Value) []*bson.Element {
+	args := []*bson.Element{}
+
+	conditions = append(filterConditions(req.Filters, req.AsOf != nil), conditions...)
+	if len(conditions) > 0 {
+		args = append(args, bson.EC.ArrayFromElements("$and", conditions...))
+	}
+
+	if len(req.Status) > 0 {
+		args = append(args, statusFilter(req.Status))
+	}
+
+	// revisions only exist while products are not archived
+	if req.AsOf != nil {
+		return append(args, validAt(*req.AsOf)...)
+	}
+
+	if req.OnlyArchived {
+		args = append(args, archivedFilter(true))
+	} else if !req.IncludeArchived {
+		args = append(args, archivedFilter(false))
+	}
+
+	return args
+}
+
+// Facets returns a cursor over one document with the counts of the listed products by ProductType and ProductGroup,
+// their total and how many have CN codes and UN numbers. The listing filters are applied but not its pagination.
+func (this *ProductRepository) Facets(req *mrequest.ListRequest) (mongo.Cursor, error) {
+	collection := this.products
+	if req.AsOf != nil {
+		collection = this.revisions
+	}
+
+	pipeline := bson.NewArray(
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$match", listQuery(req)...)),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$facet",
+			bson.EC.Array("ProductType", facetCounts("$ProductType")),
+			bson.EC.Array("ProductGroup", facetCounts("$ProductGroup")),
+			bson.EC.ArrayFromElements("Customs",
+				bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$group",
+					bson.EC.Null("_id"),
+					bson.EC.SubDocumentFromElements("total", bson.EC.Int64("$sum", 1)),
+					bson.EC.SubDocumentFromElements("CNCode", bson.EC.SubDocumentFromElements("$sum", presentCount("$CustomsDetails.CNCode"))),
+					bson.EC.SubDocumentFromElements("UNNumber", bson.EC.SubDocumentFromElements("$sum", presentCount("$CustomsDetails.UNNumber"))),
+				)),
+			),
+		)),
+	)
+
+	return collection.Aggregate(context.Background(), pipeline, aggregateopt.Collation(listCollation))
+}
+
+// facetCounts returns the $facet pipeline counting products by the values of a field, most frequent first.
+// Products without the field are counted on the empty value.
+func facetCounts(field string) *bson.Array {
+	return bson.NewArray(
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$group",
+			bson.EC.SubDocumentFromElements("_id", bson.EC.ArrayFromElements("$ifNull", bson.VC.String(field), bson.VC.String(""))),
+			bson.EC.SubDocumentFromElements("count", bson.EC.Int64("$sum", 1)),
+		)),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$sort",
+			bson.EC.Int32("count", -1),
+			bson.EC.Int32("_id", 1),
+		)),
+	)
+}
+
+// presentCount is the expression adding 1 for products having a non empty value on field and 0 otherwise
+func presentCount(field string) *bson.Element {
+	notEqual := func(v *bson.Value) *bson.Value {
+		value := bson.VC.DocumentFromElements(bson.EC.ArrayFromElements("$ifNull", bson.VC.String(field), bson.VC.Null()))
+		return bson.VC.DocumentFromElements(bson.EC.ArrayFromElements("$ne", value, v))
 	}
-	sorting[req.Sort] = sortingValue
+
+	present := bson.VC.DocumentFromElements(bson.EC.ArrayFromElements("$and",
+		notEqual(bson.VC.Null()),
+		notEqual(bson.VC.ArrayFromValues()),
+		notEqual(bson.VC.String("")),
+	))
+
+	return bson.EC.ArrayFromElements("$cond", present, bson.VC.Int64(1), bson.VC.Int64(0))
+}
+
+// Distinct returns the distinct values of a field on products not archived, starting with req.Prefix ignoring case.
+// Values of array fields, like CustomsDetails.CNCode, are returned on their own.
+func (this *ProductRepository) Distinct(req *mrequest.DistinctRequest) ([]interface{}, error) {
+	return this.products.Distinct(context.Background(), req.Field, distinctQuery(req))
+}
+
+// CountDistinct returns a cursor over the distinct values of a field, like Distinct, with the number of products having each one
+func (this *ProductRepository) CountDistinct(req *mrequest.DistinctRequest) (mongo.Cursor, error) {
+	pipeline := bson.NewArray(
+		bson.VC.DocumentFromElements(bson.EC.SubDocument("$match", distinctQuery(req))),
+		bson.VC.DocumentFromElements(bson.EC.String("$unwind", "$"+req.Field)),
+		// array fields values are matched again on their own
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$match", distinctValueFilter(req))),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$group",
+			bson.EC.String("_id", "$"+req.Field),
+			bson.EC.SubDocumentFromElements("count", bson.EC.Int64("$sum", 1)),
+		)),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$sort", bson.EC.Int32("_id", 1))),
+	)
+
+	return this.products.Aggregate(context.Background(), pipeline, aggregateopt.Collation(listCollation))
+}
+
+// distinctQuery matches the products not archived with a value on the field, starting with the prefix if provided
+func distinctQuery(req *mrequest.DistinctRequest) *bson.Document {
+	return bson.NewDocument(archivedFilter(false), distinctValueFilter(req))
+}
+
+// distinctValueFilter matches non empty field values, starting with the prefix ignoring case if provided
+func distinctValueFilter(req *mrequest.DistinctRequest) *bson.Element {
+	if req.Prefix != "" {
+		return bson.EC.Regex(req.Field, "^"+regexp.QuoteMeta(req.Prefix), "i")
+	}
+
+	return bson.EC.SubDocumentFromElements(req.Field, bson.EC.ArrayFromElements("$nin", bson.VC.Null(), bson.VC.String("")))
+}
+
+// ListForPeriod returns a cursor over the last revision of every product that was valid at some moment
+// between start (inclusive) and end (exclusive), sorted by ProductCode
+func (this *ProductRepository) ListForPeriod(start time.Time, end time.Time) (mongo.Cursor, error) {
+	match := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("ValidFrom", bson.EC.Time("$lt", end)),
+		bson.EC.ArrayFromElements("$or",
+			bson.VC.DocumentFromElements(bson.EC.Null("ValidTo")),
+			bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("ValidTo", bson.EC.Time("$gt", start))),
+		),
+	)
+
+	pipeline := bson.NewArray(
+		bson.VC.DocumentFromElements(bson.EC.SubDocument("$match", match)),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$sort",
+			bson.EC.Int32("ProductID", 1),
+			bson.EC.Int32("ValidFrom", -1),
+		)),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$group",
+			bson.EC.String("_id", "$ProductID"),
+			bson.EC.SubDocumentFromElements("revision", bson.EC.String("$first", "$$ROOT")),
+		)),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$replaceRoot", bson.EC.String("newRoot", "$revision"))),
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$sort", bson.EC.Int32("ProductCode", 1))),
+	)
+
+	return this.revisions.Aggregate(context.Background(), pipeline)
+}
+
+// Search runs a full text search over the products text index, excluding archived products.
+// Products are sorted by relevance and have their text score in the "score" field.
+// total, perPage, page, cursor, error - these are the return values
+func (this *ProductRepository) Search(req *mrequest.SearchRequest) (int64, int64, int64, mongo.Cursor, error) {
+	query := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("$text",
+			bson.EC.String("$search", req.Query),
+			bson.EC.String("$language", "portuguese"),
+			bson.EC.Boolean("$diacriticSensitive", false),
+		),
+		archivedFilter(false),
+	)
 
 	perPage := int64(req.PerPage)
 	page := int64(req.Page)
+
+	total, e := this.products.Count(context.Background(), query)
+	if e != nil {
+		return 0, perPage, page, nil, e
+	}
+
+	score := bson.NewDocument(bson.EC.SubDocumentFromElements("score", bson.EC.String("$meta", "textScore")))
+
 	cursor, e := this.products.Find(
 		context.Background(),
-		bson.NewDocument(args...),
-		findopt.Sort(sorting),
-		findopt.Skip(int64(req.PerPage*(req.Page-1))),
+		query,
+		findopt.Projection(score),
+		findopt.Sort(score),
+		findopt.Skip(perPage*(page-1)),
 		findopt.Limit(perPage),
 	)
 
 	return total, perPage, page, cursor, e
 }
+
+// Suggest returns the products, not archived, with a code or description word starting with every word of the prefix.
+// Matching ignores case and accents, using the SuggestKeys index kept on every product write.
+func (this *ProductRepository) Suggest(req *mrequest.SuggestRequest) (mongo.Cursor, error) {
+	prefixes := bson.NewArray()
+	for _, word := range strings.Fields(helper.Fold(req.Prefix)) {
+		prefixes.Append(bson.VC.Regex("^"+regexp.QuoteMeta(word), ""))
+	}
+
+	query := bson.NewDocument(
+		bson.EC.SubDocumentFromElements("SuggestKeys", bson.EC.Array("$all", prefixes)),
+		archivedFilter(false),
+	)
+
+	return this.products.Find(
+		context.Background(),
+		query,
+		findopt.Projection(bson.NewDocument(
+			bson.EC.Int32("ProductCode", 1),
+			bson.EC.Int32("ProductDescription", 1),
+		)),
+		findopt.Sort(bson.NewDocument(bson.EC.Int32("ProductCode", 1))),
+		findopt.Limit(int64(req.Limit)),
+	)
+}
+
+// Watch opens a change stream on products, with the product after the change looked up on updates.
+// Products are archived rather than deleted, so only inserts, updates and replaces are followed.
+// The stream is open until ctx is done, resuming after req.ResumeAfter if set.
+func (this *ProductRepository) Watch(ctx context.Context, req *mrequest.ChangesRequest) (mongo.Cursor, error) {
+	pipeline := bson.NewArray(
+		bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("$match",
+			bson.EC.SubDocumentFromElements("operationType", bson.EC.ArrayFromElements("$in",
+				bson.VC.String(mresponse.ChangeInsert),
+				bson.VC.String(mresponse.ChangeUpdate),
+				bson.VC.String(mresponse.ChangeReplace),
+			)),
+		)),
+	)
+
+	opts := []changestreamopt.ChangeStream{changestreamopt.FullDocument(mongoopt.UpdateLookup)}
+	if req.ResumeAfter != nil {
+		opts = append(opts, changestreamopt.ResumeAfter(req.ResumeAfter))
+	}
+
+	return this.products.Watch(ctx, pipeline, opts...)
+}
+
+// suggestKeys returns the keys a product is suggested by: its codes as a whole and the words of its description, without case nor accents
+func suggestKeys(productCode string, productNumberCode string, description string) []string {
+	keys := []string{}
+	seen := map[string]bool{}
+
+	for _, key := range append([]string{productCode, productNumberCode}, helper.Words(description)...) {
+		key = helper.Fold(key)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// validAt returns the query elements matching revisions valid at the provided moment
+func validAt(t time.Time) []*bson.Element {
+	return []*bson.Element{
+		bson.EC.SubDocumentFromElements("ValidFrom", bson.EC.Time("$lte", t)),
+		bson.EC.ArrayFromElements("$or",
+			bson.VC.DocumentFromElements(bson.EC.Null("ValidTo")),
+			bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements("ValidTo", bson.EC.Time("$gt", t))),
+		),
+	}
+}
+
+// versionFilter matches products with the provided version.
+// Products stored before versioning was introduced have no Version and are considered version 0.
+func versionFilter(version int64) *bson.Element {
+	if version == 0 {
+		return bson.EC.SubDocumentFromElements("Version", bson.EC.ArrayFromElements("$in", bson.VC.Int64(0), bson.VC.Null()))
+	}
+
+	return bson.EC.Int64("Version", version)
+}
+
+// filterConditions translates the list filters to the conditions to be matched.
+// Conditions are combined with $and as the same field may have more than one condition (e.g. ranges).
+// On revisions the product id is on ProductID, their _id is matched before being replaced by it.
+func filterConditions(filters []*mrequest.Filter, revisions bool) []*bson.Value {
+	conditions := make([]*bson.Value, len(filters))
+
+	for i, f := range filters {
+		var condition *bson.Element
+
+		switch f.Operator {
+		case mrequest.FilterEq:
+			condition = filterElement("$eq", f.Values[0])
+		case mrequest.FilterNe:
+			condition = filterElement("$ne", f.Values[0])
+		case mrequest.FilterGt, mrequest.FilterGte, mrequest.FilterLt, mrequest.FilterLte:
+			condition = filterElement("$"+f.Operator, f.Values[0])
+		case mrequest.FilterIn, mrequest.FilterNin:
+			values := bson.NewArray()
+			for _, v := range f.Values {
+				values.Append(filterElement("", v).Value())
+			}
+			condition = bson.EC.Array("$"+f.Operator, values)
+		case mrequest.FilterExists:
+			condition = bson.EC.Boolean("$exists", f.Values[0].(bool))
+		case mrequest.FilterPrefix: // case sensitive so the field index can be used
+			condition = bson.EC.Regex("$regex", "^"+regexp.QuoteMeta(f.Values[0].(string)), "")
+		default: // contains
+			condition = bson.EC.Regex("$regex", regexp.QuoteMeta(f.Values[0].(string)), "i")
+		}
+
+		field := f.Field
+		if field == "_id" && revisions {
+			field = "ProductID"
+		}
+
+		conditions[i] = bson.VC.DocumentFromElements(bson.EC.SubDocumentFromElements(field, condition))
+	}
+
+	return conditions
+}
+
+// listSorting sorts by the request sort keys, which end with _id so products with the same values always come in the same order
+func listSorting(req *mrequest.ListRequest) *bson.Document {
+	sorting := bson.NewDocument()
+
+	for _, key := range req.SortKeys {
+		direction := int32(1)
+		if key.Descending {
+			direction = -1
+		}
+
+		sorting.Append(bson.EC.Int32(key.Field, direction))
+
+		// next keys would never be compared
+		if key.Field == "_id" {
+			break
+		}
+	}
+
+	return sorting
+}
+
+// afterCursor matches the products sorted after the cursor position.
+// For sort keys (a, b, _id) that is a > va OR (a = va AND b > vb) OR (a = va AND b = vb AND _id > id), using < on descending keys.
+// Null and missing values sort before any string: after a null come all strings on ascending keys and nothing on descending
+// ones, while after a string on descending keys come the smaller strings and the nulls.
+// On revisions the product id is on ProductID.
+func afterCursor(after *mrequest.ListCursor, keys []*mrequest.SortKey, revisions bool) *bson.Value {
+	branches := []*bson.Value{}
+	equal := []*bson.Element{}
+	values := after.Values
+
+	for _, key := range keys {
+		operator := "$gt"
+		if key.Descending {
+			operator = "$lt"
+		}
+
+		if key.Field == "_id" {
+			idField := "_id"
+			if revisions {
+				idField = "ProductID"
+			}
+
+			branch := append(append([]*bson.Element{}, equal...), bson.EC.SubDocumentFromElements(idField, bson.EC.ObjectID(operator, after.ID)))
+			branches = append(branches, bson.VC.DocumentFromElements(branch...))
+			break
+		}
+
+		value := values[0]
+		values = values[1:]
+
+		after := []*bson.Element{}
+		switch {
+		case value == nil && !key.Descending:
+			after = append(after, bson.EC.SubDocumentFromElements(key.Field, bson.EC.String("$type", "string")))
+		case value != nil:
+			after = append(after, bson.EC.SubDocumentFromElements(key.Field, bson.EC.String(operator, *value)))
+			if key.Descending {
+				after = append(after, bson.EC.Null(key.Field))
+			}
+		}
+
+		for _, element := range after {
+			branch := append(append([]*bson.Element{}, equal...), element)
+			branches = append(branches, bson.VC.DocumentFromElements(branch...))
+		}
+
+		if value == nil {
+			equal = append(equal, bson.EC.Null(key.Field))
+		} else {
+			equal = append(equal, bson.EC.String(key.Field, *value))
+		}
+	}
+
+	return bson.VC.DocumentFromElements(bson.EC.ArrayFromElements("$or", branches...))
+}
+
+// projectionDocument returns the Mongo projection reading the selected fields or all but the excluded ones, nil without projection.
+// Version, Status and ArchivedAt are always read as the service relies on them, like on the sort fields for cursor pagination,
+// they're only left out of the response.
+func projectionDocument(projection *mrequest.Projection, keys []*mrequest.SortKey) *bson.Document {
+	if projection == nil {
+		return nil
+	}
+
+	value := int32(1)
+	names := projection.Fields
+	if len(projection.Exclude) > 0 {
+		value = 0
+		names = projection.Exclude
+	}
+
+	fields := []string{}
+	for _, name := range names {
+		fields = append(fields, mrequest.ProjectionFields[name])
+	}
+
+	required := []string{"Version", "Status", "ArchivedAt"}
+	for _, key := range keys {
+		required = append(required, key.Field)
+	}
+
+	if value == 1 {
+		fields = append(fields, required...)
+	} else {
+		fields = excludeFields(fields, required)
+	}
+
+	// a field can't be projected along with its own subfields
+	set := map[string]bool{}
+	for _, field := range fields {
+		set[field] = true
+	}
+
+	doc := bson.NewDocument()
+	for field := range set {
+		if i := strings.Index(field, "."); i > 0 && set[field[:i]] {
+			continue
+		}
+		doc.Append(bson.EC.Int32(field, value))
+	}
+
+	if doc.Len() == 0 {
+		return nil
+	}
+
+	return doc
+}
+
+// excludeFields returns fields without the provided ones
+func excludeFields(fields []string, excluded []string) []string {
+	res := []string{}
+	for _, field := range fields {
+		keep := true
+		for _, e := range excluded {
+			if field == e {
+				keep = false
+			}
+		}
+		if keep {
+			res = append(res, field)
+		}
+	}
+
+	return res
+}
+
+// filterElement creates the element for a filter value, which is either a string or an ObjectID
+func filterElement(key string, value interface{}) *bson.Element {
+	if oid, ok := value.(objectid.ObjectID); ok {
+		return bson.EC.ObjectID(key, oid)
+	}
+
+	return bson.EC.String(key, value.(string))
+}
+
+// archivedFilter matches archived or not archived products
+func archivedFilter(archived bool) *bson.Element {
+	if archived {
+		return bson.EC.SubDocumentFromElements("ArchivedAt", bson.EC.Null("$ne"))
+	}
+
+	return bson.EC.Null("ArchivedAt")
+}
+
+// statusFilter matches products in any of the provided statuses.
+// Products stored before statuses were introduced have no Status and are considered active.
+func statusFilter(statuses []string) *bson.Element {
+	values := bson.NewArray()
+	for _, status := range statuses {
+		values.Append(bson.VC.String(status))
+		if status == mresponse.StatusActive {
+			values.Append(bson.VC.Null())
+		}
+	}
+
+	return bson.EC.SubDocumentFromElements("Status", bson.EC.Array("$in", values))
+}
+
+// productFields returns the catalog fields of a product to be set on update
+func productFields(p *mrequest.ProductUpdate) []*bson.Element {
+	fields := []*bson.Element{
+		bson.EC.String("ProductType", p.ProductType),
+		bson.EC.String("ProductCode", p.ProductCode),
+		bson.EC.String("ProductGroup", p.ProductGroup),
+		bson.EC.String("ProductDescription", p.ProductDescription),
+		bson.EC.String("ProductNumberCode", p.ProductNumberCode),
+	}
+
+	keys := bson.NewArray()
+	for _, key := range suggestKeys(p.ProductCode, p.ProductNumberCode, p.ProductDescription) {
+		keys.Append(bson.VC.String(key))
+	}
+	fields = append(fields, bson.EC.Array("SuggestKeys", keys))
+
+	if p.CustomsDetails == nil {
+		return append(fields, bson.EC.Null("CustomsDetails"))
+	}
+
+	cnCodes := bson.NewArray()
+	for _, code := range p.CustomsDetails.CNCode {
+		cnCodes.Append(bson.VC.String(code))
+	}
+
+	unNumbers := bson.NewArray()
+	for _, number := range p.CustomsDetails.UNNumber {
+		unNumbers.Append(bson.VC.String(number))
+	}
+
+	return append(fields, bson.EC.SubDocumentFromElements("CustomsDetails",
+		bson.EC.Array("CNCode", cnCodes),
+		bson.EC.Array("UNNumber", unNumbers),
+	))
+}
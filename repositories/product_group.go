@@ -0,0 +1,140 @@
+package repositories
+
+import (
+	"context"
+	"products/models/request"
+	"products/models/response"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+)
+
+// ProductGroupRepository performs CRUD operations on product groups resource
+type ProductGroupRepository struct {
+	groups   MongoCollection
+	products MongoCollection
+}
+
+type ProductGroupRepositoryContract interface {
+	CreateOne(request *mrequest.ProductGroupCreate) (*mongo.InsertOneResult, error)
+	ReadByCode(code string) (*mresponse.ProductGroup, error)
+	List(parent *string) (mongo.Cursor, error)
+	Descendants(code string) (mongo.Cursor, error)
+	UpdateOne(code string, request *mrequest.ProductGroupUpdate, ancestors []string) (*mongo.UpdateResult, error)
+	SetAncestors(code string, ancestors []string) (*mongo.UpdateResult, error)
+	DeleteOne(code string) (*mongo.DeleteResult, error)
+	CountChildren(code string) (int64, error)
+	CountProducts(code string) (int64, error)
+}
+
+// NewProductGroupRepository is the constructor for ProductGroupRepository
+func NewProductGroupRepository(db *DBCollections) ProductGroupRepositoryContract {
+	return &ProductGroupRepository{groups: db.ProductGroup, products: db.Product}
+}
+
+// CreateOne saves provided model instance to database
+func (this *ProductGroupRepository) CreateOne(request *mrequest.ProductGroupCreate) (*mongo.InsertOneResult, error) {
+	request.ID = objectid.New()
+
+	return this.groups.InsertOne(context.Background(), request)
+}
+
+// ReadByCode returns the group with the provided code
+func (this *ProductGroupRepository) ReadByCode(code string) (*mresponse.ProductGroup, error) {
+	result := this.groups.FindOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.String("Code", code)),
+	)
+
+	res := mresponse.ProductGroup{}
+	err := result.Decode(&res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// List returns a cursor over the groups sorted by code: all of them if parent is nil,
+// the root groups if parent is empty or else the children of parent
+func (this *ProductGroupRepository) List(parent *string) (mongo.Cursor, error) {
+	query := bson.NewDocument()
+	if parent != nil && *parent == "" {
+		query.Append(bson.EC.SubDocumentFromElements("Parent", bson.EC.ArrayFromElements("$in", bson.VC.Null(), bson.VC.String(""))))
+	} else if parent != nil {
+		query.Append(bson.EC.String("Parent", *parent))
+	}
+
+	return this.groups.Find(
+		context.Background(),
+		query,
+		findopt.Sort(bson.NewDocument(bson.EC.Int32("Code", 1))),
+		findopt.Collation(listCollation),
+	)
+}
+
+// Descendants returns a cursor over all the groups below the provided one, at any depth
+func (this *ProductGroupRepository) Descendants(code string) (mongo.Cursor, error) {
+	return this.groups.Find(
+		context.Background(),
+		bson.NewDocument(bson.EC.String("Ancestors", code)),
+	)
+}
+
+// UpdateOne sets the name of the group and moves it under the last of the provided ancestors
+func (this *ProductGroupRepository) UpdateOne(code string, request *mrequest.ProductGroupUpdate, ancestors []string) (*mongo.UpdateResult, error) {
+	return this.groups.UpdateOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.String("Code", code)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$set",
+			bson.EC.String("Name", request.Name),
+			bson.EC.String("Parent", request.Parent),
+			bson.EC.Array("Ancestors", ancestorsArray(ancestors)),
+		)),
+	)
+}
+
+// SetAncestors changes the ancestors of a group, used when one of them is moved
+func (this *ProductGroupRepository) SetAncestors(code string, ancestors []string) (*mongo.UpdateResult, error) {
+	return this.groups.UpdateOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.String("Code", code)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$set", bson.EC.Array("Ancestors", ancestorsArray(ancestors)))),
+	)
+}
+
+// DeleteOne removes the group with the provided code
+func (this *ProductGroupRepository) DeleteOne(code string) (*mongo.DeleteResult, error) {
+	return this.groups.DeleteOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.String("Code", code)),
+	)
+}
+
+// CountChildren returns the number of groups directly below the provided one
+func (this *ProductGroupRepository) CountChildren(code string) (int64, error) {
+	return this.groups.Count(
+		context.Background(),
+		bson.NewDocument(bson.EC.String("Parent", code)),
+	)
+}
+
+// CountProducts returns the number of products, not archived, in the provided group
+func (this *ProductGroupRepository) CountProducts(code string) (int64, error) {
+	return this.products.Count(
+		context.Background(),
+		bson.NewDocument(bson.EC.String("ProductGroup", code), archivedFilter(false)),
+	)
+}
+
+func ancestorsArray(ancestors []string) *bson.Array {
+	values := bson.NewArray()
+	for _, a := range ancestors {
+		values.Append(bson.VC.String(a))
+	}
+
+	return values
+}
@@ -0,0 +1,33 @@
+package repositories
+
+import (
+	"net/url"
+	"products/models/request"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+)
+
+func TestListQueryAsOfMatchesProductID(t *testing.T) {
+	filters := map[string]string{"_id": "_id"}
+	params, _ := url.ParseQuery("_id=507f191e810c19729de860ea&as_of=2018-06-01T10:00:00Z")
+
+	req, e := mrequest.NewListRequest(params, map[string]string{}, filters)
+	if e != nil {
+		t.Fatalf("Expected no error but got %v", e.Errors)
+	}
+
+	// revisions are matched before their _id is replaced by the product one
+	query := bson.NewDocument(listQuery(req)...).ToExtJSON(false)
+	if !strings.Contains(query, `{"ProductID":{"$eq":{"$oid":"507f191e810c19729de860ea"}}}`) || strings.Contains(query, `"_id"`) {
+		t.Fatalf("Expected the product id to be matched on ProductID but got %s", query)
+	}
+
+	// products have it on _id
+	req.AsOf = nil
+	query = bson.NewDocument(listQuery(req)...).ToExtJSON(false)
+	if !strings.Contains(query, `{"_id":{"$eq":{"$oid":"507f191e810c19729de860ea"}}}`) {
+		t.Fatalf("Expected the product id to be matched on _id but got %s", query)
+	}
+}
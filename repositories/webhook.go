@@ -0,0 +1,296 @@
+package repositories
+
+import (
+	"context"
+	"products/models/request"
+	"products/models/response"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/mongoopt"
+	"github.com/mongodb/mongo-go-driver/mongo/updateopt"
+)
+
+// WebhookRepository persists webhook subscriptions and their deliveries, queued for any instance to send
+type WebhookRepository struct {
+	subscriptions MongoCollection
+	deliveries    MongoCollection
+}
+
+type WebhookRepositoryContract interface {
+	CreateOne(request *mrequest.WebhookCreate) (*mongo.InsertOneResult, error)
+	ReadByID(id objectid.ObjectID) (*mresponse.WebhookSubscription, error)
+	List(eventType string) (mongo.Cursor, error)
+	UpdateOne(id objectid.ObjectID, request *mrequest.WebhookUpdate) (*mongo.UpdateResult, error)
+	DeleteOne(id objectid.ObjectID) (*mongo.DeleteResult, error)
+	RecordResult(id objectid.ObjectID, delivered bool) (int64, error)
+	Disable(id objectid.ObjectID, reason string) (bool, error)
+	Enqueue(delivery *mrequest.WebhookDeliveryCreate) (*mongo.UpdateResult, error)
+	ClaimDelivery(leaseUntil time.Time) (*mresponse.WebhookDelivery, error)
+	RecordAttempt(id objectid.ObjectID, attempt *mresponse.WebhookAttempt, status string, nextAttemptAt *time.Time) error
+	ListDeliveries(id objectid.ObjectID, req *mrequest.DeliveryListRequest) (int64, mongo.Cursor, error)
+	LastDelivery() (*mresponse.WebhookDelivery, error)
+}
+
+// NewWebhookRepository is the constructor for WebhookRepository
+func NewWebhookRepository(db *DBCollections) WebhookRepositoryContract {
+	return &WebhookRepository{subscriptions: db.WebhookSubscription, deliveries: db.WebhookDelivery}
+}
+
+// CreateOne saves provided model instance to database
+func (this *WebhookRepository) CreateOne(request *mrequest.WebhookCreate) (*mongo.InsertOneResult, error) {
+	request.ID = objectid.New()
+
+	return this.subscriptions.InsertOne(context.Background(), request)
+}
+
+// ReadByID returns the subscription with the provided id
+func (this *WebhookRepository) ReadByID(id objectid.ObjectID) (*mresponse.WebhookSubscription, error) {
+	result := this.subscriptions.FindOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", id)),
+	)
+
+	res := mresponse.WebhookSubscription{}
+	err := result.Decode(&res)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// List returns a cursor over the subscriptions, oldest first: all of them if eventType is empty,
+// otherwise the enabled ones subscribed to eventType
+func (this *WebhookRepository) List(eventType string) (mongo.Cursor, error) {
+	query := bson.NewDocument()
+	if eventType != "" {
+		query.Append(bson.EC.String("Events", eventType), bson.EC.Boolean("Enabled", true))
+	}
+
+	return this.subscriptions.Find(
+		context.Background(),
+		query,
+		findopt.Sort(bson.NewDocument(bson.EC.Int32("CreatedAt", 1))),
+	)
+}
+
+// UpdateOne replaces the URL, events and description of the subscription and enables or disables it if requested.
+// Enabling it clears its failures.
+func (this *WebhookRepository) UpdateOne(id objectid.ObjectID, request *mrequest.WebhookUpdate) (*mongo.UpdateResult, error) {
+	set := bson.NewDocument(
+		bson.EC.String("URL", request.URL),
+		bson.EC.Array("Events", stringsArray(request.Events)),
+		bson.EC.String("Description", request.Description),
+	)
+
+	if request.Enabled != nil {
+		set.Append(bson.EC.Boolean("Enabled", *request.Enabled))
+		if *request.Enabled {
+			set.Append(bson.EC.Int64("ConsecutiveFailures", 0), bson.EC.String("DisabledReason", ""))
+		}
+	}
+
+	return this.subscriptions.UpdateOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", id)),
+		bson.NewDocument(bson.EC.SubDocument("$set", set)),
+	)
+}
+
+// DeleteOne removes the subscription with the provided id along with its deliveries
+func (this *WebhookRepository) DeleteOne(id objectid.ObjectID) (*mongo.DeleteResult, error) {
+	res, err := this.subscriptions.DeleteOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", id)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = this.deliveries.DeleteMany(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("SubscriptionID", id)),
+	)
+
+	return res, err
+}
+
+// RecordResult counts a failed delivery of the subscription or, if delivered, clears its failures.
+// Returns the consecutive failed deliveries of the subscription.
+func (this *WebhookRepository) RecordResult(id objectid.ObjectID, delivered bool) (int64, error) {
+	update := bson.NewDocument(bson.EC.SubDocumentFromElements("$inc", bson.EC.Int64("ConsecutiveFailures", 1)))
+	if delivered {
+		update = bson.NewDocument(bson.EC.SubDocumentFromElements("$set", bson.EC.Int64("ConsecutiveFailures", 0)))
+	}
+
+	result := this.subscriptions.FindOneAndUpdate(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", id)),
+		update,
+		findopt.ReturnDocument(mongoopt.After),
+	)
+
+	res := mresponse.WebhookSubscription{}
+	err := result.Decode(&res)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.ConsecutiveFailures, nil
+}
+
+// Disable disables an enabled subscription with the reason why. Returns false if it was already disabled.
+func (this *WebhookRepository) Disable(id objectid.ObjectID, reason string) (bool, error) {
+	res, err := this.subscriptions.UpdateOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", id), bson.EC.Boolean("Enabled", true)),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$set",
+			bson.EC.Boolean("Enabled", false),
+			bson.EC.String("DisabledReason", reason),
+		)),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return res.MatchedCount == 1, nil
+}
+
+// Enqueue saves a pending delivery, unless the event was already queued for the subscription.
+// Every instance follows the product changes, so the same delivery is enqueued by all of them.
+func (this *WebhookRepository) Enqueue(delivery *mrequest.WebhookDeliveryCreate) (*mongo.UpdateResult, error) {
+	return this.deliveries.UpdateOne(
+		context.Background(),
+		bson.NewDocument(
+			bson.EC.ObjectID("SubscriptionID", delivery.SubscriptionID),
+			bson.EC.String("EventID", delivery.EventID),
+		),
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$setOnInsert",
+			bson.EC.String("EventType", delivery.EventType),
+			bson.EC.String("Payload", delivery.Payload),
+			bson.EC.String("Status", delivery.Status),
+			bson.EC.Array("Attempts", bson.NewArray()),
+			bson.EC.Time("NextAttemptAt", delivery.NextAttemptAt),
+			bson.EC.Time("CreatedAt", delivery.CreatedAt),
+		)),
+		updateopt.Upsert(true),
+	)
+}
+
+// ClaimDelivery returns the pending delivery due the longest, or nil if there's none.
+// Its next attempt is moved to leaseUntil, so it's sent again if the worker sending it stops.
+func (this *WebhookRepository) ClaimDelivery(leaseUntil time.Time) (*mresponse.WebhookDelivery, error) {
+	filter := bson.NewDocument(
+		bson.EC.String("Status", mresponse.DeliveryPending),
+		bson.EC.SubDocumentFromElements("NextAttemptAt", bson.EC.Time("$lte", time.Now().UTC())),
+	)
+
+	result := this.deliveries.FindOneAndUpdate(
+		context.Background(),
+		filter,
+		bson.NewDocument(bson.EC.SubDocumentFromElements("$set", bson.EC.Time("NextAttemptAt", leaseUntil))),
+		findopt.Sort(bson.NewDocument(bson.EC.Int32("NextAttemptAt", 1))),
+		findopt.ReturnDocument(mongoopt.After),
+	)
+
+	delivery := mresponse.WebhookDelivery{}
+	err := result.Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// RecordAttempt adds an attempt, if any, to the log of a delivery and sets its status.
+// Pending deliveries are sent again at nextAttemptAt, the others are finished.
+func (this *WebhookRepository) RecordAttempt(id objectid.ObjectID, attempt *mresponse.WebhookAttempt, status string, nextAttemptAt *time.Time) error {
+	set := bson.NewDocument(bson.EC.String("Status", status))
+	update := bson.NewDocument()
+
+	if nextAttemptAt != nil {
+		set.Append(bson.EC.Time("NextAttemptAt", *nextAttemptAt))
+	} else {
+		set.Append(bson.EC.Time("FinishedAt", time.Now().UTC()))
+		update.Append(bson.EC.SubDocumentFromElements("$unset", bson.EC.String("NextAttemptAt", "")))
+	}
+	update.Append(bson.EC.SubDocument("$set", set))
+
+	if attempt != nil {
+		update.Append(bson.EC.SubDocumentFromElements("$push", bson.EC.SubDocumentFromElements("Attempts",
+			bson.EC.Time("At", attempt.At),
+			bson.EC.Int32("StatusCode", int32(attempt.StatusCode)),
+			bson.EC.String("Response", attempt.Response),
+			bson.EC.String("Error", attempt.Error),
+			bson.EC.Int64("DurationMs", attempt.DurationMs),
+		)))
+	}
+
+	_, err := this.deliveries.UpdateOne(
+		context.Background(),
+		bson.NewDocument(bson.EC.ObjectID("_id", id)),
+		update,
+	)
+
+	return err
+}
+
+// ListDeliveries returns the total and a cursor over a page of the deliveries of a subscription, latest first
+func (this *WebhookRepository) ListDeliveries(id objectid.ObjectID, req *mrequest.DeliveryListRequest) (int64, mongo.Cursor, error) {
+	query := bson.NewDocument(bson.EC.ObjectID("SubscriptionID", id))
+	if req.Status != "" {
+		query.Append(bson.EC.String("Status", req.Status))
+	}
+
+	total, err := this.deliveries.Count(context.Background(), query)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	cursor, err := this.deliveries.Find(
+		context.Background(),
+		query,
+		findopt.Sort(bson.NewDocument(bson.EC.Int32("CreatedAt", -1), bson.EC.Int32("_id", -1))),
+		findopt.Skip(int64((req.Page-1)*req.PerPage)),
+		findopt.Limit(int64(req.PerPage)),
+	)
+
+	return total, cursor, err
+}
+
+// LastDelivery returns the delivery enqueued last, or nil if there's none
+func (this *WebhookRepository) LastDelivery() (*mresponse.WebhookDelivery, error) {
+	result := this.deliveries.FindOne(
+		context.Background(),
+		bson.NewDocument(),
+		findopt.Sort(bson.NewDocument(bson.EC.Int32("CreatedAt", -1))),
+	)
+
+	delivery := mresponse.WebhookDelivery{}
+	err := result.Decode(&delivery)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+func stringsArray(values []string) *bson.Array {
+	array := bson.NewArray()
+	for _, v := range values {
+		array.Append(bson.VC.String(v))
+	}
+
+	return array
+}
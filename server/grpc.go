@@ -0,0 +1,43 @@
+package server
+
+import (
+	"log"
+	"net"
+	"products/config"
+	"products/controllers/v1"
+	"products/proto/productpb"
+
+	"google.golang.org/grpc"
+)
+
+// GRPCServer is the gRPC layer for product resource, served on its own port
+type GRPCServer struct {
+	config            *config.Config
+	productController *controllers.ProductGRPCController
+}
+
+// NewGRPCServer is the GRPCServer constructor
+func NewGRPCServer(cf *config.Config, pc *controllers.ProductGRPCController) *GRPCServer {
+	return &GRPCServer{
+		config:            cf,
+		productController: pc,
+	}
+}
+
+// Run registers the gRPC services and starts serving them
+func (s *GRPCServer) Run() {
+	listener, err := net.Listen("tcp", s.config.GRPCHost)
+	if err != nil {
+		panic(err)
+	}
+
+	srv := grpc.NewServer()
+	productpb.RegisterProductServiceServer(srv, s.productController)
+
+	log.Printf("Serving gRPC on %s\n", s.config.GRPCHost)
+
+	err = srv.Serve(listener)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -10,20 +10,35 @@ import (
 
 // Server is the http layer for role and user resource
 type Server struct {
-	config            *config.Config
-	productController *controllers.ProductController
-	handlers          *handlers.HttpHandlers
+	config                 *config.Config
+	productController      *controllers.ProductController
+	productGroupController *controllers.ProductGroupController
+	importJobController    *controllers.ImportJobController
+	graphQLController      *controllers.GraphQLController
+	webhookController      *controllers.WebhookController
+	handlers               *handlers.HttpHandlers
+	idempotency            *handlers.IdempotencyHandler
 }
 
 // NewServer is the Server constructor
 func NewServer(cf *config.Config,
 	pc *controllers.ProductController,
-	hand *handlers.HttpHandlers) *Server {
+	gc *controllers.ProductGroupController,
+	jc *controllers.ImportJobController,
+	qc *controllers.GraphQLController,
+	wc *controllers.WebhookController,
+	hand *handlers.HttpHandlers,
+	ih *handlers.IdempotencyHandler) *Server {
 
 	return &Server{
-		config:            cf,
-		productController: pc,
-		handlers:          hand,
+		config:                 cf,
+		productController:      pc,
+		productGroupController: gc,
+		importJobController:    jc,
+		graphQLController:      qc,
+		webhookController:      wc,
+		handlers:               hand,
+		idempotency:            ih,
 	}
 }
 
@@ -36,16 +51,113 @@ func (s *Server) Run() {
 	r.HandleMethodNotAllowed = false
 	r.NoRoute(s.handlers.NotFound)
 
+	// requests creating resources can be retried safely with an Idempotency-Key header
+	idempotent := s.idempotency.Handle
+
 	// Product resource
 	productApi := r.Group("/api/v1/product")
 	{
 		// Create a new product
-		productApi.POST("", s.productController.CreateAction)
+		productApi.POST("", idempotent, s.productController.CreateAction)
 
 		// List products with filtering and pagination
 		productApi.GET("", s.productController.ListAction)
+
+		// Products counts by type, group and customs details, with the same filters as the list
+		productApi.GET("/facets", s.productController.FacetsAction)
+
+		// Product groups in use and distinct values of product fields
+		productApi.GET("/groups", s.productController.GroupsAction)
+		productApi.GET("/distinct", s.productController.DistinctAction)
+
+		// Full text search on products
+		productApi.GET("/search", s.productController.SearchAction)
+
+		// Type-ahead suggestions of product codes and descriptions
+		productApi.GET("/suggest", s.productController.SuggestAction)
+
+		// SAF-T (PT) MasterFiles products for a fiscal period
+		productApi.GET("/saft", s.productController.SaftExportAction)
+
+		// Export the listed products as a CSV or NDJSON file
+		productApi.GET("/export", s.productController.ExportAction)
+
+		// Real-time feed of product changes, as Server-Sent Events or over a WebSocket
+		productApi.GET("/changes", s.productController.ChangesAction)
+		productApi.GET("/changes/ws", s.productController.ChangesWebSocketAction)
+
+		// Import products from a CSV or TSV file
+		productApi.POST("/import/csv", idempotent, s.productController.ImportCSVAction)
+
+		// Import jobs, processed in background with their progress tracked
+		productApi.POST("/imports", idempotent, s.importJobController.CreateAction)
+		productApi.GET("/imports/:id", s.importJobController.ReadAction)
+		productApi.POST("/imports/:id/cancel", s.importJobController.CancelAction)
+
+		// Read a product
+		productApi.GET("/:id", s.productController.ReadAction)
+
+		// Replace a product (requires If-Match)
+		productApi.PUT("/:id", s.productController.UpdateAction)
+
+		// Change some fields of a product (requires If-Match)
+		productApi.PATCH("/:id", s.productController.PatchAction)
+
+		// Archive a product (requires If-Match)
+		productApi.DELETE("/:id", s.productController.DeleteAction)
+
+		// Restore an archived product (requires If-Match)
+		productApi.POST("/:id/restore", s.productController.RestoreAction)
+
+		// Product lifecycle status transitions (require If-Match)
+		productApi.POST("/:id/activate", s.productController.ActivateAction)
+		productApi.POST("/:id/discontinue", s.productController.DiscontinueAction)
+		productApi.POST("/:id/block", s.productController.BlockAction)
 	}
 
+	// Product group resource
+	productGroupApi := r.Group("/api/v1/product-group")
+	{
+		// Create a new product group
+		productGroupApi.POST("", idempotent, s.productGroupController.CreateAction)
+
+		// List product groups, all of them or the children of a parent
+		productGroupApi.GET("", s.productGroupController.ListAction)
+
+		// Read a product group
+		productGroupApi.GET("/:code", s.productGroupController.ReadAction)
+
+		// Rename a product group or move it under another parent
+		productGroupApi.PUT("/:code", s.productGroupController.UpdateAction)
+
+		// Delete a product group without subgroups nor products
+		productGroupApi.DELETE("/:code", s.productGroupController.DeleteAction)
+	}
+
+	webhookApi := r.Group("/api/v1/webhooks")
+	{
+		// Subscribe an URL to product events
+		webhookApi.POST("", idempotent, s.webhookController.CreateAction)
+
+		// List webhook subscriptions
+		webhookApi.GET("", s.webhookController.ListAction)
+
+		// Read a webhook subscription
+		webhookApi.GET("/:id", s.webhookController.ReadAction)
+
+		// Change, enable or disable a webhook subscription
+		webhookApi.PUT("/:id", s.webhookController.UpdateAction)
+
+		// Delete a webhook subscription
+		webhookApi.DELETE("/:id", s.webhookController.DeleteAction)
+
+		// Delivery log of a webhook subscription
+		webhookApi.GET("/:id/deliveries", s.webhookController.DeliveriesAction)
+	}
+
+	// GraphQL queries and mutations on products
+	r.POST("/graphql", s.graphQLController.QueryAction)
+
 	// Fire up the server
 	r.Run(s.config.Host)
 }
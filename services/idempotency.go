@@ -0,0 +1,113 @@
+package services
+
+import (
+	"products/config"
+	"products/models/request"
+	"products/models/response"
+	"products/repositories"
+	"products/util/errors"
+	"time"
+)
+
+const (
+	// idempotencyKeyLength is the maximum length of Idempotency-Key headers
+	idempotencyKeyLength = 255
+
+	// idempotencyLock is how long a key is reserved while its request is processed,
+	// retries of requests abandoned by a stopped instance are processed again after it
+	idempotencyLock = 15 * time.Minute
+)
+
+// IdempotencyServiceContract is the abstraction for service layer on requests sent with an Idempotency-Key
+type IdempotencyServiceContract interface {
+	Begin(key string, requestHash string) (*mresponse.IdempotentRequest, *mresponse.ErrorResponse)
+	Complete(key string, status int, header map[string]string, body []byte) *mresponse.ErrorResponse
+	Release(key string) *mresponse.ErrorResponse
+}
+
+// IdempotencyService keeps the responses of requests sent with an Idempotency-Key, so retries of a request
+// get the response of the first one instead of processing it again
+type IdempotencyService struct {
+	idempotencyRepository repositories.IdempotencyRepositoryContract
+	ttl                   time.Duration
+}
+
+// NewIdempotencyService is the constructor of IdempotencyService
+func NewIdempotencyService(cf *config.Config, ir repositories.IdempotencyRepositoryContract) IdempotencyServiceContract {
+	return &IdempotencyService{
+		idempotencyRepository: ir,
+		ttl:                   cf.IdempotencyTTL,
+	}
+}
+
+// Begin reserves the key for the request identified by requestHash, returning nil if the request must be processed.
+// If the key was already used for the same request its response is returned, to be replayed.
+// Keys used with a different request, or whose request is still processed, are rejected.
+func (this *IdempotencyService) Begin(key string, requestHash string) (*mresponse.IdempotentRequest, *mresponse.ErrorResponse) {
+	if len(key) > idempotencyKeyLength {
+		details := []mresponse.ErrorDetail{
+			mresponse.ErrorDetail{
+				Property: "Idempotency-Key",
+				Message:  "Must have at most 255 characters",
+			},
+		}
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	now := time.Now().UTC()
+
+	existing, err := this.idempotencyRepository.Reserve(&mrequest.IdempotencyKeyCreate{
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(idempotencyLock),
+	})
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	if existing == nil {
+		return nil, nil
+	}
+
+	if existing.RequestHash != requestHash {
+		return nil, errors.HandleErrorResponse(errors.IDEMPOTENCY_MISMATCH, nil, "")
+	}
+
+	if existing.Status == 0 {
+		return nil, errors.HandleErrorResponse(errors.CONFLICT, nil, "A request with this Idempotency-Key is still being processed, retry later")
+	}
+
+	return existing, nil
+}
+
+// Complete saves the response of the request reserving the key, replayed for the configured TTL.
+// Server errors are not saved, so retries are processed again.
+func (this *IdempotencyService) Complete(key string, status int, header map[string]string, body []byte) *mresponse.ErrorResponse {
+	if status >= 500 {
+		return this.Release(key)
+	}
+
+	err := this.idempotencyRepository.Complete(&mresponse.IdempotentRequest{
+		Key:       key,
+		Status:    status,
+		Header:    header,
+		Body:      body,
+		ExpiresAt: time.Now().UTC().Add(this.ttl),
+	})
+	if err != nil {
+		return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	return nil
+}
+
+// Release frees the key of a request that wasn't processed, so it can be retried with the same key
+func (this *IdempotencyService) Release(key string) *mresponse.ErrorResponse {
+	err := this.idempotencyRepository.Release(key)
+	if err != nil {
+		return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"products/config"
+	"products/models/request"
+	"products/models/response"
+	"testing"
+	"time"
+)
+
+// Mock IdempotencyRepository behaviour, keeping the keys in memory
+type IdempotencyRepositoryMock struct {
+	keys map[string]*mresponse.IdempotentRequest
+}
+
+func (irm *IdempotencyRepositoryMock) Reserve(request *mrequest.IdempotencyKeyCreate) (*mresponse.IdempotentRequest, error) {
+	existing, ok := irm.keys[request.Key]
+	if ok && existing.ExpiresAt.After(time.Now()) {
+		copy := *existing
+		return &copy, nil
+	}
+
+	irm.keys[request.Key] = &mresponse.IdempotentRequest{
+		Key:         request.Key,
+		RequestHash: request.RequestHash,
+		CreatedAt:   request.CreatedAt,
+		ExpiresAt:   request.ExpiresAt,
+	}
+
+	return nil, nil
+}
+
+func (irm *IdempotencyRepositoryMock) Complete(response *mresponse.IdempotentRequest) error {
+	existing := irm.keys[response.Key]
+	existing.Status, existing.Header, existing.Body, existing.ExpiresAt = response.Status, response.Header, response.Body, response.ExpiresAt
+
+	return nil
+}
+
+func (irm *IdempotencyRepositoryMock) Release(key string) error {
+	delete(irm.keys, key)
+
+	return nil
+}
+
+func TestIdempotencyService(t *testing.T) {
+	repository := &IdempotencyRepositoryMock{keys: map[string]*mresponse.IdempotentRequest{}}
+	is := NewIdempotencyService(&config.Config{IdempotencyTTL: time.Hour}, repository)
+
+	// TEST FIRST REQUEST IS PROCESSED
+
+	stored, e := is.Begin("key-1", "hash-1")
+	if stored != nil || e != nil {
+		t.Fatalf("Expected the key to be reserved but got %v %v", stored, e)
+	}
+
+	// TEST CONCURRENT RETRY
+
+	_, e = is.Begin("key-1", "hash-1")
+	if e == nil || e.HttpCode != 409 {
+		t.Fatalf("Expected a conflict while the request is processed but got %v", e)
+	}
+
+	// TEST REPLAY
+
+	e = is.Complete("key-1", 200, map[string]string{"Content-Type": "application/json"}, []byte(`{"id":"1"}`))
+	if e != nil {
+		t.Fatalf("Unexpected error %v", e)
+	}
+
+	stored, e = is.Begin("key-1", "hash-1")
+	if e != nil || stored.Status != 200 || string(stored.Body) != `{"id":"1"}` || stored.Header["Content-Type"] != "application/json" {
+		t.Fatalf("Expected the response to be replayed but got %v %v", stored, e)
+	}
+
+	if repository.keys["key-1"].ExpiresAt.Before(time.Now().Add(59 * time.Minute)) {
+		t.Fatalf("Expected the response to be kept for the configured TTL")
+	}
+
+	// TEST KEY REUSED WITH A DIFFERENT REQUEST
+
+	_, e = is.Begin("key-1", "hash-2")
+	if e == nil || e.HttpCode != 422 || e.Code != "IDEMPOTENCY_MISMATCH" {
+		t.Fatalf("Expected the key reuse to be rejected but got %v", e)
+	}
+
+	// TEST SERVER ERRORS ARE NOT REPLAYED
+
+	is.Begin("key-2", "hash-1")
+	is.Complete("key-2", 500, nil, []byte(`{}`))
+
+	stored, e = is.Begin("key-2", "hash-1")
+	if stored != nil || e != nil {
+		t.Fatalf("Expected the retry to be processed again but got %v %v", stored, e)
+	}
+
+	// TEST INVALID KEY
+
+	long := make([]byte, 256)
+	for i := range long {
+		long[i] = 'k'
+	}
+
+	_, e = is.Begin(string(long), "hash-1")
+	if e == nil || e.HttpCode != 400 || e.Errors[0].Property != "Idempotency-Key" {
+		t.Fatalf("Expected an invalid key error but got %v", e)
+	}
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"io"
+	"products/models/request"
+	"products/models/response"
+	"products/repositories"
+	"products/util/errors"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo"
+)
+
+// ImportJobServiceContract is the abstraction for service layer on import jobs resource
+type ImportJobServiceContract interface {
+	CreateOne(request *mrequest.ImportJobCreate, file io.Reader) (*mresponse.ImportJob, *mresponse.ErrorResponse)
+	ReadOne(id string) (*mresponse.ImportJob, *mresponse.ErrorResponse)
+	CancelOne(id string) (*mresponse.ImportJob, *mresponse.ErrorResponse)
+}
+
+// ImportJobService is the layer between http client and repository for import jobs resource
+type ImportJobService struct {
+	jobRepository repositories.ImportJobRepositoryContract
+}
+
+// NewImportJobService is the constructor of ImportJobService
+func NewImportJobService(jr repositories.ImportJobRepositoryContract) ImportJobServiceContract {
+	return &ImportJobService{
+		jobRepository: jr,
+	}
+}
+
+// CreateOne stores the file and queues the job, to be processed by an ImportWorker
+func (this *ImportJobService) CreateOne(request *mrequest.ImportJobCreate, file io.Reader) (*mresponse.ImportJob, *mresponse.ErrorResponse) {
+	res, err := this.jobRepository.CreateOne(request, file)
+
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	return this.ReadOne(res.InsertedID.(objectid.ObjectID).Hex())
+}
+
+// ReadOne returns the job with the provided id, with its progress so far
+func (this *ImportJobService) ReadOne(id string) (*mresponse.ImportJob, *mresponse.ErrorResponse) {
+	oid, err := objectid.FromHex(id)
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, "Invalid import job id")
+	}
+
+	job, err := this.jobRepository.ReadByID(oid)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.HandleErrorResponse(errors.NOT_FOUND, nil, "Import job not found")
+	}
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	job.ID = job.IDdb.Hex()
+	if job.Errors == nil {
+		job.Errors = []*mresponse.ImportRowError{}
+	}
+
+	return job, nil
+}
+
+// CancelOne cancels a queued or running job, the rows a running job already imported are kept
+func (this *ImportJobService) CancelOne(id string) (*mresponse.ImportJob, *mresponse.ErrorResponse) {
+	job, e := this.ReadOne(id)
+	if e != nil {
+		return nil, e
+	}
+
+	cancelled, err := this.jobRepository.Cancel(job.IDdb)
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	if !cancelled {
+		return nil, errors.HandleErrorResponse(errors.CONFLICT, nil, "Import job already finished")
+	}
+
+	return this.ReadOne(id)
+}
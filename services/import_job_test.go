@@ -0,0 +1,272 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"products/models/request"
+	"products/models/response"
+	"products/repositories"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"go.uber.org/dig"
+)
+
+// Mock ImportJobRepository behaviour, keeping jobs and their files in memory
+type ImportJobRepositoryMock struct {
+	jobs  map[objectid.ObjectID]*mresponse.ImportJob
+	files map[objectid.ObjectID][]byte
+}
+
+func NewImportJobRepositoryMock() repositories.ImportJobRepositoryContract {
+	return &ImportJobRepositoryMock{
+		jobs:  map[objectid.ObjectID]*mresponse.ImportJob{},
+		files: map[objectid.ObjectID][]byte{},
+	}
+}
+
+func (jrm *ImportJobRepositoryMock) CreateOne(job *mrequest.ImportJobCreate, file io.Reader) (*mongo.InsertOneResult, error) {
+	if job.FileName == "error-on-create.csv" {
+		return nil, errors.New("error ocurred on repository")
+	}
+
+	data, _ := ioutil.ReadAll(file)
+	job.ID = objectid.New()
+	job.Size = int64(len(data))
+
+	jrm.files[job.ID] = data
+	jrm.jobs[job.ID] = &mresponse.ImportJob{IDdb: job.ID, Status: job.Status, FileName: job.FileName, Size: job.Size, DryRun: job.DryRun, CreatedAt: job.CreatedAt, ContentType: job.ContentType, Options: job.Options}
+
+	return &mongo.InsertOneResult{InsertedID: job.ID}, nil
+}
+
+func (jrm *ImportJobRepositoryMock) ReadByID(id objectid.ObjectID) (*mresponse.ImportJob, error) {
+	job, ok := jrm.jobs[id]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	copy := *job
+	return &copy, nil
+}
+
+func (jrm *ImportJobRepositoryMock) Claim(staleBefore time.Time) (*mresponse.ImportJob, error) {
+	for _, job := range jrm.jobs {
+		if job.Status == mresponse.JobQueued {
+			job.Status = mresponse.JobRunning
+			job.ClaimToken = objectid.New().Hex()
+			copy := *job
+			return &copy, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (jrm *ImportJobRepositoryMock) UpdateProgress(job *mresponse.ImportJob, rowErrors []*mresponse.ImportRowError) (bool, error) {
+	stored := jrm.jobs[job.IDdb]
+	if stored.Status != mresponse.JobRunning || stored.ClaimToken != job.ClaimToken {
+		return false, nil
+	}
+
+	errs := append(stored.Errors, rowErrors...)
+	*stored = *job
+	stored.Errors = errs
+
+	return true, nil
+}
+
+func (jrm *ImportJobRepositoryMock) Finish(job *mresponse.ImportJob, status string, message string) (bool, error) {
+	stored := jrm.jobs[job.IDdb]
+	if stored.Status != mresponse.JobRunning || stored.ClaimToken != job.ClaimToken {
+		return false, nil
+	}
+
+	stored.Status, stored.Message = status, message
+	delete(jrm.files, job.IDdb)
+
+	return true, nil
+}
+
+func (jrm *ImportJobRepositoryMock) Cancel(id objectid.ObjectID) (bool, error) {
+	stored := jrm.jobs[id]
+	if stored.Status != mresponse.JobQueued && stored.Status != mresponse.JobRunning {
+		return false, nil
+	}
+
+	stored.Status = mresponse.JobCancelled
+	delete(jrm.files, id)
+
+	return true, nil
+}
+
+func (jrm *ImportJobRepositoryMock) OpenFile(id objectid.ObjectID) (io.ReadCloser, error) {
+	data, ok := jrm.files[id]
+	if !ok {
+		return nil, errors.New("file not found")
+	}
+
+	return ioutil.NopCloser(strings.NewReader(string(data))), nil
+}
+
+func (jrm *ImportJobRepositoryMock) DeleteFile(id objectid.ObjectID) error {
+	delete(jrm.files, id)
+
+	return nil
+}
+
+// dependency injection provided, with the dependencies of the product service
+func buildTestImportJobContainer() *dig.Container {
+
+	container := buildTestProductContainer()
+
+	// import job repository
+	err := container.Provide(NewImportJobRepositoryMock)
+	if err != nil {
+		panic(err)
+	}
+
+	// import job service
+	err = container.Provide(NewImportJobService)
+	if err != nil {
+		panic(err)
+	}
+
+	// import worker
+	err = container.Provide(NewImportWorker)
+	if err != nil {
+		panic(err)
+	}
+
+	return container
+}
+
+func newTestImportJob(js ImportJobServiceContract, params url.Values, file string) *mresponse.ImportJob {
+	req, e := mrequest.NewImportJobCreate(params, "text/csv", "products.csv")
+	if e != nil {
+		panic(e.Response)
+	}
+
+	job, e := js.CreateOne(req, strings.NewReader(file))
+	if e != nil {
+		panic(e.Response)
+	}
+
+	return job
+}
+
+func TestCreateAndCancelImportJob(t *testing.T) {
+	container := buildTestImportJobContainer()
+
+	err := container.Invoke(func(js ImportJobServiceContract) {
+		job := newTestImportJob(js, url.Values{}, "ProductCode\r\nA1\r\n")
+		if job.ID == "" || job.Status != mresponse.JobQueued || job.Size != 17 || job.FileName != "products.csv" {
+			t.Fatalf("Unexpected job %v", job)
+		}
+
+		_, e := js.ReadOne("not-an-id")
+		if e == nil || e.HttpCode != 400 {
+			t.Fatal("Expected an invalid id error")
+		}
+
+		_, e = js.ReadOne(objectid.New().Hex())
+		if e == nil || e.HttpCode != 404 {
+			t.Fatal("Expected a not found error")
+		}
+
+		job, e = js.CancelOne(job.ID)
+		if e != nil || job.Status != mresponse.JobCancelled {
+			t.Fatalf("Expected the job to be cancelled but got %v %v", job, e)
+		}
+
+		// finished jobs can't be cancelled
+		_, e = js.CancelOne(job.ID)
+		if e == nil || e.HttpCode != 409 {
+			t.Fatal("Expected a conflict error")
+		}
+
+		req, _ := mrequest.NewImportJobCreate(url.Values{}, "text/csv", "error-on-create.csv")
+		_, e = js.CreateOne(req, strings.NewReader(""))
+		if e == nil || e.HttpCode != 500 {
+			t.Fatal("Expected a repository error")
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestImportWorkerProcess(t *testing.T) {
+	container := buildTestImportJobContainer()
+
+	file := "ProductCode,ProductType,ProductDescription,ProductNumberCode,ProductGroup\r\n" +
+		"A1,P,Screw,R1,product-group\r\n" +
+		"A2,X,Nut,R2,product-group\r\n" +
+		"A3,P,Washer,R3,unknown-group\r\n" +
+		"A4,P,Bolt,R4,some-product-group\r\n" +
+		"duplicated-code,P,Rivet,R5,product-group\r\n"
+
+	err := container.Invoke(func(js ImportJobServiceContract, jr repositories.ImportJobRepositoryContract, iw *ImportWorker) {
+		job := newTestImportJob(js, url.Values{}, file)
+
+		claimed, _ := jr.Claim(time.Now())
+		iw.Process(claimed)
+
+		// the row with a duplicated code fails to be inserted and the rest of the batch is inserted without it
+		job, _ = js.ReadOne(job.ID)
+		if job.Status != mresponse.JobCompleted || job.Rows != 5 || job.Valid != 2 || job.Imported != 2 || job.Failed != 3 || job.ErrorCount != 3 {
+			t.Fatalf("Unexpected job result %v", job)
+		}
+
+		if job.ProcessedBytes != job.Size {
+			t.Fatalf("Expected %d bytes processed but got %d", job.Size, job.ProcessedBytes)
+		}
+
+		if job.Errors[0].Row != 3 || job.Errors[0].Property != "ProductType" || job.Errors[1].Row != 4 || job.Errors[1].Property != "ProductGroup" {
+			t.Fatalf("Unexpected row errors %v %v", job.Errors[0], job.Errors[1])
+		}
+
+		if job.Errors[2].Row != 6 || job.Errors[2].Message != "E11000 duplicate key error" {
+			t.Fatalf("Unexpected insert error %v", job.Errors[2])
+		}
+
+		// resumed jobs skip the rows already processed
+		job = newTestImportJob(js, url.Values{"dry_run": {"true"}}, file)
+		claimed, _ = jr.Claim(time.Now())
+		claimed.ProcessedRows, claimed.Rows, claimed.Valid = 3, 2, 1
+		iw.Process(claimed)
+
+		job, _ = js.ReadOne(job.ID)
+		if job.Status != mresponse.JobCompleted || job.Rows != 5 || job.Valid != 3 || job.Imported != 0 || job.Failed != 1 {
+			t.Fatalf("Unexpected resumed job result %v", job)
+		}
+
+		// workers whose job was claimed again by another worker stop without writing it
+		job = newTestImportJob(js, url.Values{}, file)
+		claimed, _ = jr.Claim(time.Now())
+		jr.(*ImportJobRepositoryMock).jobs[claimed.IDdb].ClaimToken = objectid.New().Hex()
+		iw.Process(claimed)
+
+		job, _ = js.ReadOne(job.ID)
+		if job.Status != mresponse.JobRunning || job.Rows != 0 || job.Imported != 0 {
+			t.Fatalf("Expected the stale worker not to write the job but got %v", job)
+		}
+
+		if _, ok := jr.(*ImportJobRepositoryMock).files[claimed.IDdb]; !ok {
+			t.Fatal("Expected the stale worker not to delete the file of the job")
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
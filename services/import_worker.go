@@ -0,0 +1,241 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"log"
+	"products/config"
+	"products/models/request"
+	"products/models/response"
+	"products/repositories"
+
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"sync"
+	"time"
+
+	apperrors "products/util/errors"
+)
+
+const (
+	// importPollInterval is how long idle workers wait before looking for queued jobs again
+	importPollInterval = 2 * time.Second
+
+	// importJobLease is how long a running job can go without progress before other worker takes it over
+	importJobLease = 5 * time.Minute
+)
+
+// errJobCancelled stops the processing of a job cancelled meanwhile, or claimed again by another worker as its lease expired
+var errJobCancelled = errors.New("import job cancelled")
+
+// ImportWorker processes queued import jobs with a pool of workers, inserting the valid rows of each batch like ProductService.ImportCSV.
+// Progress is saved after each batch, so jobs of a stopped instance are resumed by other workers after the row they reached.
+type ImportWorker struct {
+	config            *config.Config
+	jobRepository     repositories.ImportJobRepositoryContract
+	productRepository repositories.ProductRepositoryContract
+	groupServ         ProductGroupServiceContract
+}
+
+func NewImportWorker(config *config.Config, jr repositories.ImportJobRepositoryContract, pr repositories.ProductRepositoryContract, gs ProductGroupServiceContract) *ImportWorker {
+	return &ImportWorker{
+		config:            config,
+		jobRepository:     jr,
+		productRepository: pr,
+		groupServ:         gs,
+	}
+}
+
+// Run starts the pool of workers and blocks while they work
+func (iw *ImportWorker) Run() {
+
+	log.Printf("Start processing import jobs with %d workers\n", iw.config.ImportWorkers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < iw.config.ImportWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			iw.work()
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (iw *ImportWorker) work() {
+	for {
+		job, err := iw.jobRepository.Claim(time.Now().UTC().Add(-importJobLease))
+		if err != nil {
+			log.Printf("Error claiming import job: %s\n", err.Error())
+		}
+
+		if job == nil {
+			time.Sleep(importPollInterval)
+			continue
+		}
+
+		iw.Process(job)
+	}
+}
+
+// Process imports the rows of a claimed job not processed yet and sets its final status
+func (iw *ImportWorker) Process(job *mresponse.ImportJob) {
+	status, message := mresponse.JobCompleted, ""
+
+	err := iw.importRows(job)
+	if err == errJobCancelled {
+		log.Printf("Import job %s cancelled or claimed by another worker after %d rows\n", job.IDdb.Hex(), job.Rows)
+		return
+	}
+	if err != nil {
+		status, message = mresponse.JobFailed, err.Error()
+	}
+
+	finished, err := iw.jobRepository.Finish(job, status, message)
+	if err != nil {
+		log.Printf("Error finishing import job %s: %s\n", job.IDdb.Hex(), err.Error())
+	} else if !finished {
+		log.Printf("Import job %s cancelled or claimed by another worker before finishing\n", job.IDdb.Hex())
+	}
+}
+
+// importRows reads the file of the job, skipping the rows processed before the job was resumed, saving progress every batch of rows
+func (iw *ImportWorker) importRows(job *mresponse.ImportJob) error {
+	request, e := mrequest.ParseImportOptions(job.Options, job.ContentType)
+	if e != nil {
+		return errors.New("Invalid import options: " + e.Response)
+	}
+
+	file, err := iw.jobRepository.OpenFile(job.IDdb)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	counter := &countingReader{r: file}
+	reader, e := newImportReader(counter, request)
+	if e != nil {
+		return errors.New(e.Response)
+	}
+
+	batch := []*mrequest.ProductCreate{}
+	batchRows := []int64{}
+	rowErrs := []*mresponse.ImportRowError{}
+	read := 0
+
+	for {
+		p, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !isParseError(err) {
+			return err
+		}
+
+		// rows processed before the job was resumed
+		if reader.row <= job.ProcessedRows {
+			continue
+		}
+
+		job.Rows++
+		read++
+
+		if err != nil {
+			job.Failed++
+			rowErrs = append(rowErrs, &mresponse.ImportRowError{Row: reader.row, Message: err.Error()})
+		} else if e := iw.validateRow(p); e != nil {
+			if len(e.Errors) == 0 {
+				return errors.New(e.Response)
+			}
+			job.Failed++
+			rowErrs = append(rowErrs, rowErrors(reader.row, e.Errors)...)
+		} else {
+			job.Valid++
+			batch = append(batch, p)
+			batchRows = append(batchRows, reader.row)
+		}
+
+		if read == importBatchSize {
+			job.ProcessedRows, job.ProcessedBytes = reader.row, counter.n
+			err = iw.saveBatch(job, batch, batchRows, rowErrs)
+			if err != nil {
+				return err
+			}
+			batch, batchRows, rowErrs, read = batch[:0], batchRows[:0], rowErrs[:0], 0
+		}
+	}
+
+	job.ProcessedRows, job.ProcessedBytes = reader.row, counter.n
+
+	return iw.saveBatch(job, batch, batchRows, rowErrs)
+}
+
+// validateRow validates a row like CreateOne does, setting the default status
+func (iw *ImportWorker) validateRow(p *mrequest.ProductCreate) *mresponse.ErrorResponse {
+	e := apperrors.ValidateRequest(p)
+	if e != nil {
+		return e
+	}
+
+	e = iw.groupServ.ValidateReference(p.ProductGroup)
+	if e != nil {
+		return e
+	}
+
+	if p.Status == "" {
+		p.Status = mresponse.StatusActive
+	}
+
+	return nil
+}
+
+// saveBatch inserts the valid products of the batch, unless it's a dry run, and saves the job progress.
+// Products that fail to be inserted (e.g. a duplicated ProductCode) are reported on the row they were read from.
+func (iw *ImportWorker) saveBatch(job *mresponse.ImportJob, batch []*mrequest.ProductCreate, rows []int64, rowErrs []*mresponse.ImportRowError) error {
+	if len(batch) > 0 && !job.DryRun {
+		inserted := int64(len(batch))
+
+		_, err := iw.productRepository.InsertMany(&batch)
+		if err != nil {
+			bulkErr, ok := err.(mongo.BulkWriteError)
+			if !ok {
+				return err
+			}
+
+			for _, writeErr := range bulkErr.WriteErrors {
+				rowErrs = append(rowErrs, &mresponse.ImportRowError{Row: rows[writeErr.Index], Message: writeErr.Message})
+			}
+			inserted -= int64(len(bulkErr.WriteErrors))
+		}
+
+		job.Imported += inserted
+		job.Valid -= int64(len(batch)) - inserted
+		job.Failed += int64(len(batch)) - inserted
+	}
+
+	job.ErrorCount += int64(len(rowErrs))
+
+	running, err := iw.jobRepository.UpdateProgress(job, rowErrs)
+	if err != nil {
+		return err
+	}
+
+	if !running {
+		return errJobCancelled
+	}
+
+	return nil
+}
+
+// countingReader counts the bytes read, to report the progress of jobs
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (this *countingReader) Read(p []byte) (int, error) {
+	n, err := this.r.Read(p)
+	this.n += int64(n)
+
+	return n, err
+}
@@ -2,9 +2,14 @@ package services
 
 import (
 	"log"
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"products/config"
 	"products/models/request"
+	"products/models/response"
+	"products/util/errors"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 )
@@ -12,12 +17,67 @@ import (
 type KafkaConsumer struct {
 	config      *config.Config
 	productServ ProductServiceContract
+	deadLetter  DeadLetterContract
+	serde       MessageSerdeContract
+	retries     map[partitionKey]*partitionRetry
 }
 
-func NewKafkaConsumer(config *config.Config, ps ProductServiceContract) *KafkaConsumer {
+// productsMessage is a message of the products topic with its payload decoded according to its type
+type productsMessage struct {
+	*mrequest.MessageEnvelope
+	products *[]*mrequest.ProductCreate
+	deletes  []*mrequest.MessageProductDelete
+}
+
+// partitionConsumer is the part of the Kafka consumer controlling what is fetched and committed of each partition
+type partitionConsumer interface {
+	Pause(partitions []kafka.TopicPartition) error
+	Resume(partitions []kafka.TopicPartition) error
+	Seek(partition kafka.TopicPartition, timeoutMs int) error
+	StoreOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+	Assign(partitions []kafka.TopicPartition) error
+	Unassign() error
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// partitionRetry is a partition paused until the message at offset is retried
+type partitionRetry struct {
+	offset   kafka.Offset
+	attempts int
+	resumeAt time.Time
+	paused   bool
+}
+
+// processingError is an error of the product service processing a message, transient if the service is unavailable
+type processingError struct {
+	*mresponse.ErrorResponse
+}
+
+// Error is the service error response followed by its details, e.g. the products of the message that failed
+func (e *processingError) Error() string {
+	message := e.Response
+	for _, d := range e.Errors {
+		message += "; " + d.Property + " " + d.Message
+	}
+
+	return message
+}
+
+func (e *processingError) Temporary() bool {
+	return e.HttpCode >= 500
+}
+
+func NewKafkaConsumer(config *config.Config, ps ProductServiceContract, dl DeadLetterContract, serde MessageSerdeContract) *KafkaConsumer {
 	return &KafkaConsumer{
 		config:      config,
 		productServ: ps,
+		deadLetter:  dl,
+		serde:       serde,
+		retries:     map[partitionKey]*partitionRetry{},
 	}
 }
 
@@ -26,11 +86,13 @@ func (kc *KafkaConsumer) Run() {
 	log.Println("Start receiving from Kafka")
 
 	configConsumer := kafka.ConfigMap{
-		"bootstrap.servers":       kc.config.BootstrapServers,
-		"group.id":                kc.config.GroupID,
-		"auto.offset.reset":       kc.config.AutoOffsetReset,
-		"auto.commit.enable":      kc.config.AutoCommitEnable,
-		"auto.commit.interval.ms": kc.config.AutoCommitInterval,
+		"bootstrap.servers":        kc.config.BootstrapServers,
+		"group.id":                 kc.config.GroupID,
+		"auto.offset.reset":        kc.config.AutoOffsetReset,
+		"auto.commit.enable":       kc.config.AutoCommitEnable,
+		"auto.commit.interval.ms":  kc.config.AutoCommitInterval,
+		// offsets are stored once messages are processed or dead lettered, messages being retried aren't committed
+		"enable.auto.offset.store": false,
 	}
 
 	c, err := kafka.NewConsumer(&configConsumer)
@@ -40,51 +102,301 @@ func (kc *KafkaConsumer) Run() {
 	}
 
 	topicsSubs := kc.config.TopicsSubscribed
-	err = c.SubscribeTopics(topicsSubs, nil)
+	err = c.SubscribeTopics(topicsSubs, func(c *kafka.Consumer, ev kafka.Event) error {
+		return kc.rebalance(c, ev)
+	})
 
 	if err != nil {
 		panic(err)
 	}
 
 	for {
-		msg, err := c.ReadMessage(-1)
+		kc.resumeDue(c, time.Now())
 
-		if err == nil {
+		switch ev := c.Poll(100).(type) {
+		case *kafka.Message:
+			kc.consume(c, ev)
+		case kafka.Error:
+			log.Printf("Consumer error: %v\n", ev)
+		default: //ignore any other events
+		}
+	}
 
-			topic := *msg.TopicPartition.Topic
+	c.Close()
+}
 
-			switch topic {
-			case "products":
-				log.Println("Reading a products message")
-				products, err := kc.parseProductsMessage(msg.Value)
-				if err != nil {
-					log.Printf("Error parsing event message value. Message %v \n Error: %s\n", msg.Value, err.Error())
-					break
-				}
+// consume handles a message and stores its offset, to be committed, once it's processed or dead lettered.
+// Messages failing with transient errors are retried with exponential backoff: their partition is paused and rewound
+// to them meanwhile, so the messages after them wait and keep their order. Messages failing with permanent errors,
+// or for the configured max attempts, are set aside in the dead letter topic.
+func (kc *KafkaConsumer) consume(c partitionConsumer, msg *kafka.Message) {
+	tp := msg.TopicPartition
+	key := partitionKey{topic: *tp.Topic, partition: tp.Partition}
 
-				// save products to database
-				_, e := kc.productServ.CreateMany(products)
-				if e != nil {
-					log.Printf("Error saving products to database\n Error: %s\n", e.Response)
-					break
-				}
-			default: //ignore any other topics
-			}
-		} else {
-			log.Printf("Consumer error: %v (%v)\n", err, msg)
+	retry := kc.retries[key]
+	if retry != nil && tp.Offset != retry.offset {
+		// fetched before the partition was paused, it's consumed again after the message retried
+		return
+	}
+
+	attempts := 1
+	if retry != nil {
+		attempts = retry.attempts + 1
+	}
+
+	err := kc.handle(msg)
+	if err != nil && isTransient(err) && (kc.config.ConsumerMaxAttempts == 0 || attempts < kc.config.ConsumerMaxAttempts) {
+		kc.retry(c, tp, attempts, err)
+		return
+	}
+
+	if err != nil {
+		if isTransient(err) {
+			err = fmt.Errorf("giving up after %d attempts: %s", attempts, err.Error())
+		}
+
+		log.Printf("Setting aside message of %s [%d] at offset %v in the dead letter topic\n Error: %s\n", key.topic, key.partition, tp.Offset, err.Error())
+
+		err = kc.deadLetter.DeadLetter(msg, err)
+		if err != nil {
+			log.Printf("Error producing message to the dead letter topic\n Error: %s\n", err.Error())
+			kc.retry(c, tp, attempts, err)
+			return
 		}
 	}
 
-	c.Close()
+	delete(kc.retries, key)
+
+	_, err = c.StoreOffsets([]kafka.TopicPartition{{Topic: tp.Topic, Partition: tp.Partition, Offset: tp.Offset + 1}})
+	if err != nil {
+		log.Printf("Error storing offset of %s [%d]: %s\n", key.topic, key.partition, err.Error())
+	}
 }
 
-func (kc *KafkaConsumer) parseProductsMessage(messageValue []byte) (*[]*mrequest.ProductCreate, error) {
-	products := make([]*mrequest.ProductCreate, 0)
-	err := json.Unmarshal(messageValue, &products)
+// handle processes a message according to its topic
+func (kc *KafkaConsumer) handle(msg *kafka.Message) error {
+	switch *msg.TopicPartition.Topic {
+	case "products":
+		log.Println("Reading a products message")
+		return kc.handleProductsMessage(msg)
+	default: //ignore any other topics
+		return nil
+	}
+}
+
+// retry pauses the partition of the message and rewinds it to the message, consumed again once the backoff elapses
+func (kc *KafkaConsumer) retry(c partitionConsumer, tp kafka.TopicPartition, attempts int, reason error) {
+	backoff := kc.backoff(attempts)
+	log.Printf("Error consuming message of %s [%d] at offset %v, attempt %d, retrying in %s\n Error: %s\n",
+		*tp.Topic, tp.Partition, tp.Offset, attempts, backoff, reason.Error())
+
+	kc.retries[partitionKey{topic: *tp.Topic, partition: tp.Partition}] = &partitionRetry{
+		offset:   tp.Offset,
+		attempts: attempts,
+		resumeAt: time.Now().Add(backoff),
+		paused:   true,
+	}
+
+	err := c.Pause([]kafka.TopicPartition{{Topic: tp.Topic, Partition: tp.Partition}})
+	if err != nil {
+		log.Printf("Error pausing %s [%d]: %s\n", *tp.Topic, tp.Partition, err.Error())
+	}
 
+	err = c.Seek(tp, 0)
 	if err != nil {
-		return nil, err
+		// the messages after the retried one would be skipped while waiting for it, the partition goes on without retrying it
+		log.Printf("Error rewinding %s [%d] to offset %v, the message is not retried: %s\n", *tp.Topic, tp.Partition, tp.Offset, err.Error())
+		delete(kc.retries, partitionKey{topic: *tp.Topic, partition: tp.Partition})
+
+		err = c.Resume([]kafka.TopicPartition{{Topic: tp.Topic, Partition: tp.Partition}})
+		if err != nil {
+			log.Printf("Error resuming %s [%d]: %s\n", *tp.Topic, tp.Partition, err.Error())
+		}
+	}
+}
+
+// rebalance assigns and revokes the partitions of the consumer group. Partitions start over from their committed offset
+// when assigned, so the retries of the partitions assigned or revoked no longer apply.
+// It's called by Poll, from the same goroutine as consume.
+func (kc *KafkaConsumer) rebalance(c partitionConsumer, ev kafka.Event) error {
+	switch e := ev.(type) {
+	case kafka.AssignedPartitions:
+		kc.forgetRetries(e.Partitions)
+		return c.Assign(e.Partitions)
+	case kafka.RevokedPartitions:
+		kc.forgetRetries(e.Partitions)
+		return c.Unassign()
+	default:
+		return nil
+	}
+}
+
+// forgetRetries drops the retries of the partitions
+func (kc *KafkaConsumer) forgetRetries(partitions []kafka.TopicPartition) {
+	for _, tp := range partitions {
+		delete(kc.retries, partitionKey{topic: *tp.Topic, partition: tp.Partition})
+	}
+}
+
+// resumeDue resumes the paused partitions whose backoff elapsed
+func (kc *KafkaConsumer) resumeDue(c partitionConsumer, now time.Time) {
+	for key, retry := range kc.retries {
+		if !retry.paused || now.Before(retry.resumeAt) {
+			continue
+		}
+
+		topic := key.topic
+		err := c.Resume([]kafka.TopicPartition{{Topic: &topic, Partition: key.partition}})
+		if err != nil {
+			// the partition was revoked meanwhile, its message is consumed again from the committed offset
+			log.Printf("Error resuming %s [%d]: %s\n", key.topic, key.partition, err.Error())
+			delete(kc.retries, key)
+			continue
+		}
+
+		retry.paused = false
+	}
+}
+
+// backoff returns the wait after the failed attempt number attempts
+func (kc *KafkaConsumer) backoff(attempts int) time.Duration {
+	if attempts > 30 {
+		return kc.config.ConsumerMaxBackoff
+	}
+
+	backoff := kc.config.ConsumerBackoff << uint(attempts-1)
+	if backoff > kc.config.ConsumerMaxBackoff {
+		return kc.config.ConsumerMaxBackoff
+	}
+
+	return backoff
+}
+
+// isTransient tells whether the error may not happen again, e.g. the database or the schema registry being unavailable
+func isTransient(err error) bool {
+	temporary, ok := err.(interface {
+		Temporary() bool
+	})
+
+	return ok && temporary.Temporary()
+}
+
+// handleProductsMessage processes a message of the products topic, in JSON, Avro or Protobuf.
+// Messages breaking the contract of the topic are rejected with permanent errors, retrying them won't help.
+func (kc *KafkaConsumer) handleProductsMessage(msg *kafka.Message) error {
+	value, err := kc.serde.Decode(msg.Value)
+	if err != nil {
+		log.Printf("Error decoding event message value. Message %v \n Error: %s\n", msg.Value, err.Error())
+		return err
+	}
+
+	message, err := kc.parseProductsMessage(value)
+	if err != nil {
+		log.Printf("Error parsing event message value. Message %v \n Error: %s\n", msg.Value, err.Error())
+		return err
+	}
+
+	e := kc.processProductsMessage(message)
+	if e != nil {
+		log.Printf("Error processing %s message (source: %s, correlation id: %s, tenant: %s)\n Error: %s\n",
+			message.Type, message.Source, message.CorrelationID, message.Tenant, e.Response)
+		return &processingError{e}
+	}
+
+	return nil
+}
+
+// parseProductsMessage reads a versioned envelope or, from producers not migrated to it yet,
+// a bare JSON array of products to create. Envelopes of unknown versions or types are rejected.
+func (kc *KafkaConsumer) parseProductsMessage(messageValue []byte) (*productsMessage, error) {
+	envelope := mrequest.MessageEnvelope{}
+
+	value := bytes.TrimSpace(messageValue)
+	if len(value) > 0 && value[0] == '[' {
+		envelope = mrequest.MessageEnvelope{
+			Type:    mrequest.MessageProductsCreate,
+			Version: mrequest.MessageVersion,
+			Source:  "legacy",
+			Payload: value,
+		}
+	} else {
+		err := json.Unmarshal(value, &envelope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if envelope.Version != mrequest.MessageVersion {
+		return nil, fmt.Errorf("unsupported message version %d, expected %d", envelope.Version, mrequest.MessageVersion)
+	}
+
+	message := productsMessage{MessageEnvelope: &envelope}
+
+	switch envelope.Type {
+	case mrequest.MessageProductsCreate, mrequest.MessageProductsUpsert:
+		products := make([]*mrequest.ProductCreate, 0)
+		err := json.Unmarshal(envelope.Payload, &products)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s payload: %s", envelope.Type, err.Error())
+		}
+		message.products = &products
+
+	case mrequest.MessageProductsDelete:
+		err := json.Unmarshal(envelope.Payload, &message.deletes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s payload: %s", envelope.Type, err.Error())
+		}
+		for i, d := range message.deletes {
+			e := errors.ValidateRequest(d)
+			if e != nil {
+				return nil, fmt.Errorf("invalid %s payload: [%d].%s %s", envelope.Type, i, e.Errors[0].Property, e.Errors[0].Message)
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown message type %q", envelope.Type)
+	}
+
+	return &message, nil
+}
+
+// processProductsMessage applies the operation of the message type to the products of its payload.
+// Upserts and deletes go on after a product fails, the first error is returned, the first service unavailable error
+// if any so the message is retried.
+func (kc *KafkaConsumer) processProductsMessage(message *productsMessage) *mresponse.ErrorResponse {
+	var first *mresponse.ErrorResponse
+
+	switch message.Type {
+	case mrequest.MessageProductsCreate:
+		_, first = kc.productServ.CreateMany(message.products)
+
+	case mrequest.MessageProductsUpsert:
+		for i, p := range *message.products {
+			_, e := kc.productServ.UpsertByCode(p)
+			if e != nil {
+				log.Printf("Error upserting product [%d] %s\n Error: %s\n", i, p.ProductCode, e.Response)
+				if first == nil || (e.HttpCode >= 500 && first.HttpCode < 500) {
+					first = e
+				}
+			}
+		}
+
+	case mrequest.MessageProductsDelete:
+		for i, d := range message.deletes {
+			reason := d.Reason
+			if reason == "" {
+				reason = "Deleted by " + message.Source
+			}
+
+			_, e := kc.productServ.ArchiveByCode(d.ProductCode, reason)
+			if e != nil {
+				log.Printf("Error deleting product [%d] %s\n Error: %s\n", i, d.ProductCode, e.Response)
+				if first == nil || (e.HttpCode >= 500 && first.HttpCode < 500) {
+					first = e
+				}
+			}
+		}
 	}
 
-	return &products, nil
+	return first
 }
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"products/config"
+	"products/models/request"
+	"products/models/response"
+	"products/util/errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// stub ProductService behaviour, recording the operations of the consumed messages
+type ProductServiceStub struct {
+	ProductServiceContract
+	operations  []string
+	unavailable int // calls of CreateMany failing as if the database was down
+}
+
+func (ps *ProductServiceStub) CreateMany(request *[]*mrequest.ProductCreate) (*[]*mresponse.ProductCreate, *mresponse.ErrorResponse) {
+	if ps.unavailable > 0 {
+		ps.unavailable--
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, "")
+	}
+
+	details := []mresponse.ErrorDetail{}
+	for i, p := range *request {
+		ps.operations = append(ps.operations, "create "+p.ProductCode)
+		if p.ProductCode == "duplicated" {
+			details = append(details, mresponse.ErrorDetail{Property: "[" + strconv.Itoa(i) + "]", Message: "E11000 duplicate key error"})
+		}
+	}
+
+	if len(details) != 0 {
+		return nil, errors.HandleErrorResponse(errors.CONFLICT, details, "Some products could not be created, the others were")
+	}
+
+	return &[]*mresponse.ProductCreate{}, nil
+}
+
+func (ps *ProductServiceStub) UpsertByCode(request *mrequest.ProductCreate) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	ps.operations = append(ps.operations, "upsert "+request.ProductCode)
+
+	if request.ProductCode == "invalid" {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, "")
+	}
+
+	return &mresponse.ProductRead{ProductCode: request.ProductCode}, nil
+}
+
+func (ps *ProductServiceStub) ArchiveByCode(code string, reason string) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	ps.operations = append(ps.operations, "delete "+code+": "+reason)
+
+	return &mresponse.ProductRead{ProductCode: code}, nil
+}
+
+// Mock dead letter topic, keeping the messages set aside
+type DeadLetterMock struct {
+	reasons []string
+}
+
+func (dlm *DeadLetterMock) DeadLetter(msg *kafka.Message, reason error) error {
+	dlm.reasons = append(dlm.reasons, reason.Error())
+
+	return nil
+}
+
+// Mock consumer, keeping the paused partitions and the stored offsets
+type PartitionConsumerMock struct {
+	paused  map[int32]bool
+	seeks   []kafka.Offset
+	stored  []kafka.Offset
+	seekErr error
+}
+
+func (pcm *PartitionConsumerMock) Pause(partitions []kafka.TopicPartition) error {
+	for _, p := range partitions {
+		pcm.paused[p.Partition] = true
+	}
+	return nil
+}
+
+func (pcm *PartitionConsumerMock) Resume(partitions []kafka.TopicPartition) error {
+	for _, p := range partitions {
+		pcm.paused[p.Partition] = false
+	}
+	return nil
+}
+
+func (pcm *PartitionConsumerMock) Seek(partition kafka.TopicPartition, timeoutMs int) error {
+	pcm.seeks = append(pcm.seeks, partition.Offset)
+	return pcm.seekErr
+}
+
+func (pcm *PartitionConsumerMock) StoreOffsets(offsets []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	for _, o := range offsets {
+		pcm.stored = append(pcm.stored, o.Offset)
+	}
+	return offsets, nil
+}
+
+func (pcm *PartitionConsumerMock) Assign(partitions []kafka.TopicPartition) error {
+	for _, p := range partitions {
+		pcm.paused[p.Partition] = false
+	}
+	return nil
+}
+
+func (pcm *PartitionConsumerMock) Unassign() error {
+	pcm.paused = map[int32]bool{}
+	return nil
+}
+
+func buildTestKafkaConsumer(ps ProductServiceContract, dl DeadLetterContract) *KafkaConsumer {
+	cf := &config.Config{KafkaConsumerConfig: &config.KafkaConsumerConfig{
+		MessageFormat:       config.MessageFormatJSON,
+		ConsumerMaxAttempts: 3,
+		ConsumerBackoff:     time.Second,
+		ConsumerMaxBackoff:  2 * time.Second,
+	}}
+
+	return NewKafkaConsumer(cf, ps, dl, NewMessageSerde(cf, nil))
+}
+
+func TestKafkaConsumerProductsMessages(t *testing.T) {
+	ps := &ProductServiceStub{}
+	dl := &DeadLetterMock{}
+	kc := buildTestKafkaConsumer(ps, dl)
+	pc := &PartitionConsumerMock{paused: map[int32]bool{}}
+
+	topic := "products"
+	offset := kafka.Offset(0)
+	consume := func(value string) {
+		kc.consume(pc, &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Offset: offset}, Value: []byte(value)})
+		offset++
+	}
+
+	// TEST LEGACY BARE ARRAYS
+
+	consume(` [{"ProductCode":"A"},{"ProductCode":"B"}]`)
+
+	// TEST ENVELOPES DISPATCHED BY TYPE
+
+	consume(`{"type":"products.create","version":1,"source":"erp","correlation_id":"c-1","payload":[{"ProductCode":"C"}]}`)
+	consume(`{"type":"products.upsert","version":1,"source":"erp","payload":[{"ProductCode":"invalid"},{"ProductCode":"D"}]}`)
+	consume(`{"type":"products.delete","version":1,"source":"erp","payload":[{"ProductCode":"E"},{"ProductCode":"F","reason":"Replaced by G"}]}`)
+
+	expected := []string{"create A", "create B", "create C", "upsert invalid", "upsert D", "delete E: Deleted by erp", "delete F: Replaced by G"}
+	if strings.Join(ps.operations, "|") != strings.Join(expected, "|") {
+		t.Fatalf("Expected operations %v but got %v", expected, ps.operations)
+	}
+
+	if len(dl.reasons) != 1 || dl.reasons[0] != "Invalid request provided" {
+		t.Fatalf("Expected the upsert of an invalid product to be dead lettered but got %v", dl.reasons)
+	}
+
+	// TEST PRODUCTS FAILING TO BE CREATED ARE DEAD LETTERED WITH THEIR ERRORS
+
+	consume(`{"type":"products.create","version":1,"payload":[{"ProductCode":"I"},{"ProductCode":"duplicated"}]}`)
+
+	expected = append(expected, "create I", "create duplicated")
+	if len(dl.reasons) != 2 || dl.reasons[1] != "Some products could not be created, the others were; [1] E11000 duplicate key error" {
+		t.Fatalf("Expected the message with a duplicated product to be dead lettered but got %v", dl.reasons)
+	}
+
+	// TEST MESSAGES BREAKING THE CONTRACT ARE DEAD LETTERED
+
+	consume(`{"type":"products.create","version":2,"payload":[{"ProductCode":"H"}]}`)
+	consume(`{"type":"products.create","payload":[{"ProductCode":"H"}]}`)
+	consume(`{"type":"products.rename","version":1,"payload":[]}`)
+	consume(`{"type":"products.upsert","version":1,"payload":{"ProductCode":"H"}}`)
+	consume(`{"type":"products.delete","version":1,"payload":[{"reason":"Replaced"}]}`)
+	consume(`not json`)
+
+	if len(ps.operations) != len(expected) {
+		t.Fatalf("Expected invalid messages not to be processed but got %v", ps.operations)
+	}
+
+	if len(dl.reasons) != 8 || dl.reasons[2] != "unsupported message version 2, expected 1" || dl.reasons[4] != `unknown message type "products.rename"` ||
+		dl.reasons[6] != "invalid products.delete payload: [0].ProductCode Field token cannot be empty or is missing" {
+		t.Fatalf("Unexpected dead letters %v", dl.reasons)
+	}
+
+	// TEST OFFSETS ARE STORED ONCE MESSAGES ARE PROCESSED OR DEAD LETTERED
+
+	if len(pc.stored) != 11 || pc.stored[10] != 11 || len(pc.seeks) != 0 {
+		t.Fatalf("Unexpected stored offsets %v", pc.stored)
+	}
+}
+
+func TestKafkaConsumerRetries(t *testing.T) {
+	ps := &ProductServiceStub{unavailable: 1}
+	dl := &DeadLetterMock{}
+	kc := buildTestKafkaConsumer(ps, dl)
+	pc := &PartitionConsumerMock{paused: map[int32]bool{}}
+
+	topic := "products"
+	consume := func(offset kafka.Offset, code string) {
+		value := `{"type":"products.create","version":1,"payload":[{"ProductCode":"` + code + `"}]}`
+		kc.consume(pc, &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 1, Offset: offset}, Value: []byte(value)})
+	}
+
+	// TEST TRANSIENT ERRORS PAUSE THE PARTITION
+
+	consume(5, "A")
+
+	if !pc.paused[1] || len(pc.seeks) != 1 || pc.seeks[0] != 5 || len(pc.stored) != 0 || len(dl.reasons) != 0 {
+		t.Fatalf("Expected the partition to be paused and rewound, got paused %v seeks %v stored %v", pc.paused, pc.seeks, pc.stored)
+	}
+
+	// messages fetched before pausing are skipped, they're consumed again after the retried one
+	consume(6, "B")
+
+	kc.resumeDue(pc, time.Now())
+	if !pc.paused[1] || len(ps.operations) != 0 {
+		t.Fatalf("Expected the partition to stay paused during the backoff")
+	}
+
+	kc.resumeDue(pc, time.Now().Add(time.Second))
+	if pc.paused[1] {
+		t.Fatalf("Expected the partition to be resumed after the backoff")
+	}
+
+	// TEST THE RETRIED MESSAGE SUCCEEDS
+
+	consume(5, "A")
+	consume(6, "B")
+
+	if strings.Join(ps.operations, "|") != "create A|create B" || len(dl.reasons) != 0 || len(pc.stored) != 2 || pc.stored[1] != 7 {
+		t.Fatalf("Unexpected operations %v, dead letters %v and stored offsets %v", ps.operations, dl.reasons, pc.stored)
+	}
+
+	// TEST MESSAGES ARE DEAD LETTERED AFTER THE MAX ATTEMPTS
+
+	ps.unavailable = 10
+	for attempt := 1; attempt <= 3; attempt++ {
+		consume(7, "C")
+		kc.resumeDue(pc, time.Now().Add(time.Minute))
+	}
+
+	if len(pc.seeks) != 3 || len(dl.reasons) != 1 || dl.reasons[0] != "giving up after 3 attempts: The service is currently unavailable" ||
+		len(pc.stored) != 3 || pc.stored[2] != 8 || len(kc.retries) != 0 {
+		t.Fatalf("Unexpected seeks %v, dead letters %v and stored offsets %v", pc.seeks, dl.reasons, pc.stored)
+	}
+}
+
+func TestKafkaConsumerRebalance(t *testing.T) {
+	ps := &ProductServiceStub{unavailable: 1}
+	dl := &DeadLetterMock{}
+	kc := buildTestKafkaConsumer(ps, dl)
+	pc := &PartitionConsumerMock{paused: map[int32]bool{}}
+
+	topic := "products"
+	partitions := []kafka.TopicPartition{{Topic: &topic, Partition: 1}}
+	consume := func(offset kafka.Offset, code string) {
+		value := `{"type":"products.create","version":1,"payload":[{"ProductCode":"` + code + `"}]}`
+		kc.consume(pc, &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 1, Offset: offset}, Value: []byte(value)})
+	}
+
+	// TEST RETRIES ARE DROPPED WHEN THE PARTITION IS REVOKED
+
+	consume(5, "A")
+	if len(kc.retries) != 1 {
+		t.Fatalf("Expected the message to be retried")
+	}
+
+	kc.rebalance(pc, kafka.RevokedPartitions{Partitions: partitions})
+	kc.rebalance(pc, kafka.AssignedPartitions{Partitions: partitions})
+
+	if len(kc.retries) != 0 || pc.paused[1] {
+		t.Fatalf("Expected the retry to be dropped, got retries %v paused %v", kc.retries, pc.paused)
+	}
+
+	// another consumer of the group processed the message meanwhile, the partition goes on from its committed offset
+	consume(7, "B")
+
+	if strings.Join(ps.operations, "|") != "create B" || len(pc.stored) != 1 || pc.stored[0] != 8 {
+		t.Fatalf("Unexpected operations %v and stored offsets %v", ps.operations, pc.stored)
+	}
+
+	// TEST PARTITIONS THAT CAN'T BE REWOUND GO ON
+
+	ps.unavailable = 1
+	pc.seekErr = kafka.Error{}
+	consume(8, "C")
+
+	if len(kc.retries) != 0 || pc.paused[1] {
+		t.Fatalf("Expected the partition to go on, got retries %v paused %v", kc.retries, pc.paused)
+	}
+
+	consume(9, "D")
+
+	if strings.Join(ps.operations, "|") != "create B|create D" || len(pc.stored) != 2 || pc.stored[1] != 10 {
+		t.Fatalf("Unexpected operations %v and stored offsets %v", ps.operations, pc.stored)
+	}
+}
+
+func TestKafkaConsumerBackoff(t *testing.T) {
+	kc := buildTestKafkaConsumer(nil, nil)
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 2 * time.Second}
+	for i, backoff := range expected {
+		if kc.backoff(i+1) != backoff {
+			t.Fatalf("Expected backoff %s after attempt %d but got %s", backoff, i+1, kc.backoff(i+1))
+		}
+	}
+
+	if kc.backoff(100) != 2*time.Second {
+		t.Fatal("Expected backoff not to overflow")
+	}
+
+	if isTransient(&processingError{errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, "")}) ||
+		!isTransient(&processingError{errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, "")}) ||
+		!isTransient(&SchemaRegistryError{StatusCode: 503}) || isTransient(&SchemaRegistryError{StatusCode: 404}) {
+		t.Fatal("Unexpected transient errors")
+	}
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"products/config"
+	"strconv"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// DeadLetterContract is the abstraction for setting aside consumed messages that can't be processed
+type DeadLetterContract interface {
+	DeadLetter(msg *kafka.Message, reason error) error
+}
+
+// KafkaDeadLetter produces the consumed messages that can't be processed to the dead letter topic, as they were consumed.
+// Where they came from and why they were set aside is kept in their headers, so they can be fixed and produced again.
+type KafkaDeadLetter struct {
+	config   *config.Config
+	producer *kafka.Producer
+}
+
+// NewKafkaDeadLetter is the constructor of KafkaDeadLetter
+func NewKafkaDeadLetter(config *config.Config) DeadLetterContract {
+	configProducer := kafka.ConfigMap{
+		"bootstrap.servers":        config.BootstrapServers,
+		"request.timeout.ms":       config.RequestTimeout,
+		"message.send.max.retries": config.Retries,
+	}
+
+	p, err := kafka.NewProducer(&configProducer)
+
+	if err != nil {
+		panic(err)
+	}
+
+	// producer errors, delivery reports are sent to DeadLetter
+	go func() {
+		for e := range p.Events() {
+			switch ev := e.(type) {
+			case kafka.Error:
+				log.Printf("Dead letter producer error: %v\n", ev)
+			default:
+			}
+		}
+	}()
+
+	return &KafkaDeadLetter{
+		config:   config,
+		producer: p,
+	}
+}
+
+// DeadLetter produces the message to the dead letter topic with its key, value and headers,
+// adding the topic, partition and offset it was consumed from and the reason why it can't be processed.
+// It waits for the message to be delivered, so its offset is only stored once it's safe in the dead letter topic.
+func (kd *KafkaDeadLetter) DeadLetter(msg *kafka.Message, reason error) error {
+	topic := kd.config.DeadLetterTopic
+
+	headers := append([]kafka.Header{}, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: "dlq.reason", Value: []byte(reason.Error())},
+		kafka.Header{Key: "dlq.topic", Value: []byte(*msg.TopicPartition.Topic)},
+		kafka.Header{Key: "dlq.partition", Value: []byte(strconv.Itoa(int(msg.TopicPartition.Partition)))},
+		kafka.Header{Key: "dlq.offset", Value: []byte(strconv.FormatInt(int64(msg.TopicPartition.Offset), 10))},
+	)
+
+	delivery := make(chan kafka.Event, 1)
+	err := kd.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+	}, delivery)
+	if err != nil {
+		return err
+	}
+
+	report, ok := (<-delivery).(*kafka.Message)
+	if !ok {
+		return errors.New("no delivery report of the dead letter message")
+	}
+
+	return report.TopicPartition.Error
+}
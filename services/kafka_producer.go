@@ -0,0 +1,73 @@
+package services
+
+import (
+	"log"
+	"products/config"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// EventPublisherContract is the abstraction for publishing product events
+type EventPublisherContract interface {
+	Publish(key string, event interface{}) error
+}
+
+// KafkaProducer publishes product events to the product events Kafka topic
+type KafkaProducer struct {
+	config   *config.Config
+	producer *kafka.Producer
+	serde    MessageSerdeContract
+}
+
+// NewKafkaProducer is the constructor of KafkaProducer
+func NewKafkaProducer(config *config.Config, serde MessageSerdeContract) EventPublisherContract {
+	configProducer := kafka.ConfigMap{
+		"bootstrap.servers":          config.BootstrapServers,
+		"request.timeout.ms":         config.RequestTimeout,
+		"message.send.max.retries":   config.Retries,
+		"queue.buffering.max.ms":     config.Linger,
+		"queue.buffering.max.kbytes": config.BufferMemory / 1024,
+	}
+
+	p, err := kafka.NewProducer(&configProducer)
+
+	if err != nil {
+		panic(err)
+	}
+
+	// delivery reports
+	go func() {
+		for e := range p.Events() {
+			switch ev := e.(type) {
+			case *kafka.Message:
+				if ev.TopicPartition.Error != nil {
+					log.Printf("Error delivering event to Kafka: %v\n", ev.TopicPartition.Error)
+				}
+			default:
+			}
+		}
+	}()
+
+	return &KafkaProducer{
+		config:   config,
+		producer: p,
+		serde:    serde,
+	}
+}
+
+// Publish produces the event, encoded in the configured message format, keyed by the provided key so events of the same
+// product keep their order
+func (kp *KafkaProducer) Publish(key string, event interface{}) error {
+	topic := kp.config.ProductEventsTopic
+
+	value, err := kp.serde.Encode(topic, event)
+	if err != nil {
+		return err
+	}
+
+	return kp.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            []byte(key),
+		Value:          value,
+	}, nil)
+}
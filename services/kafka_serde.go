@@ -0,0 +1,344 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"products/config"
+	"products/models/request"
+	"products/models/response"
+	"products/proto/messagepb"
+	"sync"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// wireMagicByte starts the messages in Confluent wire format, followed by the 4 bytes big endian id of their schema.
+// Protobuf messages then have the indexes of their message type in the schema, as zigzag varints.
+const wireMagicByte = 0
+
+// MessageSerdeContract is the abstraction for reading and writing Kafka messages in the configured format
+type MessageSerdeContract interface {
+	Decode(value []byte) ([]byte, error)
+	Encode(topic string, event interface{}) ([]byte, error)
+}
+
+// MessageSerde reads products messages in JSON, Avro or Protobuf, the format of each message is told by its first byte,
+// and writes the product events in the configured format
+type MessageSerde struct {
+	format   string
+	registry SchemaRegistryContract
+
+	mu     sync.Mutex
+	codecs map[int]*goavro.Codec
+}
+
+// NewMessageSerde is the constructor of MessageSerde, the registry may be nil if only JSON messages are used
+func NewMessageSerde(config *config.Config, registry SchemaRegistryContract) MessageSerdeContract {
+	return &MessageSerde{
+		format:   config.MessageFormat,
+		registry: registry,
+		codecs:   map[int]*goavro.Codec{},
+	}
+}
+
+// Decode returns the JSON envelope of a products message. JSON messages are returned as they are,
+// Avro and Protobuf ones are read with the schema they were written with.
+func (ms *MessageSerde) Decode(value []byte) ([]byte, error) {
+	if len(value) == 0 || value[0] != wireMagicByte {
+		return value, nil
+	}
+
+	if len(value) < 5 {
+		return nil, errors.New("message shorter than the wire format header")
+	}
+
+	id := int(binary.BigEndian.Uint32(value[1:5]))
+	if ms.registry == nil {
+		return nil, fmt.Errorf("no schema registry configured to read message with schema id %d", id)
+	}
+
+	schema, err := ms.registry.SchemaByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope *mrequest.MessageEnvelope
+	switch schema.Type {
+	case SchemaTypeProtobuf:
+		envelope, err = decodeProtobufMessage(value[5:])
+	case SchemaTypeAvro, "":
+		envelope, err = ms.decodeAvroMessage(schema, value[5:])
+	default:
+		err = fmt.Errorf("unsupported schema type %s", schema.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(envelope)
+}
+
+// Encode writes the event in the configured format, Avro and Protobuf events with the latest schema
+// of the topic subject, <topic>-value
+func (ms *MessageSerde) Encode(topic string, event interface{}) ([]byte, error) {
+	if ms.format == config.MessageFormatJSON {
+		return json.Marshal(event)
+	}
+
+	productEvent, ok := event.(*mresponse.ProductEvent)
+	if !ok {
+		return nil, fmt.Errorf("%T can't be produced as %s", event, ms.format)
+	}
+
+	schema, err := ms.registry.LatestSchema(topic + "-value")
+	if err != nil {
+		return nil, err
+	}
+
+	value := make([]byte, 5, 64)
+	value[0] = wireMagicByte
+	binary.BigEndian.PutUint32(value[1:5], uint32(schema.ID))
+
+	switch ms.format {
+	case config.MessageFormatProtobuf:
+		if schema.Type != SchemaTypeProtobuf {
+			return nil, fmt.Errorf("latest schema of %s-value is not a Protobuf schema", topic)
+		}
+
+		payload, err := proto.Marshal(&messagepb.ProductEvent{
+			Type:       productEvent.Type,
+			ProductId:  productEvent.ProductID,
+			Version:    productEvent.Version,
+			From:       productEvent.From,
+			To:         productEvent.To,
+			OccurredAt: timestamppb.New(productEvent.OccurredAt),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// the event is the first message of the schema, its indexes [0] are written as a single 0
+		value = append(value, 0)
+		return append(value, payload...), nil
+
+	default:
+		if schema.Type != SchemaTypeAvro && schema.Type != "" {
+			return nil, fmt.Errorf("latest schema of %s-value is not an Avro schema", topic)
+		}
+
+		codec, err := ms.avroCodec(schema)
+		if err != nil {
+			return nil, err
+		}
+
+		return codec.BinaryFromNative(value, map[string]interface{}{
+			"type":        productEvent.Type,
+			"product_id":  productEvent.ProductID,
+			"version":     productEvent.Version,
+			"from":        avroOptionalString(productEvent.From),
+			"to":          avroOptionalString(productEvent.To),
+			"occurred_at": productEvent.OccurredAt.UnixNano() / int64(time.Millisecond),
+		})
+	}
+}
+
+// avroCodec returns the codec of the schema, codecs are kept by schema id as schemas never change
+func (ms *MessageSerde) avroCodec(schema *Schema) (*goavro.Codec, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	codec, ok := ms.codecs[schema.ID]
+	if ok {
+		return codec, nil
+	}
+
+	codec, err := goavro.NewCodec(schema.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro schema %d: %s", schema.ID, err.Error())
+	}
+
+	ms.codecs[schema.ID] = codec
+	return codec, nil
+}
+
+// decodeProtobufMessage reads a ProductMessage, which must be the first message of its schema
+func decodeProtobufMessage(value []byte) (*mrequest.MessageEnvelope, error) {
+	count, n := binary.Varint(value)
+	if n <= 0 {
+		return nil, errors.New("invalid Protobuf message indexes")
+	}
+	value = value[n:]
+
+	// a count of 0 stands for the indexes [0]
+	if count != 0 {
+		index, n := binary.Varint(value)
+		if n <= 0 {
+			return nil, errors.New("invalid Protobuf message indexes")
+		}
+		if count != 1 || index != 0 {
+			return nil, errors.New("Protobuf message must be the first message of its schema")
+		}
+		value = value[n:]
+	}
+
+	message := messagepb.ProductMessage{}
+	err := proto.Unmarshal(value, &message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Protobuf message: %s", err.Error())
+	}
+
+	envelope := &mrequest.MessageEnvelope{
+		Type:          message.Type,
+		Version:       int(message.Version),
+		Source:        message.Source,
+		CorrelationID: message.CorrelationId,
+		Tenant:        message.Tenant,
+	}
+
+	if message.Type == mrequest.MessageProductsDelete {
+		deletes := make([]*mrequest.MessageProductDelete, 0, len(message.Deletes))
+		for _, d := range message.Deletes {
+			deletes = append(deletes, &mrequest.MessageProductDelete{ProductCode: d.ProductCode, Reason: d.Reason})
+		}
+		envelope.Payload, err = json.Marshal(deletes)
+		return envelope, err
+	}
+
+	products := make([]*mrequest.ProductCreate, 0, len(message.Products))
+	for _, p := range message.Products {
+		product := &mrequest.ProductCreate{
+			Status:             p.Status,
+			ProductType:        p.ProductType,
+			ProductCode:        p.ProductCode,
+			ProductGroup:       p.ProductGroup,
+			ProductDescription: p.ProductDescription,
+			ProductNumberCode:  p.ProductNumberCode,
+		}
+		if p.CustomsDetails != nil {
+			product.CustomsDetails = &mrequest.CustomsDetails{CNCode: p.CustomsDetails.CnCode, UNNumber: p.CustomsDetails.UnNumber}
+		}
+		products = append(products, product)
+	}
+	envelope.Payload, err = json.Marshal(products)
+	return envelope, err
+}
+
+// decodeAvroMessage reads a ProductMessage record written with the schema
+func (ms *MessageSerde) decodeAvroMessage(schema *Schema, value []byte) (*mrequest.MessageEnvelope, error) {
+	codec, err := ms.avroCodec(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	native, _, err := codec.NativeFromBinary(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro message: %s", err.Error())
+	}
+
+	record, ok := native.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Avro message is not a record")
+	}
+
+	envelope := &mrequest.MessageEnvelope{
+		Type:          avroString(record["type"]),
+		Version:       avroInt(record["version"]),
+		Source:        avroString(avroUnion(record["source"])),
+		CorrelationID: avroString(avroUnion(record["correlation_id"])),
+		Tenant:        avroString(avroUnion(record["tenant"])),
+	}
+
+	if envelope.Type == mrequest.MessageProductsDelete {
+		deletes := make([]*mrequest.MessageProductDelete, 0)
+		for _, item := range avroArray(record["deletes"]) {
+			d, _ := item.(map[string]interface{})
+			deletes = append(deletes, &mrequest.MessageProductDelete{
+				ProductCode: avroString(d["ProductCode"]),
+				Reason:      avroString(avroUnion(d["reason"])),
+			})
+		}
+		envelope.Payload, err = json.Marshal(deletes)
+		return envelope, err
+	}
+
+	products := make([]*mrequest.ProductCreate, 0)
+	for _, item := range avroArray(record["products"]) {
+		p, _ := item.(map[string]interface{})
+		product := &mrequest.ProductCreate{
+			Status:             avroString(avroUnion(p["status"])),
+			ProductType:        avroString(p["ProductType"]),
+			ProductCode:        avroString(p["ProductCode"]),
+			ProductGroup:       avroString(avroUnion(p["ProductGroup"])),
+			ProductDescription: avroString(p["ProductDescription"]),
+			ProductNumberCode:  avroString(p["ProductNumberCode"]),
+		}
+		if customs, ok := avroUnion(p["CustomsDetails"]).(map[string]interface{}); ok {
+			product.CustomsDetails = &mrequest.CustomsDetails{
+				CNCode:   avroStrings(customs["CNCode"]),
+				UNNumber: avroStrings(customs["UNNumber"]),
+			}
+		}
+		products = append(products, product)
+	}
+	envelope.Payload, err = json.Marshal(products)
+	return envelope, err
+}
+
+// avroOptionalString is the native value of a ["null","string"] union, null for empty strings
+func avroOptionalString(s string) interface{} {
+	if s == "" {
+		return goavro.Union("null", nil)
+	}
+
+	return goavro.Union("string", s)
+}
+
+// avroUnion returns the value of a union, which goavro reads as a map of the type name to the value, or nil
+func avroUnion(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return v
+	}
+
+	for _, value := range m {
+		return value
+	}
+	return nil
+}
+
+func avroString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func avroInt(v interface{}) int {
+	switch n := v.(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+func avroArray(v interface{}) []interface{} {
+	a, _ := v.([]interface{})
+	return a
+}
+
+func avroStrings(v interface{}) []string {
+	strings := []string{}
+	for _, item := range avroArray(v) {
+		strings = append(strings, avroString(item))
+	}
+	return strings
+}
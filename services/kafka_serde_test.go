@@ -0,0 +1,313 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"products/config"
+	"products/models/request"
+	"products/models/response"
+	"products/proto/messagepb"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// buildTestSchemaDir copies the message schemas of the repo to a directory standing in for the schema registry
+func buildTestSchemaDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "schemas-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"../proto/messagepb/product_message.proto": "products-value.1.proto",
+		"../proto/messagepb/product_event.proto":   "product-events-value.2.proto",
+		"../schemas/product_message.avsc":          "products-avro-value.3.avsc",
+		"../schemas/product_event.avsc":            "product-events-avro-value.4.avsc",
+	}
+	for from, to := range files {
+		content, err := ioutil.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, to), content, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func buildTestMessageSerde(dir string, format string) MessageSerdeContract {
+	cf := &config.Config{KafkaConsumerConfig: &config.KafkaConsumerConfig{MessageFormat: format, SchemaRegistryURL: "file://" + dir}}
+	return NewMessageSerde(cf, NewSchemaRegistry(cf))
+}
+
+// wireHeader is the Confluent wire format header of the schema id
+func wireHeader(id int) []byte {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header[1:], uint32(id))
+	return header
+}
+
+func decodeTestEnvelope(t *testing.T, serde MessageSerdeContract, value []byte) *productsMessage {
+	decoded, err := serde.Decode(value)
+	if err != nil {
+		t.Fatalf("Unexpected decode error %s", err.Error())
+	}
+
+	message, err := (&KafkaConsumer{}).parseProductsMessage(decoded)
+	if err != nil {
+		t.Fatalf("Unexpected parse error %s in %s", err.Error(), decoded)
+	}
+
+	return message
+}
+
+func TestMessageSerdeJSON(t *testing.T) {
+	serde := NewMessageSerde(&config.Config{KafkaConsumerConfig: &config.KafkaConsumerConfig{MessageFormat: config.MessageFormatJSON}}, nil)
+
+	value := []byte(`{"type":"products.create","version":1,"payload":[]}`)
+	decoded, err := serde.Decode(value)
+	if err != nil || string(decoded) != string(value) {
+		t.Fatalf("Expected JSON messages as they are but got %s %v", decoded, err)
+	}
+
+	_, err = serde.Decode(append(wireHeader(1), 0))
+	if err == nil || !strings.Contains(err.Error(), "no schema registry") {
+		t.Fatalf("Expected an error without schema registry but got %v", err)
+	}
+
+	encoded, err := serde.Encode("product-events", &mresponse.ProductEvent{Type: "product.created", ProductID: "id"})
+	if err != nil || !strings.Contains(string(encoded), `"product_id":"id"`) {
+		t.Fatalf("Expected a JSON event but got %s %v", encoded, err)
+	}
+}
+
+func TestMessageSerdeProtobuf(t *testing.T) {
+	dir := buildTestSchemaDir(t)
+	defer os.RemoveAll(dir)
+
+	serde := buildTestMessageSerde(dir, config.MessageFormatProtobuf)
+
+	// TEST UPSERT MESSAGES
+
+	payload, _ := proto.Marshal(&messagepb.ProductMessage{
+		Type:          mrequest.MessageProductsUpsert,
+		Version:       1,
+		Source:        "erp",
+		CorrelationId: "c-1",
+		Products: []*messagepb.Product{
+			{ProductType: "P", ProductCode: "A", ProductDescription: "Product A", ProductNumberCode: "A1", CustomsDetails: &messagepb.CustomsDetails{CnCode: []string{"8471"}}},
+		},
+	})
+
+	message := decodeTestEnvelope(t, serde, append(append(wireHeader(1), 0), payload...))
+	products := *message.products
+	if message.Type != mrequest.MessageProductsUpsert || message.Source != "erp" || message.CorrelationID != "c-1" ||
+		len(products) != 1 || products[0].ProductCode != "A" || products[0].CustomsDetails.CNCode[0] != "8471" {
+		t.Fatalf("Unexpected message %v %v", message.MessageEnvelope, products)
+	}
+
+	// TEST DELETE MESSAGES, WITH EXPLICIT MESSAGE INDEXES [0]
+
+	payload, _ = proto.Marshal(&messagepb.ProductMessage{
+		Type:    mrequest.MessageProductsDelete,
+		Version: 1,
+		Deletes: []*messagepb.ProductDelete{{ProductCode: "B", Reason: "Replaced"}},
+	})
+
+	message = decodeTestEnvelope(t, serde, append(append(wireHeader(1), 2, 0), payload...))
+	if len(message.deletes) != 1 || message.deletes[0].ProductCode != "B" || message.deletes[0].Reason != "Replaced" {
+		t.Fatalf("Unexpected deletes %v", message.deletes)
+	}
+
+	// TEST INVALID MESSAGES
+
+	_, err := serde.Decode(append(append(wireHeader(1), 2, 2), payload...))
+	if err == nil || !strings.Contains(err.Error(), "first message") {
+		t.Fatalf("Expected an error for other message types but got %v", err)
+	}
+
+	_, err = serde.Decode(append(append(wireHeader(99), 0), payload...))
+	if registryErr, ok := err.(*SchemaRegistryError); !ok || registryErr.StatusCode != http.StatusNotFound || registryErr.Temporary() {
+		t.Fatalf("Expected an unknown schema error but got %v", err)
+	}
+
+	_, err = serde.Decode([]byte{0, 0, 1})
+	if err == nil {
+		t.Fatalf("Expected an error for a truncated header")
+	}
+
+	// TEST EVENTS ARE PRODUCED WITH THE LATEST SCHEMA OF THE TOPIC
+
+	occurredAt := time.Date(2019, 3, 4, 10, 0, 0, 0, time.UTC)
+	encoded, err := serde.Encode("product-events", &mresponse.ProductEvent{Type: "product.status_changed", ProductID: "id", Version: 3, From: "draft", To: "active", OccurredAt: occurredAt})
+	if err != nil {
+		t.Fatalf("Unexpected encode error %s", err.Error())
+	}
+
+	if string(encoded[:6]) != string(append(wireHeader(2), 0)) {
+		t.Fatalf("Unexpected wire format header %v", encoded[:6])
+	}
+
+	event := messagepb.ProductEvent{}
+	err = proto.Unmarshal(encoded[6:], &event)
+	if err != nil || event.ProductId != "id" || event.Version != 3 || event.To != "active" || !event.OccurredAt.AsTime().Equal(occurredAt) {
+		t.Fatalf("Unexpected event %v %v", &event, err)
+	}
+
+	_, err = serde.Encode("product-events-avro", &mresponse.ProductEvent{Type: "product.created"})
+	if err == nil {
+		t.Fatalf("Expected an error encoding Protobuf events with an Avro schema")
+	}
+}
+
+func TestMessageSerdeAvro(t *testing.T) {
+	dir := buildTestSchemaDir(t)
+	defer os.RemoveAll(dir)
+
+	serde := buildTestMessageSerde(dir, config.MessageFormatAvro)
+
+	schema, _ := ioutil.ReadFile(filepath.Join(dir, "products-avro-value.3.avsc"))
+	codec, err := goavro.NewCodec(string(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TEST CREATE MESSAGES
+
+	value, err := codec.BinaryFromNative(wireHeader(3), map[string]interface{}{
+		"type":           mrequest.MessageProductsCreate,
+		"version":        int32(1),
+		"source":         goavro.Union("string", "erp"),
+		"correlation_id": goavro.Union("null", nil),
+		"tenant":         goavro.Union("string", "acme"),
+		"products": []interface{}{
+			map[string]interface{}{
+				"status":             goavro.Union("string", "draft"),
+				"ProductType":        "P",
+				"ProductCode":        "A",
+				"ProductGroup":       goavro.Union("null", nil),
+				"ProductDescription": "Product A",
+				"ProductNumberCode":  "A1",
+				"CustomsDetails": goavro.Union("products.messages.v1.CustomsDetails", map[string]interface{}{
+					"CNCode":   []interface{}{"8471"},
+					"UNNumber": []interface{}{},
+				}),
+			},
+		},
+		"deletes": []interface{}{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := decodeTestEnvelope(t, serde, value)
+	products := *message.products
+	if message.Type != mrequest.MessageProductsCreate || message.Source != "erp" || message.Tenant != "acme" || message.CorrelationID != "" ||
+		len(products) != 1 || products[0].Status != "draft" || products[0].ProductCode != "A" || products[0].CustomsDetails.CNCode[0] != "8471" {
+		t.Fatalf("Unexpected message %v %v", message.MessageEnvelope, products)
+	}
+
+	// TEST DELETE MESSAGES
+
+	value, _ = codec.BinaryFromNative(wireHeader(3), map[string]interface{}{
+		"type":     mrequest.MessageProductsDelete,
+		"version":  int32(1),
+		"products": []interface{}{},
+		"deletes":  []interface{}{map[string]interface{}{"ProductCode": "B", "reason": goavro.Union("null", nil)}},
+	})
+
+	message = decodeTestEnvelope(t, serde, value)
+	if len(message.deletes) != 1 || message.deletes[0].ProductCode != "B" || message.deletes[0].Reason != "" {
+		t.Fatalf("Unexpected deletes %v", message.deletes)
+	}
+
+	// TEST EVENTS ARE PRODUCED WITH THE LATEST SCHEMA OF THE TOPIC
+
+	encoded, err := serde.Encode("product-events-avro", &mresponse.ProductEvent{Type: "product.created", ProductID: "id", Version: 1, OccurredAt: time.Unix(1551693600, 0)})
+	if err != nil {
+		t.Fatalf("Unexpected encode error %s", err.Error())
+	}
+
+	if string(encoded[:5]) != string(wireHeader(4)) {
+		t.Fatalf("Unexpected wire format header %v", encoded[:5])
+	}
+
+	schema, _ = ioutil.ReadFile(filepath.Join(dir, "product-events-avro-value.4.avsc"))
+	eventCodec, _ := goavro.NewCodec(string(schema))
+	native, _, err := eventCodec.NativeFromBinary(encoded[5:])
+	event, _ := native.(map[string]interface{})
+	if err != nil || avroString(event["product_id"]) != "id" || avroUnion(event["from"]) != nil || avroInt(event["occurred_at"]) != 1551693600000 {
+		t.Fatalf("Unexpected event %v %v", native, err)
+	}
+}
+
+func TestHTTPSchemaRegistry(t *testing.T) {
+	requests := 0
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		if r.Header.Get("Accept") != "application/vnd.schemaregistry.v1+json" {
+			t.Errorf("Unexpected Accept header %s", r.Header.Get("Accept"))
+		}
+
+		switch r.URL.Path {
+		case "/schemas/ids/7":
+			json.NewEncoder(w).Encode(map[string]interface{}{"schema": `{"type":"string"}`})
+		case "/subjects/product-events-value/versions/latest":
+			json.NewEncoder(w).Encode(map[string]interface{}{"subject": "product-events-value", "id": 8, "version": 2, "schemaType": "PROTOBUF", "schema": "syntax = \"proto3\";"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error_code": 40403, "message": "Schema not found"})
+		}
+	}))
+	defer registry.Close()
+
+	sr := NewSchemaRegistry(&config.Config{KafkaConsumerConfig: &config.KafkaConsumerConfig{SchemaRegistryURL: registry.URL + "/"}})
+
+	// TEST SCHEMAS ARE CACHED
+
+	for i := 0; i < 2; i++ {
+		schema, err := sr.SchemaByID(7)
+		if err != nil || schema.ID != 7 || schema.Type != "" || schema.Schema != `{"type":"string"}` {
+			t.Fatalf("Unexpected schema %v %v", schema, err)
+		}
+
+		schema, err = sr.LatestSchema("product-events-value")
+		if err != nil || schema.ID != 8 || schema.Type != SchemaTypeProtobuf {
+			t.Fatalf("Unexpected schema %v %v", schema, err)
+		}
+	}
+
+	_, err := sr.SchemaByID(8)
+	if err != nil || requests != 2 {
+		t.Fatalf("Expected the schemas to be read once but got %d requests %v", requests, err)
+	}
+
+	// TEST REGISTRY ERRORS
+
+	_, err = sr.SchemaByID(9)
+	registryErr, ok := err.(*SchemaRegistryError)
+	if !ok || registryErr.StatusCode != http.StatusNotFound || registryErr.ErrorCode != 40403 || registryErr.Temporary() {
+		t.Fatalf("Expected a not found error but got %v", err)
+	}
+
+	registry.Close()
+
+	_, err = sr.LatestSchema("products-value")
+	registryErr, ok = err.(*SchemaRegistryError)
+	if !ok || !registryErr.Temporary() {
+		t.Fatalf("Expected a temporary error but got %v", err)
+	}
+}
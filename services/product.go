@@ -2,12 +2,16 @@ package services
 
 import (
 	"context"
-	"log"
+	"io"
 	"products/models/request"
 	"products/models/response"
+	"products/models/saft-pt-4"
 	"products/repositories"
 	"products/util/errors"
+	"strconv"
+	"time"
 
+	"github.com/mongodb/mongo-go-driver/bson"
 	"github.com/mongodb/mongo-go-driver/bson/objectid"
 	"github.com/mongodb/mongo-go-driver/mongo"
 )
@@ -16,18 +20,38 @@ import (
 type ProductServiceContract interface {
 	CreateOne(request *mrequest.ProductCreate) (*mresponse.ProductCreate, *mresponse.ErrorResponse)
 	CreateMany(request *[]*mrequest.ProductCreate) (*[]*mresponse.ProductCreate, *mresponse.ErrorResponse)
+	ReadOne(id string, asOf *time.Time, projection *mrequest.Projection) (*mresponse.ProductRead, *mresponse.ErrorResponse)
+	UpdateOne(id string, version int64, request *mrequest.ProductUpdate) (*mresponse.ProductRead, *mresponse.ErrorResponse)
+	PatchOne(id string, version int64, request *mrequest.ProductPatch) (*mresponse.ProductRead, *mresponse.ErrorResponse)
+	ArchiveOne(id string, version int64, reason string) (*mresponse.ProductRead, *mresponse.ErrorResponse)
+	RestoreOne(id string, version int64) (*mresponse.ProductRead, *mresponse.ErrorResponse)
+	ChangeStatus(id string, version int64, status string) (*mresponse.ProductRead, *mresponse.ErrorResponse)
 	List(request *mrequest.ListRequest) (*mresponse.ProductList, *mresponse.ErrorResponse)
+	Export(request *mrequest.ListRequest, each func(*mresponse.ProductRead) error) *mresponse.ErrorResponse
+	Facets(request *mrequest.ListRequest) (*mresponse.ProductFacets, *mresponse.ErrorResponse)
+	Distinct(request *mrequest.DistinctRequest) (*mresponse.DistinctList, *mresponse.ErrorResponse)
+	ExportSaft(request *mrequest.SaftExport) (*msaft.AuditFile, *mresponse.ErrorResponse)
+	Search(request *mrequest.SearchRequest) (*mresponse.ProductSearchList, *mresponse.ErrorResponse)
+	Suggest(request *mrequest.SuggestRequest) (*mresponse.ProductSuggestList, *mresponse.ErrorResponse)
+	ImportCSV(r io.Reader, request *mrequest.CSVImport) (*mresponse.ImportResult, *mresponse.ErrorResponse)
+	UpsertByCode(request *mrequest.ProductCreate) (*mresponse.ProductRead, *mresponse.ErrorResponse)
+	ArchiveByCode(code string, reason string) (*mresponse.ProductRead, *mresponse.ErrorResponse)
+	Watch(ctx context.Context, request *mrequest.ChangesRequest) (<-chan *mresponse.ProductChange, *mresponse.ErrorResponse)
 }
 
 // ProductService is the layer between http client and repository for product resource
 type ProductService struct {
 	productRepository repositories.ProductRepositoryContract
+	groupService      ProductGroupServiceContract
+	eventPublisher    EventPublisherContract
 }
 
 // NewProductService is the constructor of ProductService
-func NewProductService(pr repositories.ProductRepositoryContract) ProductServiceContract {
+func NewProductService(pr repositories.ProductRepositoryContract, gs ProductGroupServiceContract, ep EventPublisherContract) ProductServiceContract {
 	return &ProductService{
 		productRepository: pr,
+		groupService:      gs,
+		eventPublisher:    ep,
 	}
 }
 
@@ -40,6 +64,15 @@ func (this *ProductService) CreateOne(request *mrequest.ProductCreate) (*mrespon
 		return nil, e
 	}
 
+	e = this.groupService.ValidateReference(request.ProductGroup)
+	if e != nil {
+		return nil, e
+	}
+
+	if request.Status == "" {
+		request.Status = mresponse.StatusActive
+	}
+
 	res, err := this.productRepository.CreateOne(request)
 
 	if err != nil {
@@ -55,16 +88,50 @@ func (this *ProductService) CreateOne(request *mrequest.ProductCreate) (*mrespon
 	return &p, nil
 }
 
-// CreateMany saves many products in one bulk operation
+// CreateMany saves many products in one bulk operation.
+// Products failing to be inserted, e.g. with a duplicated key, are returned as the details of a conflict error.
 func (this *ProductService) CreateMany(request *[]*mrequest.ProductCreate) (*[]*mresponse.ProductCreate, *mresponse.ErrorResponse) {
 
+	details := []mresponse.ErrorDetail{}
+	for i, p := range *request {
+		if p.Status == "" {
+			p.Status = mresponse.StatusActive
+		}
+
+		e := this.groupService.ValidateReference(p.ProductGroup)
+		if e == nil {
+			continue
+		}
+		if len(e.Errors) == 0 {
+			return nil, e
+		}
+		for _, d := range e.Errors {
+			d.Property = "[" + strconv.Itoa(i) + "]." + d.Property
+			details = append(details, d)
+		}
+	}
+
+	if len(details) != 0 {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
 	res, err := this.productRepository.InsertMany(request)
 
 	if err != nil {
-		mngBulkError := err.(mongo.BulkWriteError)
-		writeErrors := mngBulkError.WriteErrors
-		for _, err := range writeErrors {
-			log.Println(err) // for now only print errors
+		mngBulkError, ok := err.(mongo.BulkWriteError)
+		if !ok {
+			return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		}
+
+		// inserts are unordered, the products without write errors were created
+		for _, writeErr := range mngBulkError.WriteErrors {
+			details = append(details, mresponse.ErrorDetail{
+				Property: "[" + strconv.Itoa(writeErr.Index) + "]",
+				Message:  writeErr.Message,
+			})
+		}
+		if len(details) != 0 {
+			return nil, errors.HandleErrorResponse(errors.CONFLICT, details, "Some products could not be created, the others were")
 		}
 	}
 
@@ -83,9 +150,115 @@ func (this *ProductService) CreateMany(request *[]*mrequest.ProductCreate) (*[]*
 	return &result, nil
 }
 
+// ReadOne returns the product with the provided id, as it existed at asOf if provided.
+// If projection is provided only the selected fields are read.
+func (this *ProductService) ReadOne(id string, asOf *time.Time, projection *mrequest.Projection) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+
+	oid, e := parseProductID(id)
+	if e != nil {
+		return nil, e
+	}
+
+	p, err := this.productRepository.ReadByID(oid, asOf, projection)
+
+	if err != nil {
+		return nil, handleProductError(err)
+	}
+
+	p.ID = p.IDdb.Hex()
+	if p.Status == "" {
+		p.Status = mresponse.StatusActive
+	}
+
+	return p, nil
+}
+
+// UpdateOne replaces the product fields if the product is still at the provided version
+func (this *ProductService) UpdateOne(id string, version int64, request *mrequest.ProductUpdate) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+
+	oid, e := parseProductID(id)
+	if e != nil {
+		return nil, e
+	}
+
+	// validate request
+	e = errors.ValidateRequest(request)
+	if e != nil {
+		return nil, e
+	}
+
+	e = this.groupService.ValidateReference(request.ProductGroup)
+	if e != nil {
+		return nil, e
+	}
+
+	_, err := this.productRepository.UpdateOne(oid, version, request)
+
+	if err != nil {
+		return nil, handleProductError(err)
+	}
+
+	return this.ReadOne(id, nil, nil)
+}
+
+// PatchOne changes only the provided product fields if the product is still at the provided version
+func (this *ProductService) PatchOne(id string, version int64, request *mrequest.ProductPatch) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+
+	current, e := this.ReadOne(id, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+
+	if current.Version != version {
+		return nil, errors.HandleErrorResponse(errors.PRECONDITION_FAILED, nil, "")
+	}
+
+	return this.UpdateOne(id, version, request.Merge(current))
+}
+
+// ArchiveOne archives the product if it is still at the provided version.
+// Products are never removed as issued documents may reference them.
+func (this *ProductService) ArchiveOne(id string, version int64, reason string) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+
+	oid, e := parseProductID(id)
+	if e != nil {
+		return nil, e
+	}
+
+	_, err := this.productRepository.ArchiveOne(oid, version, reason)
+
+	if err != nil {
+		return nil, handleProductError(err)
+	}
+
+	return this.ReadOne(id, nil, nil)
+}
+
+// RestoreOne brings an archived product back to the catalog if it is still at the provided version
+func (this *ProductService) RestoreOne(id string, version int64) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+
+	oid, e := parseProductID(id)
+	if e != nil {
+		return nil, e
+	}
+
+	_, err := this.productRepository.RestoreOne(oid, version)
+
+	if err != nil {
+		return nil, handleProductError(err)
+	}
+
+	return this.ReadOne(id, nil, nil)
+}
+
 // List returns a list of products with pagination and filtering options
 func (this *ProductService) List(request *mrequest.ListRequest) (*mresponse.ProductList, *mresponse.ErrorResponse) {
 
+	e := this.filterGroupSubtree(request)
+	if e != nil {
+		return nil, e
+	}
+
 	total, perPage, page, cursor, err := this.productRepository.List(request)
 
 	if err != nil {
@@ -94,6 +267,7 @@ func (this *ProductService) List(request *mrequest.ListRequest) (*mresponse.Prod
 	}
 
 	docs := []*mresponse.ProductRead{}
+	var lastStored *bson.Document
 
 	for cursor.Next(context.Background()) {
 		doc := mresponse.ProductRead{}
@@ -103,16 +277,203 @@ func (this *ProductService) List(request *mrequest.ListRequest) (*mresponse.Prod
 			return nil, errR
 		}
 
+		// the cursor of the next page needs to tell null and missing sort fields of the last product from ""
+		if request.CursorMode && int64(len(docs)) == perPage-1 {
+			lastStored = bson.NewDocument()
+			err = cursor.Decode(lastStored)
+			if err != nil {
+				errR := errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+				return nil, errR
+			}
+		}
+
 		doc.ID = doc.IDdb.Hex()
+		if doc.Status == "" {
+			doc.Status = mresponse.StatusActive
+		}
 
 		docs = append(docs, &doc)
 	}
-	
+
 	resp := mresponse.ProductList{
-		Total: total,
 		PerPage: perPage,
-		Page: page,
-		Items: &docs,
 	}
+
+	if !request.SkipTotal {
+		resp.Total = &total
+	}
+
+	if !request.CursorMode {
+		resp.Page = page
+	} else if int64(len(docs)) > perPage {
+		// the repository returns one more product to tell there's a next page
+		docs = docs[:perPage]
+		last := docs[len(docs)-1]
+
+		next := mrequest.ListCursor{
+			Sort:   request.Sort,
+			Order:  request.Order,
+			Values: []*string{},
+			ID:     last.IDdb,
+		}
+		for _, key := range request.SortKeys {
+			if key.Field == "_id" {
+				break
+			}
+			next.Values = append(next.Values, sortValue(last, lastStored, key.Field))
+		}
+		resp.NextCursor = next.Encode()
+	}
+
+	resp.Items = &docs
 	return &resp, nil
 }
+
+// Export reads all the listed products, without pagination, passing them to each as they're read so they are never all in memory.
+// An error returned by each stops the export.
+func (this *ProductService) Export(request *mrequest.ListRequest, each func(*mresponse.ProductRead) error) *mresponse.ErrorResponse {
+
+	e := this.filterGroupSubtree(request)
+	if e != nil {
+		return e
+	}
+
+	cursor, err := this.productRepository.Export(request)
+
+	if err != nil {
+		return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		doc := mresponse.ProductRead{}
+		err := cursor.Decode(&doc)
+		if err != nil {
+			return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		}
+
+		doc.ID = doc.IDdb.Hex()
+		if doc.Status == "" {
+			doc.Status = mresponse.StatusActive
+		}
+
+		err = each(&doc)
+		if err != nil {
+			return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		}
+	}
+
+	err = cursor.Err()
+	if err != nil {
+		return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	return nil
+}
+
+// filterGroupSubtree adds to the list request a filter on the products of its group subtree, if any
+func (this *ProductService) filterGroupSubtree(request *mrequest.ListRequest) *mresponse.ErrorResponse {
+	if request.GroupSubtree == "" {
+		return nil
+	}
+
+	codes, e := this.groupService.Subtree(request.GroupSubtree)
+	if e != nil {
+		return e
+	}
+
+	filter := mrequest.Filter{
+		Field:    "ProductGroup",
+		Operator: mrequest.FilterIn,
+	}
+	for _, code := range codes {
+		filter.Values = append(filter.Values, code)
+	}
+	request.Filters = append(request.Filters, &filter)
+
+	return nil
+}
+
+// sortValue returns the value of the product on the field a list is sorted by, used for cursor pagination.
+// It's nil if the field is null or missing on the stored product, as those sort before "".
+func sortValue(p *mresponse.ProductRead, stored *bson.Document, field string) *string {
+	value, err := stored.LookupErr(field)
+	if err != nil || value.Type() == bson.TypeNull {
+		return nil
+	}
+
+	var v string
+	switch field {
+	case "ProductType":
+		v = p.ProductType
+	case "ProductCode":
+		v = p.ProductCode
+	case "ProductGroup":
+		v = p.ProductGroup
+	case "ProductDescription":
+		v = p.ProductDescription
+	case "ProductNumberCode":
+		v = p.ProductNumberCode
+	}
+
+	return &v
+}
+
+// ExportSaft returns the SAF-T MasterFiles products for the requested fiscal period.
+// Each product is exported as it existed at the end of the period, or at the moment it stopped being valid within it.
+func (this *ProductService) ExportSaft(request *mrequest.SaftExport) (*msaft.AuditFile, *mresponse.ErrorResponse) {
+
+	cursor, err := this.productRepository.ListForPeriod(request.StartDate, request.PeriodEnd())
+
+	if err != nil {
+		e := errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		return nil, e
+	}
+
+	products := []*msaft.Product{}
+
+	for cursor.Next(context.Background()) {
+		p := msaft.Product{}
+		err := cursor.Decode(&p)
+		if err != nil {
+			errR := errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+			return nil, errR
+		}
+
+		products = append(products, &p)
+	}
+
+	return &msaft.AuditFile{Products: products}, nil
+}
+
+func parseProductID(id string) (objectid.ObjectID, *mresponse.ErrorResponse) {
+	oid, err := objectid.FromHex(id)
+	if err != nil {
+		details := []mresponse.ErrorDetail{
+			mresponse.ErrorDetail{
+				Property: "id",
+				Message:  "Must be a valid product id",
+			},
+		}
+		return oid, errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	return oid, nil
+}
+
+// handleProductError maps repository errors on operations over a single product to the App error response
+func handleProductError(err error) *mresponse.ErrorResponse {
+	switch err {
+	case mongo.ErrNoDocuments:
+		return errors.HandleErrorResponse(errors.NOT_FOUND, nil, "Product not found")
+	case repositories.ErrVersionMismatch:
+		return errors.HandleErrorResponse(errors.PRECONDITION_FAILED, nil, "")
+	case repositories.ErrArchived:
+		return errors.HandleErrorResponse(errors.CONFLICT, nil, "Product is archived, restore it first")
+	case repositories.ErrNotArchived:
+		return errors.HandleErrorResponse(errors.CONFLICT, nil, "Product is not archived")
+	default:
+		return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+}
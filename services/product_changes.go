@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"log"
+	"products/models/request"
+	"products/models/response"
+	"products/util/errors"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+)
+
+// productChangeEvent is an event of the products change stream
+type productChangeEvent struct {
+	ID            *bson.Document `bson:"_id"`
+	OperationType string         `bson:"operationType"`
+	DocumentKey   struct {
+		ID objectid.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	UpdateDescription struct {
+		UpdatedFields *bson.Document `bson:"updatedFields"`
+	} `bson:"updateDescription"`
+	FullDocument *mresponse.ProductRead `bson:"fullDocument"`
+	ClusterTime  bson.Timestamp         `bson:"clusterTime"`
+}
+
+// operation is the feed operation of the event: updates setting ArchivedAt archive the product and updates clearing it restore it
+func (e *productChangeEvent) operation() string {
+	if e.OperationType != mresponse.ChangeUpdate || e.UpdateDescription.UpdatedFields == nil {
+		return e.OperationType
+	}
+
+	archivedAt, err := e.UpdateDescription.UpdatedFields.LookupErr("ArchivedAt")
+	if err != nil {
+		return e.OperationType
+	}
+
+	if archivedAt.Type() == bson.TypeNull {
+		return mresponse.ChangeRestore
+	}
+
+	return mresponse.ChangeArchive
+}
+
+// Watch returns the changes of products as they happen, until ctx is done or the change stream fails.
+// The channel is closed when the feed ends, clients resume it with the token of the last change they got.
+func (this *ProductService) Watch(ctx context.Context, request *mrequest.ChangesRequest) (<-chan *mresponse.ProductChange, *mresponse.ErrorResponse) {
+
+	cursor, err := this.productRepository.Watch(ctx, request)
+
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	changes := make(chan *mresponse.ProductChange)
+
+	go func() {
+		defer close(changes)
+		defer cursor.Close(context.Background())
+
+		for cursor.Next(ctx) {
+			event := productChangeEvent{}
+			err := cursor.Decode(&event)
+			if err != nil {
+				log.Printf("Error decoding product change: %s\n", err.Error())
+				return
+			}
+
+			change := mresponse.ProductChange{
+				Token:     mrequest.EncodeResumeToken(event.ID),
+				Operation: event.operation(),
+				ProductID: event.DocumentKey.ID.Hex(),
+			}
+			if event.ClusterTime.T != 0 {
+				occurredAt := time.Unix(int64(event.ClusterTime.T), 0).UTC()
+				change.OccurredAt = &occurredAt
+			}
+
+			// products removed from the database before the lookup have no full document
+			if event.FullDocument != nil {
+				change.Product = event.FullDocument
+				change.Product.ID = change.ProductID
+				if change.Product.Status == "" {
+					change.Product.Status = mresponse.StatusActive
+				}
+			}
+
+			select {
+			case changes <- &change:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		err := cursor.Err()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Product change stream closed: %s\n", err.Error())
+		}
+	}()
+
+	return changes, nil
+}
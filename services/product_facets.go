@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"products/helper"
+	"products/models/request"
+	"products/models/response"
+	"products/util/errors"
+	"sort"
+	"strings"
+)
+
+// productFacetsResult is the document returned by the products facets aggregation
+type productFacetsResult struct {
+	ProductType  []*mresponse.FacetCount `bson:"ProductType"`
+	ProductGroup []*mresponse.FacetCount `bson:"ProductGroup"`
+	Customs      []struct {
+		Total    int64 `bson:"total"`
+		CNCode   int64 `bson:"CNCode"`
+		UNNumber int64 `bson:"UNNumber"`
+	} `bson:"Customs"`
+}
+
+// Facets returns the counts of the products matching the list request by ProductType and ProductGroup,
+// and how many have CN codes and UN numbers. Pagination is not applied.
+func (this *ProductService) Facets(request *mrequest.ListRequest) (*mresponse.ProductFacets, *mresponse.ErrorResponse) {
+
+	e := this.filterGroupSubtree(request)
+	if e != nil {
+		return nil, e
+	}
+
+	cursor, err := this.productRepository.Facets(request)
+
+	if err != nil {
+		e := errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		return nil, e
+	}
+
+	doc := productFacetsResult{}
+	if cursor.Next(context.Background()) {
+		err := cursor.Decode(&doc)
+		if err != nil {
+			errR := errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+			return nil, errR
+		}
+	}
+
+	res := mresponse.ProductFacets{
+		ProductType:  []*mresponse.FacetCount{},
+		ProductGroup: []*mresponse.FacetCount{},
+	}
+
+	if doc.ProductType != nil {
+		res.ProductType = doc.ProductType
+	}
+
+	if doc.ProductGroup != nil {
+		res.ProductGroup = doc.ProductGroup
+	}
+
+	// no products matched if there are no customs counts
+	if len(doc.Customs) > 0 {
+		customs := doc.Customs[0]
+		res.Total = customs.Total
+		res.CNCode = mresponse.PresenceCount{With: customs.CNCode, Without: customs.Total - customs.CNCode}
+		res.UNNumber = mresponse.PresenceCount{With: customs.UNNumber, Without: customs.Total - customs.UNNumber}
+	}
+
+	return &res, nil
+}
+
+// Distinct returns the distinct values of a product field, optionally starting with a prefix,
+// and with the number of products having each value if requested
+func (this *ProductService) Distinct(request *mrequest.DistinctRequest) (*mresponse.DistinctList, *mresponse.ErrorResponse) {
+	res := mresponse.DistinctList{
+		Field: request.Field,
+		Items: []*mresponse.DistinctValue{},
+	}
+
+	if request.WithCounts {
+		cursor, err := this.productRepository.CountDistinct(request)
+
+		if err != nil {
+			e := errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+			return nil, e
+		}
+
+		for cursor.Next(context.Background()) {
+			doc := mresponse.DistinctValue{}
+			err := cursor.Decode(&doc)
+			if err != nil {
+				errR := errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+				return nil, errR
+			}
+
+			res.Items = append(res.Items, &doc)
+		}
+
+		return &res, nil
+	}
+
+	values, err := this.productRepository.Distinct(request)
+
+	if err != nil {
+		e := errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		return nil, e
+	}
+
+	// array fields have all the values of the products matching the prefix, not only the ones with it
+	prefix := strings.ToLower(request.Prefix)
+	for _, v := range values {
+		value, ok := v.(string)
+		if !ok || value == "" || !strings.HasPrefix(strings.ToLower(value), prefix) {
+			continue
+		}
+
+		res.Items = append(res.Items, &mresponse.DistinctValue{Value: value})
+	}
+
+	sort.SliceStable(res.Items, func(i, j int) bool {
+		a, b := helper.Fold(res.Items[i].Value), helper.Fold(res.Items[j].Value)
+		if a == b {
+			return res.Items[i].Value < res.Items[j].Value
+		}
+		return a < b
+	})
+
+	return &res, nil
+}
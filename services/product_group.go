@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"log"
+	"products/config"
+	"products/models/request"
+	"products/models/response"
+	"products/repositories"
+	"products/util/errors"
+
+	"github.com/mongodb/mongo-go-driver/mongo"
+)
+
+// ProductGroupServiceContract is the abstraction for service layer on product groups resource
+type ProductGroupServiceContract interface {
+	CreateOne(request *mrequest.ProductGroupCreate) (*mresponse.ProductGroup, *mresponse.ErrorResponse)
+	ReadOne(code string) (*mresponse.ProductGroup, *mresponse.ErrorResponse)
+	List(parent *string) (*mresponse.ProductGroupList, *mresponse.ErrorResponse)
+	UpdateOne(code string, request *mrequest.ProductGroupUpdate) (*mresponse.ProductGroup, *mresponse.ErrorResponse)
+	DeleteOne(code string) *mresponse.ErrorResponse
+	Subtree(code string) ([]string, *mresponse.ErrorResponse)
+	ValidateReference(code string) *mresponse.ErrorResponse
+}
+
+// ProductGroupService is the layer between http client and repository for product groups resource
+type ProductGroupService struct {
+	groupRepository repositories.ProductGroupRepositoryContract
+	validation      string
+}
+
+// NewProductGroupService is the constructor of ProductGroupService
+func NewProductGroupService(gr repositories.ProductGroupRepositoryContract, cf *config.Config) ProductGroupServiceContract {
+	return &ProductGroupService{
+		groupRepository: gr,
+		validation:      cf.ProductGroupValidation,
+	}
+}
+
+// CreateOne saves a new group under its parent, if any
+func (this *ProductGroupService) CreateOne(request *mrequest.ProductGroupCreate) (*mresponse.ProductGroup, *mresponse.ErrorResponse) {
+
+	// validate request
+	e := errors.ValidateRequest(request)
+	if e != nil {
+		return nil, e
+	}
+
+	_, err := this.groupRepository.ReadByCode(request.Code)
+	if err == nil {
+		return nil, errors.HandleErrorResponse(errors.DUPLICATED_ENTITY, nil, "Product group already exists")
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	request.Ancestors, e = this.ancestors(request.Code, request.Parent)
+	if e != nil {
+		return nil, e
+	}
+
+	_, err = this.groupRepository.CreateOne(request)
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	return this.ReadOne(request.Code)
+}
+
+// ReadOne returns the group with the provided code
+func (this *ProductGroupService) ReadOne(code string) (*mresponse.ProductGroup, *mresponse.ErrorResponse) {
+	g, err := this.groupRepository.ReadByCode(code)
+
+	if err != nil {
+		return nil, handleGroupError(err)
+	}
+
+	g.ID = g.IDdb.Hex()
+
+	return g, nil
+}
+
+// List returns all the groups if parent is nil, the root groups if it's empty or else the children of parent
+func (this *ProductGroupService) List(parent *string) (*mresponse.ProductGroupList, *mresponse.ErrorResponse) {
+	cursor, err := this.groupRepository.List(parent)
+
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	docs := []*mresponse.ProductGroup{}
+
+	for cursor.Next(context.Background()) {
+		doc := mresponse.ProductGroup{}
+		err := cursor.Decode(&doc)
+		if err != nil {
+			return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		}
+
+		doc.ID = doc.IDdb.Hex()
+		docs = append(docs, &doc)
+	}
+
+	return &mresponse.ProductGroupList{Items: &docs}, nil
+}
+
+// UpdateOne renames the group and, if its parent changes, moves it along with its subgroups
+func (this *ProductGroupService) UpdateOne(code string, request *mrequest.ProductGroupUpdate) (*mresponse.ProductGroup, *mresponse.ErrorResponse) {
+
+	// validate request
+	e := errors.ValidateRequest(request)
+	if e != nil {
+		return nil, e
+	}
+
+	current, e := this.ReadOne(code)
+	if e != nil {
+		return nil, e
+	}
+
+	ancestors, e := this.ancestors(code, request.Parent)
+	if e != nil {
+		return nil, e
+	}
+
+	_, err := this.groupRepository.UpdateOne(code, request, ancestors)
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	if current.Parent != request.Parent {
+		err = this.moveDescendants(code, ancestors)
+		if err != nil {
+			return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		}
+	}
+
+	return this.ReadOne(code)
+}
+
+// DeleteOne removes a group without subgroups nor products
+func (this *ProductGroupService) DeleteOne(code string) *mresponse.ErrorResponse {
+	_, e := this.ReadOne(code)
+	if e != nil {
+		return e
+	}
+
+	children, err := this.groupRepository.CountChildren(code)
+	if err != nil {
+		return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+	if children > 0 {
+		return errors.HandleErrorResponse(errors.CONFLICT, nil, "Product group has subgroups, move or delete them first")
+	}
+
+	products, err := this.groupRepository.CountProducts(code)
+	if err != nil {
+		return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+	if products > 0 {
+		return errors.HandleErrorResponse(errors.CONFLICT, nil, "Product group has products, move them to another group first")
+	}
+
+	_, err = this.groupRepository.DeleteOne(code)
+	if err != nil {
+		return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	return nil
+}
+
+// Subtree returns the code of the group followed by the codes of all the groups below it
+func (this *ProductGroupService) Subtree(code string) ([]string, *mresponse.ErrorResponse) {
+	_, e := this.ReadOne(code)
+	if e != nil {
+		return nil, e
+	}
+
+	cursor, err := this.groupRepository.Descendants(code)
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	codes := []string{code}
+
+	for cursor.Next(context.Background()) {
+		doc := mresponse.ProductGroup{}
+		err := cursor.Decode(&doc)
+		if err != nil {
+			return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		}
+
+		codes = append(codes, doc.Code)
+	}
+
+	return codes, nil
+}
+
+// ValidateReference checks a product references an existing group, according to the configured validation mode:
+// strict rejects unknown groups, warn only logs them and off does not check. Products without group are always valid.
+func (this *ProductGroupService) ValidateReference(code string) *mresponse.ErrorResponse {
+	if code == "" || this.validation == config.GroupValidationOff {
+		return nil
+	}
+
+	_, err := this.groupRepository.ReadByCode(code)
+	if err == nil {
+		return nil
+	}
+
+	if this.validation == config.GroupValidationWarn {
+		log.Printf("Product references product group %s which can't be found: %s\n", code, err.Error())
+		return nil
+	}
+
+	if err != mongo.ErrNoDocuments {
+		return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	details := []mresponse.ErrorDetail{
+		mresponse.ErrorDetail{
+			Property: "ProductGroup",
+			Message:  "Must be the code of an existing product group",
+		},
+	}
+	return errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+}
+
+// ancestors returns the ancestors of a group placed under parent, checking parent exists and is not the group itself or one of its subgroups
+func (this *ProductGroupService) ancestors(code string, parent string) ([]string, *mresponse.ErrorResponse) {
+	if parent == "" {
+		return []string{}, nil
+	}
+
+	p, err := this.groupRepository.ReadByCode(parent)
+	if err == mongo.ErrNoDocuments {
+		return nil, invalidParent("Must be the code of an existing product group")
+	}
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	ancestors := append(p.Ancestors, p.Code)
+	for _, a := range ancestors {
+		if a == code {
+			return nil, invalidParent("Cannot be the group itself or one of its subgroups")
+		}
+	}
+
+	return ancestors, nil
+}
+
+// moveDescendants updates the ancestors of the groups below a group moved to new ancestors
+func (this *ProductGroupService) moveDescendants(code string, ancestors []string) error {
+	cursor, err := this.groupRepository.Descendants(code)
+	if err != nil {
+		return err
+	}
+
+	for cursor.Next(context.Background()) {
+		doc := mresponse.ProductGroup{}
+		err := cursor.Decode(&doc)
+		if err != nil {
+			return err
+		}
+
+		// keep the path below the moved group
+		moved := append([]string{}, ancestors...)
+		for i, a := range doc.Ancestors {
+			if a == code {
+				moved = append(moved, doc.Ancestors[i:]...)
+				break
+			}
+		}
+
+		_, err = this.groupRepository.SetAncestors(doc.Code, moved)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func invalidParent(message string) *mresponse.ErrorResponse {
+	details := []mresponse.ErrorDetail{
+		mresponse.ErrorDetail{
+			Property: "parent",
+			Message:  message,
+		},
+	}
+	return errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+}
+
+// handleGroupError maps repository errors on operations over a single group to the App error response
+func handleGroupError(err error) *mresponse.ErrorResponse {
+	if err == mongo.ErrNoDocuments {
+		return errors.HandleErrorResponse(errors.NOT_FOUND, nil, "Product group not found")
+	}
+
+	return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+}
@@ -0,0 +1,265 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"products/config"
+	"products/models/request"
+	"products/models/response"
+	"products/repositories"
+	"testing"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"go.uber.org/dig"
+)
+
+// Mock ProductGroupRepository behaviour, with the tree:
+// hardware > tools > drills, product-group and some-product-group
+type ProductGroupRepositoryMock struct {
+	groups map[string]*mresponse.ProductGroup
+}
+
+func NewProductGroupRepositoryMock() repositories.ProductGroupRepositoryContract {
+	groups := map[string]*mresponse.ProductGroup{}
+	for _, g := range []mresponse.ProductGroup{
+		{Code: "hardware", Name: "Hardware", Ancestors: []string{}},
+		{Code: "tools", Name: "Tools", Parent: "hardware", Ancestors: []string{"hardware"}},
+		{Code: "drills", Name: "Drills", Parent: "tools", Ancestors: []string{"hardware", "tools"}},
+		{Code: "product-group", Name: "Product group", Ancestors: []string{}},
+		{Code: "some-product-group", Name: "Some product group", Ancestors: []string{}},
+	} {
+		group := g
+		group.IDdb = objectid.New()
+		groups[g.Code] = &group
+	}
+
+	return &ProductGroupRepositoryMock{groups: groups}
+}
+
+func (grm *ProductGroupRepositoryMock) CreateOne(request *mrequest.ProductGroupCreate) (*mongo.InsertOneResult, error) {
+	if request.Code == "error-on-create" {
+		return nil, errors.New("error ocurred on repository")
+	}
+
+	grm.groups[request.Code] = &mresponse.ProductGroup{IDdb: objectid.New(), Code: request.Code, Name: request.Name, Parent: request.Parent, Ancestors: request.Ancestors}
+
+	return &mongo.InsertOneResult{}, nil
+}
+
+func (grm *ProductGroupRepositoryMock) ReadByCode(code string) (*mresponse.ProductGroup, error) {
+	g, ok := grm.groups[code]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	copy := *g
+	return &copy, nil
+}
+
+func (grm *ProductGroupRepositoryMock) List(parent *string) (mongo.Cursor, error) {
+	cursor := MongoCursorMock{
+		Size:     len(grm.groups),
+		Position: 0,
+	}
+	return &cursor, nil
+}
+
+func (grm *ProductGroupRepositoryMock) Descendants(code string) (mongo.Cursor, error) {
+	cursor := MongoCursorMock{
+		Size:     0,
+		Position: 0,
+	}
+	return &cursor, nil
+}
+
+func (grm *ProductGroupRepositoryMock) UpdateOne(code string, request *mrequest.ProductGroupUpdate, ancestors []string) (*mongo.UpdateResult, error) {
+	g := grm.groups[code]
+	g.Name, g.Parent, g.Ancestors = request.Name, request.Parent, ancestors
+
+	return &mongo.UpdateResult{MatchedCount: 1}, nil
+}
+
+func (grm *ProductGroupRepositoryMock) SetAncestors(code string, ancestors []string) (*mongo.UpdateResult, error) {
+	return &mongo.UpdateResult{MatchedCount: 1}, nil
+}
+
+func (grm *ProductGroupRepositoryMock) DeleteOne(code string) (*mongo.DeleteResult, error) {
+	delete(grm.groups, code)
+
+	return &mongo.DeleteResult{DeletedCount: 1}, nil
+}
+
+func (grm *ProductGroupRepositoryMock) CountChildren(code string) (int64, error) {
+	var count int64
+	for _, g := range grm.groups {
+		if g.Parent == code {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (grm *ProductGroupRepositoryMock) CountProducts(code string) (int64, error) {
+	if code == "drills" {
+		return 3, nil
+	}
+
+	return 0, nil
+}
+
+func newTestConfig(validation string) func() *config.Config {
+	return func() *config.Config {
+		return &config.Config{ProductGroupValidation: validation}
+	}
+}
+
+// dependency injection provided
+func buildTestProductGroupContainer(validation string) *dig.Container {
+
+	container := dig.New()
+
+	// product group repository
+	err := container.Provide(NewProductGroupRepositoryMock)
+	if err != nil {
+		panic(err)
+	}
+
+	// config
+	err = container.Provide(newTestConfig(validation))
+	if err != nil {
+		panic(err)
+	}
+
+	// product group service
+	err = container.Provide(NewProductGroupService)
+	if err != nil {
+		panic(err)
+	}
+
+	return container
+}
+
+func TestCreateProductGroup(t *testing.T) {
+	container := buildTestProductGroupContainer(config.GroupValidationStrict)
+
+	err := container.Invoke(func(gs ProductGroupServiceContract) {
+		succ, err := gs.CreateOne(&mrequest.ProductGroupCreate{Code: "screwdrivers", Name: "Screwdrivers", Parent: "tools"})
+		if err != nil {
+			t.Fatalf("Expected no error but got %v", err)
+		}
+
+		if succ.ID == "" || len(succ.Ancestors) != 2 || succ.Ancestors[0] != "hardware" || succ.Ancestors[1] != "tools" {
+			t.Fatalf("Unexpected group %v", succ)
+		}
+
+		_, err = gs.CreateOne(&mrequest.ProductGroupCreate{Code: "tools", Name: "Tools"})
+		if err == nil || err.HttpCode != 409 {
+			t.Fatal("Expected a duplicated group error")
+		}
+
+		_, err = gs.CreateOne(&mrequest.ProductGroupCreate{Code: "saws", Name: "Saws", Parent: "unknown"})
+		if err == nil || err.HttpCode != 400 || err.Errors[0].Property != "parent" {
+			t.Fatal("Expected an invalid parent error")
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestUpdateProductGroupCycle(t *testing.T) {
+	container := buildTestProductGroupContainer(config.GroupValidationStrict)
+
+	err := container.Invoke(func(gs ProductGroupServiceContract) {
+		for _, parent := range []string{"hardware", "drills"} {
+			_, err := gs.UpdateOne("hardware", &mrequest.ProductGroupUpdate{Name: "Hardware", Parent: parent})
+			if err == nil || err.HttpCode != 400 || err.Errors[0].Property != "parent" {
+				t.Fatalf("Expected an invalid parent error moving hardware under %s", parent)
+			}
+		}
+
+		succ, err := gs.UpdateOne("drills", &mrequest.ProductGroupUpdate{Name: "Drills", Parent: "hardware"})
+		if err != nil {
+			t.Fatalf("Expected no error but got %v", err)
+		}
+
+		if succ.Parent != "hardware" || len(succ.Ancestors) != 1 {
+			t.Fatalf("Unexpected group %v", succ)
+		}
+
+		_, err = gs.UpdateOne("unknown", &mrequest.ProductGroupUpdate{Name: "Unknown"})
+		if err == nil || err.HttpCode != 404 {
+			t.Fatal("Expected a not found error")
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestDeleteProductGroup(t *testing.T) {
+	container := buildTestProductGroupContainer(config.GroupValidationStrict)
+
+	err := container.Invoke(func(gs ProductGroupServiceContract) {
+		// hardware has subgroups and drills has products
+		for _, code := range []string{"hardware", "drills"} {
+			err := gs.DeleteOne(code)
+			if err == nil || err.HttpCode != 409 {
+				t.Fatalf("Expected a conflict deleting %s", code)
+			}
+		}
+
+		err := gs.DeleteOne("product-group")
+		if err != nil {
+			t.Fatalf("Expected no error but got %v", err)
+		}
+
+		_, err = gs.ReadOne("product-group")
+		if err == nil || err.HttpCode != 404 {
+			t.Fatal("Expected a not found error")
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestValidateReference(t *testing.T) {
+	expected := map[string]bool{
+		config.GroupValidationStrict: false,
+		config.GroupValidationWarn:   true,
+		config.GroupValidationOff:    true,
+	}
+
+	for validation, valid := range expected {
+		container := buildTestProductGroupContainer(validation)
+
+		err := container.Invoke(func(gs ProductGroupServiceContract) {
+			if gs.ValidateReference("tools") != nil || gs.ValidateReference("") != nil {
+				t.Fatalf("Expected existing groups to be valid with %s validation", validation)
+			}
+
+			err := gs.ValidateReference("unknown")
+			if (err == nil) != valid {
+				t.Fatalf("Unexpected validation of unknown group with %s validation: %v", validation, err)
+			}
+
+			if err != nil && (err.HttpCode != 400 || err.Errors[0].Property != "ProductGroup") {
+				t.Fatalf("Unexpected error %v", err)
+			}
+		})
+
+		if err != nil {
+			log.Println(err.Error())
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"products/helper"
+	"products/models/request"
+	"products/models/response"
+	"products/util/errors"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-go-driver/mongo"
+)
+
+// importBatchSize is how many valid rows are saved at once, so files are imported without being fully read into memory
+const importBatchSize = 500
+
+// ImportCSV creates products from the rows of a CSV file read from r, saving them in batches as the file is read.
+// Invalid rows are reported and skipped, the other rows are still imported.
+func (this *ProductService) ImportCSV(r io.Reader, request *mrequest.CSVImport) (*mresponse.ImportResult, *mresponse.ErrorResponse) {
+	reader, e := newImportReader(r, request)
+	if e != nil {
+		return nil, e
+	}
+
+	res := mresponse.ImportResult{DryRun: request.DryRun, Errors: []*mresponse.ImportRowError{}}
+	batch := []*mrequest.ProductCreate{}
+	batchRows := []int64{}
+
+	for {
+		p, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !isParseError(err) {
+			return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, err.Error())
+		}
+		res.Rows++
+
+		if err != nil {
+			res.Failed++
+			res.Errors = append(res.Errors, &mresponse.ImportRowError{Row: reader.row, Message: err.Error()})
+			continue
+		}
+
+		e := this.validateImported(p)
+		if e != nil && len(e.Errors) == 0 {
+			return nil, e
+		}
+		if e != nil {
+			res.Failed++
+			res.Errors = append(res.Errors, rowErrors(reader.row, e.Errors)...)
+			continue
+		}
+
+		res.Valid++
+		batch = append(batch, p)
+		batchRows = append(batchRows, reader.row)
+
+		if len(batch) == importBatchSize {
+			e = this.saveImported(batch, batchRows, &res)
+			if e != nil {
+				return nil, e
+			}
+			batch, batchRows = batch[:0], batchRows[:0]
+		}
+	}
+
+	e = this.saveImported(batch, batchRows, &res)
+	if e != nil {
+		return nil, e
+	}
+
+	return &res, nil
+}
+
+// validateImported validates an imported product like CreateOne does, setting the default status
+func (this *ProductService) validateImported(p *mrequest.ProductCreate) *mresponse.ErrorResponse {
+	e := errors.ValidateRequest(p)
+	if e != nil {
+		return e
+	}
+
+	e = this.groupService.ValidateReference(p.ProductGroup)
+	if e != nil {
+		return e
+	}
+
+	if p.Status == "" {
+		p.Status = mresponse.StatusActive
+	}
+
+	return nil
+}
+
+// saveImported inserts a batch of valid products, unless it's a dry run, reporting the rows that couldn't be inserted
+func (this *ProductService) saveImported(batch []*mrequest.ProductCreate, rows []int64, res *mresponse.ImportResult) *mresponse.ErrorResponse {
+	if len(batch) == 0 || res.DryRun {
+		return nil
+	}
+
+	inserted := int64(len(batch))
+
+	_, err := this.productRepository.InsertMany(&batch)
+	if err != nil {
+		bulkErr, ok := err.(mongo.BulkWriteError)
+		if !ok {
+			message := "Import stopped at row " + strconv.FormatInt(rows[0], 10) + ", previous rows were imported: " + err.Error()
+			return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, message)
+		}
+
+		for _, writeErr := range bulkErr.WriteErrors {
+			res.Errors = append(res.Errors, &mresponse.ImportRowError{Row: rows[writeErr.Index], Message: writeErr.Message})
+		}
+		inserted -= int64(len(bulkErr.WriteErrors))
+	}
+
+	res.Imported += inserted
+	res.Failed += int64(len(batch)) - inserted
+
+	return nil
+}
+
+// importReader reads the products of the rows of an imported CSV file
+type importReader struct {
+	reader          *csv.Reader
+	columns         []string
+	valuesDelimiter string
+	row             int64 // number of the last row read, the header is row 1
+}
+
+// newImportReader starts reading a CSV file, mapping the columns of its header row to product fields
+func newImportReader(r io.Reader, request *mrequest.CSVImport) (*importReader, *mresponse.ErrorResponse) {
+	decoded, err := helper.NewDecoder(r, request.Encoding)
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, err.Error())
+	}
+
+	reader := csv.NewReader(decoded)
+	reader.Comma = request.Delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, "File is empty, the first row must have the column headers")
+	}
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, "Error reading the header row: "+err.Error())
+	}
+
+	columns, err := request.Columns(header)
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, err.Error())
+	}
+
+	return &importReader{reader: reader, columns: columns, valuesDelimiter: request.ValuesDelimiter, row: 1}, nil
+}
+
+// next returns the product of the next row that's not blank, or io.EOF at the end of the file.
+// Rows that can't be parsed return a *csv.ParseError, the following rows can still be read.
+func (this *importReader) next() (*mrequest.ProductCreate, error) {
+	for {
+		record, err := this.reader.Read()
+		if err == io.EOF {
+			return nil, err
+		}
+		this.row++
+
+		if err != nil && !isParseError(err) {
+			return nil, fmt.Errorf("Error reading row %d: %s", this.row, err.Error())
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !isBlankRecord(record) {
+			return productFromRecord(record, this.columns, this.valuesDelimiter), nil
+		}
+	}
+}
+
+func isParseError(err error) bool {
+	_, ok := err.(*csv.ParseError)
+	return ok
+}
+
+// rowErrors returns the validation error details of a row as row errors
+func rowErrors(row int64, details []mresponse.ErrorDetail) []*mresponse.ImportRowError {
+	res := []*mresponse.ImportRowError{}
+	for _, d := range details {
+		res = append(res, &mresponse.ImportRowError{Row: row, Property: d.Property, Message: d.Message})
+	}
+
+	return res
+}
+
+// productFromRecord builds a product from the values of a row, according to the field of each column
+func productFromRecord(record []string, columns []string, valuesDelimiter string) *mrequest.ProductCreate {
+	p := mrequest.ProductCreate{}
+
+	for i, value := range record {
+		if i >= len(columns) {
+			break
+		}
+		value = strings.TrimSpace(value)
+
+		switch columns[i] {
+		case "Status":
+			p.Status = value
+		case "ProductType":
+			p.ProductType = value
+		case "ProductCode":
+			p.ProductCode = value
+		case "ProductGroup":
+			p.ProductGroup = value
+		case "ProductDescription":
+			p.ProductDescription = value
+		case "ProductNumberCode":
+			p.ProductNumberCode = value
+		case "CustomsDetails.CNCode", "CustomsDetails.UNNumber":
+			values := splitValues(value, valuesDelimiter)
+			if len(values) == 0 {
+				continue
+			}
+			if p.CustomsDetails == nil {
+				p.CustomsDetails = &mrequest.CustomsDetails{CNCode: []string{}, UNNumber: []string{}}
+			}
+			if columns[i] == "CustomsDetails.CNCode" {
+				p.CustomsDetails.CNCode = values
+			} else {
+				p.CustomsDetails.UNNumber = values
+			}
+		}
+	}
+
+	return &p
+}
+
+// splitValues splits the values of a multi-valued column, leaving out blank values
+func splitValues(value string, delimiter string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, delimiter) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+func isBlankRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"html"
+	"products/helper"
+	"products/models/request"
+	"products/models/response"
+	"products/util/errors"
+	"strings"
+	"unicode"
+)
+
+const (
+	highlightPre  = "<em>"
+	highlightPost = "</em>"
+
+	// snippetLength is the maximum number of characters of a highlighted field, longer fields are cut around the first match
+	snippetLength = 160
+)
+
+// searchFields are the fields of the products text index, in the order highlights are looked for
+var searchFields = []string{"ProductDescription", "ProductCode", "ProductNumberCode", "ProductGroup"}
+
+// Search returns the products matching the full text query, most relevant first.
+// Each product comes with its relevance score and the matching fields with the searched terms highlighted.
+func (this *ProductService) Search(request *mrequest.SearchRequest) (*mresponse.ProductSearchList, *mresponse.ErrorResponse) {
+
+	total, perPage, page, cursor, err := this.productRepository.Search(request)
+
+	if err != nil {
+		e := errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		return nil, e
+	}
+
+	terms := searchTerms(request.Query)
+	hits := []*mresponse.ProductSearchHit{}
+
+	for cursor.Next(context.Background()) {
+		doc := mresponse.ProductRead{}
+		score := struct {
+			Score float64 `bson:"score"`
+		}{}
+
+		err := cursor.Decode(&doc)
+		if err == nil {
+			err = cursor.Decode(&score)
+		}
+		if err != nil {
+			errR := errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+			return nil, errR
+		}
+
+		doc.ID = doc.IDdb.Hex()
+		if doc.Status == "" {
+			doc.Status = mresponse.StatusActive
+		}
+
+		hits = append(hits, &mresponse.ProductSearchHit{
+			ProductRead: &doc,
+			Score:       score.Score,
+			Highlights:  highlights(&doc, terms),
+		})
+	}
+
+	resp := mresponse.ProductSearchList{
+		Total:   total,
+		PerPage: perPage,
+		Page:    page,
+		Items:   &hits,
+	}
+	return &resp, nil
+}
+
+// Suggest returns the products to be suggested while typing the provided prefix, sorted by ProductCode
+func (this *ProductService) Suggest(request *mrequest.SuggestRequest) (*mresponse.ProductSuggestList, *mresponse.ErrorResponse) {
+
+	cursor, err := this.productRepository.Suggest(request)
+
+	if err != nil {
+		e := errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		return nil, e
+	}
+
+	docs := []*mresponse.ProductSuggestion{}
+
+	for cursor.Next(context.Background()) {
+		doc := mresponse.ProductSuggestion{}
+		err := cursor.Decode(&doc)
+		if err != nil {
+			errR := errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+			return nil, errR
+		}
+
+		doc.ID = doc.IDdb.Hex()
+		docs = append(docs, &doc)
+	}
+
+	return &mresponse.ProductSuggestList{Items: &docs}, nil
+}
+
+// highlights returns the snippets of the product fields containing any of the terms
+func highlights(p *mresponse.ProductRead, terms []string) map[string]string {
+	values := map[string]string{
+		"ProductDescription": p.ProductDescription,
+		"ProductCode":        p.ProductCode,
+		"ProductNumberCode":  p.ProductNumberCode,
+		"ProductGroup":       p.ProductGroup,
+	}
+
+	res := map[string]string{}
+	for _, field := range searchFields {
+		if snippet, ok := highlight(values[field], terms); ok {
+			res[field] = snippet
+		}
+	}
+
+	if len(res) == 0 {
+		return nil
+	}
+
+	return res
+}
+
+// highlight wraps the words of value matching any of the terms with highlightPre and highlightPost.
+// The snippet is HTML, the text of value is escaped so only the highlight tags are markup.
+// Words are compared without accents and case, and match terms sharing the same stem (e.g. "parafusos" matches "parafuso").
+// It returns false if no word matched.
+func highlight(value string, terms []string) (string, bool) {
+	text := []rune(value)
+	type match struct{ start, end int }
+	matches := []match{}
+
+	for start := 0; start < len(text); {
+		if !isWordRune(text[start]) {
+			start++
+			continue
+		}
+
+		end := start
+		for end < len(text) && isWordRune(text[end]) {
+			end++
+		}
+
+		word := helper.Fold(string(text[start:end]))
+		for _, term := range terms {
+			if sameStem(word, term) {
+				matches = append(matches, match{start, end})
+				break
+			}
+		}
+
+		start = end
+	}
+
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	// cut long values around the first match
+	from, to := 0, len(text)
+	if len(text) > snippetLength {
+		from = matches[0].start - snippetLength/4
+		if from < 0 {
+			from = 0
+		}
+		to = from + snippetLength
+		if to > len(text) {
+			to = len(text)
+			from = to - snippetLength
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+
+	pos := from
+	for _, m := range matches {
+		if m.start < from || m.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(text[pos:m.start])))
+		b.WriteString(highlightPre)
+		b.WriteString(html.EscapeString(string(text[m.start:m.end])))
+		b.WriteString(highlightPost)
+		pos = m.end
+	}
+	b.WriteString(html.EscapeString(string(text[pos:to])))
+
+	if to < len(text) {
+		b.WriteString("…")
+	}
+
+	return b.String(), true
+}
+
+// searchTerms returns the folded terms of a text search query, ignoring negated terms and phrase quotes
+func searchTerms(query string) []string {
+	terms := []string{}
+
+	for _, t := range strings.Fields(query) {
+		if strings.HasPrefix(t, "-") {
+			continue
+		}
+
+		t = helper.Fold(strings.Trim(t, `"`))
+		if t != "" {
+			terms = append(terms, t)
+		}
+	}
+
+	return terms
+}
+
+// sameStem is a light stemming comparison, words share the same stem if they only differ
+// on their last two letters (plurals, gender) and have at least three letters in common
+func sameStem(word string, term string) bool {
+	w, t := []rune(word), []rune(term)
+
+	common := 0
+	for common < len(w) && common < len(t) && w[common] == t[common] {
+		common++
+	}
+
+	shortest := len(w)
+	if len(t) < shortest {
+		shortest = len(t)
+	}
+
+	min := shortest - 2
+	if min < 3 {
+		min = 3
+	}
+	if min > shortest {
+		min = shortest
+	}
+
+	return common >= min
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
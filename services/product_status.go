@@ -0,0 +1,78 @@
+package services
+
+import (
+	"log"
+	"products/models/response"
+	"products/util/errors"
+	"time"
+)
+
+// productStatusTransitions is the product lifecycle state machine, it maps each status to the statuses it can move to
+var productStatusTransitions = map[string][]string{
+	mresponse.StatusDraft:        []string{mresponse.StatusActive, mresponse.StatusBlocked},
+	mresponse.StatusActive:       []string{mresponse.StatusDiscontinued, mresponse.StatusBlocked},
+	mresponse.StatusBlocked:      []string{mresponse.StatusActive, mresponse.StatusDiscontinued},
+	mresponse.StatusDiscontinued: []string{mresponse.StatusActive},
+}
+
+// productStatusEvents maps each target status to the event produced when a product moves to it
+var productStatusEvents = map[string]string{
+	mresponse.StatusActive:       "product.activated",
+	mresponse.StatusDiscontinued: "product.discontinued",
+	mresponse.StatusBlocked:      "product.blocked",
+}
+
+// ChangeStatus moves the product to the provided status if the transition is allowed and the product is still at the provided version.
+// Each transition produces a product event.
+func (this *ProductService) ChangeStatus(id string, version int64, status string) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+
+	current, e := this.ReadOne(id, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+
+	if current.Version != version {
+		return nil, errors.HandleErrorResponse(errors.PRECONDITION_FAILED, nil, "")
+	}
+
+	if !canTransition(current.Status, status) {
+		return nil, errors.HandleErrorResponse(errors.CONFLICT, nil, "Product can not move from status "+current.Status+" to "+status)
+	}
+
+	_, err := this.productRepository.UpdateStatus(current.IDdb, version, status)
+
+	if err != nil {
+		return nil, handleProductError(err)
+	}
+
+	p, e := this.ReadOne(id, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+
+	event := mresponse.ProductEvent{
+		Type:       productStatusEvents[status],
+		ProductID:  id,
+		Version:    p.Version,
+		From:       current.Status,
+		To:         status,
+		OccurredAt: time.Now().UTC(),
+	}
+
+	err = this.eventPublisher.Publish(id, &event)
+	if err != nil {
+		log.Printf("Error publishing event %s of product %s: %s\n", event.Type, id, err.Error())
+	}
+
+	return p, nil
+}
+
+func canTransition(from string, to string) bool {
+	for _, allowed := range productStatusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+
+	return false
+}
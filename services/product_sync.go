@@ -0,0 +1,73 @@
+package services
+
+import (
+	"products/models/request"
+	"products/models/response"
+	"products/util/errors"
+
+	"github.com/mongodb/mongo-go-driver/mongo"
+)
+
+// UpsertByCode creates the product if there's none with its code, otherwise replaces the fields of the existing one.
+// Upstream systems identify products by code, they don't know the ids nor the versions of the catalog.
+func (this *ProductService) UpsertByCode(request *mrequest.ProductCreate) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+
+	// validate request
+	e := errors.ValidateRequest(request)
+	if e != nil {
+		return nil, e
+	}
+
+	current, e := this.readByCode(request.ProductCode)
+	if e != nil && e.Code != errors.NOT_FOUND {
+		return nil, e
+	}
+
+	if current == nil {
+		created, e := this.CreateOne(request)
+		if e != nil {
+			return nil, e
+		}
+
+		return this.ReadOne(created.ID, nil, nil)
+	}
+
+	return this.UpdateOne(current.ID, current.Version, &mrequest.ProductUpdate{
+		ProductType:        request.ProductType,
+		ProductCode:        request.ProductCode,
+		ProductGroup:       request.ProductGroup,
+		ProductDescription: request.ProductDescription,
+		ProductNumberCode:  request.ProductNumberCode,
+		CustomsDetails:     request.CustomsDetails,
+	})
+}
+
+// ArchiveByCode archives the product with the provided code, products already archived are left as they are
+func (this *ProductService) ArchiveByCode(code string, reason string) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	current, e := this.readByCode(code)
+	if e != nil {
+		return nil, e
+	}
+
+	if current.ArchivedAt != nil {
+		return current, nil
+	}
+
+	return this.ArchiveOne(current.ID, current.Version, reason)
+}
+
+// readByCode returns the current state of the product with the provided code
+func (this *ProductService) readByCode(code string) (*mresponse.ProductRead, *mresponse.ErrorResponse) {
+	found := mrequest.ProductRead{ProductCode: code}
+
+	_, err := this.productRepository.ReadOne(&found)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.HandleErrorResponse(errors.NOT_FOUND, nil, "Product not found")
+	}
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	return this.ReadOne(found.ID.Hex(), nil, nil)
+}
@@ -3,10 +3,14 @@ package services
 import (
 	"errors"
 	"log"
+	"net/url"
+	"products/config"
 	"products/models/request"
 	"products/models/response"
 	"products/repositories"
+	"strings"
 	"testing"
+	"time"
 
 	"context"
 
@@ -41,19 +45,93 @@ func (prm *ProductRepositoryMock) CreateOne(request *mrequest.ProductCreate) (*m
 }
 
 func (prm *ProductRepositoryMock) ReadOne(p *mrequest.ProductRead) (*mresponse.Product, error) {
-	return nil, nil
+	if p.ProductCode == "product-code-for-success" || p.ProductCode == "unknown-product-code" {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	p.ID, _ = objectid.FromHex("507f191e810c19729de860ec")
+
+	return &mresponse.Product{}, nil
+}
+
+func (prm *ProductRepositoryMock) ReadByID(id objectid.ObjectID, asOf *time.Time, projection *mrequest.Projection) (*mresponse.ProductRead, error) {
+	if id.Hex() == "507f191e810c19729de860eb" {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	res := mresponse.ProductRead{IDdb: id, Version: 3, ProductType: "P", ProductCode: "product-code", ProductGroup: "product-group", ProductDescription: "current-description", ProductNumberCode: "product-number-code"}
+	if asOf != nil {
+		res.ProductDescription = "old-description"
+	}
+
+	return &res, nil
+}
+
+func (prm *ProductRepositoryMock) UpdateOne(id objectid.ObjectID, version int64, request *mrequest.ProductUpdate) (*mongo.UpdateResult, error) {
+	if version != 3 {
+		return nil, repositories.ErrVersionMismatch
+	}
+
+	if request.ProductDescription == "description-that-cause-repository-error" {
+		return nil, errors.New("error ocurred on repository")
+	}
+
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (prm *ProductRepositoryMock) ArchiveOne(id objectid.ObjectID, version int64, reason string) (*mongo.UpdateResult, error) {
+	if id.Hex() == "507f191e810c19729de860eb" {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	if reason == "archived-product" {
+		return nil, repositories.ErrArchived
+	}
+
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (prm *ProductRepositoryMock) RestoreOne(id objectid.ObjectID, version int64) (*mongo.UpdateResult, error) {
+	if version != 4 {
+		return nil, repositories.ErrNotArchived
+	}
+
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (prm *ProductRepositoryMock) UpdateStatus(id objectid.ObjectID, version int64, status string) (*mongo.UpdateResult, error) {
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func (prm *ProductRepositoryMock) ListForPeriod(start time.Time, end time.Time) (mongo.Cursor, error) {
+	if end.Before(start) {
+		return nil, errors.New("invalid period")
+	}
+
+	cursor := MongoCursorMock{
+		Size:     2,
+		Position: 0,
+	}
+	return &cursor, nil
 }
 
 func (prm *ProductRepositoryMock) InsertMany(request *[]*mrequest.ProductCreate) (*mongo.InsertManyResult, error) {
 
 	res := mongo.InsertManyResult{}
 	res.InsertedIDs = make([]interface{}, 0)
+	writeErrors := mongo.WriteErrors{}
 	for i, productCreate := range *request {
 		if productCreate.ProductCode == "product-code-for-error" {
 			e := mongo.BulkWriteError{}
 			return nil, e
 		}
 
+		// unordered inserts go on after a duplicated key
+		if productCreate.ProductCode == "duplicated-code" {
+			writeErrors = append(writeErrors, mongo.WriteError{Index: i, Code: 11000, Message: "E11000 duplicate key error"})
+			continue
+		}
+
 		var id objectid.ObjectID
 		if i == 0 {
 			id, _ = objectid.FromHex("507f191e810c19729de860ea")
@@ -66,6 +144,10 @@ func (prm *ProductRepositoryMock) InsertMany(request *[]*mrequest.ProductCreate)
 		res.InsertedIDs = append(res.InsertedIDs, id)
 	}
 
+	if len(writeErrors) > 0 {
+		return &res, mongo.BulkWriteError{WriteErrors: writeErrors}
+	}
+
 	return &res, nil
 }
 
@@ -85,6 +167,15 @@ func (prm *ProductRepositoryMock) List(req *mrequest.ListRequest) (int64, int64,
 		return 3, 10, 3, &cursor, nil
 	}
 
+	// cursor mode returns one more product if there's a next page
+	if req.CursorMode {
+		cursor := MongoCursorMock{
+			Size:     req.PerPage + 1,
+			Position: 0,
+		}
+		return 0, int64(req.PerPage), 1, &cursor, nil
+	}
+
 	// successful cursor
 	if req.Page == 1 && req.PerPage == 10 {
 		cursor := MongoCursorMock{
@@ -97,6 +188,110 @@ func (prm *ProductRepositoryMock) List(req *mrequest.ListRequest) (int64, int64,
 	return 0, 0, 0, nil, nil
 }
 
+func (prm *ProductRepositoryMock) Export(req *mrequest.ListRequest) (mongo.Cursor, error) {
+	if len(req.Status) > 0 {
+		return nil, errors.New("error ocurred on repository")
+	}
+
+	cursor := MongoCursorMock{
+		Size:     3,
+		Position: 0,
+	}
+	return &cursor, nil
+}
+
+func (prm *ProductRepositoryMock) Search(req *mrequest.SearchRequest) (int64, int64, int64, mongo.Cursor, error) {
+	if req.Query == "query-that-cause-repository-error" {
+		return 0, 0, 0, nil, errors.New("error ocurred on repository")
+	}
+
+	cursor := MongoCursorMock{
+		Size:     2,
+		Position: 0,
+	}
+	return 2, int64(req.PerPage), int64(req.Page), &cursor, nil
+}
+
+func (prm *ProductRepositoryMock) Suggest(req *mrequest.SuggestRequest) (mongo.Cursor, error) {
+	if req.Prefix == "prefix-that-cause-repository-error" {
+		return nil, errors.New("error ocurred on repository")
+	}
+
+	cursor := MongoCursorMock{
+		Size:     req.Limit,
+		Position: 0,
+	}
+	return &cursor, nil
+}
+
+func (prm *ProductRepositoryMock) Facets(req *mrequest.ListRequest) (mongo.Cursor, error) {
+	if len(req.Status) > 0 {
+		return nil, errors.New("error ocurred on repository")
+	}
+
+	// no products match, the aggregation returns the facets without counts
+	cursor := MongoCursorMock{
+		Size:     1,
+		Position: 0,
+	}
+	return &cursor, nil
+}
+
+func (prm *ProductRepositoryMock) Distinct(req *mrequest.DistinctRequest) ([]interface{}, error) {
+	if req.Field == "CustomsDetails.CNCode" {
+		return []interface{}{"8501", "", "7318", "2710"}, nil
+	}
+
+	return []interface{}{"Ferragens", "Água", "ferramentas", nil}, nil
+}
+
+func (prm *ProductRepositoryMock) CountDistinct(req *mrequest.DistinctRequest) (mongo.Cursor, error) {
+	cursor := MongoCursorMock{
+		Size:     2,
+		Position: 0,
+	}
+	return &cursor, nil
+}
+
+func (prm *ProductRepositoryMock) Watch(ctx context.Context, req *mrequest.ChangesRequest) (mongo.Cursor, error) {
+	if req.ResumeAfter != nil {
+		return nil, errors.New("resume token was not found")
+	}
+
+	id, _ := objectid.FromHex("507f191e810c19729de860ea")
+	cursor := ChangeCursorMock{
+		Events: []*productChangeEvent{
+			&productChangeEvent{OperationType: mresponse.ChangeInsert, FullDocument: &mresponse.ProductRead{ProductCode: "PRF-001", Version: 1}},
+			&productChangeEvent{OperationType: mresponse.ChangeUpdate, FullDocument: &mresponse.ProductRead{ProductCode: "PRF-001", Version: 2}},
+			&productChangeEvent{OperationType: mresponse.ChangeUpdate},
+			&productChangeEvent{OperationType: mresponse.ChangeUpdate},
+		},
+	}
+	cursor.Events[1].UpdateDescription.UpdatedFields = bson.NewDocument(bson.EC.String("ProductDescription", "Screw"))
+	cursor.Events[2].UpdateDescription.UpdatedFields = bson.NewDocument(bson.EC.Time("ArchivedAt", time.Now()), bson.EC.String("ArchiveReason", "Replaced"))
+	cursor.Events[3].UpdateDescription.UpdatedFields = bson.NewDocument(bson.EC.Null("ArchivedAt"), bson.EC.String("ArchiveReason", ""))
+	for i, e := range cursor.Events {
+		e.DocumentKey.ID = id
+		e.ClusterTime = bson.Timestamp{T: 1551693600, I: uint32(i)}
+	}
+
+	return &cursor, nil
+}
+
+// Mock event publisher behaviour, events are kept to be inspected
+type EventPublisherMock struct {
+	Events []interface{}
+}
+
+func NewEventPublisherMock() EventPublisherContract {
+	return &EventPublisherMock{}
+}
+
+func (epm *EventPublisherMock) Publish(key string, event interface{}) error {
+	epm.Events = append(epm.Events, event)
+	return nil
+}
+
 // Mock Mongo cursor behaviour
 type MongoCursorMock struct {
 	Size     int
@@ -137,6 +332,28 @@ func (mc MongoCursorMock) Close(context.Context) error {
 	return nil
 }
 
+// Mock change stream behaviour, the events are decoded in order
+type ChangeCursorMock struct {
+	MongoCursorMock
+	Events []*productChangeEvent
+}
+
+func (mc *ChangeCursorMock) Next(context.Context) bool {
+	if mc.Position >= len(mc.Events) {
+		return false
+	}
+
+	mc.Position++
+
+	return true
+}
+
+func (mc *ChangeCursorMock) Decode(obj interface{}) error {
+	*obj.(*productChangeEvent) = *mc.Events[mc.Position-1]
+
+	return nil
+}
+
 // dependency injection provided
 func buildTestProductContainer() *dig.Container {
 
@@ -148,6 +365,26 @@ func buildTestProductContainer() *dig.Container {
 		panic(err)
 	}
 
+	// event publisher
+	err = container.Provide(NewEventPublisherMock)
+	if err != nil {
+		panic(err)
+	}
+
+	// product group service, strict so products can only reference the groups in the mock
+	err = container.Provide(NewProductGroupRepositoryMock)
+	if err != nil {
+		panic(err)
+	}
+	err = container.Provide(newTestConfig(config.GroupValidationStrict))
+	if err != nil {
+		panic(err)
+	}
+	err = container.Provide(NewProductGroupService)
+	if err != nil {
+		panic(err)
+	}
+
 	// product service
 	err = container.Provide(NewProductService)
 	if err != nil {
@@ -290,6 +527,28 @@ func TestCreateManyErrorOnRepository(t *testing.T) {
 	}
 }
 
+func TestCreateManyWriteErrors(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		req := []*mrequest.ProductCreate{
+			&mrequest.ProductCreate{ProductType: "P", ProductCode: "product-code-one", ProductGroup: "some-product-group"},
+			&mrequest.ProductCreate{ProductType: "P", ProductCode: "duplicated-code", ProductGroup: "some-product-group"},
+		}
+
+		_, e := ps.CreateMany(&req)
+
+		if e == nil || e.HttpCode != 409 || len(e.Errors) != 1 || e.Errors[0].Property != "[1]" || e.Errors[0].Message != "E11000 duplicate key error" {
+			t.Fatalf("Expected the duplicated product on the error details but got %v", e)
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
 func TestCreateManySuccess(t *testing.T) {
 	container := buildTestProductContainer()
 
@@ -402,7 +661,163 @@ func TestCreateListSuccess(t *testing.T) {
 			t.Fail()
 		}
 
-		if succ.Total != 2 || succ.PerPage != 10 || len(*succ.Items) != 2 || succ.Page != 1 {
+		if *succ.Total != 2 || succ.PerPage != 10 || len(*succ.Items) != 2 || succ.Page != 1 {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestReadOneInvalidID(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+
+		_, err := ps.ReadOne("not-an-object-id", nil, nil)
+
+		if err == nil || err.Code != "INVALID_REQUEST" {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestReadOneNotFound(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+
+		_, err := ps.ReadOne("507f191e810c19729de860eb", nil, nil)
+
+		if err == nil || err.HttpCode != 404 {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestReadOneAsOfSuccess(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+
+		asOf := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+		succ, err := ps.ReadOne("507f191e810c19729de860ea", &asOf, nil)
+
+		if err != nil {
+			t.Fail()
+		}
+
+		if succ.ID != "507f191e810c19729de860ea" || succ.ProductDescription != "old-description" {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestExportSaftSuccess(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+
+		req := mrequest.SaftExport{
+			StartDate: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2018, 12, 31, 0, 0, 0, 0, time.UTC),
+		}
+
+		succ, err := ps.ExportSaft(&req)
+
+		if err != nil {
+			t.Fail()
+		}
+
+		if len(succ.Products) != 2 {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestUpdateOneVersionMismatch(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		pu := mrequest.ProductUpdate{
+			ProductType:        "P",
+			ProductCode:        "product-code",
+			ProductGroup:       "some-product-group",
+			ProductDescription: "some-product-description",
+			ProductNumberCode:  "some-product-number-code",
+		}
+
+		_, err := ps.UpdateOne("507f191e810c19729de860ea", 2, &pu)
+
+		if err == nil || err.HttpCode != 412 {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestPatchOneValidatesMergedProduct(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		// patched description is too short for the resulting product
+		description := "x"
+		_, err := ps.PatchOne("507f191e810c19729de860ea", 3, &mrequest.ProductPatch{ProductDescription: &description})
+
+		if err == nil || err.Code != "INVALID_REQUEST" {
+			t.Fail()
+		}
+
+		// patched description is kept along with the other current fields
+		description = "description-that-cause-repository-error"
+		_, err = ps.PatchOne("507f191e810c19729de860ea", 3, &mrequest.ProductPatch{ProductDescription: &description})
+
+		if err == nil || err.Response != "error ocurred on repository" {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestPatchOneVersionMismatch(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		description := "some-product-description"
+		_, err := ps.PatchOne("507f191e810c19729de860ea", 1, &mrequest.ProductPatch{ProductDescription: &description})
+
+		if err == nil || err.Code != "PRECONDITION_FAILED" {
 			t.Fail()
 		}
 	})
@@ -412,3 +827,517 @@ func TestCreateListSuccess(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestArchiveOneNotFound(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		_, err := ps.ArchiveOne("507f191e810c19729de860eb", 3, "")
+
+		if err == nil || err.HttpCode != 404 {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestArchiveOneAlreadyArchived(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		_, err := ps.ArchiveOne("507f191e810c19729de860ea", 3, "archived-product")
+
+		if err == nil || err.Code != "CONFLICT" {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestRestoreOne(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		_, err := ps.RestoreOne("507f191e810c19729de860ea", 3)
+
+		if err == nil || err.Code != "CONFLICT" {
+			t.Fail()
+		}
+
+		succ, err := ps.RestoreOne("507f191e810c19729de860ea", 4)
+
+		if err != nil || succ.ID != "507f191e810c19729de860ea" {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestChangeStatusIllegalTransition(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract, ep EventPublisherContract) {
+		// mocked product has no status, so it is considered active
+		_, err := ps.ChangeStatus("507f191e810c19729de860ea", 3, mresponse.StatusDraft)
+
+		if err == nil || err.Code != "CONFLICT" {
+			t.Fail()
+		}
+
+		if len(ep.(*EventPublisherMock).Events) != 0 {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestChangeStatusPublishesEvent(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract, ep EventPublisherContract) {
+		_, err := ps.ChangeStatus("507f191e810c19729de860ea", 3, mresponse.StatusDiscontinued)
+
+		if err != nil {
+			t.Fail()
+		}
+
+		events := ep.(*EventPublisherMock).Events
+		if len(events) != 1 {
+			t.FailNow()
+		}
+
+		event := events[0].(*mresponse.ProductEvent)
+		if event.Type != "product.discontinued" || event.From != mresponse.StatusActive || event.To != mresponse.StatusDiscontinued {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestSearchSuccess(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		req := mrequest.SearchRequest{Query: "parafuso", PerPage: 10, Page: 1}
+
+		succ, err := ps.Search(&req)
+
+		if err != nil {
+			t.FailNow()
+		}
+
+		if succ.Total != 2 || len(*succ.Items) != 2 {
+			t.Fail()
+		}
+
+		req.Query = "query-that-cause-repository-error"
+		_, err = ps.Search(&req)
+
+		if err == nil || err.HttpCode != 500 {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	terms := searchTerms(`Parafusos "inox" -latão`)
+
+	res, ok := highlight("Parafuso sextavado em aço INÓX", terms)
+	if !ok || res != "<em>Parafuso</em> sextavado em aço <em>INÓX</em>" {
+		t.Fatalf("Unexpected highlight: %s", res)
+	}
+
+	// the snippets are HTML, markup on product fields is escaped
+	res, ok = highlight(`Parafuso <b>inox</b> & "anilha"`, terms)
+	if !ok || res != "<em>Parafuso</em> &lt;b&gt;<em>inox</em>&lt;/b&gt; &amp; &#34;anilha&#34;" {
+		t.Fatalf("Unexpected highlight of markup: %s", res)
+	}
+
+	_, ok = highlight("Porca em latão", terms)
+	if ok {
+		t.Fatal("Expected no match on negated terms")
+	}
+
+	long := strings.Repeat("abc ", 60) + "parafuso " + strings.Repeat("xyz ", 60)
+	res, ok = highlight(long, terms)
+	if !ok || !strings.HasPrefix(res, "…") || !strings.HasSuffix(res, "…") || !strings.Contains(res, "<em>parafuso</em>") {
+		t.Fatalf("Unexpected snippet: %s", res)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		req := mrequest.SuggestRequest{Prefix: "paraf", Limit: 3}
+
+		succ, err := ps.Suggest(&req)
+
+		if err != nil || len(*succ.Items) != 3 {
+			t.Fail()
+		}
+
+		req.Prefix = "prefix-that-cause-repository-error"
+		_, err = ps.Suggest(&req)
+
+		if err == nil || err.HttpCode != 500 {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestListCursorMode(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		req := mrequest.ListRequest{
+			PerPage:    2,
+			Page:       1,
+			Sort:       "ProductCode",
+			Order:      "normal",
+			SortKeys:   []*mrequest.SortKey{{Field: "ProductCode"}, {Field: "_id"}},
+			CursorMode: true,
+			SkipTotal:  true,
+		}
+
+		succ, err := ps.List(&req)
+
+		if err != nil {
+			t.FailNow()
+		}
+
+		if len(*succ.Items) != 2 || succ.Total != nil || succ.Page != 0 {
+			t.Fail()
+		}
+
+		next, e := mrequest.DecodeListCursor(succ.NextCursor)
+		if e != nil || next.Sort != "ProductCode" || next.Order != "normal" || len(next.Values) != 1 {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestFacets(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		req := mrequest.ListRequest{}
+
+		succ, err := ps.Facets(&req)
+
+		if err != nil {
+			t.FailNow()
+		}
+
+		if succ.Total != 0 || succ.ProductType == nil || succ.ProductGroup == nil || succ.CNCode.With != 0 {
+			t.Fail()
+		}
+
+		req.Status = []string{mresponse.StatusActive}
+		_, err = ps.Facets(&req)
+
+		if err == nil || err.HttpCode != 500 {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestDistinct(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		succ, err := ps.Distinct(&mrequest.DistinctRequest{Field: "ProductGroup"})
+
+		if err != nil || len(succ.Items) != 3 {
+			t.FailNow()
+		}
+
+		// sorted ignoring case and accents
+		if succ.Items[0].Value != "Água" || succ.Items[1].Value != "Ferragens" || succ.Items[2].Value != "ferramentas" {
+			t.Fail()
+		}
+
+		// array fields values not matching the prefix are left out
+		succ, err = ps.Distinct(&mrequest.DistinctRequest{Field: "CustomsDetails.CNCode", Prefix: "7"})
+
+		if err != nil || len(succ.Items) != 1 || succ.Items[0].Value != "7318" {
+			t.Fail()
+		}
+
+		succ, err = ps.Distinct(&mrequest.DistinctRequest{Field: "ProductGroup", WithCounts: true})
+
+		if err != nil || len(succ.Items) != 2 {
+			t.Fail()
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		params := url.Values{
+			"delimiter":        {"semicolon"},
+			"encoding":         {"windows-1252"},
+			"map[Código]":      {"ProductCode"},
+			"map[Descrição]":   {"ProductDescription"},
+			"map[NC]":          {"CNCode"},
+			"values_delimiter": {","},
+		}
+		req, e := mrequest.NewCSVImport(params, "text/csv")
+		if e != nil {
+			t.Fatalf("Expected no error but got %v", e.Errors)
+		}
+
+		// Windows-1252 encoded file: ó is 0xF3, ç is 0xE7 and ã is 0xE3
+		file := "C\xf3digo;ProductType;Descri\xe7\xe3o;ProductNumberCode;ProductGroup;NC;Notes\r\n" +
+			"A1;P;Parafuso a\xe7o;R1;product-group;7318, 7319;\r\n" +
+			"A2;X;Porca;R2;product-group;;\r\n" +
+			";;;;;;\r\n" +
+			"A3;P;Anilha;R3;unknown-group;;n\xe3o\r\n"
+
+		succ, err := ps.ImportCSV(strings.NewReader(file), req)
+		if err != nil {
+			t.Fatalf("Expected no error but got %v", err)
+		}
+
+		if succ.Rows != 3 || succ.Valid != 1 || succ.Imported != 1 || succ.Failed != 2 || len(succ.Errors) != 2 {
+			t.Fatalf("Unexpected import result %v", succ)
+		}
+
+		if succ.Errors[0].Row != 3 || succ.Errors[0].Property != "ProductType" || succ.Errors[1].Row != 5 || succ.Errors[1].Property != "ProductGroup" {
+			t.Fatalf("Unexpected row errors %v %v", succ.Errors[0], succ.Errors[1])
+		}
+
+		// dry runs only validate
+		req.DryRun = true
+		succ, _ = ps.ImportCSV(strings.NewReader(file), req)
+		if succ.Valid != 1 || succ.Imported != 0 {
+			t.Fatalf("Unexpected dry run result %v", succ)
+		}
+
+		// mapped columns must exist
+		_, err = ps.ImportCSV(strings.NewReader("ProductCode;ProductType\r\n"), req)
+		if err == nil || err.HttpCode != 400 {
+			t.Fatal("Expected a missing column error")
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestProductFromRecord(t *testing.T) {
+	columns := []string{"ProductCode", "", "CustomsDetails.CNCode", "CustomsDetails.UNNumber"}
+
+	p := productFromRecord([]string{" A1 ", "ignored", "7318| |7319", ""}, columns, "|")
+
+	if p.ProductCode != "A1" || p.CustomsDetails == nil || len(p.CustomsDetails.CNCode) != 2 || p.CustomsDetails.CNCode[1] != "7319" || len(p.CustomsDetails.UNNumber) != 0 {
+		t.Fatalf("Unexpected product %v", p)
+	}
+
+	p = productFromRecord([]string{"A2", "", ""}, columns, "|")
+	if p.CustomsDetails != nil {
+		t.Fatalf("Expected no customs details but got %v", p.CustomsDetails)
+	}
+}
+
+func TestExport(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		exported := 0
+		err := ps.Export(&mrequest.ListRequest{}, func(p *mresponse.ProductRead) error {
+			exported++
+			if p.Status != mresponse.StatusActive {
+				t.Fatalf("Expected default status but got %s", p.Status)
+			}
+			return nil
+		})
+
+		if err != nil || exported != 3 {
+			t.Fatalf("Expected 3 exported products but got %d (%v)", exported, err)
+		}
+
+		// errors writing products stop the export
+		exported = 0
+		err = ps.Export(&mrequest.ListRequest{}, func(p *mresponse.ProductRead) error {
+			exported++
+			return errors.New("connection closed")
+		})
+
+		if err == nil || exported != 1 {
+			t.Fatalf("Expected the export to stop after 1 product but got %d", exported)
+		}
+
+		err = ps.Export(&mrequest.ListRequest{Status: []string{mresponse.StatusActive}}, func(p *mresponse.ProductRead) error {
+			return nil
+		})
+		if err == nil || err.Code != "SERVICE_UNAVAILABLE" {
+			t.Fatal("Expected a repository error")
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestWatch(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		changes, err := ps.Watch(context.Background(), &mrequest.ChangesRequest{})
+		if err != nil {
+			t.Fatalf("Expected the feed to start but got %v", err)
+		}
+
+		received := []*mresponse.ProductChange{}
+		for change := range changes {
+			received = append(received, change)
+		}
+
+		if len(received) != 4 || received[1].Operation != mresponse.ChangeUpdate || received[1].Product.Version != 2 || received[1].Product.Status != mresponse.StatusActive {
+			t.Fatalf("Unexpected changes %v", received)
+		}
+
+		if !received[0].OccurredAt.Equal(time.Unix(1551693600, 0)) {
+			t.Fatalf("Expected the change to have occurred at its cluster time but got %s", received[0].OccurredAt)
+		}
+
+		// archiving and restoring are updates of ArchivedAt
+		if received[2].Operation != mresponse.ChangeArchive || received[2].Product != nil || received[2].ProductID != "507f191e810c19729de860ea" {
+			t.Fatalf("Unexpected archive %v", received[2])
+		}
+
+		if received[3].Operation != mresponse.ChangeRestore {
+			t.Fatalf("Unexpected restore %v", received[3])
+		}
+
+		// the feed stops when the client is gone
+		ctx, cancel := context.WithCancel(context.Background())
+		changes, _ = ps.Watch(ctx, &mrequest.ChangesRequest{})
+		<-changes
+		cancel()
+
+		for range changes {
+		}
+
+		_, err = ps.Watch(context.Background(), &mrequest.ChangesRequest{ResumeAfter: bson.NewDocument()})
+		if err == nil || err.Code != "SERVICE_UNAVAILABLE" {
+			t.Fatal("Expected a repository error")
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestUpsertByCode(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+
+		// TEST VALIDATION
+
+		_, e := ps.UpsertByCode(&mrequest.ProductCreate{ProductCode: "product-code"})
+		if e == nil || e.Code != "INVALID_REQUEST" {
+			t.Fatalf("Expected a validation error but got %v", e)
+		}
+
+		// TEST NEW CODE IS CREATED
+
+		p, e := ps.UpsertByCode(&mrequest.ProductCreate{ProductType: "P", ProductCode: "product-code-for-success", ProductDescription: "description", ProductNumberCode: "number-code"})
+		if e != nil || p.ID != "507f191e810c19729de860ea" {
+			t.Fatalf("Expected the product to be created but got %v %v", p, e)
+		}
+
+		// TEST EXISTING CODE IS UPDATED
+
+		p, e = ps.UpsertByCode(&mrequest.ProductCreate{ProductType: "P", ProductCode: "product-code", ProductDescription: "description", ProductNumberCode: "number-code"})
+		if e != nil || p.ID != "507f191e810c19729de860ec" {
+			t.Fatalf("Expected the product to be updated but got %v %v", p, e)
+		}
+
+		_, e = ps.UpsertByCode(&mrequest.ProductCreate{ProductType: "P", ProductCode: "product-code", ProductDescription: "description-that-cause-repository-error", ProductNumberCode: "number-code"})
+		if e == nil || e.Code != "SERVICE_UNAVAILABLE" {
+			t.Fatalf("Expected a repository error but got %v", e)
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestArchiveByCode(t *testing.T) {
+	container := buildTestProductContainer()
+
+	err := container.Invoke(func(ps ProductServiceContract) {
+		_, e := ps.ArchiveByCode("unknown-product-code", "Deleted by erp")
+		if e == nil || e.HttpCode != 404 {
+			t.Fatalf("Expected the product not to be found but got %v", e)
+		}
+
+		p, e := ps.ArchiveByCode("product-code", "Deleted by erp")
+		if e != nil || p.ID != "507f191e810c19729de860ec" {
+			t.Fatalf("Expected the product to be archived but got %v %v", p, e)
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
@@ -0,0 +1,279 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"products/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SchemaTypeAvro and SchemaTypeProtobuf are the types of the registered schemas, the registry omits the type of Avro schemas
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+
+	// schemaRegistryLatestTTL is how long the latest schema of a subject is cached, schemas by id never change
+	schemaRegistryLatestTTL = 5 * time.Minute
+
+	schemaRegistryTimeout = 10 * time.Second
+)
+
+// Schema is a schema of the registry
+type Schema struct {
+	ID     int    `json:"id"`
+	Type   string `json:"schemaType"`
+	Schema string `json:"schema"`
+}
+
+// SchemaRegistryError is an error response of the schema registry
+type SchemaRegistryError struct {
+	StatusCode int    `json:"-"`
+	ErrorCode  int    `json:"error_code"`
+	Message    string `json:"message"`
+}
+
+func (e *SchemaRegistryError) Error() string {
+	return fmt.Sprintf("schema registry error %d: %s", e.ErrorCode, e.Message)
+}
+
+// Temporary tells whether the request may succeed if retried, i.e. the registry failed rather than the request
+func (e *SchemaRegistryError) Temporary() bool {
+	return e.StatusCode == 0 || e.StatusCode >= 500
+}
+
+// SchemaRegistryContract is the abstraction for reading the schemas of Avro and Protobuf messages
+type SchemaRegistryContract interface {
+	SchemaByID(id int) (*Schema, error)
+	LatestSchema(subject string) (*Schema, error)
+}
+
+// NewSchemaRegistry is the constructor of the schema registry configured, which is nil if none is.
+// A file:// URL reads schemas from a directory instead, named <subject>.<id>.avsc or <subject>.<id>.proto
+func NewSchemaRegistry(config *config.Config) SchemaRegistryContract {
+	if config.SchemaRegistryURL == "" {
+		return nil
+	}
+
+	var registry SchemaRegistryContract
+	if strings.HasPrefix(config.SchemaRegistryURL, "file://") {
+		registry = &FileSchemaRegistry{dir: strings.TrimPrefix(config.SchemaRegistryURL, "file://")}
+	} else {
+		registry = &HTTPSchemaRegistry{
+			url:    strings.TrimRight(config.SchemaRegistryURL, "/"),
+			client: &http.Client{Timeout: schemaRegistryTimeout},
+		}
+	}
+
+	return newCachedSchemaRegistry(registry, schemaRegistryLatestTTL)
+}
+
+// HTTPSchemaRegistry reads schemas from a Confluent compatible schema registry
+type HTTPSchemaRegistry struct {
+	url    string
+	client *http.Client
+}
+
+func (sr *HTTPSchemaRegistry) SchemaByID(id int) (*Schema, error) {
+	schema := Schema{}
+	err := sr.get("/schemas/ids/"+strconv.Itoa(id), &schema)
+	if err != nil {
+		return nil, err
+	}
+
+	schema.ID = id
+	return &schema, nil
+}
+
+func (sr *HTTPSchemaRegistry) LatestSchema(subject string) (*Schema, error) {
+	schema := Schema{}
+	err := sr.get("/subjects/"+url.PathEscape(subject)+"/versions/latest", &schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schema, nil
+}
+
+func (sr *HTTPSchemaRegistry) get(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, sr.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+
+	res, err := sr.client.Do(req)
+	if err != nil {
+		return &SchemaRegistryError{Message: err.Error()}
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return &SchemaRegistryError{Message: err.Error()}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		registryErr := &SchemaRegistryError{}
+		if json.Unmarshal(body, registryErr) != nil || registryErr.Message == "" {
+			registryErr = &SchemaRegistryError{ErrorCode: res.StatusCode, Message: http.StatusText(res.StatusCode)}
+		}
+		registryErr.StatusCode = res.StatusCode
+		return registryErr
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// FileSchemaRegistry reads schemas from a directory, for development and tests without a schema registry
+type FileSchemaRegistry struct {
+	dir string
+}
+
+func (sr *FileSchemaRegistry) SchemaByID(id int) (*Schema, error) {
+	files, err := sr.files("*")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if f.id == id {
+			return f.read()
+		}
+	}
+
+	return nil, &SchemaRegistryError{StatusCode: http.StatusNotFound, ErrorCode: 40403, Message: fmt.Sprintf("Schema %d not found", id)}
+}
+
+func (sr *FileSchemaRegistry) LatestSchema(subject string) (*Schema, error) {
+	files, err := sr.files(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *schemaFile
+	for i := range files {
+		if latest == nil || files[i].id > latest.id {
+			latest = &files[i]
+		}
+	}
+
+	if latest == nil {
+		return nil, &SchemaRegistryError{StatusCode: http.StatusNotFound, ErrorCode: 40401, Message: fmt.Sprintf("Subject '%s' not found.", subject)}
+	}
+
+	return latest.read()
+}
+
+type schemaFile struct {
+	path       string
+	id         int
+	schemaType string
+}
+
+func (f *schemaFile) read() (*Schema, error) {
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, &SchemaRegistryError{Message: err.Error()}
+	}
+
+	return &Schema{ID: f.id, Type: f.schemaType, Schema: string(content)}, nil
+}
+
+// files lists the schema files of the subject pattern
+func (sr *FileSchemaRegistry) files(subject string) ([]schemaFile, error) {
+	paths, err := filepath.Glob(filepath.Join(sr.dir, subject+".*.*"))
+	if err != nil {
+		return nil, err
+	}
+
+	files := []schemaFile{}
+	for _, path := range paths {
+		name := filepath.Base(path)
+		ext := filepath.Ext(name)
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Ext(strings.TrimSuffix(name, ext)), "."))
+		if err != nil {
+			continue
+		}
+
+		switch ext {
+		case ".avsc":
+			files = append(files, schemaFile{path: path, id: id, schemaType: SchemaTypeAvro})
+		case ".proto":
+			files = append(files, schemaFile{path: path, id: id, schemaType: SchemaTypeProtobuf})
+		}
+	}
+
+	return files, nil
+}
+
+// cachedSchemaRegistry keeps the schemas read, so the registry is only asked for schemas not seen yet
+type cachedSchemaRegistry struct {
+	registry  SchemaRegistryContract
+	latestTTL time.Duration
+
+	mu     sync.Mutex
+	byID   map[int]*Schema
+	latest map[string]cachedLatestSchema
+}
+
+type cachedLatestSchema struct {
+	schema    *Schema
+	expiresAt time.Time
+}
+
+func newCachedSchemaRegistry(registry SchemaRegistryContract, latestTTL time.Duration) *cachedSchemaRegistry {
+	return &cachedSchemaRegistry{
+		registry:  registry,
+		latestTTL: latestTTL,
+		byID:      map[int]*Schema{},
+		latest:    map[string]cachedLatestSchema{},
+	}
+}
+
+func (sr *cachedSchemaRegistry) SchemaByID(id int) (*Schema, error) {
+	sr.mu.Lock()
+	schema, ok := sr.byID[id]
+	sr.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	schema, err := sr.registry.SchemaByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	sr.mu.Lock()
+	sr.byID[id] = schema
+	sr.mu.Unlock()
+
+	return schema, nil
+}
+
+func (sr *cachedSchemaRegistry) LatestSchema(subject string) (*Schema, error) {
+	sr.mu.Lock()
+	cached, ok := sr.latest[subject]
+	sr.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.schema, nil
+	}
+
+	schema, err := sr.registry.LatestSchema(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	sr.mu.Lock()
+	sr.latest[subject] = cachedLatestSchema{schema: schema, expiresAt: time.Now().Add(sr.latestTTL)}
+	sr.byID[schema.ID] = schema
+	sr.mu.Unlock()
+
+	return schema, nil
+}
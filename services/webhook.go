@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"products/models/request"
+	"products/models/response"
+	"products/repositories"
+	"products/util/errors"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo"
+)
+
+// webhookEventTypes are the event types subscriptions can subscribe to
+var webhookEventTypes = map[string]bool{
+	mresponse.WebhookProductCreated:  true,
+	mresponse.WebhookProductUpdated:  true,
+	mresponse.WebhookProductDeleted:  true,
+	mresponse.WebhookProductRestored: true,
+}
+
+// WebhookServiceContract is the abstraction for service layer on webhook subscriptions resource
+type WebhookServiceContract interface {
+	CreateOne(request *mrequest.WebhookCreate) (*mresponse.WebhookSubscription, *mresponse.ErrorResponse)
+	ReadOne(id string) (*mresponse.WebhookSubscription, *mresponse.ErrorResponse)
+	List() (*mresponse.WebhookSubscriptionList, *mresponse.ErrorResponse)
+	UpdateOne(id string, request *mrequest.WebhookUpdate) (*mresponse.WebhookSubscription, *mresponse.ErrorResponse)
+	DeleteOne(id string) *mresponse.ErrorResponse
+	ListDeliveries(id string, request *mrequest.DeliveryListRequest) (*mresponse.WebhookDeliveryList, *mresponse.ErrorResponse)
+}
+
+// WebhookService is the layer between http client and repository for webhook subscriptions resource
+type WebhookService struct {
+	webhookRepository repositories.WebhookRepositoryContract
+}
+
+// NewWebhookService is the constructor of WebhookService
+func NewWebhookService(wr repositories.WebhookRepositoryContract) WebhookServiceContract {
+	return &WebhookService{
+		webhookRepository: wr,
+	}
+}
+
+// CreateOne saves a new subscription, enabled unless requested otherwise.
+// The secret payloads are signed with is generated if not provided, it's only returned here.
+func (this *WebhookService) CreateOne(request *mrequest.WebhookCreate) (*mresponse.WebhookSubscription, *mresponse.ErrorResponse) {
+
+	// validate request
+	e := errors.ValidateRequest(request)
+	if e != nil {
+		return nil, e
+	}
+
+	e = validateWebhook(request.URL, request.Events)
+	if e != nil {
+		return nil, e
+	}
+
+	if request.Secret == "" {
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		}
+		request.Secret = hex.EncodeToString(secret)
+	}
+
+	if request.Enabled == nil {
+		enabled := true
+		request.Enabled = &enabled
+	}
+	request.CreatedAt = time.Now().UTC()
+
+	_, err := this.webhookRepository.CreateOne(request)
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	res, e := this.ReadOne(request.ID.Hex())
+	if e != nil {
+		return nil, e
+	}
+	res.Secret = request.Secret
+
+	return res, nil
+}
+
+// ReadOne returns the subscription with the provided id, without its secret
+func (this *WebhookService) ReadOne(id string) (*mresponse.WebhookSubscription, *mresponse.ErrorResponse) {
+	oid, err := objectid.FromHex(id)
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.INVALID_REQUEST, nil, "Invalid webhook subscription id")
+	}
+
+	sub, err := this.webhookRepository.ReadByID(oid)
+
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.HandleErrorResponse(errors.NOT_FOUND, nil, "Webhook subscription not found")
+	}
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	sub.ID = sub.IDdb.Hex()
+	sub.Secret = ""
+
+	return sub, nil
+}
+
+// List returns all the subscriptions, without their secrets
+func (this *WebhookService) List() (*mresponse.WebhookSubscriptionList, *mresponse.ErrorResponse) {
+	cursor, err := this.webhookRepository.List("")
+
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	docs := []*mresponse.WebhookSubscription{}
+
+	for cursor.Next(context.Background()) {
+		doc := mresponse.WebhookSubscription{}
+		err := cursor.Decode(&doc)
+		if err != nil {
+			return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		}
+
+		doc.ID = doc.IDdb.Hex()
+		doc.Secret = ""
+		docs = append(docs, &doc)
+	}
+
+	return &mresponse.WebhookSubscriptionList{Items: &docs}, nil
+}
+
+// UpdateOne replaces the URL, events and description of the subscription, enabling or disabling it if requested
+func (this *WebhookService) UpdateOne(id string, request *mrequest.WebhookUpdate) (*mresponse.WebhookSubscription, *mresponse.ErrorResponse) {
+
+	// validate request
+	e := errors.ValidateRequest(request)
+	if e != nil {
+		return nil, e
+	}
+
+	e = validateWebhook(request.URL, request.Events)
+	if e != nil {
+		return nil, e
+	}
+
+	sub, e := this.ReadOne(id)
+	if e != nil {
+		return nil, e
+	}
+
+	_, err := this.webhookRepository.UpdateOne(sub.IDdb, request)
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	return this.ReadOne(id)
+}
+
+// DeleteOne removes the subscription and its delivery log, pending deliveries are not sent
+func (this *WebhookService) DeleteOne(id string) *mresponse.ErrorResponse {
+	sub, e := this.ReadOne(id)
+	if e != nil {
+		return e
+	}
+
+	_, err := this.webhookRepository.DeleteOne(sub.IDdb)
+	if err != nil {
+		return errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	return nil
+}
+
+// ListDeliveries returns a page of the delivery log of the subscription, latest first
+func (this *WebhookService) ListDeliveries(id string, request *mrequest.DeliveryListRequest) (*mresponse.WebhookDeliveryList, *mresponse.ErrorResponse) {
+	sub, e := this.ReadOne(id)
+	if e != nil {
+		return nil, e
+	}
+
+	total, cursor, err := this.webhookRepository.ListDeliveries(sub.IDdb, request)
+
+	if err != nil {
+		return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+	}
+
+	docs := []*mresponse.WebhookDelivery{}
+
+	for cursor.Next(context.Background()) {
+		doc := mresponse.WebhookDelivery{}
+		err := cursor.Decode(&doc)
+		if err != nil {
+			return nil, errors.HandleErrorResponse(errors.SERVICE_UNAVAILABLE, nil, err.Error())
+		}
+
+		doc.ID = doc.IDdb.Hex()
+		if doc.Attempts == nil {
+			doc.Attempts = []*mresponse.WebhookAttempt{}
+		}
+		docs = append(docs, &doc)
+	}
+
+	return &mresponse.WebhookDeliveryList{
+		Total:   total,
+		PerPage: int64(request.PerPage),
+		Page:    int64(request.Page),
+		Items:   &docs,
+	}, nil
+}
+
+// validateWebhook checks subscriptions have an http(s) URL and known event types
+func validateWebhook(rawURL string, events []string) *mresponse.ErrorResponse {
+	details := []mresponse.ErrorDetail{}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		details = append(details, mresponse.ErrorDetail{
+			Property: "url",
+			Message:  "Must be an http or https URL",
+		})
+	}
+
+	for _, event := range events {
+		if !webhookEventTypes[event] {
+			details = append(details, mresponse.ErrorDetail{
+				Property: "events",
+				Message:  "Unknown event type " + event,
+			})
+		}
+	}
+
+	if len(details) != 0 {
+		return errors.HandleErrorResponse(errors.INVALID_REQUEST, details, "")
+	}
+
+	return nil
+}
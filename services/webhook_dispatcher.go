@@ -0,0 +1,344 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"products/config"
+	"products/models/request"
+	"products/models/response"
+	"products/repositories"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/mongo"
+)
+
+const (
+	// webhookPollInterval is how long idle workers wait before looking for due deliveries again
+	webhookPollInterval = 2 * time.Second
+
+	// webhookDeliveryLease is how long a claimed delivery waits before other worker sends it, if its worker stops
+	webhookDeliveryLease = 5 * time.Minute
+
+	// webhookReconnectInterval is how long the dispatcher waits before following the change feed again when it ends
+	webhookReconnectInterval = 5 * time.Second
+
+	// webhookResumeAttempts is how many times the feed is resumed after the last queued change before starting over,
+	// the change may no longer be in the oplog
+	webhookResumeAttempts = 3
+
+	// webhookFirstBackoff is the wait before the second attempt of a delivery, doubled on each attempt up to webhookMaxBackoff
+	webhookFirstBackoff = 30 * time.Second
+	webhookMaxBackoff   = time.Hour
+
+	// webhookResponseLimit is how many bytes of partner responses are kept in the delivery log
+	webhookResponseLimit = 512
+)
+
+// webhookEvents are the event types of the change feed operations, archived products are notified as deleted
+var webhookEvents = map[string]string{
+	mresponse.ChangeInsert:  mresponse.WebhookProductCreated,
+	mresponse.ChangeUpdate:  mresponse.WebhookProductUpdated,
+	mresponse.ChangeReplace: mresponse.WebhookProductUpdated,
+	mresponse.ChangeArchive: mresponse.WebhookProductDeleted,
+	mresponse.ChangeRestore: mresponse.WebhookProductRestored,
+}
+
+// WebhookDispatcher notifies webhook subscriptions of product changes. It follows the change feed queueing a delivery
+// of each change per subscription, and a pool of workers sends the due deliveries, retrying failed ones with exponential backoff.
+type WebhookDispatcher struct {
+	config            *config.Config
+	webhookRepository repositories.WebhookRepositoryContract
+	productServ       ProductServiceContract
+	client            *http.Client
+}
+
+func NewWebhookDispatcher(config *config.Config, wr repositories.WebhookRepositoryContract, ps ProductServiceContract) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		config:            config,
+		webhookRepository: wr,
+		productServ:       ps,
+		client:            &http.Client{Timeout: config.WebhookTimeout},
+	}
+}
+
+// Run follows the change feed and starts the pool of workers, it blocks while they work.
+// Instances without workers only queue deliveries.
+func (wd *WebhookDispatcher) Run() {
+
+	log.Printf("Start sending webhooks with %d workers\n", wd.config.WebhookWorkers)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		wd.follow()
+	}()
+
+	for i := 0; i < wd.config.WebhookWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wd.work()
+		}()
+	}
+
+	wg.Wait()
+}
+
+// follow queues the deliveries of product changes, resuming the change feed after the last queued change when it ends
+func (wd *WebhookDispatcher) follow() {
+	token := ""
+
+	last, err := wd.webhookRepository.LastDelivery()
+	if err != nil {
+		log.Printf("Error reading last webhook delivery: %s\n", err.Error())
+	}
+	if last != nil {
+		token = last.EventID
+	}
+
+	for failures := 0; ; time.Sleep(webhookReconnectInterval) {
+		req, e := mrequest.NewChangesRequest(token)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var changes <-chan *mresponse.ProductChange
+		if e == nil {
+			changes, e = wd.productServ.Watch(ctx, req)
+		}
+
+		if e != nil {
+			cancel()
+			log.Printf("Error following product changes for webhooks: %s\n", e.Response)
+			failures++
+			if failures >= webhookResumeAttempts {
+				token, failures = "", 0
+			}
+			continue
+		}
+		failures = 0
+
+		token = wd.queue(changes, token)
+		cancel()
+	}
+}
+
+// queue queues the deliveries of the changes until the feed ends or the deliveries of a change fail to be queued,
+// so the feed is resumed from that change. It returns the token of the last change queued.
+func (wd *WebhookDispatcher) queue(changes <-chan *mresponse.ProductChange, token string) string {
+	for change := range changes {
+		err := wd.Dispatch(change)
+		if err != nil {
+			log.Printf("Error queueing webhook deliveries of product %s: %s\n", change.ProductID, err.Error())
+			break
+		}
+		token = change.Token
+	}
+
+	return token
+}
+
+// Dispatch queues a delivery of the change to each enabled subscription of its event type.
+// Deliveries already queued, by this or other instance, are not queued again.
+func (wd *WebhookDispatcher) Dispatch(change *mresponse.ProductChange) error {
+	eventType, ok := webhookEvents[change.Operation]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now().UTC()
+
+	// changes of MongoDB versions before 4.0 have no cluster time
+	occurredAt := now
+	if change.OccurredAt != nil {
+		occurredAt = *change.OccurredAt
+	}
+
+	payload, err := json.Marshal(mresponse.WebhookPayload{
+		ID:         change.Token,
+		Type:       eventType,
+		ProductID:  change.ProductID,
+		Product:    change.Product,
+		OccurredAt: occurredAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	cursor, err := wd.webhookRepository.List(eventType)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		sub := mresponse.WebhookSubscription{}
+		err := cursor.Decode(&sub)
+		if err != nil {
+			return err
+		}
+
+		_, err = wd.webhookRepository.Enqueue(&mrequest.WebhookDeliveryCreate{
+			SubscriptionID: sub.IDdb,
+			EventID:        change.Token,
+			EventType:      eventType,
+			Payload:        string(payload),
+			Status:         mresponse.DeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+func (wd *WebhookDispatcher) work() {
+	for {
+		delivery, err := wd.webhookRepository.ClaimDelivery(time.Now().UTC().Add(webhookDeliveryLease))
+		if err != nil {
+			log.Printf("Error claiming webhook delivery: %s\n", err.Error())
+		}
+
+		if delivery == nil {
+			time.Sleep(webhookPollInterval)
+			continue
+		}
+
+		wd.Deliver(delivery)
+	}
+}
+
+// Deliver sends a claimed delivery and records the attempt. Failed deliveries are retried until they run out of attempts,
+// then they count as a failure of the subscription, which is disabled after WebhookDisableAfter consecutive failures.
+func (wd *WebhookDispatcher) Deliver(delivery *mresponse.WebhookDelivery) {
+	sub, err := wd.webhookRepository.ReadByID(delivery.SubscriptionID)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Error reading webhook subscription %s: %s\n", delivery.SubscriptionID.Hex(), err.Error())
+		return
+	}
+
+	// subscriptions deleted or disabled meanwhile are not notified
+	if sub == nil || !sub.Enabled {
+		wd.record(delivery, nil, mresponse.DeliverySkipped, nil)
+		return
+	}
+
+	attempt := wd.send(sub, delivery)
+
+	if attempt.Error == "" {
+		wd.record(delivery, attempt, mresponse.DeliveryDelivered, nil)
+		_, err = wd.webhookRepository.RecordResult(sub.IDdb, true)
+		if err != nil {
+			log.Printf("Error saving result of webhook subscription %s: %s\n", sub.IDdb.Hex(), err.Error())
+		}
+		return
+	}
+
+	attempts := len(delivery.Attempts) + 1
+	if attempts < wd.config.WebhookMaxAttempts {
+		next := attempt.At.Add(webhookBackoff(attempts))
+		wd.record(delivery, attempt, mresponse.DeliveryPending, &next)
+		return
+	}
+
+	wd.record(delivery, attempt, mresponse.DeliveryFailed, nil)
+
+	failures, err := wd.webhookRepository.RecordResult(sub.IDdb, false)
+	if err != nil {
+		log.Printf("Error saving result of webhook subscription %s: %s\n", sub.IDdb.Hex(), err.Error())
+		return
+	}
+
+	if failures >= int64(wd.config.WebhookDisableAfter) {
+		reason := "Disabled after " + strconv.FormatInt(failures, 10) + " consecutive failed deliveries"
+		disabled, err := wd.webhookRepository.Disable(sub.IDdb, reason)
+		if err != nil {
+			log.Printf("Error disabling webhook subscription %s: %s\n", sub.IDdb.Hex(), err.Error())
+		}
+		if disabled {
+			log.Printf("Webhook subscription %s disabled after %d consecutive failed deliveries\n", sub.IDdb.Hex(), failures)
+		}
+	}
+}
+
+// send posts the payload of the delivery to the subscription URL, signed with the subscription secret
+func (wd *WebhookDispatcher) send(sub *mresponse.WebhookSubscription, delivery *mresponse.WebhookDelivery) *mresponse.WebhookAttempt {
+	start := time.Now().UTC()
+	attempt := mresponse.WebhookAttempt{At: start}
+
+	req, err := http.NewRequest(http.MethodPost, sub.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return &attempt
+	}
+
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "products-webhooks")
+	req.Header.Set("X-Webhook-Delivery", delivery.IDdb.Hex())
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhook(sub.Secret, timestamp, []byte(delivery.Payload)))
+
+	res, err := wd.client.Do(req)
+	attempt.DurationMs = int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		attempt.Error = err.Error()
+		return &attempt
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, webhookResponseLimit))
+	attempt.StatusCode = res.StatusCode
+	attempt.Response = string(body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		attempt.Error = "Unexpected response status " + strconv.Itoa(res.StatusCode)
+	}
+
+	return &attempt
+}
+
+func (wd *WebhookDispatcher) record(delivery *mresponse.WebhookDelivery, attempt *mresponse.WebhookAttempt, status string, nextAttemptAt *time.Time) {
+	err := wd.webhookRepository.RecordAttempt(delivery.IDdb, attempt, status, nextAttemptAt)
+	if err != nil {
+		log.Printf("Error saving attempt of webhook delivery %s: %s\n", delivery.IDdb.Hex(), err.Error())
+	}
+}
+
+// SignWebhook returns the hex HMAC-SHA256, keyed by the secret, of the timestamp and the body joined by a dot.
+// Partners check the X-Webhook-Signature header computing it from the X-Webhook-Timestamp header and the body they got.
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the wait after the failed attempt number attempts
+func webhookBackoff(attempts int) time.Duration {
+	if attempts > 30 {
+		return webhookMaxBackoff
+	}
+
+	backoff := webhookFirstBackoff << uint(attempts-1)
+	if backoff > webhookMaxBackoff {
+		return webhookMaxBackoff
+	}
+
+	return backoff
+}
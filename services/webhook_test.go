@@ -0,0 +1,539 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"products/config"
+	"products/models/request"
+	"products/models/response"
+	"products/repositories"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"go.uber.org/dig"
+)
+
+// Mock WebhookRepository behaviour, keeping subscriptions and deliveries in memory
+type WebhookRepositoryMock struct {
+	subscriptions []*mresponse.WebhookSubscription
+	deliveries    []*mresponse.WebhookDelivery
+}
+
+func NewWebhookRepositoryMock() repositories.WebhookRepositoryContract {
+	return &WebhookRepositoryMock{}
+}
+
+func (wrm *WebhookRepositoryMock) CreateOne(request *mrequest.WebhookCreate) (*mongo.InsertOneResult, error) {
+	request.ID = objectid.New()
+	wrm.subscriptions = append(wrm.subscriptions, &mresponse.WebhookSubscription{
+		IDdb:        request.ID,
+		URL:         request.URL,
+		Events:      request.Events,
+		Description: request.Description,
+		Secret:      request.Secret,
+		Enabled:     *request.Enabled,
+		CreatedAt:   request.CreatedAt,
+	})
+
+	return &mongo.InsertOneResult{InsertedID: request.ID}, nil
+}
+
+func (wrm *WebhookRepositoryMock) ReadByID(id objectid.ObjectID) (*mresponse.WebhookSubscription, error) {
+	for _, sub := range wrm.subscriptions {
+		if sub.IDdb == id {
+			copy := *sub
+			return &copy, nil
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func (wrm *WebhookRepositoryMock) List(eventType string) (mongo.Cursor, error) {
+	items := []interface{}{}
+	for _, sub := range wrm.subscriptions {
+		subscribed := false
+		for _, e := range sub.Events {
+			subscribed = subscribed || e == eventType
+		}
+
+		if eventType == "" || (subscribed && sub.Enabled) {
+			copy := *sub
+			items = append(items, &copy)
+		}
+	}
+
+	return &SliceCursorMock{Items: items}, nil
+}
+
+func (wrm *WebhookRepositoryMock) UpdateOne(id objectid.ObjectID, request *mrequest.WebhookUpdate) (*mongo.UpdateResult, error) {
+	for _, sub := range wrm.subscriptions {
+		if sub.IDdb == id {
+			sub.URL, sub.Events, sub.Description = request.URL, request.Events, request.Description
+			if request.Enabled != nil {
+				sub.Enabled = *request.Enabled
+				if sub.Enabled {
+					sub.ConsecutiveFailures, sub.DisabledReason = 0, ""
+				}
+			}
+			return &mongo.UpdateResult{MatchedCount: 1}, nil
+		}
+	}
+
+	return &mongo.UpdateResult{}, nil
+}
+
+func (wrm *WebhookRepositoryMock) DeleteOne(id objectid.ObjectID) (*mongo.DeleteResult, error) {
+	for i, sub := range wrm.subscriptions {
+		if sub.IDdb == id {
+			wrm.subscriptions = append(wrm.subscriptions[:i], wrm.subscriptions[i+1:]...)
+			return &mongo.DeleteResult{DeletedCount: 1}, nil
+		}
+	}
+
+	return &mongo.DeleteResult{}, nil
+}
+
+func (wrm *WebhookRepositoryMock) RecordResult(id objectid.ObjectID, delivered bool) (int64, error) {
+	for _, sub := range wrm.subscriptions {
+		if sub.IDdb == id {
+			sub.ConsecutiveFailures++
+			if delivered {
+				sub.ConsecutiveFailures = 0
+			}
+			return sub.ConsecutiveFailures, nil
+		}
+	}
+
+	return 0, mongo.ErrNoDocuments
+}
+
+func (wrm *WebhookRepositoryMock) Disable(id objectid.ObjectID, reason string) (bool, error) {
+	for _, sub := range wrm.subscriptions {
+		if sub.IDdb == id && sub.Enabled {
+			sub.Enabled, sub.DisabledReason = false, reason
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (wrm *WebhookRepositoryMock) Enqueue(delivery *mrequest.WebhookDeliveryCreate) (*mongo.UpdateResult, error) {
+	if delivery.EventID == "token-unavailable" {
+		return nil, errors.New("database is unavailable")
+	}
+
+	for _, d := range wrm.deliveries {
+		if d.SubscriptionID == delivery.SubscriptionID && d.EventID == delivery.EventID {
+			return &mongo.UpdateResult{MatchedCount: 1}, nil
+		}
+	}
+
+	next := delivery.NextAttemptAt
+	wrm.deliveries = append(wrm.deliveries, &mresponse.WebhookDelivery{
+		IDdb:           objectid.New(),
+		SubscriptionID: delivery.SubscriptionID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Payload:        delivery.Payload,
+		Status:         delivery.Status,
+		NextAttemptAt:  &next,
+		CreatedAt:      delivery.CreatedAt,
+	})
+
+	return &mongo.UpdateResult{}, nil
+}
+
+// ClaimDelivery returns the first pending delivery, without waiting for its next attempt
+func (wrm *WebhookRepositoryMock) ClaimDelivery(leaseUntil time.Time) (*mresponse.WebhookDelivery, error) {
+	for _, d := range wrm.deliveries {
+		if d.Status == mresponse.DeliveryPending {
+			copy := *d
+			return &copy, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (wrm *WebhookRepositoryMock) RecordAttempt(id objectid.ObjectID, attempt *mresponse.WebhookAttempt, status string, nextAttemptAt *time.Time) error {
+	for _, d := range wrm.deliveries {
+		if d.IDdb == id {
+			d.Status, d.NextAttemptAt = status, nextAttemptAt
+			if attempt != nil {
+				d.Attempts = append(d.Attempts, attempt)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (wrm *WebhookRepositoryMock) ListDeliveries(id objectid.ObjectID, req *mrequest.DeliveryListRequest) (int64, mongo.Cursor, error) {
+	items := []interface{}{}
+	for i := len(wrm.deliveries) - 1; i >= 0; i-- {
+		d := wrm.deliveries[i]
+		if d.SubscriptionID == id && (req.Status == "" || d.Status == req.Status) {
+			copy := *d
+			items = append(items, &copy)
+		}
+	}
+
+	return int64(len(items)), &SliceCursorMock{Items: items}, nil
+}
+
+func (wrm *WebhookRepositoryMock) LastDelivery() (*mresponse.WebhookDelivery, error) {
+	if len(wrm.deliveries) == 0 {
+		return nil, nil
+	}
+
+	return wrm.deliveries[len(wrm.deliveries)-1], nil
+}
+
+// Mock Mongo cursor over documents kept in a slice, decoded as copies
+type SliceCursorMock struct {
+	MongoCursorMock
+	Items []interface{}
+}
+
+func (mc *SliceCursorMock) Next(context.Context) bool {
+	if mc.Position >= len(mc.Items) {
+		return false
+	}
+
+	mc.Position++
+
+	return true
+}
+
+func (mc *SliceCursorMock) Decode(obj interface{}) error {
+	reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(mc.Items[mc.Position-1]).Elem())
+
+	return nil
+}
+
+func (mc *SliceCursorMock) DecodeBytes() (bson.Reader, error) {
+	return nil, nil
+}
+
+// dependency injection provided
+func buildTestWebhookContainer() *dig.Container {
+
+	container := dig.New()
+
+	// webhook repository
+	err := container.Provide(NewWebhookRepositoryMock)
+	if err != nil {
+		panic(err)
+	}
+
+	// webhook service
+	err = container.Provide(NewWebhookService)
+	if err != nil {
+		panic(err)
+	}
+
+	// webhook dispatcher, failing deliveries after 2 attempts and disabling subscriptions after 2 failed deliveries
+	err = container.Provide(func() *config.Config {
+		return &config.Config{WebhookConfig: &config.WebhookConfig{WebhookWorkers: 1, WebhookMaxAttempts: 2, WebhookDisableAfter: 2, WebhookTimeout: time.Second}}
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = container.Provide(func() ProductServiceContract { return nil })
+	if err != nil {
+		panic(err)
+	}
+	err = container.Provide(NewWebhookDispatcher)
+	if err != nil {
+		panic(err)
+	}
+
+	return container
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	container := buildTestWebhookContainer()
+
+	err := container.Invoke(func(ws WebhookServiceContract) {
+
+		// TEST VALIDATION
+
+		_, e := ws.CreateOne(&mrequest.WebhookCreate{URL: "ftp://partner.example/hooks", Events: []string{"product.created", "product.sold"}})
+		if e == nil || e.Code != "INVALID_REQUEST" || len(e.Errors) != 2 || e.Errors[1].Message != "Unknown event type product.sold" {
+			t.Fatalf("Expected invalid URL and event errors but got %v", e)
+		}
+
+		_, e = ws.CreateOne(&mrequest.WebhookCreate{URL: "https://partner.example/hooks"})
+		if e == nil || e.Errors[0].Property != "events" {
+			t.Fatalf("Expected events to be required but got %v", e)
+		}
+
+		// TEST CREATE WITH GENERATED SECRET
+
+		sub, e := ws.CreateOne(&mrequest.WebhookCreate{URL: "https://partner.example/hooks", Events: []string{mresponse.WebhookProductCreated}})
+		if e != nil || !sub.Enabled || len(sub.Secret) != 64 {
+			t.Fatalf("Expected an enabled subscription with a secret but got %v %v", sub, e)
+		}
+
+		// secrets are only returned on create
+		read, e := ws.ReadOne(sub.ID)
+		if e != nil || read.Secret != "" || read.URL != "https://partner.example/hooks" {
+			t.Fatalf("Unexpected subscription %v %v", read, e)
+		}
+
+		// TEST UPDATE
+
+		disabled := false
+		read, e = ws.UpdateOne(sub.ID, &mrequest.WebhookUpdate{URL: "https://partner.example/v2", Events: []string{mresponse.WebhookProductDeleted}, Enabled: &disabled})
+		if e != nil || read.Enabled || read.Events[0] != mresponse.WebhookProductDeleted {
+			t.Fatalf("Unexpected updated subscription %v %v", read, e)
+		}
+
+		list, e := ws.List()
+		if e != nil || len(*list.Items) != 1 || (*list.Items)[0].Secret != "" {
+			t.Fatalf("Unexpected subscriptions %v %v", list, e)
+		}
+
+		// TEST DELETE
+
+		e = ws.DeleteOne(sub.ID)
+		if e != nil {
+			t.Fatalf("Expected the subscription to be deleted but got %v", e)
+		}
+
+		_, e = ws.ReadOne(sub.ID)
+		if e == nil || e.Code != "NOT_FOUND" {
+			t.Fatalf("Expected the subscription not to be found but got %v", e)
+		}
+
+		_, e = ws.ReadOne("invalid-id")
+		if e == nil || e.Code != "INVALID_REQUEST" {
+			t.Fatalf("Expected an invalid id error but got %v", e)
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	container := buildTestWebhookContainer()
+
+	// partner stub, checking the signature of the deliveries
+	secret := "partner-secret-0123456789"
+	status := http.StatusOK
+	received := []*mresponse.WebhookPayload{}
+
+	partner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Webhook-Signature") != "sha256="+SignWebhook(secret, r.Header.Get("X-Webhook-Timestamp"), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		payload := mresponse.WebhookPayload{}
+		json.Unmarshal(body, &payload)
+		received = append(received, &payload)
+
+		w.WriteHeader(status)
+		w.Write([]byte("ack"))
+	}))
+	defer partner.Close()
+
+	err := container.Invoke(func(ws WebhookServiceContract, wd *WebhookDispatcher) {
+		sub, _ := ws.CreateOne(&mrequest.WebhookCreate{URL: partner.URL, Events: []string{mresponse.WebhookProductUpdated}, Secret: secret})
+		other, _ := ws.CreateOne(&mrequest.WebhookCreate{URL: partner.URL, Events: []string{mresponse.WebhookProductDeleted}})
+
+		deliver := func() *mresponse.WebhookDelivery {
+			delivery, _ := wd.webhookRepository.ClaimDelivery(time.Now())
+			if delivery == nil {
+				t.Fatal("Expected a pending delivery")
+			}
+			wd.Deliver(delivery)
+
+			deliveries, _ := ws.ListDeliveries(sub.ID, &mrequest.DeliveryListRequest{PerPage: 10, Page: 1})
+			for _, d := range *deliveries.Items {
+				if d.ID == delivery.IDdb.Hex() {
+					return d
+				}
+			}
+			return nil
+		}
+
+		// TEST DELIVERED ONCE PER SUBSCRIPTION
+
+		change := &mresponse.ProductChange{Token: "token-1", Operation: mresponse.ChangeReplace, ProductID: "507f191e810c19729de860ea", Product: &mresponse.ProductRead{ProductCode: "PRF-001"}}
+		wd.Dispatch(change)
+		wd.Dispatch(change)
+
+		delivered := deliver()
+		if delivered.Status != mresponse.DeliveryDelivered || delivered.Attempts[0].StatusCode != 200 || delivered.Attempts[0].Response != "ack" {
+			t.Fatalf("Unexpected delivery %v", delivered)
+		}
+
+		if len(received) != 1 || received[0].Type != mresponse.WebhookProductUpdated || received[0].Product.ProductCode != "PRF-001" || received[0].ID != "token-1" {
+			t.Fatalf("Unexpected payloads %v", received)
+		}
+
+		// TEST RETRIES WITH BACKOFF
+
+		status = http.StatusInternalServerError
+		wd.Dispatch(&mresponse.ProductChange{Token: "token-2", Operation: mresponse.ChangeUpdate, ProductID: "507f191e810c19729de860ea"})
+
+		retried := deliver()
+		if retried.Status != mresponse.DeliveryPending || retried.NextAttemptAt.Sub(retried.Attempts[0].At) != webhookFirstBackoff {
+			t.Fatalf("Expected the delivery to be retried but got %v", retried)
+		}
+
+		failed := deliver()
+		if failed.Status != mresponse.DeliveryFailed || len(failed.Attempts) != 2 || failed.Attempts[1].Error != "Unexpected response status 500" {
+			t.Fatalf("Expected the delivery to fail but got %v", failed)
+		}
+
+		// TEST DISABLED AFTER CONSECUTIVE FAILED DELIVERIES
+
+		wd.Dispatch(&mresponse.ProductChange{Token: "token-3", Operation: mresponse.ChangeUpdate, ProductID: "507f191e810c19729de860ea"})
+		wd.Dispatch(&mresponse.ProductChange{Token: "token-4", Operation: mresponse.ChangeUpdate, ProductID: "507f191e810c19729de860ea"})
+		deliver()
+		deliver()
+
+		disabled, _ := ws.ReadOne(sub.ID)
+		if disabled.Enabled || disabled.DisabledReason != "Disabled after 2 consecutive failed deliveries" {
+			t.Fatalf("Expected the subscription to be disabled but got %v", disabled)
+		}
+
+		// pending deliveries of disabled subscriptions are skipped
+		skipped := deliver()
+		if skipped.Status != mresponse.DeliverySkipped || len(skipped.Attempts) != 0 {
+			t.Fatalf("Expected the delivery to be skipped but got %v", skipped)
+		}
+
+		// and new changes are not queued
+		wd.Dispatch(&mresponse.ProductChange{Token: "token-5", Operation: mresponse.ChangeUpdate, ProductID: "507f191e810c19729de860ea"})
+		pending, _ := wd.webhookRepository.ClaimDelivery(time.Now())
+		if pending != nil {
+			t.Fatalf("Expected no pending deliveries but got %v", pending)
+		}
+
+		otherDeliveries, _ := ws.ListDeliveries(other.ID, &mrequest.DeliveryListRequest{PerPage: 10, Page: 1})
+		if otherDeliveries.Total != 0 {
+			t.Fatalf("Expected no deliveries to subscriptions of other events but got %d", otherDeliveries.Total)
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestWebhookDispatcherQueue(t *testing.T) {
+	container := buildTestWebhookContainer()
+
+	err := container.Invoke(func(ws WebhookServiceContract, wd *WebhookDispatcher) {
+		sub, _ := ws.CreateOne(&mrequest.WebhookCreate{URL: "http://localhost/webhooks", Events: []string{mresponse.WebhookProductUpdated}})
+
+		changes := make(chan *mresponse.ProductChange, 3)
+		for _, token := range []string{"token-1", "token-unavailable", "token-3"} {
+			changes <- &mresponse.ProductChange{Token: token, Operation: mresponse.ChangeUpdate, ProductID: "507f191e810c19729de860ea"}
+		}
+		close(changes)
+
+		// the feed is resumed after the last queued change, the changes after the failed one are left for then
+		token := wd.queue(changes, "token-0")
+		if token != "token-1" {
+			t.Fatalf("Expected to resume after token-1 but got %s", token)
+		}
+
+		deliveries, _ := ws.ListDeliveries(sub.ID, &mrequest.DeliveryListRequest{PerPage: 10, Page: 1})
+		if deliveries.Total != 1 || (*deliveries.Items)[0].EventID != "token-1" {
+			t.Fatalf("Expected only the delivery of token-1 to be queued but got %v", *deliveries.Items)
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestWebhookDispatcherArchive(t *testing.T) {
+	container := buildTestWebhookContainer()
+
+	received := []*mresponse.WebhookPayload{}
+	partner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := mresponse.WebhookPayload{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		received = append(received, &payload)
+	}))
+	defer partner.Close()
+
+	// the feed of the product service mock has an insert, an update, an archive and a restore of a product
+	var changes <-chan *mresponse.ProductChange
+	buildTestProductContainer().Invoke(func(ps ProductServiceContract) {
+		changes, _ = ps.Watch(context.Background(), &mrequest.ChangesRequest{})
+	})
+
+	err := container.Invoke(func(ws WebhookServiceContract, wd *WebhookDispatcher) {
+		ws.CreateOne(&mrequest.WebhookCreate{URL: partner.URL, Events: []string{mresponse.WebhookProductDeleted, mresponse.WebhookProductRestored}})
+
+		for change := range changes {
+			change.Token = change.Operation // the changes of the mock have no resume token
+			wd.Dispatch(change)
+		}
+
+		for {
+			delivery, _ := wd.webhookRepository.ClaimDelivery(time.Now())
+			if delivery == nil {
+				break
+			}
+			wd.Deliver(delivery)
+		}
+
+		types := map[string]int{}
+		for _, payload := range received {
+			types[payload.Type]++
+		}
+
+		if len(received) != 2 || types[mresponse.WebhookProductDeleted] != 1 || types[mresponse.WebhookProductRestored] != 1 {
+			t.Fatalf("Expected the archive and the restore to be notified but got %v", received)
+		}
+
+		// changes occurred at their cluster time, not when they were queued
+		if !received[0].OccurredAt.Equal(time.Unix(1551693600, 0)) {
+			t.Fatalf("Expected the payload to have the time of the change but got %s", received[0].OccurredAt)
+		}
+	})
+
+	if err != nil {
+		log.Println(err.Error())
+		t.Fail()
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	expected := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 32 * time.Minute, time.Hour, time.Hour}
+
+	for i, backoff := range expected {
+		if webhookBackoff(i+1) != backoff {
+			t.Fatalf("Expected backoff %s after attempt %d but got %s", backoff, i+1, webhookBackoff(i+1))
+		}
+	}
+
+	if webhookBackoff(100) != webhookMaxBackoff {
+		t.Fatal("Expected backoff not to overflow")
+	}
+}
@@ -5,33 +5,45 @@ import (
 )
 
 var (
-	SERVICE_UNAVAILABLE string = "SERVICE_UNAVAILABLE"
-	UNKNOWN_ERROR       string = "UNKNOWN_ERROR"
-	DUPLICATED_ENTITY   string = "DUPLICATED_ENTITY"
-	INVALID_REQUEST     string = "INVALID_REQUEST"
-	EMPTY               string = "EMPTY"
-	UNAUTHORIZED        string = "UNAUTHORIZED"
-	NOT_FOUND           string = "NOT_FOUND"
+	SERVICE_UNAVAILABLE   string = "SERVICE_UNAVAILABLE"
+	UNKNOWN_ERROR         string = "UNKNOWN_ERROR"
+	DUPLICATED_ENTITY     string = "DUPLICATED_ENTITY"
+	INVALID_REQUEST       string = "INVALID_REQUEST"
+	EMPTY                 string = "EMPTY"
+	UNAUTHORIZED          string = "UNAUTHORIZED"
+	NOT_FOUND             string = "NOT_FOUND"
+	PRECONDITION_FAILED   string = "PRECONDITION_FAILED"
+	PRECONDITION_REQUIRED string = "PRECONDITION_REQUIRED"
+	CONFLICT              string = "CONFLICT"
+	IDEMPOTENCY_MISMATCH  string = "IDEMPOTENCY_MISMATCH"
 )
 
 var HttpErrorsMapper = map[string]int{
-	SERVICE_UNAVAILABLE: 500,
-	UNKNOWN_ERROR:       500,
-	INVALID_REQUEST:     400,
-	EMPTY:               400,
-	DUPLICATED_ENTITY:   409,
-	UNAUTHORIZED:        401,
-	NOT_FOUND:           404,
+	SERVICE_UNAVAILABLE:   500,
+	UNKNOWN_ERROR:         500,
+	INVALID_REQUEST:       400,
+	EMPTY:                 400,
+	DUPLICATED_ENTITY:     409,
+	UNAUTHORIZED:          401,
+	NOT_FOUND:             404,
+	PRECONDITION_FAILED:   412,
+	PRECONDITION_REQUIRED: 428,
+	CONFLICT:              409,
+	IDEMPOTENCY_MISMATCH:  422,
 }
 
 var ResponseMessageErrorsMapper = map[string]string{
-	SERVICE_UNAVAILABLE: "The service is currently unavailable",
-	UNKNOWN_ERROR:       "Unknown server error",
-	INVALID_REQUEST:     "Invalid request provided",
-	EMPTY:               "Request with provided arguments resulted in an empty resource",
-	DUPLICATED_ENTITY:   "Entity already exists",
-	UNAUTHORIZED:        "User not found or invalid password",
-	NOT_FOUND:           "This route does not exist",
+	SERVICE_UNAVAILABLE:   "The service is currently unavailable",
+	UNKNOWN_ERROR:         "Unknown server error",
+	INVALID_REQUEST:       "Invalid request provided",
+	EMPTY:                 "Request with provided arguments resulted in an empty resource",
+	DUPLICATED_ENTITY:     "Entity already exists",
+	UNAUTHORIZED:          "User not found or invalid password",
+	NOT_FOUND:             "This route does not exist",
+	PRECONDITION_FAILED:   "Resource was modified meanwhile, provided version does not match the current one",
+	PRECONDITION_REQUIRED: "Request must be conditional, provide the If-Match header with the resource ETag",
+	CONFLICT:              "Request conflicts with the current state of the resource",
+	IDEMPOTENCY_MISMATCH:  "Idempotency-Key was already used with a different request",
 }
 
 // HandleErrorResponse returns a pointer to an ErrorResponse instance that matches App error response message protocol.